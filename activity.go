@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+Session activity.
+
+Clients name themselves with the application_name startup parameter, and may change the name
+with SET later. The name is reported back in a ParameterStatus at startup and whenever it
+changes, and is in the log line of every query the session runs:
+
+```
+backend 3 (psql): select * from person
+```
+
+pg_stat_activity shows a row for every session of the server, with what it is doing, like in
+Postgres:
+
+```sql
+select pid, application_name, state, query from pg_stat_activity;
+ pid | application_name |        state        |                     query
+-----+------------------+---------------------+------------------------------------------------
+   3 | psql             | active              | select pid, application_name, state, query ...
+   4 | reports          | idle in transaction | select * from person
+```
+
+The query of an idle session is the last one it ran.
+
+*/
+
+// What a session is doing, read by pg_stat_activity from the goroutines of other sessions.
+type backendActivity struct {
+	user            string
+	applicationName string
+	backendStart    time.Time
+	state           string
+	query           string
+	queryStart      time.Time
+}
+
+// The settings that are reported to the client in a ParameterStatus when they change.
+var reportedSettings = map[string]bool{
+	"application_name": true,
+	"client_encoding":  true,
+}
+
+func (pgs *pgServer) startActivity(sm *pgproto3.StartupMessage) {
+	pgs.activityMu.Lock()
+	defer pgs.activityMu.Unlock()
+	pgs.activity = backendActivity{
+		user:            sm.Parameters["user"],
+		applicationName: pgs.applicationName(),
+		backendStart:    time.Now(),
+		state:           "idle",
+	}
+}
+
+// The application name of the session, given at startup or set later.
+func (pgs *pgServer) applicationName() string {
+	if name, ok := pgs.sessionSetting("application_name"); ok {
+		return name
+	}
+	return pgs.startupApplicationName
+}
+
+// Mark the session active running the query, and log it with the session it runs in.
+func (pgs *pgServer) logQuery(query string) {
+	pgs.activityMu.Lock()
+	pgs.activity.state = "active"
+	pgs.activity.query = query
+	pgs.activity.queryStart = time.Now()
+	pgs.activityMu.Unlock()
+
+	log.Printf("backend %d (%s): %s", pgs.pid, pgs.applicationName(), query)
+}
+
+// Mark the session idle, once it is ready for the next query.
+func (pgs *pgServer) idle() {
+	state := "idle"
+	switch pgs.txStatus() {
+	case 'T':
+		state = "idle in transaction"
+	case 'E':
+		state = "idle in transaction (aborted)"
+	}
+
+	pgs.activityMu.Lock()
+	defer pgs.activityMu.Unlock()
+	pgs.activity.state = state
+}
+
+// Report the new value of a setting that changed, if the client is told about it.
+func (pgs *pgServer) settingChanged(name string) {
+	if !reportedSettings[name] {
+		return
+	}
+
+	if name == "client_encoding" {
+		pgs.useClientEncoding()
+	}
+	value, _ := pgs.setting(name)
+	if name == "application_name" {
+		pgs.activityMu.Lock()
+		pgs.activity.applicationName = value
+		pgs.activityMu.Unlock()
+	}
+
+	buf := (&pgproto3.ParameterStatus{Name: name, Value: value}).Encode(nil)
+	if err := pgs.write(buf); err != nil {
+		log.Printf("failed to write parameter status: %s", err)
+	}
+}
+
+// Note: sessions of every database are shown, like in Postgres
+func pgStatActivityRows(pe pgEngine) ([]row, error) {
+	backendsMu.Lock()
+	sessions := make([]*pgServer, 0, len(backends))
+	for _, pgs := range backends {
+		sessions = append(sessions, pgs)
+	}
+	backendsMu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].pid < sessions[j].pid })
+
+	var rows []row
+	for _, pgs := range sessions {
+		pgs.activityMu.Lock()
+		a := pgs.activity
+		pgs.activityMu.Unlock()
+
+		r := row{
+			"datname":          pgs.database,
+			"pid":              strconv.FormatUint(uint64(pgs.pid), 10),
+			"usename":          a.user,
+			"application_name": a.applicationName,
+			"client_addr":      pgs.conn.RemoteAddr().String(),
+			"backend_start":    timestamptz(microsFromTime(a.backendStart)),
+			"state":            a.state,
+			"query":            a.query,
+			"query_start":      nil,
+		}
+		if !a.queryStart.IsZero() {
+			r["query_start"] = timestamptz(microsFromTime(a.queryStart))
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/google/uuid"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Advisory locks, as taken by pg_advisory_lock and friends.
+
+Every lock is a key holding the connection that owns it and until when it owns it:
+
+```
+locks/advisory/("bigint", 42):   ("<owner id>", <lease expiry in unix nanoseconds>)
+locks/advisory/("int", 4, 2):    ("<owner id>", <lease expiry in unix nanoseconds>)
+```
+
+Like in Postgres, pg_advisory_lock(bigint) and pg_advisory_lock(int, int) lock different
+keys, so the key is tagged with the form it was taken with.
+
+A connection renews the leases of the locks it holds in the background and releases them
+when it disconnects. If the server holding a lock dies, the lease runs out and the lock can
+be taken by someone else. A renewal only renews the locks the connection still owns, a lock
+whose lease ran out and that someone else took meanwhile is lost and forgotten by the connection. Waiting for a lock uses a FoundationDB watch on the lock key, so
+waiters wake up as soon as it is released.
+
+Like Postgres, locks are reentrant: a lock taken twice by the same connection has to be
+unlocked twice. Unlocking a lock the connection doesn't hold returns false with a warning:
+
+```sql
+select pg_advisory_unlock(42);
+WARNING:  you don't own a lock of type ExclusiveLock
+```
+
+*/
+
+const (
+	advisoryLockLease   = 30 * time.Second
+	advisoryLockRenewal = 10 * time.Second
+)
+
+type advisoryLocks struct {
+	mu    sync.Mutex
+	owner string
+	held  map[advisoryKey]int
+	stop  chan struct{}
+}
+
+func newAdvisoryLocks() *advisoryLocks {
+	return &advisoryLocks{owner: uuid.New().String(), held: map[advisoryKey]int{}, stop: make(chan struct{})}
+}
+
+// The key of an advisory lock, of the bigint form or of the (int, int) form.
+type advisoryKey struct {
+	pair bool
+	key1 int64
+	key2 int64
+}
+
+func (k advisoryKey) tuple() tuple.Tuple {
+	if k.pair {
+		return tuple.Tuple{"int", k.key1, k.key2}
+	}
+	return tuple.Tuple{"bigint", k.key1}
+}
+
+func (k advisoryKey) String() string {
+	if k.pair {
+		return fmt.Sprintf("%d, %d", k.key1, k.key2)
+	}
+	return fmt.Sprintf("%d", k.key1)
+}
+
+func (pe pgEngine) tryAdvisoryLock(owner string, key advisoryKey) (bool, fdb.FutureNil, error) {
+	locksDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("locks"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockKey := locksDir.Sub("advisory").Pack(key.tuple())
+
+	var watch fdb.FutureNil
+	acquired, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if value := tr.Get(lockKey).MustGet(); value != nil {
+			t, err := tuple.Unpack(value)
+			if err != nil {
+				return nil, err
+			}
+
+			if t[0].(string) != owner && t[1].(int64) > time.Now().UnixNano() {
+				watch = tr.Watch(lockKey)
+				return false, nil
+			}
+		}
+
+		writes(tr).Set(lockKey, tuple.Tuple{owner, time.Now().Add(advisoryLockLease).UnixNano()}.Pack())
+		return true, nil
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("could not take advisory lock: %w", err)
+	}
+
+	return acquired.(bool), watch, nil
+}
+
+// Release the locks, only the ones still owned by owner are cleared.
+func (pe pgEngine) advisoryUnlock(owner string, keys []advisoryKey) error {
+	locksDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("locks"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	advisorySS := locksDir.Sub("advisory")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, key := range keys {
+			lockKey := advisorySS.Pack(key.tuple())
+			value := tr.Get(lockKey).MustGet()
+			if value == nil {
+				continue
+			}
+
+			t, err := tuple.Unpack(value)
+			if err != nil {
+				return nil, err
+			}
+			if t[0].(string) == owner {
+				writes(tr).Clear(lockKey)
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not release advisory lock: %w", err)
+	}
+
+	return nil
+}
+
+// Renew the leases of the locks still owned by owner, returning the ones it lost.
+func (pe pgEngine) renewAdvisoryLocks(owner string, keys []advisoryKey) ([]advisoryKey, error) {
+	locksDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("locks"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	advisorySS := locksDir.Sub("advisory")
+
+	lost, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		var lost []advisoryKey
+		for _, key := range keys {
+			lockKey := advisorySS.Pack(key.tuple())
+			value := tr.Get(lockKey).MustGet()
+			if value == nil {
+				lost = append(lost, key)
+				continue
+			}
+
+			t, err := tuple.Unpack(value)
+			if err != nil {
+				return nil, err
+			}
+			if t[0].(string) != owner {
+				lost = append(lost, key)
+				continue
+			}
+
+			writes(tr).Set(lockKey, tuple.Tuple{owner, time.Now().Add(advisoryLockLease).UnixNano()}.Pack())
+		}
+		return lost, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not renew advisory locks: %w", err)
+	}
+
+	return lost.([]advisoryKey), nil
+}
+
+func (pgs *pgServer) renewAdvisoryLocks() {
+	ticker := time.NewTicker(advisoryLockRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pgs.locks.stop:
+			return
+		case <-ticker.C:
+			pgs.locks.mu.Lock()
+			var keys []advisoryKey
+			for key := range pgs.locks.held {
+				keys = append(keys, key)
+			}
+			pgs.locks.mu.Unlock()
+
+			if len(keys) == 0 {
+				continue
+			}
+
+			pe := pgs.newEngine(pgs.keyspace, pgs.database)
+			lost, err := pe.renewAdvisoryLocks(pgs.locks.owner, keys)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			pgs.locks.mu.Lock()
+			for _, key := range lost {
+				log.Printf("advisory lock %s was lost, its lease ran out", key)
+				delete(pgs.locks.held, key)
+			}
+			pgs.locks.mu.Unlock()
+		}
+	}
+}
+
+// Called when the connection closes.
+func (pgs *pgServer) releaseAdvisoryLocks() {
+	close(pgs.locks.stop)
+
+	pgs.locks.mu.Lock()
+	defer pgs.locks.mu.Unlock()
+
+	var keys []advisoryKey
+	for key := range pgs.locks.held {
+		keys = append(keys, key)
+	}
+	pgs.locks.held = map[advisoryKey]int{}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	pe := pgs.engine
+	if err := pe.advisoryUnlock(pgs.locks.owner, keys); err != nil {
+		log.Println(err)
+	}
+}
+
+// Take the lock, waiting for it if wait is set until it is released or the query is canceled.
+func (pgs *pgServer) lockAdvisory(ctx context.Context, key advisoryKey, wait bool) (bool, error) {
+	pgs.locks.mu.Lock()
+	if pgs.locks.held[key] > 0 {
+		pgs.locks.held[key] += 1
+		pgs.locks.mu.Unlock()
+		return true, nil
+	}
+	pgs.locks.mu.Unlock()
+
+	// Note: the mutex is not held while waiting, so the leases of other held locks keep being renewed
+	pe := pgs.engine.withContext(ctx)
+	for {
+		acquired, watch, err := pe.tryAdvisoryLock(pgs.locks.owner, key)
+		if err != nil {
+			return false, err
+		}
+
+		if acquired {
+			pgs.locks.mu.Lock()
+			pgs.locks.held[key] = 1
+			pgs.locks.mu.Unlock()
+			return true, nil
+		}
+
+		if !wait {
+			watch.Cancel()
+			return false, nil
+		}
+
+		// Note: the watch fires when the lock is released, the timer catches expired leases
+		fired := make(chan struct{})
+		go func() {
+			watch.BlockUntilReady()
+			close(fired)
+		}()
+		select {
+		case <-fired:
+		case <-time.After(advisoryLockLease):
+			watch.Cancel()
+		case <-ctx.Done():
+			watch.Cancel()
+			return false, pe.checkCanceled()
+		}
+	}
+}
+
+func (pgs *pgServer) unlockAdvisory(key advisoryKey) (bool, error) {
+	pgs.locks.mu.Lock()
+	defer pgs.locks.mu.Unlock()
+
+	if pgs.locks.held[key] == 0 {
+		pgs.sendNotice(notice{Severity: warningSeverity, Code: sqlStateWarning, Message: "you don't own a lock of type ExclusiveLock"})
+		return false, nil
+	}
+
+	pgs.locks.held[key] -= 1
+	if pgs.locks.held[key] > 0 {
+		return true, nil
+	}
+
+	delete(pgs.locks.held, key)
+	pe := pgs.engine
+	return true, pe.advisoryUnlock(pgs.locks.owner, []advisoryKey{key})
+}
+
+func (pgs *pgServer) unlockAllAdvisory() error {
+	pgs.locks.mu.Lock()
+	defer pgs.locks.mu.Unlock()
+
+	var keys []advisoryKey
+	for key := range pgs.locks.held {
+		keys = append(keys, key)
+	}
+	pgs.locks.held = map[advisoryKey]int{}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pe := pgs.engine
+	return pe.advisoryUnlock(pgs.locks.owner, keys)
+}
+
+// pg_advisory_lock(bigint) or pg_advisory_lock(int, int), the two forms lock different keys.
+func advisoryLockKey(args []*pgquery.Node) (advisoryKey, error) {
+	var ints []int64
+	for _, arg := range args {
+		v, err := evalExpr(arg, &tableDefinition{}, row{})
+		i, ok := v.(int64)
+		if err != nil || !ok {
+			return advisoryKey{}, fmt.Errorf("advisory lock keys must be integer constants")
+		}
+		ints = append(ints, i)
+	}
+
+	switch len(ints) {
+	case 1:
+		return advisoryKey{key1: ints[0]}, nil
+	case 2:
+		return advisoryKey{pair: true, key1: ints[0], key2: ints[1]}, nil
+	}
+	return advisoryKey{}, fmt.Errorf("advisory lock functions take one bigint or two int keys")
+}
+
+/*
+
+Handle `select pg_advisory_lock(...)` and the other advisory lock functions. The locks belong
+to the connection, so they are handled here instead of in the engine. Returns false for any
+other statement.
+
+*/
+
+func (pgs *pgServer) handleAdvisoryLockStmt(ctx context.Context, stmt *pgquery.Node) (bool, error) {
+	s := stmt.GetSelectStmt()
+	if s == nil || len(s.FromClause) > 0 || len(s.TargetList) != 1 {
+		return false, nil
+	}
+
+	fc := s.TargetList[0].GetResTarget().GetVal().GetFuncCall()
+	if fc == nil || len(fc.Funcname) == 0 {
+		return false, nil
+	}
+
+	name := fc.Funcname[len(fc.Funcname)-1].GetString_().GetStr()
+	var value any = ""
+	fieldType := "pg_catalog.bool"
+	switch name {
+	case "pg_advisory_lock", "pg_try_advisory_lock":
+		key, err := advisoryLockKey(fc.Args)
+		if err != nil {
+			return true, err
+		}
+
+		acquired, err := pgs.lockAdvisory(ctx, key, name == "pg_advisory_lock")
+		if err != nil {
+			return true, err
+		}
+
+		if name == "pg_advisory_lock" {
+			fieldType = "void"
+		} else {
+			value = acquired
+		}
+	case "pg_advisory_unlock":
+		key, err := advisoryLockKey(fc.Args)
+		if err != nil {
+			return true, err
+		}
+
+		released, err := pgs.unlockAdvisory(key)
+		if err != nil {
+			return true, err
+		}
+		value = released
+	case "pg_advisory_unlock_all":
+		if err := pgs.unlockAllAdvisory(); err != nil {
+			return true, err
+		}
+		fieldType = "void"
+	default:
+		return false, nil
+	}
+
+	return true, pgs.writePgResult(&pgResult{
+		fieldNames: []string{name},
+		fieldTypes: []string{fieldType},
+		rows:       [][]any{{value}},
+	}, "SELECT")
+}
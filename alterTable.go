@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+func (pe pgEngine) executeAlterTable(stmt *pgquery.AlterTableStmt) error {
+	for _, c := range stmt.Cmds {
+		cmd := c.GetAlterTableCmd()
+		switch cmd.Subtype {
+		case pgquery.AlterTableType_AT_AlterColumnType:
+			if err := pe.alterColumnType(stmt.Relation.Relname, cmd); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported ALTER TABLE command: %s", cmd.Subtype)
+		}
+	}
+
+	return nil
+}
+
+/*
+
+Change the type of a column, rewriting the existing values.
+
+Example:
+
+```sql
+alter table customer alter column age type text;
+alter table customer alter column age type int using age::int * 12;
+```
+
+A table can be bigger than a single transaction, so the rewrite runs in batches (see
+scanRowBatches) that commit one by one, and it is not atomic. A first pass only validates
+that every value can be converted, so a value that can't be fails the statement before
+anything is written. A second pass writes the converted values in the layouts the table
+keeps and the catalog is updated last. A batch of the second pass that fails, or a server
+that stops during it, leaves the rows of the batches before it converted and the others in
+the old type, with the catalog still at the old type.
+
+Partitioned tables rewrite every partition. Full text indexes over the column are updated
+in the same batches as the values.
+
+*/
+
+func (pe pgEngine) alterColumnType(tblName string, cmd *pgquery.AlterTableCmd) error {
+	tbl, err := pe.getTableDefinition(tblName)
+	if err != nil {
+		return err
+	}
+
+	columnName := cmd.Name
+	oldType, ok := tbl.columnType(columnName)
+	if !ok {
+		return &pgError{
+			Code:    sqlStateUndefinedColumn,
+			Message: fmt.Sprintf("column \"%s\" of relation \"%s\" does not exist", columnName, tblName),
+		}
+	}
+	// Note: the entries of the keys of the rows are in the encoding of the type, see primaryKey.go
+	if columnName == tbl.PrimaryKey {
+		return &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("cannot alter type of column \"%s\", it is the primary key of relation \"%s\"", columnName, tblName)}
+	}
+
+	cd := cmd.Def.GetColumnDef()
+	newType := typeNameString(cd.TypeName)
+	using := cd.RawDefault
+
+	// Note: values are converted to the base type of a domain and then checked against its constraints
+	castType := newType
+	newDomain, err := pe.lookupDomain(newType)
+	if err != nil {
+		return err
+	}
+	if newDomain != nil {
+		castType = newDomain.BaseType
+	}
+	newEnum, err := pe.lookupEnum(castType)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{tblName}
+	partitions, err := pe.getPartitionSpec(tblName)
+	if err != nil {
+		return err
+	}
+	if partitions != nil {
+		if partitions.Column == columnName {
+			return fmt.Errorf("cannot alter column \"%s\" because it is part of the partition key of relation \"%s\"", columnName, tblName)
+		}
+		for _, p := range partitions.Partitions {
+			tables = append(tables, p.Name)
+		}
+	}
+
+	convert := func(r row) (*string, error) {
+		var v any
+		var err error
+		if using != nil {
+			v, err = evalExpr(using, tbl, r)
+		} else if cell, ok := r[columnName]; ok {
+			v, err = cellValue(oldType, cell)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var s *string
+		if v != nil {
+			cast, err := castValue(v, castType)
+			if err != nil {
+				return nil, err
+			}
+			s = &cast
+		}
+
+		if newDomain != nil {
+			if err := newDomain.validate(s); err != nil {
+				return nil, err
+			}
+		}
+		if newEnum != nil && s != nil {
+			if _, err := newEnum.order(*s); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+
+	for _, name := range tables {
+		err = pe.scanRowBatches(name, func(tr fdb.Transaction, ids []string, rows []row) error {
+			for _, r := range rows {
+				if _, err := convert(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not alter column type: %w", err)
+		}
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	for _, name := range tables {
+		err = pe.scanRowBatches(name, func(tr fdb.Transaction, ids []string, rows []row) error {
+			var indexes []textIndex
+			for _, idx := range pe.getTextIndexes(tr, tblName) {
+				if idx.Column == columnName {
+					indexes = append(indexes, idx)
+				}
+			}
+
+			for i, r := range rows {
+				s, err := convert(r)
+				if err != nil {
+					return err
+				}
+				var typed any
+				if s != nil {
+					if typed, err = parseCell(castType, *s); err != nil {
+						return err
+					}
+				}
+
+				for _, idx := range indexes {
+					if err := pe.indexText(tr, tblName, idx, name, ids[i], r[columnName], false); err != nil {
+						return err
+					}
+					if s == nil {
+						continue
+					}
+					// Note: btree indexes hold the value in the order of its type, the others index its text
+					var value any = *s
+					if idx.Config == btreeIndexConfig {
+						value = typed
+					}
+					if err := pe.indexText(tr, tblName, idx, name, ids[i], value, true); err != nil {
+						return err
+					}
+				}
+
+				setCell(tr, tableDataSS, tbl, name, ids[i], columnName, encodeCell(typed))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not alter column type: %w", err)
+		}
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableSS := catalogDir.Sub("table")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, name := range tables {
+			writes(tr).Set(tableSS.Pack(tuple.Tuple{name, columnName}), []byte(newType))
+		}
+		pe.bumpCatalogVersion(tr)
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not alter column type: %w", err)
+	}
+
+	pe.catalogChanged()
+	return nil
+}
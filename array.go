@@ -0,0 +1,539 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Arrays of the other types, like int[] and text[].
+
+```sql
+create table post (title text, tags text[], scores int[]);
+insert into post values ('hello', '{intro,"first post"}', ARRAY[3, 5, 8]);
+select title, tags[1], scores[2:3] from post where 'intro' = ANY(tags);
+select unnest(tags) from post;
+```
+
+Values are written in the Postgres array text format, the elements separated by commas in
+braces. Elements are quoted when they are empty, hold special characters or spell NULL:
+
+```
+{intro,"first post",NULL}
+```
+
+Indexes start at 1 and an index outside of the array is NULL. An array of arrays is a
+multidimensional array, written as {{1,2},{3,4}}.
+
+Cells hold the element type and the elements, each encoded as a cell of its own:
+
+```
+data/table_data/post/r/72746a7f-727f-4e0a-88f1-d983fea5c158/scores: (("array", "pg_catalog.int4", (3, 5, 8)),)
+```
+
+*/
+
+type array struct {
+	elemType string
+	elems    []any
+}
+
+// The element type of an array type, `pg_catalog.int4[]` holds pg_catalog.int4.
+func arrayElemType(colType string) (string, bool) {
+	if !strings.HasSuffix(colType, "[]") {
+		return "", false
+	}
+	return strings.TrimSuffix(colType, "[]"), true
+}
+
+func isArrayType(colType string) bool {
+	_, ok := arrayElemType(colType)
+	return ok
+}
+
+// Split an array literal into its elements: strings, nil for NULL and []any for nested arrays.
+func parseArrayLiteral(s string) ([]any, error) {
+	p := &arrayParser{s: s}
+	elems, ok := p.parseArray()
+	p.skipSpace()
+	if !ok || p.pos < len(p.s) {
+		return nil, fmt.Errorf("malformed array literal: %q", s)
+	}
+	return elems, nil
+}
+
+type arrayParser struct {
+	s   string
+	pos int
+}
+
+func (p *arrayParser) skipSpace() {
+	for p.pos < len(p.s) && isArraySpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+func isArraySpace(c byte) bool {
+	return strings.IndexByte(" \t\n\r\v\f", c) >= 0
+}
+
+func (p *arrayParser) parseArray() ([]any, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.s) || p.s[p.pos] != '{' {
+		return nil, false
+	}
+	p.pos++
+
+	elems := []any{}
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		return elems, true
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, false
+		}
+
+		switch p.s[p.pos] {
+		case '{':
+			nested, ok := p.parseArray()
+			if !ok {
+				return nil, false
+			}
+			elems = append(elems, nested)
+		case '"':
+			s, ok := p.parseQuoted()
+			if !ok {
+				return nil, false
+			}
+			elems = append(elems, s)
+		default:
+			s, quoted := p.parseUnquoted()
+			switch {
+			case s == "" && !quoted:
+				return nil, false
+			case strings.EqualFold(s, "NULL") && !quoted:
+				elems = append(elems, nil)
+			default:
+				elems = append(elems, s)
+			}
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, false
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return elems, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (p *arrayParser) parseQuoted() (string, bool) {
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; c {
+		case '"':
+			p.pos++
+			return b.String(), true
+		case '\\':
+			p.pos++
+			if p.pos < len(p.s) {
+				b.WriteByte(p.s[p.pos])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// An unquoted element ends at a comma or brace, surrounding whitespace isn't part of it. Escaped
+// characters count as quoted, so \NULL is the string NULL.
+func (p *arrayParser) parseUnquoted() (string, bool) {
+	var b strings.Builder
+	var escaped bool
+	end := 0
+	for ; p.pos < len(p.s); p.pos++ {
+		c := p.s[p.pos]
+		if c == ',' || c == '}' || c == '{' || c == '"' {
+			break
+		}
+		if c == '\\' && p.pos+1 < len(p.s) {
+			p.pos++
+			c = p.s[p.pos]
+			escaped = true
+			b.WriteByte(c)
+			end = b.Len()
+			continue
+		}
+		b.WriteByte(c)
+		if !isArraySpace(c) {
+			end = b.Len()
+		}
+	}
+	return b.String()[:end], escaped
+}
+
+// The value of an array or an array literal as an array of colType, with every element converted to the element type.
+func arrayOf(v any, colType string) (array, error) {
+	elemType, _ := arrayElemType(colType)
+
+	var elems []any
+	switch value := v.(type) {
+	case array:
+		elems = value.elems
+	case string:
+		var err error
+		if elems, err = parseArrayLiteral(value); err != nil {
+			return array{}, err
+		}
+	default:
+		return array{}, fmt.Errorf("cannot cast type %T to %s", v, sqlTypeName(colType))
+	}
+
+	converted := make([]any, len(elems))
+	for i, e := range elems {
+		switch value := e.(type) {
+		case nil:
+			continue
+		case []any:
+			nested, err := arrayOf(array{elems: value}, colType)
+			if err != nil {
+				return array{}, err
+			}
+			converted[i] = nested
+		case array:
+			nested, err := arrayOf(value, colType)
+			if err != nil {
+				return array{}, err
+			}
+			converted[i] = nested
+		default:
+			s, err := castValue(e, elemType)
+			if err != nil {
+				return array{}, err
+			}
+			if converted[i], err = parseCell(elemType, s); err != nil {
+				return array{}, err
+			}
+		}
+	}
+	return array{elemType: elemType, elems: converted}, nil
+}
+
+func castArray(v any, colType string) (string, error) {
+	a, err := arrayOf(v, colType)
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
+}
+
+func (a array) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, e := range a.elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		switch value := e.(type) {
+		case nil:
+			b.WriteString("NULL")
+		case array:
+			b.WriteString(value.String())
+		default:
+			b.WriteString(quoteArrayElement(arrayElementText(value)))
+		}
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// The text of an element, which is the output of its type, so booleans are t and f and char(n) keeps its padding.
+func arrayElementText(e any) string {
+	switch value := e.(type) {
+	case bool:
+		return boolString(value)
+	case bpchar:
+		return string(value)
+	}
+	s, _ := castValue(e, "text")
+	return s
+}
+
+func quoteArrayElement(s string) string {
+	needsQuotes := s == "" || strings.EqualFold(s, "NULL")
+	for i := 0; i < len(s) && !needsQuotes; i++ {
+		needsQuotes = strings.IndexByte(`{},"\`, s[i]) >= 0 || isArraySpace(s[i])
+	}
+	if !needsQuotes {
+		return s
+	}
+
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// The elements of an array and of the arrays nested in it, in order, which is what unnest returns.
+func (a array) flatten() []any {
+	var elems []any
+	for _, e := range a.elems {
+		if nested, ok := e.(array); ok {
+			elems = append(elems, nested.flatten()...)
+			continue
+		}
+		elems = append(elems, e)
+	}
+	return elems
+}
+
+func isArray(v any) bool {
+	_, ok := v.(array)
+	return ok
+}
+
+// ARRAY[...], the type of the array is that of its first element that isn't NULL.
+func evalArrayExpr(ae *pgquery.A_ArrayExpr, tbl *tableDefinition, r row) (any, error) {
+	a := array{elemType: "text", elems: []any{}}
+	typed := false
+	for _, n := range ae.Elements {
+		v, err := evalExpr(n, tbl, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if v != nil && !typed {
+			typed = true
+			a.elemType = valueType(v)
+			if nested, ok := v.(array); ok {
+				a.elemType = nested.elemType
+			}
+		}
+		a.elems = append(a.elems, v)
+	}
+	return a, nil
+}
+
+// Subscripts of an array, a[i] for an element and a[i:j] for a slice.
+func evalIndirection(ind *pgquery.A_Indirection, tbl *tableDefinition, r row) (any, error) {
+	v, err := evalExpr(ind.Arg, tbl, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range ind.Indirection {
+		// Note: a name selects a field of a record, `(home).zip`
+		if field := n.GetString_(); field != nil {
+			if v == nil {
+				return nil, nil
+			}
+			if v, err = recordField(v, field.GetStr()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		indices := n.GetAIndices()
+		if indices == nil {
+			return nil, fmt.Errorf("unsupported indirection: %s", n)
+		}
+		if v == nil {
+			return nil, nil
+		}
+
+		a, ok := v.(array)
+		if !ok {
+			return nil, fmt.Errorf("cannot subscript type %T because it is not an array", v)
+		}
+
+		upper, isNull, err := arraySubscript(indices.Uidx, int64(len(a.elems)), tbl, r)
+		if err != nil || isNull {
+			return nil, err
+		}
+
+		if !indices.IsSlice {
+			if upper < 1 || upper > int64(len(a.elems)) {
+				return nil, nil
+			}
+			v = a.elems[upper-1]
+			continue
+		}
+
+		lower, isNull, err := arraySubscript(indices.Lidx, 1, tbl, r)
+		if err != nil || isNull {
+			return nil, err
+		}
+
+		lower, upper = max(lower, 1), min(upper, int64(len(a.elems)))
+		if lower > upper {
+			v = array{elemType: a.elemType, elems: []any{}}
+			continue
+		}
+		v = array{elemType: a.elemType, elems: a.elems[lower-1 : upper]}
+	}
+
+	return v, nil
+}
+
+// The value of a subscript, or bound when it is left out of a slice. True if the subscript is NULL.
+func arraySubscript(n *pgquery.Node, bound int64, tbl *tableDefinition, r row) (int64, bool, error) {
+	if n == nil {
+		return bound, false, nil
+	}
+
+	v, err := evalExpr(n, tbl, r)
+	if err != nil || v == nil {
+		return 0, true, err
+	}
+
+	i, ok := v.(int64)
+	if !ok {
+		return 0, false, fmt.Errorf("array subscript must have type integer")
+	}
+	return i, false, nil
+}
+
+/*
+
+`x op ANY (array)` and `x op ALL (array)`. Like Postgres, if no element decides the result and
+an element or x is NULL, the result is NULL.
+
+*/
+
+func evalArrayOp(a *pgquery.A_Expr, tbl *tableDefinition, r row) (any, error) {
+	left, err := evalExpr(a.Lexpr, tbl, r)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := evalExpr(a.Rexpr, tbl, r)
+	if err != nil || right == nil {
+		return nil, err
+	}
+
+	// Note: a string constant is an array literal of text, its elements compare as constants do
+	if _, ok := right.(string); ok {
+		if right, err = arrayOf(right, "text[]"); err != nil {
+			return nil, err
+		}
+	}
+	elems, ok := right.(array)
+	if !ok {
+		return nil, fmt.Errorf("op ANY/ALL (array) requires array on right side")
+	}
+
+	coll, err := operatorCollation(a)
+	if err != nil {
+		return nil, err
+	}
+
+	isAny := a.Kind == pgquery.A_Expr_Kind_AEXPR_OP_ANY
+	var sawNull bool
+	for _, e := range elems.elems {
+		if left == nil || e == nil {
+			sawNull = true
+			continue
+		}
+
+		v, err := binaryOp(a.Name[0].GetString_().GetStr(), left, e, coll)
+		if err != nil {
+			return nil, err
+		}
+
+		b, ok := v.(bool)
+		if v != nil && !ok {
+			return nil, fmt.Errorf("op ANY/ALL (array) requires operator to yield boolean")
+		}
+
+		switch {
+		case v == nil:
+			sawNull = true
+		case b == isAny:
+			return b, nil
+		}
+	}
+
+	if sawNull {
+		return nil, nil
+	}
+	return !isAny, nil
+}
+
+// Compare arrays element by element, NULL elements are larger than any other. String constants are read as array literals.
+func cmpArray(left, right any, coll *collation) (int, error) {
+	operand := func(v any) (array, error) {
+		switch value := v.(type) {
+		case array:
+			return value, nil
+		case string:
+			return arrayOf(value, "text[]")
+		}
+		return array{}, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(l.elems) && i < len(r.elems); i++ {
+		le, re := l.elems[i], r.elems[i]
+		switch {
+		case le == nil && re == nil:
+			continue
+		case le == nil:
+			return 1, nil
+		case re == nil:
+			return -1, nil
+		}
+
+		if c, err := compareValues(le, re, coll); err != nil || c != 0 {
+			return c, err
+		}
+	}
+	return cmpInt(int64(len(l.elems)), int64(len(r.elems))), nil
+}
+
+func encodeArray(a array) tuple.Tuple {
+	elems := tuple.Tuple{}
+	for _, e := range a.elems {
+		elems = append(elems, cellElement(e))
+	}
+	return tuple.Tuple{"array", a.elemType, elems}
+}
+
+func decodeArray(t tuple.Tuple) (array, bool) {
+	if len(t) != 3 || t[0] != "array" {
+		return array{}, false
+	}
+	elemType, ok := t[1].(string)
+	elems, elemsOk := t[2].(tuple.Tuple)
+	if !ok || !elemsOk {
+		return array{}, false
+	}
+
+	a := array{elemType: elemType, elems: []any{}}
+	for _, e := range elems {
+		a.elems = append(a.elems, decodeCellElement(e))
+	}
+	return a, true
+}
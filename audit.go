@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Audit log.
+
+With -audit-log every connection, authentication, statement and disconnection is recorded as a
+JSON object, one a line, appended to the file -audit-log names:
+
+```bash
+$ ./fakegres-fdb -audit-log=/var/log/fakegres/audit.jsonl
+```
+
+```
+{"time":"2024-03-02T10:00:00.1Z","event":"connect","pid":3,"client":"203.0.113.7:51234"}
+{"time":"2024-03-02T10:00:00.2Z","event":"authenticate","pid":3,"client":"203.0.113.7:51234","user":"alice","database":"postgres","method":"md5"}
+{"time":"2024-03-02T10:00:01.5Z","event":"statement","pid":3,"client":"203.0.113.7:51234","user":"alice","database":"postgres","application_name":"psql","statement":"select * from person where name = 'garry'"}
+{"time":"2024-03-02T10:00:02.0Z","event":"statement","pid":3,...,"statement":"select * from persn","sqlstate":"42P01","error":"relation \"persn\" does not exist"}
+{"time":"2024-03-02T10:00:09.0Z","event":"disconnect","pid":3,...,"duration_ms":8800}
+```
+
+A failed authentication has the error it failed with. A statement is recorded once it ran, with
+the error it failed with if it did, and the statement of a portal has its parameters in it.
+-audit-redact replaces the constants of statements with $1, $2... so the values clients send
+aren't in the log. Error messages can quote values too, like the key of a duplicate row, so the
+failed statements only have their SQLSTATE:
+
+```
+{"time":"2024-03-02T10:00:01.5Z","event":"statement",...,"statement":"select * from person where name = $1"}
+{"time":"2024-03-02T10:00:02.0Z","event":"statement",...,"statement":"insert into person values ($1, $2)","sqlstate":"23505"}
+```
+
+-audit-log=fdb records the events in FoundationDB instead, keyed by the versionstamp of the
+transaction that wrote them so they read back in the order they happened, for every database of
+the server together:
+
+```
+audit/<versionstamp>: {"time":"2024-03-02T10:00:00.1Z","event":"connect",...}
+```
+
+An event that can't be recorded is logged, the session goes on.
+
+*/
+
+type auditEvent struct {
+	Time            time.Time `json:"time"`
+	Event           string    `json:"event"`
+	Pid             uint32    `json:"pid"`
+	Client          string    `json:"client,omitempty"`
+	User            string    `json:"user,omitempty"`
+	Database        string    `json:"database,omitempty"`
+	ApplicationName string    `json:"application_name,omitempty"`
+	Method          string    `json:"method,omitempty"`
+	Statement       string    `json:"statement,omitempty"`
+	SQLState        string    `json:"sqlstate,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	DurationMs      int64     `json:"duration_ms,omitempty"`
+}
+
+type auditLogger struct {
+	mu sync.Mutex
+	// The file events are appended to, nil when they are recorded in the database
+	file   *os.File
+	db     fdb.Database
+	redact bool
+}
+
+// The audit log of the server, nil without -audit-log.
+var auditLog *auditLogger
+
+func openAuditLog(db fdb.Database, cfg config) *auditLogger {
+	if cfg.auditLog == "" {
+		return nil
+	}
+	al := &auditLogger{db: db, redact: cfg.auditRedact}
+	if cfg.auditLog == "fdb" {
+		return al
+	}
+
+	f, err := os.OpenFile(cfg.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("could not open audit log: %s", err)
+	}
+	al.file = f
+	return al
+}
+
+func (al *auditLogger) record(e auditEvent) error {
+	// Note: statements are kept as they were sent, without escaping < > & for HTML
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+
+	if al.file != nil {
+		al.mu.Lock()
+		defer al.mu.Unlock()
+		_, err := al.file.Write(buf.Bytes())
+		return err
+	}
+	line := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	auditDir, err := directory.CreateOrOpen(al.db, []string{"audit"}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = al.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		key, err := auditDir.PackWithVersionstamp(tuple.Tuple{tuple.IncompleteVersionstamp(0)})
+		if err != nil {
+			return nil, err
+		}
+		tr.SetVersionstampedKey(key, line)
+		return nil, nil
+	})
+	return err
+}
+
+// Record an event of the session, filling in who the session is.
+func (pgs *pgServer) audit(e auditEvent) {
+	if auditLog == nil {
+		return
+	}
+
+	pgs.activityMu.Lock()
+	user := pgs.activity.user
+	pgs.activityMu.Unlock()
+
+	e.Time = time.Now().UTC()
+	e.Pid = pgs.pid
+	e.Client = pgs.conn.RemoteAddr().String()
+	if e.User == "" {
+		e.User = user
+	}
+	if e.Database == "" {
+		e.Database = pgs.database
+	}
+	e.ApplicationName = pgs.applicationName()
+	if err := auditLog.record(e); err != nil {
+		log.Printf("could not record audit event %s of backend %d: %s", e.Event, pgs.pid, err)
+	}
+}
+
+func (pgs *pgServer) auditAuthentication(user string, err error) {
+	if auditLog == nil {
+		return
+	}
+	e := auditEvent{Event: "authenticate", User: user, Method: pgs.cfg.auth}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	pgs.audit(e)
+}
+
+// Record a statement that ran, with the error it failed with.
+func (pgs *pgServer) auditStatement(query string, err error) {
+	if auditLog == nil {
+		return
+	}
+
+	e := auditEvent{Event: "statement", Statement: query}
+	if auditLog.redact {
+		// Note: a statement that doesn't parse may have constants anywhere in it, so none of it is recorded
+		normalized, normalizeErr := pgquery.Normalize(query)
+		if normalizeErr != nil {
+			normalized = ""
+		}
+		e.Statement = normalized
+	}
+	if err != nil {
+		e.SQLState = internalErrorCode
+		e.Error = err.Error()
+		var pgErr *pgError
+		if errors.As(err, &pgErr) {
+			e.SQLState = pgErr.Code
+			e.Error = pgErr.Message
+		}
+		if auditLog.redact {
+			e.Error = ""
+		}
+	}
+	pgs.audit(e)
+}
+
+func (pgs *pgServer) auditDisconnect(start time.Time) {
+	pgs.audit(auditEvent{Event: "disconnect", DurationMs: time.Since(start).Milliseconds()})
+}
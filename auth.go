@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/jackc/pgproto3/v2"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Password authentication.
+
+With -auth=password or -auth=md5 every session has to give the password of the user it
+starts as, after the StartupMessage and before AuthenticationOk. password asks for the
+password in clear text, which should only be used over TLS. md5 sends a random salt and
+expects the password hashed the way Postgres does, so it never travels in the clear:
+
+```
+"md5" + md5hex(md5hex(password + user) + salt)
+```
+
+The default, trust, lets everyone in without a password. cert takes a client certificate
+instead of a password, see clientCert.go.
+
+Users come from -users, a comma separated list of user:password, from -users-file, a file with
+a user:password per line, and from the roles in the catalog. -users-file keeps the passwords
+out of the command line, which other users of the machine can see. Like Postgres, passwords of roles are only kept as the md5 of the password and the
+name of the role:
+
+```sql
+create user alice with password 'secret';
+drop user alice;
+```
+
+```
+catalog/role/alice: md54a0a68b43b6cd5cf266fa02f196e2371
+```
+
+*/
+
+var authMethods = []string{"trust", "password", "md5", "cert"}
+
+// The users of -users and -users-file, by name, with their password hashed like the passwords of roles.
+var configuredUsers = map[string]string{}
+
+func checkAuthMethod(method string) error {
+	for _, m := range authMethods {
+		if m == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown authentication method %q, expected one of %s", method, strings.Join(authMethods, ", "))
+}
+
+func parseUsers(s string) (map[string]string, error) {
+	users := map[string]string{}
+	if s == "" {
+		return users, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		name, password, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid user %q, expected user:password", entry)
+		}
+		users[name] = md5Password(name, password)
+	}
+	return users, nil
+}
+
+// Read the users of -users-file, skipping empty lines and comments.
+func readUsersFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read users file: %w", err)
+	}
+
+	users := map[string]string{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Note: the line isn't quoted in the error, it holds a password
+		name, password, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid user on line %d of the users file, expected user:password", i+1)
+		}
+		users[name] = md5Password(name, password)
+	}
+	return users, nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// The password as Postgres stores it in pg_authid.
+func md5Password(user, password string) string {
+	return "md5" + md5Hex(password+user)
+}
+
+/*
+
+Ask the client for the password of the user and check it. Returns an error, after sending a
+FATAL ErrorResponse to the client, if the password is wrong or the user has none.
+
+*/
+
+func (pgs *pgServer) authenticate(pgconn *pgproto3.Backend, user string) error {
+	switch pgs.cfg.auth {
+	case "trust":
+		return nil
+	case "cert":
+		return pgs.authenticateCert(user)
+	}
+
+	var salt [4]byte
+	var request []byte
+	if pgs.cfg.auth == "md5" {
+		if _, err := rand.Read(salt[:]); err != nil {
+			return fmt.Errorf("could not generate salt: %w", err)
+		}
+		request = (&pgproto3.AuthenticationMD5Password{Salt: salt}).Encode(nil)
+	} else {
+		request = (&pgproto3.AuthenticationCleartextPassword{}).Encode(nil)
+	}
+	if err := pgs.write(request); err != nil {
+		return fmt.Errorf("error sending authentication request: %w", err)
+	}
+
+	msg, err := pgconn.Receive()
+	if err != nil {
+		return fmt.Errorf("error receiving password: %w", err)
+	}
+	pm, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return fmt.Errorf("expected password message, got %#v", msg)
+	}
+
+	stored, err := newPgEngine(pgs.db, defaultDatabase).getRolePassword(user)
+	if err != nil {
+		return err
+	}
+
+	var expected string
+	if pgs.cfg.auth == "md5" {
+		expected = "md5" + md5Hex(strings.TrimPrefix(stored, "md5")+string(salt[:]))
+	} else {
+		expected = stored
+		pm.Password = md5Password(user, pm.Password)
+	}
+
+	if stored == "" || subtle.ConstantTimeCompare([]byte(pm.Password), []byte(expected)) != 1 {
+		if err := pgs.writeFatal(sqlStateInvalidPassword, fmt.Sprintf("password authentication failed for user \"%s\"", user)); err != nil {
+			return err
+		}
+		return fmt.Errorf("password authentication failed for user %s", user)
+	}
+
+	return nil
+}
+
+// The hashed password of a user of -users or a role in the catalog, empty if there is none.
+// Note: roles are in the root catalog, whatever the database of the engine
+func (pe pgEngine) getRolePassword(name string) (string, error) {
+	if password, ok := configuredUsers[name]; ok {
+		return password, nil
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, []string{"catalog"}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	roleSS := catalogDir.Sub("role")
+
+	password, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		return string(rtr.Get(roleSS.Pack(tuple.Tuple{name})).MustGet()), nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not get role: %w", err)
+	}
+
+	return password.(string), nil
+}
+
+func (pe pgEngine) executeCreateRole(stmt *pgquery.CreateRoleStmt) error {
+	var password string
+	for _, o := range stmt.Options {
+		d := o.GetDefElem()
+		if d.Defname != "password" {
+			continue
+		}
+		// Note: PASSWORD NULL leaves the role without a password
+		if s := d.Arg.GetString_(); s != nil {
+			password = md5Password(stmt.Role, s.Str)
+		}
+	}
+
+	// Note: roles are outside of tenants, so they are written in a transaction of their own with -tenants
+	db := pe.roleTransactor()
+	catalogDir, err := directory.CreateOrOpen(db, []string{"catalog"}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	roleKey := catalogDir.Sub("role").Pack(tuple.Tuple{stmt.Role})
+
+	_, err = db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if tr.Get(roleKey).MustGet() != nil {
+			return nil, &pgError{Code: sqlStateDuplicateObject, Message: fmt.Sprintf("role \"%s\" already exists", stmt.Role)}
+		}
+
+		writes(tr).Set(roleKey, []byte(password))
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not create role: %w", err)
+	}
+
+	return nil
+}
+
+func (pe pgEngine) executeDropRole(stmt *pgquery.DropRoleStmt) error {
+	// Note: roles are outside of tenants, so they are written in a transaction of their own with -tenants
+	db := pe.roleTransactor()
+	catalogDir, err := directory.CreateOrOpen(db, []string{"catalog"}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	roleSS := catalogDir.Sub("role")
+
+	_, err = db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, r := range stmt.Roles {
+			name := r.GetRoleSpec().Rolename
+			key := roleSS.Pack(tuple.Tuple{name})
+			if tr.Get(key).MustGet() == nil {
+				if stmt.MissingOk {
+					pe.raiseNotice(sqlStateSuccessfulCompletion, "role \"%s\" does not exist, skipping", name)
+					continue
+				}
+				return nil, &pgError{Code: sqlStateUndefinedObject, Message: fmt.Sprintf("role \"%s\" does not exist", name)}
+			}
+
+			writes(tr).Clear(key)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not drop role: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+/*
+
+Parameters in binary format.
+
+Bind gives a format for every parameter, drivers like pgx send the types they know in the
+binary format by default. Binary parameters are decoded by the type of the parameter into
+their text form, and are bound like text parameters from there on:
+
+```
+int4         00 00 00 2a                ->  42
+bool         01                         ->  true
+float8       40 09 21 fb 54 44 2d 18    ->  3.141592653589793
+timestamp    microseconds since 2000-01-01 00:00:00
+date         days since 2000-01-01
+uuid         the 16 bytes of the uuid
+```
+
+Parameters of other types, or of unknown type, can only be sent as text.
+
+*/
+
+const binaryFormatCode = 1
+
+// The epoch of binary dates and timestamps.
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Decode parameter n, counted from 1, of a Bind from the binary format of its type into its text form.
+func decodeBinaryParam(n int, oid uint32, data []byte) (string, error) {
+	invalid := &pgError{Code: sqlStateInvalidBinaryRepresentation, Message: fmt.Sprintf("incorrect binary data format in bind parameter %d", n)}
+	fixedLen := func(size int) bool { return len(data) == size }
+
+	switch oid {
+	case 16: // bool
+		if !fixedLen(1) {
+			return "", invalid
+		}
+		return boolString(data[0] != 0), nil
+	case 21: // int2
+		if !fixedLen(2) {
+			return "", invalid
+		}
+		return strconv.FormatInt(int64(int16(binary.BigEndian.Uint16(data))), 10), nil
+	case 23: // int4
+		if !fixedLen(4) {
+			return "", invalid
+		}
+		return strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(data))), 10), nil
+	case 20: // int8
+		if !fixedLen(8) {
+			return "", invalid
+		}
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(data)), 10), nil
+	case 700: // float4
+		if !fixedLen(4) {
+			return "", invalid
+		}
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))), 'g', -1, 32), nil
+	case 701: // float8
+		if !fixedLen(8) {
+			return "", invalid
+		}
+		return strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(data)), 'g', -1, 64), nil
+	case 25, 1043, 1042, 114: // text, varchar, bpchar, json
+		if !utf8.Valid(data) {
+			return "", invalid
+		}
+		return string(data), nil
+	case 3802: // jsonb, a version byte before the text
+		if len(data) < 1 || data[0] != 1 || !utf8.Valid(data[1:]) {
+			return "", invalid
+		}
+		return string(data[1:]), nil
+	case 2950: // uuid
+		u, err := uuid.FromBytes(data)
+		if err != nil {
+			return "", invalid
+		}
+		return u.String(), nil
+	case 1082: // date
+		if !fixedLen(4) {
+			return "", invalid
+		}
+		days := int32(binary.BigEndian.Uint32(data))
+		switch days {
+		case math.MaxInt32:
+			return "infinity", nil
+		case math.MinInt32:
+			return "-infinity", nil
+		}
+		return postgresEpoch.AddDate(0, 0, int(days)).Format("2006-01-02"), nil
+	case 1114, 1184: // timestamp, timestamptz
+		if !fixedLen(8) {
+			return "", invalid
+		}
+		micros := int64(binary.BigEndian.Uint64(data))
+		switch micros {
+		case math.MaxInt64:
+			return "infinity", nil
+		case math.MinInt64:
+			return "-infinity", nil
+		}
+		t := time.Unix(postgresEpoch.Unix()+micros/1e6, micros%1e6*1e3).UTC()
+		// Note: timestamptz is sent in UTC
+		if oid == 1184 {
+			return t.Format("2006-01-02 15:04:05.999999-07"), nil
+		}
+		return t.Format("2006-01-02 15:04:05.999999"), nil
+	}
+
+	if oid == 0 {
+		return "", &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("bind parameter %d of unknown type can't be in binary format", n)}
+	}
+	return "", &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("binary format for bind parameter %d of type %d is not supported", n, oid)}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Bulk loads.
+
+COPY FROM STDIN loads rows sent by the client in the text format of Postgres, a line per row
+with its columns separated by tabs and \N for NULL:
+
+```sql
+copy person (name, age) from stdin;
+garry	14
+ted	\N
+\.
+COPY 2
+```
+
+The rows don't go through SQL: their values are converted to the types of the columns from their
+text, and written like the rows of an INSERT (see insertValues), in batches of transactions (see
+writeBatch.go) that maintain the indexes, row counts and primary keys of the table. The rows of
+a COPY are read before any is written, so a row that isn't valid writes none of them. COPYs of at
+least bulkInsertRows rows run at batch priority, see priority.go.
+
+The same load is the bulkInsert method of the engine, for rows a program has at hand. COPY takes
+the DELIMITER and NULL options, other formats than text and COPY from files aren't supported.
+COPY FROM can't run in a transaction block, PREPARE TRANSACTION couldn't replay its rows. COPY TO
+writes Parquet files, see parquetExport.go.
+
+*/
+
+/*
+
+Insert rows of values into the table without parsing SQL. The values of a row are those of the
+columns of the table in their order, nil for NULL, text values are converted to the types of the
+columns. Returns the number of rows inserted.
+
+*/
+
+func (pe pgEngine) bulkInsert(table string, rows [][]any) (int, error) {
+	tbl, err := pe.getTableDefinition(table)
+	if err != nil {
+		return 0, err
+	}
+	for _, values := range rows {
+		if len(values) > len(tbl.ColumnNames) {
+			return 0, &pgError{Code: sqlStateBadCopyFileFormat, Message: "extra data after last expected column"}
+		}
+	}
+	return pe.insertValues(tbl, rows, false)
+}
+
+// The options of a COPY FROM STDIN.
+type copyOptions struct {
+	delimiter string
+	null      string
+}
+
+func copyOptionsOf(stmt *pgquery.CopyStmt) (copyOptions, error) {
+	options := copyOptions{delimiter: "\t", null: `\N`}
+	for _, o := range stmt.Options {
+		de := o.GetDefElem()
+		value := de.GetArg().GetString_().GetStr()
+		switch de.Defname {
+		case "format":
+			if value != "text" {
+				return options, &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("COPY format \"%s\" is not supported", value)}
+			}
+		case "delimiter":
+			if len(value) != 1 || value == "\\" || value == "\n" || value == "\r" {
+				return options, &pgError{Code: sqlStateFeatureNotSupported, Message: "COPY delimiter must be a single one-byte character"}
+			}
+			options.delimiter = value
+		case "null":
+			options.null = value
+		default:
+			return options, &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("COPY option \"%s\" is not supported", de.Defname)}
+		}
+	}
+	return options, nil
+}
+
+func (pgs *pgServer) handleCopyStmt(ctx context.Context, stmt *pgquery.Node) (bool, error) {
+	cs := stmt.GetCopyStmt()
+	if cs == nil {
+		return false, nil
+	}
+
+	if !cs.IsFrom {
+		return true, pgs.handleCopyTo(ctx, cs)
+	}
+	if cs.IsProgram || cs.Filename != "" || cs.Relation == nil || cs.WhereClause != nil {
+		return true, &pgError{Code: sqlStateFeatureNotSupported, Message: "only COPY table FROM STDIN is supported"}
+	}
+	if pgs.tx != nil {
+		return true, &pgError{Code: sqlStateActiveSQLTransaction, Message: "COPY FROM STDIN cannot run inside a transaction block"}
+	}
+	options, err := copyOptionsOf(cs)
+	if err != nil {
+		return true, err
+	}
+
+	pe := pgs.engine.withTransactor(pgs.transactor()).withContext(ctx)
+	tbl, err := pe.getTableDefinition(cs.Relation.Relname)
+	if err != nil {
+		return true, err
+	}
+	columns, err := copyColumns(cs, tbl)
+	if err != nil {
+		return true, err
+	}
+
+	data, err := pgs.receiveCopyData(len(columns))
+	if err != nil {
+		return true, err
+	}
+	text, err := pgs.clientEncoding().decode(data)
+	if err != nil {
+		return true, err
+	}
+
+	var rows [][]any
+	for lineNumber, line := range copyLines(text) {
+		values, err := copyRow(tbl, columns, copyFields(line, options), lineNumber+1)
+		if err != nil {
+			return true, err
+		}
+		rows = append(rows, values)
+	}
+
+	copied, err := pe.bulkInsert(tbl.Name, rows)
+	if err != nil {
+		return true, err
+	}
+	pgs.done(nil, fmt.Sprintf("COPY %d", copied))
+	return true, nil
+}
+
+// The columns of the table the fields of the rows of a COPY are for, in their order.
+func copyColumns(cs *pgquery.CopyStmt, tbl *tableDefinition) ([]int, error) {
+	// Note: the columns of the rows are those listed, in their order, the others are NULL
+	columns := make([]int, 0, len(tbl.ColumnNames))
+	for _, a := range cs.Attlist {
+		name := a.GetString_().GetStr()
+		columnIndex := -1
+		for i, c := range tbl.ColumnNames {
+			if c == name {
+				columnIndex = i
+			}
+		}
+		if columnIndex < 0 {
+			return nil, &pgError{Code: sqlStateUndefinedColumn, Message: fmt.Sprintf("column \"%s\" of relation \"%s\" does not exist", name, tbl.Name)}
+		}
+		columns = append(columns, columnIndex)
+	}
+	if len(cs.Attlist) == 0 {
+		for i := range tbl.ColumnNames {
+			columns = append(columns, i)
+		}
+	}
+	return columns, nil
+}
+
+// The values of a row of the table from the fields of a line of COPY data, for the columns of copyColumns.
+func copyRow(tbl *tableDefinition, columns []int, fields []*string, lineNumber int) ([]any, error) {
+	if len(fields) > len(columns) {
+		return nil, &pgError{Code: sqlStateBadCopyFileFormat, Message: "extra data after last expected column", Detail: fmt.Sprintf("COPY %s, line %d", tbl.Name, lineNumber)}
+	}
+	if len(fields) < len(columns) {
+		return nil, &pgError{Code: sqlStateBadCopyFileFormat, Message: fmt.Sprintf("missing data for column \"%s\"", tbl.ColumnNames[columns[len(fields)]]), Detail: fmt.Sprintf("COPY %s, line %d", tbl.Name, lineNumber)}
+	}
+
+	values := make([]any, len(tbl.ColumnNames))
+	for i, f := range fields {
+		if f != nil {
+			values[columns[i]] = *f
+		}
+	}
+	return values, nil
+}
+
+// Ask the client for the data of a COPY FROM STDIN of rows of the number of columns, and receive it up to its CopyDone.
+func (pgs *pgServer) receiveCopyData(columns int) (string, error) {
+	response := &pgproto3.CopyInResponse{OverallFormat: 0, ColumnFormatCodes: make([]uint16, columns)}
+	if err := pgs.write(response.Encode(nil)); err != nil {
+		return "", err
+	}
+
+	var data strings.Builder
+	for {
+		msg, err := pgs.receive(pgs.backend)
+		if err != nil {
+			return "", err
+		}
+		switch m := msg.(type) {
+		case *pgproto3.CopyData:
+			// Note: the data of the message is only valid until the next one is received
+			data.Write(m.Data)
+		case *pgproto3.CopyDone:
+			return data.String(), nil
+		case *pgproto3.CopyFail:
+			return "", &pgError{Code: sqlStateQueryCanceled, Message: fmt.Sprintf("COPY from stdin failed: %s", m.Message)}
+		case *pgproto3.Flush, *pgproto3.Sync:
+			// Note: like Postgres, Flush and Sync are ignored during a COPY
+		default:
+			return "", &pgError{Code: sqlStateProtocolViolation, Message: fmt.Sprintf("unexpected message type during COPY from stdin: %T", msg)}
+		}
+	}
+}
+
+// The lines of the rows of COPY data, up to the end-of-data marker \. if there is one.
+func copyLines(data string) []string {
+	lines := strings.Split(data, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if line == `\.` {
+			return lines[:i]
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// The fields of a line of COPY data in the text format, nil for NULL.
+func copyFields(line string, options copyOptions) []*string {
+	var fields []*string
+	start := 0
+	for i := 0; i <= len(line); i++ {
+		if i+1 < len(line) && line[i] == '\\' {
+			i++
+			continue
+		}
+		if i < len(line) && line[i] != options.delimiter[0] {
+			continue
+		}
+
+		// Note: the NULL marker is matched before escapes are applied, like in Postgres
+		raw := line[start:i]
+		if raw == options.null {
+			fields = append(fields, nil)
+		} else {
+			value := copyUnescape(raw)
+			fields = append(fields, &value)
+		}
+		start = i + 1
+	}
+	return fields
+}
+
+// A value as a field of COPY data, with backslash escapes for the characters that end fields and lines.
+func copyEscape(value string) string {
+	return copyEscaper.Replace(value)
+}
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// The value of a field of COPY data, with its backslash escapes applied.
+func copyUnescape(raw string) string {
+	if !strings.Contains(raw, `\`) {
+		return raw
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' || i+1 == len(raw) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = raw[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// Note: up to three octal digits
+			v := c - '0'
+			for n := 1; n < 3 && i+1 < len(raw) && raw[i+1] >= '0' && raw[i+1] <= '7'; n++ {
+				i++
+				v = v*8 + raw[i] - '0'
+			}
+			b.WriteByte(v)
+		case 'x':
+			// Note: up to two hex digits, \x without any is an x
+			v, n := byte(0), 0
+			for ; n < 2 && i+1 < len(raw) && hexDigit(raw[i+1]) >= 0; n++ {
+				i++
+				v = v*16 + byte(hexDigit(raw[i]))
+			}
+			if n == 0 {
+				b.WriteByte('x')
+			} else {
+				b.WriteByte(v)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// The value of a hex digit, -1 if it isn't one.
+func hexDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+Query cancellation.
+
+Every session gets a process id and a random secret key, sent to the client in BackendKeyData
+at startup. To cancel a query the client opens a new connection and sends a CancelRequest with
+both instead of a StartupMessage. If they match a live session, the query it is running is
+canceled and fails with 57014:
+
+```
+select * from big_table;
+^C
+ERROR:  canceling statement due to user request
+```
+
+Queries run with a context that the cancel request cancels. The engine checks it between the
+rows and batches it reads and writes, so a canceled statement stops at the next row. A
+pg_advisory_lock waiting for a lock stops waiting.
+
+*/
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[uint32]*pgServer{}
+)
+
+// Add the session to the sessions cancel requests can reach, with a new secret key. Fails with
+// 53300 if there are -max-connections sessions already.
+func (pgs *pgServer) registerBackend() error {
+	var secret [4]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return fmt.Errorf("could not generate secret key: %w", err)
+	}
+	pgs.secret = binary.BigEndian.Uint32(secret[:])
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if pgs.cfg.maxConnections > 0 && len(backends) >= pgs.cfg.maxConnections {
+		return &pgError{Code: sqlStateTooManyConnections, Message: "sorry, too many clients already"}
+	}
+	backends[pgs.pid] = pgs
+	return nil
+}
+
+func (pgs *pgServer) unregisterBackend() {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	delete(backends, pgs.pid)
+}
+
+// Cancel the query of the session the request is for. Requests with a wrong key are ignored, like Postgres does.
+func cancelBackend(req *pgproto3.CancelRequest) bool {
+	backendsMu.Lock()
+	pgs, ok := backends[req.ProcessID]
+	backendsMu.Unlock()
+	if !ok || pgs.secret != req.SecretKey {
+		return false
+	}
+
+	pgs.cancelMu.Lock()
+	defer pgs.cancelMu.Unlock()
+	if pgs.cancelQuery != nil {
+		pgs.cancelQuery()
+	}
+	return true
+}
+
+// The context of the query about to run, which cancel requests cancel until it is done. It
+// times out after the statement_timeout of the session.
+func (pgs *pgServer) startQuery() (context.Context, func()) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout := pgs.statementTimeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	pgs.cancelMu.Lock()
+	pgs.cancelQuery = cancel
+	pgs.cancelMu.Unlock()
+
+	return ctx, func() {
+		pgs.cancelMu.Lock()
+		pgs.cancelQuery = nil
+		pgs.cancelMu.Unlock()
+		cancel()
+	}
+}
+
+func (pe pgEngine) withContext(ctx context.Context) pgEngine {
+	pe.ctx = ctx
+	return pe
+}
+
+// Fail if the query of the engine was canceled, or ran into its timeout.
+func (pe pgEngine) checkCanceled() error {
+	if pe.ctx == nil || pe.ctx.Err() == nil {
+		return nil
+	}
+	if errors.Is(pe.ctx.Err(), context.DeadlineExceeded) {
+		return &pgError{Code: sqlStateQueryCanceled, Message: "canceling statement due to statement timeout"}
+	}
+	return &pgError{Code: sqlStateQueryCanceled, Message: "canceling statement due to user request"}
+}
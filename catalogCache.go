@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+/*
+
+Catalog cache.
+
+The table definitions read outside of transaction blocks are cached for the whole server, by
+database and schema, with the catalog version they were read at (see session.go). Every cached
+catalog has a goroutine with a FoundationDB watch on its version key, which fires when a
+statement that changes the catalog commits, on this server or any other one:
+
+```
+goroutine: watch catalog/version  ->  42 ... fires  ->  43, drop the cached tables, watch again
+```
+
+As long as the watch hasn't fired the cached definitions are current, so statements find their
+tables without reading from FoundationDB at all, not even the version. A session that changes
+the catalog drops the cache right after it commits, so its next statement doesn't wait for the
+watch to see the change, other sessions see it as soon as the watch fires. Until then a select
+of another session may still use the definition from before the change, as if it had started
+before it was committed. Writes don't: INSERT reads the catalog version in its transaction,
+which makes it conflict with a change of the catalog committed meanwhile, and when the version
+isn't the one of its definition it drops the cache and checks its rows again with the current
+definition.
+
+Transaction blocks keep using the cache of their session, which checks the version in their
+transaction, and see their own uncommitted changes of the catalog.
+
+*/
+
+type catalogCache struct {
+	mu sync.Mutex
+	// The catalog version the watch saw last, the tables read at it are current until the watch fires
+	version  int64
+	watching bool
+	// Bumped by invalidate, a version the watch read before it is not trusted
+	generation int
+	tables     map[string]*tableDefinition
+}
+
+type catalogCacheRegistry struct {
+	mu     sync.Mutex
+	caches map[string]*catalogCache
+}
+
+var catalogCaches = catalogCacheRegistry{caches: map[string]*catalogCache{}}
+
+func catalogCacheKey(database, schema string) string {
+	return database + "." + schema
+}
+
+// The cache of the catalog of the engine, watching its version key from the first time it is asked for.
+func (r *catalogCacheRegistry) get(pe pgEngine, ks keyspace) *catalogCache {
+	key := catalogCacheKey(pe.database, pe.schema)
+	versionKey := pe.withTransactor(ks).catalogVersionKey()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.caches[key]; ok {
+		return c
+	}
+	c := &catalogCache{tables: map[string]*tableDefinition{}}
+	r.caches[key] = c
+	go c.watch(ks, versionKey, func() { r.remove(key, c) })
+	return c
+}
+
+func (r *catalogCacheRegistry) remove(key string, c *catalogCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.caches[key] == c {
+		delete(r.caches, key)
+	}
+}
+
+// Drop the cached tables of every schema of the database, after a statement of this server changed its catalog.
+func (r *catalogCacheRegistry) invalidate(database string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, c := range r.caches {
+		if strings.HasPrefix(key, database+".") {
+			c.invalidate()
+		}
+	}
+}
+
+// Watch the version key until the catalog is gone, calling done then.
+func (c *catalogCache) watch(ks keyspace, versionKey fdb.Key, done func()) {
+	defer done()
+	seen := false
+	for {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		var version []byte
+		w, err := ks.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			version = tr.Get(versionKey).MustGet()
+			return tr.Watch(versionKey), nil
+		})
+		if err == nil {
+			// Note: the catalog of a dropped schema or database has no version key anymore
+			if version == nil && seen {
+				c.invalidate()
+				return
+			}
+			seen = seen || version != nil
+			c.setVersion(decodeCatalogVersion(version), generation)
+			err = w.(fdb.FutureNil).Get()
+		}
+		if err != nil {
+			c.invalidate()
+			// Note: the tenant of a dropped database can't be watched anymore
+			if isFDBError(err, fdbTenantNotFound) {
+				return
+			}
+			log.Printf("could not watch the catalog version: %s", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func decodeCatalogVersion(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(value))
+}
+
+func (c *catalogCache) setVersion(version int64, generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if version != c.version {
+		c.tables = map[string]*tableDefinition{}
+	}
+	c.version = version
+	c.watching = true
+}
+
+func (c *catalogCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.watching = false
+	c.tables = map[string]*tableDefinition{}
+}
+
+// The cached definition of the table, nil if there is none or the watch isn't set.
+func (c *catalogCache) table(name string) *tableDefinition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.watching {
+		return nil
+	}
+	return c.tables[name]
+}
+
+// Cache the definition of the table, if it was read at the version the watch is set at.
+func (c *catalogCache) put(tbl *tableDefinition, version int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watching && version == c.version {
+		c.tables[tbl.Name] = tbl
+	}
+}
+
+// The keyspace of the engine if it runs outside of a transaction block, whose statements can use the catalog cache.
+func (pe pgEngine) catalogCacheKeyspace() (keyspace, bool) {
+	ks, ok := unwrapTransactor(pe.db).(keyspace)
+	return ks, ok
+}
+
+// The transactor the snapshot, cached read version and priority transactors read with.
+func unwrapTransactor(t fdb.Transactor) fdb.Transactor {
+	for {
+		switch w := t.(type) {
+		case snapshotTransactor:
+			t = w.Transactor
+		case cachedReadVersionTransactor:
+			t = w.Transactor
+		case priorityKeyspace:
+			t = w.keyspace
+		default:
+			return t
+		}
+	}
+}
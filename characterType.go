@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+
+Character types with a declared length, varchar(n) and char(n).
+
+The length is kept with the type as `pg_catalog.varchar(10)`. Like Postgres, storing a value
+with more characters than the length is an error, unless the extra characters are spaces,
+which are cut off. An explicit cast cuts off any extra characters instead:
+
+```sql
+create table code (short varchar(3), fixed char(5));
+insert into code values ('abc', 'xy');      -- fixed is stored as 'xy   '
+insert into code values ('abcd', 'xy');     -- ERROR: value too long for type character varying(3)
+select 'abcd'::varchar(3);                  -- abc
+```
+
+char(n) values are padded with spaces to the length, stored and sent to the client that way,
+while comparisons and conversions to text ignore the trailing spaces.
+
+*/
+
+// A value of a char(n) column, with its padding.
+type bpchar string
+
+// Without the padding, which is how char(n) values become text.
+func (c bpchar) String() string {
+	return strings.TrimRight(string(c), " ")
+}
+
+var characterTypes = map[string]string{
+	"pg_catalog.varchar": "character varying",
+	"pg_catalog.bpchar":  "character",
+}
+
+func isCharacterType(colType string) bool {
+	base, _ := splitTypeMods(colType)
+	_, ok := characterTypes[base]
+	return ok
+}
+
+// The declared length of a character type, false when it has none.
+func characterLength(colType string) (int, bool) {
+	_, mods := splitTypeMods(colType)
+	if len(mods) == 0 {
+		return 0, false
+	}
+	return mods[0], true
+}
+
+// Cut a value down to the declared length of a character type, for explicit casts.
+func truncateCharacter(v any, colType string) (any, error) {
+	n, ok := characterLength(colType)
+	if !ok {
+		return v, nil
+	}
+
+	s, err := castValue(v, "text")
+	if err != nil {
+		return nil, err
+	}
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n]), nil
+	}
+	return s, nil
+}
+
+func castCharacter(v any, colType string) (string, error) {
+	s, err := castValue(v, "text")
+	if err != nil {
+		return "", err
+	}
+
+	n, ok := characterLength(colType)
+	if !ok {
+		return s, nil
+	}
+
+	runes := []rune(s)
+	if len(runes) > n {
+		if strings.TrimRight(string(runes[n:]), " ") != "" {
+			base, _ := splitTypeMods(colType)
+			return "", &pgError{
+				Code:    sqlStateStringDataRightTruncation,
+				Message: fmt.Sprintf("value too long for type %s(%d)", characterTypes[base], n),
+			}
+		}
+		runes = runes[:n]
+	}
+
+	if base, _ := splitTypeMods(colType); base == "pg_catalog.bpchar" {
+		return string(runes) + strings.Repeat(" ", n-len(runes)), nil
+	}
+	return string(runes), nil
+}
+
+func isBpchar(v any) bool {
+	_, ok := v.(bpchar)
+	return ok
+}
+
+// Compare char(n) values with each other and with strings, trailing spaces don't count.
+func cmpBpchar(left, right any, coll *collation) (int, error) {
+	operand := func(v any) (string, error) {
+		switch value := v.(type) {
+		case bpchar:
+			return value.String(), nil
+		case string:
+			return strings.TrimRight(value, " "), nil
+		}
+		return "", fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return coll.compare(l, r), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Range clear deletes.
+
+A DELETE of every row of a table doesn't read the rows it deletes. The keys of the rows of a
+table or partition are all under its name, and so are the entries of its indexes, its row
+count and the expirations of its rows, so one clear of a range of keys each takes them all,
+whatever the number of rows:
+
+```
+delete from person;
+
+ClearRange data/table_data/person/...    rows of both layouts, chunks, tombstones
+ClearRange data/ttl/person/...
+ClearRange data/text_index/person/...
+Clear      data/stats/person/rows
+```
+
+The clears of the table and its partitions are in one transaction, a clear is the same few bytes
+of a transaction for a row or a billion, so the DELETE commits or fails as a whole. The rows it
+deleted are the rows of the row counts (see rowCount.go) it read in the transaction, which can
+be off if the counts drifted. Tables with at least -tombstone-delete-rows rows are deleted with
+tombstones instead, see tombstone.go.
+
+*/
+
+// Delete every row of the table and its partitions, returning how many there were by their row counts.
+func (pe pgEngine) clearTable(name string, targets []string) (int, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+	statsSS := pe.statsSubspace()
+
+	deleted, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if tr.Get(catalogDir.Sub("table").Pack(tuple.Tuple{name})).MustGet() == nil {
+			return nil, undefinedTable(name)
+		}
+
+		counts := make([]fdb.FutureByteSlice, len(targets))
+		for i, target := range targets {
+			counts[i] = tr.Get(rowCountKey(statsSS, target))
+		}
+		var deleted int64
+		for i, target := range targets {
+			deleted += max(0, decodeRowCount(counts[i].MustGet()))
+			writes(tr).ClearRange(tableDataSS.Sub(target))
+			writes(tr).ClearRange(dataDir.Sub("ttl").Sub(target))
+			writes(tr).Clear(rowCountKey(statsSS, target))
+			writes(tr).Clear(pe.tombstonedKey(target))
+		}
+		pe.clearTextIndexes(tr, name)
+		return int(deleted), nil
+	})
+	if err != nil {
+		return 0, transactionLimitError(err)
+	}
+	return deleted.(int), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+/*
+
+Client certificate authentication.
+
+With -tls-client-ca the server asks TLS clients for a certificate and verifies the ones they
+send against the CA certificates of the file. With -auth=cert a session has to come over TLS
+with a verified certificate, and the common name of the certificate is the role it logs in
+as, like the cert method of Postgres:
+
+```bash
+$ ./fakegres-fdb -tls-cert=server.crt -tls-key=server.key -tls-client-ca=root.crt -auth=cert
+$ psql "host=localhost port=6000 user=alice sslmode=verify-full sslcert=alice.crt sslkey=alice.key"
+```
+
+A common name that isn't the name of the role can be mapped to it with -cert-map, a comma
+separated list of cn:role:
+
+```bash
+$ ./fakegres-fdb ... -auth=cert -cert-map=alice.example.com:alice
+```
+
+*/
+
+// The roles the common names of -cert-map log in as, by common name.
+var certRoles = map[string]string{}
+
+func parseCertMap(s string) (map[string]string, error) {
+	roles := map[string]string{}
+	if s == "" {
+		return roles, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		cn, role, ok := strings.Cut(entry, ":")
+		if !ok || cn == "" || role == "" {
+			return nil, fmt.Errorf("invalid certificate mapping %q, expected cn:role", entry)
+		}
+		roles[cn] = role
+	}
+	return roles, nil
+}
+
+// Ask TLS clients for a certificate signed by a CA of -tls-client-ca. Clients without one still
+// get in, unless they need it to authenticate.
+func loadClientCA(tlsConfig *tls.Config, cfg config) {
+	if cfg.tlsClientCA == "" {
+		if cfg.auth == "cert" {
+			log.Fatal("-auth=cert needs -tls-client-ca")
+		}
+		return
+	}
+
+	pem, err := os.ReadFile(cfg.tlsClientCA)
+	if err != nil {
+		log.Fatalf("could not read client CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Fatalf("no certificates in client CA %s", cfg.tlsClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+}
+
+// The role the verified client certificate of the session logs in as, empty if it has none.
+func (pgs *pgServer) certRole() string {
+	conn, ok := pgs.conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	// Note: the handshake verified the chain, a certificate that failed it ended the connection
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+
+	cn := certs[0].Subject.CommonName
+	if role, ok := certRoles[cn]; ok {
+		return role
+	}
+	return cn
+}
+
+// Check the client certificate of the session is the one of the user, sending a FATAL ErrorResponse if it isn't.
+func (pgs *pgServer) authenticateCert(user string) error {
+	role := pgs.certRole()
+	if role == "" {
+		if err := pgs.writeFatal(sqlStateInvalidAuthorizationSpecification, "connection requires a valid client certificate"); err != nil {
+			return err
+		}
+		return fmt.Errorf("connection without client certificate for user %s", user)
+	}
+
+	if role != user {
+		if err := pgs.writeFatal(sqlStateInvalidAuthorizationSpecification, fmt.Sprintf("certificate authentication failed for user \"%s\"", user)); err != nil {
+			return err
+		}
+		return fmt.Errorf("certificate of %s doesn't authenticate user %s", role, user)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+/*
+
+Client encodings.
+
+Text is UTF-8 in the server. A client that works in another encoding names it in the
+client_encoding startup parameter, or changes it later with SET, and the text it sends is
+converted from it and the text it gets is converted to it, like in Postgres:
+
+```sql
+set client_encoding = 'LATIN1';
+show client_encoding;
+ client_encoding
+-----------------
+ LATIN1
+```
+
+Names are matched like Postgres matches them, ignoring case and anything that isn't a letter
+or a digit, with its aliases, so latin1, ISO-8859-1 and iso88591 are all LATIN1. The new
+encoding is reported in a ParameterStatus. An encoding that isn't supported is refused with
+22023, at startup with a FATAL.
+
+Values that have no equivalent in the encoding of the client fail the query with 22P05.
+Messages, like the ones of errors and notices, get a ? in place of those characters instead,
+so an error is always sent. SQL_ASCII, like in Postgres, turns conversion off.
+
+*/
+
+type clientEncoding struct {
+	// The Postgres name of the encoding
+	name string
+	// Nil when text isn't converted
+	enc encoding.Encoding
+}
+
+var utf8Encoding = clientEncoding{name: "UTF8"}
+
+var clientEncodings = map[string]clientEncoding{
+	"UTF8":       utf8Encoding,
+	"SQL_ASCII":  {name: "SQL_ASCII"},
+	"LATIN1":     {name: "LATIN1", enc: charmap.ISO8859_1},
+	"LATIN2":     {name: "LATIN2", enc: charmap.ISO8859_2},
+	"LATIN3":     {name: "LATIN3", enc: charmap.ISO8859_3},
+	"LATIN4":     {name: "LATIN4", enc: charmap.ISO8859_4},
+	"LATIN5":     {name: "LATIN5", enc: charmap.ISO8859_9},
+	"LATIN6":     {name: "LATIN6", enc: charmap.ISO8859_10},
+	"LATIN7":     {name: "LATIN7", enc: charmap.ISO8859_13},
+	"LATIN8":     {name: "LATIN8", enc: charmap.ISO8859_14},
+	"LATIN9":     {name: "LATIN9", enc: charmap.ISO8859_15},
+	"LATIN10":    {name: "LATIN10", enc: charmap.ISO8859_16},
+	"ISO_8859_5": {name: "ISO_8859_5", enc: charmap.ISO8859_5},
+	"ISO_8859_6": {name: "ISO_8859_6", enc: charmap.ISO8859_6},
+	"ISO_8859_7": {name: "ISO_8859_7", enc: charmap.ISO8859_7},
+	"ISO_8859_8": {name: "ISO_8859_8", enc: charmap.ISO8859_8},
+	"WIN866":     {name: "WIN866", enc: charmap.CodePage866},
+	"WIN874":     {name: "WIN874", enc: charmap.Windows874},
+	"WIN1250":    {name: "WIN1250", enc: charmap.Windows1250},
+	"WIN1251":    {name: "WIN1251", enc: charmap.Windows1251},
+	"WIN1252":    {name: "WIN1252", enc: charmap.Windows1252},
+	"WIN1253":    {name: "WIN1253", enc: charmap.Windows1253},
+	"WIN1254":    {name: "WIN1254", enc: charmap.Windows1254},
+	"WIN1255":    {name: "WIN1255", enc: charmap.Windows1255},
+	"WIN1256":    {name: "WIN1256", enc: charmap.Windows1256},
+	"WIN1257":    {name: "WIN1257", enc: charmap.Windows1257},
+	"WIN1258":    {name: "WIN1258", enc: charmap.Windows1258},
+	"KOI8R":      {name: "KOI8R", enc: charmap.KOI8R},
+	"KOI8U":      {name: "KOI8U", enc: charmap.KOI8U},
+	"EUC_JP":     {name: "EUC_JP", enc: japanese.EUCJP},
+	"SJIS":       {name: "SJIS", enc: japanese.ShiftJIS},
+	"EUC_KR":     {name: "EUC_KR", enc: korean.EUCKR},
+	"GBK":        {name: "GBK", enc: simplifiedchinese.GBK},
+	"GB18030":    {name: "GB18030", enc: simplifiedchinese.GB18030},
+	"BIG5":       {name: "BIG5", enc: traditionalchinese.Big5},
+}
+
+// Other names of the encodings, after cleanEncodingName.
+var clientEncodingAliases = map[string]string{
+	"unicode":     "UTF8",
+	"iso88591":    "LATIN1",
+	"iso88592":    "LATIN2",
+	"iso88593":    "LATIN3",
+	"iso88594":    "LATIN4",
+	"iso88599":    "LATIN5",
+	"iso885910":   "LATIN6",
+	"iso885913":   "LATIN7",
+	"iso885914":   "LATIN8",
+	"iso885915":   "LATIN9",
+	"iso885916":   "LATIN10",
+	"alt":         "WIN866",
+	"windows866":  "WIN866",
+	"windows874":  "WIN874",
+	"windows1250": "WIN1250",
+	"windows1251": "WIN1251",
+	"windows1252": "WIN1252",
+	"windows1253": "WIN1253",
+	"windows1254": "WIN1254",
+	"windows1255": "WIN1255",
+	"windows1256": "WIN1256",
+	"windows1257": "WIN1257",
+	"windows1258": "WIN1258",
+	"win":         "WIN1251",
+	"koi8":        "KOI8R",
+	"shiftjis":    "SJIS",
+	"mskanji":     "SJIS",
+	"cp936":       "GBK",
+	"tcvn":        "WIN1258",
+}
+
+// The name lower cased, without anything that isn't a letter or a digit, like Postgres compares encoding names.
+func cleanEncodingName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+func lookupClientEncoding(name string) (clientEncoding, bool) {
+	clean := cleanEncodingName(name)
+	if canonical, ok := clientEncodingAliases[clean]; ok {
+		return clientEncodings[canonical], true
+	}
+	for canonical, ce := range clientEncodings {
+		if cleanEncodingName(canonical) == clean {
+			return ce, true
+		}
+	}
+	return clientEncoding{}, false
+}
+
+// The Postgres name of a client_encoding value, or an error if the encoding isn't supported.
+func checkClientEncoding(value string) (string, error) {
+	ce, ok := lookupClientEncoding(value)
+	if !ok {
+		return "", &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("invalid value for parameter \"client_encoding\": \"%s\"", value)}
+	}
+	return ce.name, nil
+}
+
+// Convert text of a value to the encoding, failing for characters it has no equivalent for.
+func (ce *clientEncoding) encode(b []byte) ([]byte, error) {
+	if ce.enc == nil {
+		return b, nil
+	}
+	out, err := ce.enc.NewEncoder().Bytes(b)
+	if err == nil {
+		return out, nil
+	}
+
+	for _, r := range string(b) {
+		if _, err := ce.enc.NewEncoder().String(string(r)); err != nil {
+			var seq []string
+			for _, c := range []byte(string(r)) {
+				seq = append(seq, fmt.Sprintf("0x%02x", c))
+			}
+			return nil, &pgError{
+				Code:    sqlStateUntranslatableCharacter,
+				Message: fmt.Sprintf("character with byte sequence %s in encoding \"UTF8\" has no equivalent in encoding \"%s\"", strings.Join(seq, " "), ce.name),
+			}
+		}
+	}
+	return nil, err
+}
+
+// Convert text of a message to the encoding, replacing the characters it has no equivalent for with ?.
+func (ce *clientEncoding) encodeMessage(s string) string {
+	if ce.enc == nil {
+		return s
+	}
+	if out, err := ce.enc.NewEncoder().String(s); err == nil {
+		return out
+	}
+
+	var out strings.Builder
+	for _, r := range s {
+		c, err := ce.enc.NewEncoder().String(string(r))
+		if err != nil {
+			c = "?"
+		}
+		out.WriteString(c)
+	}
+	return out.String()
+}
+
+// Convert text the client sent to UTF-8.
+func (ce *clientEncoding) decode(s string) (string, error) {
+	if ce.enc == nil {
+		return s, nil
+	}
+	out, err := ce.enc.NewDecoder().String(s)
+	if err != nil {
+		return "", &pgError{Code: sqlStateCharacterNotInRepertoire, Message: fmt.Sprintf("invalid byte sequence for encoding \"%s\"", ce.name)}
+	}
+	return out, nil
+}
+
+// The encoding of the client. Notifications are converted on the goroutines of the listeners,
+// so it is kept apart from the settings.
+func (pgs *pgServer) clientEncoding() *clientEncoding {
+	if ce := pgs.encoding.Load(); ce != nil {
+		return ce
+	}
+	return &utf8Encoding
+}
+
+// Convert text to and from the client with the encoding the setting is now.
+func (pgs *pgServer) useClientEncoding() {
+	ce := utf8Encoding
+	if name, ok := pgs.sessionSetting("client_encoding"); ok {
+		ce, _ = lookupClientEncoding(name)
+	}
+	pgs.encoding.Store(&ce)
+}
+
+// Take the client_encoding startup parameter as the encoding of the session.
+func (pgs *pgServer) applyStartupEncoding(value string) error {
+	if value != "" {
+		name, err := checkClientEncoding(value)
+		if err != nil {
+			return pgs.refuseConnection(err)
+		}
+		pgs.startupSettings["client_encoding"] = name
+	}
+	pgs.useClientEncoding()
+	return nil
+}
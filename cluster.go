@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+/*
+
+The FoundationDB cluster.
+
+The server connects to the cluster of the default cluster file (FDB_CLUSTER_FILE, or
+/etc/foundationdb/fdb.cluster) unless -cluster-file names another one. The other flags tune the
+client and every transaction it runs:
+
+```
+fakegres -cluster-file=/etc/foundationdb/staging.cluster -fdb-api-version=710 \
+	-fdb-retry-limit=10 -fdb-transaction-timeout=10s
+```
+
+A transaction that failed -fdb-retry-limit times with retryable errors, like conflicts, fails
+the statement with the last error instead of being retried again. A transaction that runs
+longer than -fdb-transaction-timeout is canceled and fails with transaction_timed_out (1031),
+which isn't retried. The API version has to be supported by both the bindings and the
+libfdb_c the server is linked with, tenants (see tenant.go) need 710.
+
+*/
+
+// Open the database of the cluster of the configuration, with its transaction options.
+func openDatabase(cfg config) (fdb.Database, error) {
+	if err := fdb.APIVersion(cfg.fdbAPIVersion); err != nil {
+		return fdb.Database{}, fmt.Errorf("could not select FoundationDB API version %d: %w", cfg.fdbAPIVersion, err)
+	}
+
+	db, err := fdb.OpenDatabase(cfg.clusterFile)
+	if err != nil {
+		return fdb.Database{}, fmt.Errorf("could not open database: %w", err)
+	}
+
+	if cfg.fdbRetryLimit >= 0 {
+		if err := db.Options().SetTransactionRetryLimit(int64(cfg.fdbRetryLimit)); err != nil {
+			return fdb.Database{}, fmt.Errorf("could not set transaction retry limit: %w", err)
+		}
+	}
+	if cfg.fdbTransactionTimeout > 0 {
+		if err := db.Options().SetTransactionTimeout(cfg.fdbTransactionTimeout.Milliseconds()); err != nil {
+			return fdb.Database{}, fmt.Errorf("could not set transaction timeout: %w", err)
+		}
+	}
+	return db, nil
+}
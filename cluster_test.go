@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+// The database of the default cluster the tests run against, opened by the first test that needs it.
+var (
+	testDatabaseOnce sync.Once
+	testDatabase     fdb.Database
+	testDatabaseErr  error
+)
+
+// An engine for a database of its own in the default cluster, removed when the test ends. Skips
+// the test when there is no cluster to connect to.
+func testEngine(t *testing.T) pgEngine {
+	t.Helper()
+	testDatabaseOnce.Do(func() {
+		// Note: without a cluster file the client waits for a cluster forever, whatever the timeout
+		clusterFile := os.Getenv("FDB_CLUSTER_FILE")
+		if clusterFile == "" {
+			clusterFile = "/etc/foundationdb/fdb.cluster"
+		}
+		if _, testDatabaseErr = os.Stat(clusterFile); testDatabaseErr != nil {
+			return
+		}
+		testDatabase, testDatabaseErr = openDatabase(config{fdbAPIVersion: 710, fdbRetryLimit: -1, fdbTransactionTimeout: 5 * time.Second})
+		if testDatabaseErr != nil {
+			return
+		}
+		_, testDatabaseErr = testDatabase.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+			return rtr.GetReadVersion().Get()
+		})
+	})
+	if testDatabaseErr != nil {
+		t.Skipf("no FoundationDB cluster: %s", testDatabaseErr)
+	}
+
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		if _, err := directory.Root().Remove(testDatabase, []string{"database", name}); err != nil {
+			t.Errorf("could not remove database %s: %s", name, err)
+		}
+	})
+	return newPgEngine(testDatabase, name)
+}
+
+// Execute the statements of the query with the engine, failing the test on an error.
+func execSQL(t *testing.T, pe pgEngine, query string) {
+	t.Helper()
+	tree, err := pgquery.Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pe.execute(tree); err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Implicit coercion of the operands of comparisons.
+
+Like Postgres, a comparison of two different types is resolved by implicitly casting one
+side to the type of the other, along the implicit casts Postgres has: integers widen to
+bigger integers, numeric and floats, numeric to floats, dates to timestamps, timestamps
+to timestamptz and char(n) and varchar to text:
+
+```sql
+select name from customer where age = 14.0;       -- integer = numeric
+select name from customer where signup > current_date - 7;  -- timestamp > date
+select name from customer where name = 14;         -- ERROR: operator does not exist: text = integer
+```
+
+String constants have no type of their own (unknown) and take the type of the other side,
+so `age = '14'` compares integers. Types without an implicit cast between them can't be
+compared, instead of comparing their text.
+
+The values themselves are compared by compareValues, which converts between the Go values
+of the types. The types of the operands are taken from the parse tree where it has them,
+columns have the type in the catalog and casts the type cast to, the rest have the type of
+their value.
+
+*/
+
+const unknownType = "unknown"
+
+// The types each type casts to implicitly.
+var implicitCasts = map[string][]string{
+	"pg_catalog.int2":      {"pg_catalog.int4", "pg_catalog.int8", "pg_catalog.numeric", "pg_catalog.float4", "pg_catalog.float8"},
+	"pg_catalog.int4":      {"pg_catalog.int8", "pg_catalog.numeric", "pg_catalog.float4", "pg_catalog.float8"},
+	"pg_catalog.int8":      {"pg_catalog.numeric", "pg_catalog.float4", "pg_catalog.float8"},
+	"pg_catalog.numeric":   {"pg_catalog.float4", "pg_catalog.float8"},
+	"pg_catalog.float4":    {"pg_catalog.float8"},
+	"pg_catalog.date":      {"pg_catalog.timestamp", "pg_catalog.timestamptz"},
+	"pg_catalog.timestamp": {"pg_catalog.timestamptz"},
+	"pg_catalog.time":      {"pg_catalog.interval"},
+	"pg_catalog.bpchar":    {"text", "pg_catalog.varchar"},
+	"pg_catalog.varchar":   {"text"},
+	"text":                 {"pg_catalog.varchar"},
+	"pg_catalog.cidr":      {"pg_catalog.inet"},
+}
+
+var comparisonOps = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func hasImplicitCast(from, to string) bool {
+	for _, t := range implicitCasts[from] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether values of the two types can be compared, because they are the same or one casts to the other implicitly.
+func implicitlyComparable(l, r string) bool {
+	if l == unknownType || r == unknownType || l == r {
+		return true
+	}
+	// Note: records from ROW() compare with records of any composite type, field by field
+	if l == "record" || r == "record" {
+		return true
+	}
+
+	le, lok := arrayElemType(l)
+	re, rok := arrayElemType(r)
+	if lok || rok {
+		return lok && rok && implicitlyComparable(le, re)
+	}
+	return hasImplicitCast(l, r) || hasImplicitCast(r, l)
+}
+
+// The type of an operand, before type modifiers. String constants and NULL are unknown.
+func operandType(n *pgquery.Node, tbl *tableDefinition, v any) string {
+	var t string
+	switch {
+	case n.GetAConst() != nil:
+		c := n.GetAConst()
+		if c.Val.GetString_() != nil || c.Val.GetNull() != nil {
+			return unknownType
+		}
+		// Note: integer constants that fit in 32 bits are integer, bigger ones are parsed as floats
+		if c.Val.GetInteger() != nil {
+			return "pg_catalog.int4"
+		}
+	case n.GetColumnRef() != nil:
+		fields := n.GetColumnRef().Fields
+		t, _ = tbl.columnType(fields[len(fields)-1].GetString_().GetStr())
+	case n.GetTypeCast() != nil:
+		t = typeNameString(n.GetTypeCast().TypeName)
+	case n.GetCollateClause() != nil:
+		return operandType(n.GetCollateClause().Arg, tbl, v)
+	}
+
+	if t == "" {
+		if v == nil {
+			return unknownType
+		}
+		t = valueType(v)
+	}
+
+	base, _ := splitTypeMods(t)
+	return base
+}
+
+/*
+
+Check that the operands of a comparison can be compared and cast them to their common type
+where their values don't already compare that way. A char(n) compared with text or varchar
+is cast to text, so its padding is dropped but trailing spaces of the other side count.
+
+*/
+
+func coerceComparison(a *pgquery.A_Expr, tbl *tableDefinition, left, right any) (any, any, error) {
+	op := a.Name[0].GetString_().GetStr()
+	lt := operandType(a.Lexpr, tbl, left)
+	rt := operandType(a.Rexpr, tbl, right)
+
+	if !implicitlyComparable(lt, rt) {
+		return nil, nil, &pgError{
+			Code:    sqlStateUndefinedFunction,
+			Message: fmt.Sprintf("operator does not exist: %s %s %s", sqlTypeName(lt), op, sqlTypeName(rt)),
+		}
+	}
+
+	if c, ok := left.(bpchar); ok && (rt == "text" || rt == "pg_catalog.varchar") {
+		left = c.String()
+	}
+	if c, ok := right.(bpchar); ok && (lt == "text" || lt == "pg_catalog.varchar") {
+		right = c.String()
+	}
+	return left, right, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+/*
+
+Collations for comparing and sorting text.
+
+Text is compared in byte order by the "C" collation, any other collation compares by the
+Unicode collation rules of its locale. Locale names are accepted in the forms Postgres uses,
+so these all name the same collation:
+
+```sql
+select name from customer where name collate "de_DE" < 'b';
+select name from customer order by name collate "de-DE-x-icu";
+select name from customer order by name collate "de_DE.UTF-8";
+```
+
+Text without a COLLATE clause uses the default collation, which is set with the -collation
+flag. Partition bounds are always compared in byte order, so changing the default collation
+doesn't move rows into other partitions.
+
+*/
+
+var defaultCollation = "C"
+
+type collation struct {
+	mu       sync.Mutex
+	collator *collate.Collator
+}
+
+var (
+	collationsMu sync.Mutex
+	collations   = map[string]*collation{}
+)
+
+// Look up a collation by name, the empty name is the default collation. A nil collation compares in byte order.
+func lookupCollation(name string) (*collation, error) {
+	if name == "" || name == "default" {
+		name = defaultCollation
+	}
+
+	if name == "C" || name == "POSIX" {
+		return nil, nil
+	}
+
+	collationsMu.Lock()
+	defer collationsMu.Unlock()
+
+	if c, ok := collations[name]; ok {
+		return c, nil
+	}
+
+	locale := name
+	for _, suffix := range []string{".UTF-8", ".utf8", "-x-icu"} {
+		locale = strings.TrimSuffix(locale, suffix)
+	}
+	tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+	if err != nil {
+		return nil, fmt.Errorf("collation \"%s\" for encoding \"UTF8\" does not exist", name)
+	}
+
+	c := &collation{collator: collate.New(tag)}
+	collations[name] = c
+	return c, nil
+}
+
+func (c *collation) compare(l, r string) int {
+	if c == nil {
+		return strings.Compare(l, r)
+	}
+
+	// Note: collators keep state between calls, so they can't be used concurrently
+	c.mu.Lock()
+	cmp := c.collator.CompareString(l, r)
+	c.mu.Unlock()
+
+	// Like the deterministic collations of Postgres, only identical strings are equal
+	if cmp == 0 {
+		return strings.Compare(l, r)
+	}
+	return cmp
+}
+
+// The name of the explicit COLLATE clause of an expression, empty if it has none.
+func exprCollation(n *pgquery.Node) string {
+	cc := n.GetCollateClause()
+	if cc == nil {
+		return ""
+	}
+
+	return cc.Collname[len(cc.Collname)-1].GetString_().GetStr()
+}
+
+// The collation of a binary operator, an explicit COLLATE clause on either side wins over the default.
+func operatorCollation(a *pgquery.A_Expr) (*collation, error) {
+	var left string
+	if a.Lexpr != nil {
+		left = exprCollation(a.Lexpr)
+	}
+	right := exprCollation(a.Rexpr)
+
+	if left != "" && right != "" && left != right {
+		return nil, fmt.Errorf("collation mismatch between explicit collations \"%s\" and \"%s\"", left, right)
+	}
+
+	if left == "" {
+		left = right
+	}
+	return lookupCollation(left)
+}
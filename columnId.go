@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Column ids.
+
+The keys of the cells of a row hold their column, and a column name repeated in the key of
+every cell of a wide table takes more space than the cell itself often does. Every column of
+a table gets a small integer id when the table is created, which the keys of its cells and the
+values of rows (see rowFormat.go) hold instead of the name:
+
+```
+catalog/column_id/person/age: (1,)
+catalog/column_id/person/name: (2,)
+data/table_data/person/r/<row id>: 0x01 <1> <2> (14,) <2> <7> ("garry",)
+data/table_data/person/c/2/<row id>: ("garry",)
+data/table_data/person/x/<row id>/2/0: <chunk of a large cell>
+```
+
+The ids are part of the table definition, which translates them back to the names of the
+columns when rows are read. Partitions have the ids of their partitioned table, whose rows
+they hold. Tables created before columns had ids get them when the server starts (see
+migration.go), the keys of cells that have the name of their column are still read. The entries of indexes are keyed by the name of the index, which
+doesn't repeat for every row, and the statistics of a column by its name, once per column.
+
+*/
+
+// The ids of the columns of a new table, in the order of its columns.
+func newColumnIDs(columns []string) []int64 {
+	ids := make([]int64, len(columns))
+	for i := range columns {
+		ids[i] = int64(i + 1)
+	}
+	return ids
+}
+
+// The element of the keys of the cells of a column, its id or, for tables without ids, its name.
+func (tbl tableDefinition) columnKey(name string) tuple.TupleElement {
+	for i, cn := range tbl.ColumnNames {
+		if cn == name && i < len(tbl.ColumnIDs) && tbl.ColumnIDs[i] != 0 {
+			return tbl.ColumnIDs[i]
+		}
+	}
+	return name
+}
+
+// The name of the column of an element of the key of a cell.
+func (tbl tableDefinition) columnOfKey(e tuple.TupleElement) string {
+	id, ok := e.(int64)
+	if !ok {
+		name, _ := e.(string)
+		return name
+	}
+	for i, columnID := range tbl.ColumnIDs {
+		if columnID == id {
+			return tbl.ColumnNames[i]
+		}
+	}
+	return ""
+}
+
+func (pe pgEngine) setColumnIDs(tr fdb.Transaction, tbl *tableDefinition) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, id := range tbl.ColumnIDs {
+		writes(tr).Set(catalogDir.Sub("column_id").Pack(tuple.Tuple{tbl.Name, tbl.ColumnNames[i]}), tuple.Tuple{id}.Pack())
+	}
+}
+
+// Start reading the ids of the columns of the table, the returned function waits for them by column name.
+func (pe pgEngine) readColumnIDs(rtr fdb.ReadTransaction, name string) func() map[string]int64 {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	columnIDSS := catalogDir.Sub("column_id").Sub(name)
+	f := rtr.GetRange(columnIDSS, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll})
+	return func() map[string]int64 {
+		ids := map[string]int64{}
+		for _, kv := range f.GetSliceOrPanic() {
+			k, err := columnIDSS.Unpack(kv.Key)
+			if err != nil {
+				continue
+			}
+			v, err := tuple.Unpack(kv.Value)
+			if err != nil || len(v) != 1 {
+				continue
+			}
+			if id, ok := v[0].(int64); ok {
+				ids[k[0].(string)] = id
+			}
+		}
+		return ids
+	}
+}
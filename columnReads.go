@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+)
+
+/*
+
+Parallel column reads.
+
+A select of the columnar layout reads a range of cells for every column it projects, and every
+range read waits for FoundationDB to answer before the next one starts. The ranges of the columns
+are read at the same time instead, a goroutine each, and the cells are put together by row id
+once all of them arrived:
+
+```sql
+select name, age, city from person;
+```
+
+```
+goroutine 1: data/table_data/person/c/name/...  \
+goroutine 2: data/table_data/person/c/age/...    >  rows by id
+goroutine 3: data/table_data/person/c/city/...  /
+```
+
+So the select takes about as long as the read of its largest column, not the sum of them.
+
+*/
+
+// A cell of a column of the columnar layout and the id of its row.
+type columnCell struct {
+	id    string
+	value any
+}
+
+// Read the cells of every column in its range with its options, all of the columns at once.
+func readColumnRanges(tr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target string, columns []string, ranges []fdb.KeyRange, opts []fdb.RangeOptions) [][]columnCell {
+	cells := make([][]columnCell, len(columns))
+	panics := make([]any, len(columns))
+	var wg sync.WaitGroup
+	for i := range columns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Note: the transaction only recovers the errors of reads in its own goroutine, they are raised there below
+			defer func() { panics[i] = recover() }()
+
+			for _, kv := range tr.GetRange(ranges[i], opts[i]).GetSliceOrPanic() {
+				t, _ := tableDataSS.Unpack(kv.Key)
+				id := rowIDString(t[3])
+				cells[i] = append(cells[i], columnCell{id: id, value: readCell(tr, tableDataSS, target, id, tbl.columnKey(columns[i]), kv.Value)})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, p := range panics {
+		if p != nil {
+			panic(p)
+		}
+	}
+	return cells
+}
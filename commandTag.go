@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+The command tag of the CommandComplete of a statement, which drivers parse for the number of
+rows it affected. Like Postgres, statements that change rows carry the count, and INSERT the
+OID of the inserted row too, which is always 0:
+
+```
+insert into person values ('garry'), ('ted');  ->  INSERT 0 2
+delete from person;                            ->  DELETE 2
+create table person (name text);               ->  CREATE TABLE
+drop type mood;                                ->  DROP TYPE
+```
+
+Statements the engine doesn't know are tagged with their first word.
+
+*/
+
+var dropObjectTags = map[pgquery.ObjectType]string{
+	pgquery.ObjectType_OBJECT_TABLE:    "DROP TABLE",
+	pgquery.ObjectType_OBJECT_INDEX:    "DROP INDEX",
+	pgquery.ObjectType_OBJECT_TYPE:     "DROP TYPE",
+	pgquery.ObjectType_OBJECT_DOMAIN:   "DROP DOMAIN",
+	pgquery.ObjectType_OBJECT_VIEW:     "DROP VIEW",
+	pgquery.ObjectType_OBJECT_SEQUENCE: "DROP SEQUENCE",
+	pgquery.ObjectType_OBJECT_SCHEMA:   "DROP SCHEMA",
+	pgquery.ObjectType_OBJECT_FUNCTION: "DROP FUNCTION",
+}
+
+func commandTag(stmt *pgquery.Node, query string, rows int) string {
+	switch {
+	case stmt.GetInsertStmt() != nil:
+		return fmt.Sprintf("INSERT 0 %d", rows)
+	case stmt.GetDeleteStmt() != nil:
+		return fmt.Sprintf("DELETE %d", rows)
+	case stmt.GetUpdateStmt() != nil:
+		return fmt.Sprintf("UPDATE %d", rows)
+	case stmt.GetCreateStmt() != nil:
+		return "CREATE TABLE"
+	case stmt.GetAlterTableStmt() != nil:
+		return "ALTER TABLE"
+	case stmt.GetIndexStmt() != nil:
+		return "CREATE INDEX"
+	case stmt.GetCreateDomainStmt() != nil:
+		return "CREATE DOMAIN"
+	case stmt.GetCreateEnumStmt() != nil, stmt.GetCompositeTypeStmt() != nil:
+		return "CREATE TYPE"
+	case stmt.GetCreateSchemaStmt() != nil:
+		return "CREATE SCHEMA"
+	case stmt.GetCreatedbStmt() != nil:
+		return "CREATE DATABASE"
+	case stmt.GetDropdbStmt() != nil:
+		return "DROP DATABASE"
+	// Note: like Postgres, CREATE USER and CREATE GROUP are tagged CREATE ROLE
+	case stmt.GetCreateRoleStmt() != nil:
+		return "CREATE ROLE"
+	case stmt.GetDropRoleStmt() != nil:
+		return "DROP ROLE"
+	case stmt.GetCommentStmt() != nil:
+		return "COMMENT"
+	case stmt.GetDropStmt() != nil:
+		if tag, ok := dropObjectTags[stmt.GetDropStmt().RemoveType]; ok {
+			return tag
+		}
+	}
+
+	if fields := strings.Fields(query); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Parse the comment statement and store the comment in the catalog.
+
+Example:
+
+The following SQL
+
+```sql
+comment on table customer is 'People who buy things';
+comment on column customer.age is 'Age in years';
+```
+
+Will produce the following KV structure
+
+```
+catalog/comment/customer: People who buy things
+catalog/comment/customer/age: Age in years
+```
+
+`comment on ... is null` removes the comment. Comments are exposed through
+pg_catalog.pg_description.
+
+*/
+
+func (pe pgEngine) executeComment(stmt *pgquery.CommentStmt) error {
+	var names []string
+	for _, item := range stmt.Object.GetList().GetItems() {
+		names = append(names, item.GetString_().GetStr())
+	}
+
+	var key tuple.Tuple
+	switch stmt.Objtype {
+	case pgquery.ObjectType_OBJECT_TABLE:
+		key = tuple.Tuple{names[len(names)-1]}
+	case pgquery.ObjectType_OBJECT_COLUMN:
+		if len(names) < 2 {
+			return fmt.Errorf("column name must be qualified")
+		}
+		key = tuple.Tuple{names[len(names)-2], names[len(names)-1]}
+	default:
+		return fmt.Errorf("comments on %s are not supported", stmt.Objtype)
+	}
+
+	tblName := key[0].(string)
+	tbl, err := pe.getTableDefinition(tblName)
+	if err != nil {
+		return err
+	}
+
+	if len(key) == 2 {
+		if _, ok := tbl.columnType(key[1].(string)); !ok {
+			return &pgError{
+				Code:    sqlStateUndefinedColumn,
+				Message: fmt.Sprintf("column \"%s\" of relation \"%s\" does not exist", key[1], tblName),
+			}
+		}
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableSS := catalogDir.Sub("table")
+	tableKey := tableSS.Pack(tuple.Tuple{tblName})
+	commentSS := catalogDir.Sub("comment")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if tr.Get(tableKey).MustGet() == nil {
+			return nil, undefinedTable(tblName)
+		}
+
+		if stmt.Comment == "" {
+			writes(tr).Clear(commentSS.Pack(key))
+			return nil, nil
+		}
+
+		writes(tr).Set(commentSS.Pack(key), []byte(stmt.Comment))
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not comment: %w", err)
+	}
+
+	return nil
+}
+
+type comment struct {
+	Table       string
+	Column      string
+	Description string
+}
+
+func (pe pgEngine) getComments() ([]comment, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	commentSS := catalogDir.Sub("comment")
+
+	var comments []comment
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		ri := rtr.GetRange(commentSS, fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			t, _ := commentSS.Unpack(kv.Key)
+
+			c := comment{Table: t[0].(string), Description: string(kv.Value)}
+			if len(t) > 1 {
+				c.Column = t[1].(string)
+			}
+			comments = append(comments, c)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get comments: %w", err)
+	}
+
+	return comments, nil
+}
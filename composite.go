@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Composite types, a list of named fields each with a type of its own.
+
+Example:
+
+```sql
+create type address as (street text, zip int);
+create table person (name text, home address);
+insert into person values ('garry', row('main st', 12345));
+select (home).zip, (home).* from person where home = '(main st,12345)';
+```
+
+Will produce the following KV structure, with the OID the type was given when it was created:
+
+```
+catalog/composite/address: (16384, (("street", "text"), ("zip", "pg_catalog.int4")))
+catalog/next_oid: (16385,)
+catalog/table/person/home: address
+data/table_data/person/r/72746a7f-727f-4e0a-88f1-d983fea5c158/home: (("record", ("main st", 12345)),)
+```
+
+Cells hold the fields tagged as a record, each field encoded like a cell of its own. Values
+are written and read in the text form Postgres uses for records, `(main st,12345)`, and ROW()
+builds a record from expressions. Like enums, the type of a column is resolved when the table
+definition is read, so `(home).zip` finds the field and `(home).*` expands to a target per field.
+
+*/
+
+type compositeField struct {
+	Name string
+	Type string
+}
+
+type compositeType struct {
+	Name   string
+	Oid    uint32
+	Fields []compositeField
+}
+
+// A record, of a composite type or built by ROW() without one.
+type composite struct {
+	typ    *compositeType
+	fields []any
+}
+
+func (c composite) String() string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for i, f := range c.fields {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		// Note: NULL fields are left empty, empty strings are quoted
+		if f == nil {
+			continue
+		}
+		sb.WriteString(quoteRecordField(string(formatText(f))))
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+func quoteRecordField(s string) string {
+	if s != "" && !strings.ContainsAny(s, "\"\\(), \t\n\r") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `""`)
+	return `"` + s + `"`
+}
+
+// Split a record literal into the text of its fields, nil for NULL fields.
+func parseRecordLiteral(s string) ([]*string, error) {
+	malformed := &pgError{
+		Code:    sqlStateInvalidTextRepresentation,
+		Message: fmt.Sprintf("malformed record literal: %q", s),
+	}
+
+	body := strings.TrimSpace(s)
+	if !strings.HasPrefix(body, "(") || !strings.HasSuffix(body, ")") {
+		return nil, malformed
+	}
+	body = body[1 : len(body)-1]
+
+	var fields []*string
+	var sb strings.Builder
+	quoted, inQuotes := false, false
+	for i := 0; i <= len(body); i++ {
+		if i == len(body) || (body[i] == ',' && !inQuotes) {
+			if inQuotes {
+				return nil, malformed
+			}
+			if !quoted && sb.Len() == 0 {
+				fields = append(fields, nil)
+			} else {
+				field := sb.String()
+				fields = append(fields, &field)
+			}
+			sb.Reset()
+			quoted = false
+			continue
+		}
+
+		switch ch := body[i]; {
+		case ch == '\\' && i+1 < len(body):
+			i++
+			sb.WriteByte(body[i])
+		case ch == '"' && inQuotes && i+1 < len(body) && body[i+1] == '"':
+			i++
+			sb.WriteByte('"')
+		case ch == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return fields, nil
+}
+
+// Read a record literal as a value of the type, converting the fields to their types.
+func (ct *compositeType) parse(s string) (composite, error) {
+	texts, err := parseRecordLiteral(s)
+	if err != nil {
+		return composite{}, err
+	}
+	if len(texts) != len(ct.Fields) {
+		return composite{}, &pgError{
+			Code:    sqlStateInvalidTextRepresentation,
+			Message: fmt.Sprintf("malformed record literal: %q", s),
+		}
+	}
+
+	c := composite{typ: ct, fields: make([]any, len(texts))}
+	for i, text := range texts {
+		if text == nil {
+			continue
+		}
+		fieldText, err := castValue(*text, ct.Fields[i].Type)
+		if err != nil {
+			return composite{}, err
+		}
+		c.fields[i], err = parseCell(ct.Fields[i].Type, fieldText)
+		if err != nil {
+			return composite{}, err
+		}
+	}
+	return c, nil
+}
+
+// A value of the type from a record, or from the text of one.
+func (ct *compositeType) value(v any) (composite, error) {
+	switch value := v.(type) {
+	case composite:
+		// Note: records of other types and from ROW() go through their text, which converts the fields
+		if value.typ != nil && value.typ.Name == ct.Name {
+			return value, nil
+		}
+		return ct.parse(value.String())
+	case string:
+		return ct.parse(value)
+	}
+	return composite{}, fmt.Errorf("cannot cast type %T to %s", v, ct.Name)
+}
+
+// The index of a field by name.
+func (ct *compositeType) field(name string) (int, bool) {
+	for i, f := range ct.Fields {
+		if f.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (pe pgEngine) executeCreateComposite(stmt *pgquery.CompositeTypeStmt) error {
+	name := stmt.Typevar.Relname
+
+	ct := &compositeType{Name: name}
+	for _, c := range stmt.Coldeflist {
+		cd := c.GetColumnDef()
+		for _, f := range ct.Fields {
+			if f.Name == cd.Colname {
+				return fmt.Errorf("column \"%s\" specified more than once", cd.Colname)
+			}
+		}
+		ct.Fields = append(ct.Fields, compositeField{Name: cd.Colname, Type: typeNameString(cd.TypeName)})
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	compositeKey := catalogDir.Sub("composite").Pack(tuple.Tuple{name})
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if typeExists(tr, catalogDir, name) {
+			return nil, &pgError{Code: sqlStateDuplicateObject, Message: fmt.Sprintf("type \"%s\" already exists", name)}
+		}
+
+		ct.Oid, err = nextOid(tr, catalogDir)
+		if err != nil {
+			return nil, err
+		}
+
+		var fields tuple.Tuple
+		for _, f := range ct.Fields {
+			fields = append(fields, tuple.Tuple{f.Name, f.Type})
+		}
+		writes(tr).Set(compositeKey, tuple.Tuple{int64(ct.Oid), fields}.Pack())
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not create type: %w", err)
+	}
+
+	return nil
+}
+
+// Get the composite type with the given name, nil if the type is not a composite type.
+func (pe pgEngine) getComposite(tr fdb.ReadTransaction, typeName string) (*compositeType, error) {
+	return pe.readComposite(tr, typeName)()
+}
+
+// Start reading the composite type with the given name, the returned function waits for it, see getComposite.
+func (pe pgEngine) readComposite(tr fdb.ReadTransaction, typeName string) func() (*compositeType, error) {
+	name := domainName(typeName)
+	f := pe.readTypeKey(tr, "composite", name)
+	return func() (*compositeType, error) {
+		value := f()
+		if value == nil {
+			return nil, nil
+		}
+		return decodeCompositeType(name, value)
+	}
+}
+
+func decodeCompositeType(name string, value []byte) (*compositeType, error) {
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &compositeType{Name: name, Oid: uint32(t[0].(int64))}
+	for _, f := range t[1].(tuple.Tuple) {
+		field := f.(tuple.Tuple)
+		ct.Fields = append(ct.Fields, compositeField{Name: field[0].(string), Type: field[1].(string)})
+	}
+	return ct, nil
+}
+
+func (pe pgEngine) lookupComposite(typeName string) (*compositeType, error) {
+	ct, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		return pe.getComposite(rtr, typeName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get composite type: %w", err)
+	}
+	return ct.(*compositeType), nil
+}
+
+// List all composite types, ordered by name.
+func (pe pgEngine) getComposites() ([]*compositeType, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	compositeSS := catalogDir.Sub("composite")
+
+	var composites []*compositeType
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		ri := rtr.GetRange(compositeSS, fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			t, err := compositeSS.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			ct, err := decodeCompositeType(t[0].(string), kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			composites = append(composites, ct)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list composite types: %w", err)
+	}
+
+	return composites, nil
+}
+
+func isComposite(v any) bool {
+	_, ok := v.(composite)
+	return ok
+}
+
+// Build a record from ROW() or a parenthesized list of expressions.
+func evalRowExpr(re *pgquery.RowExpr, tbl *tableDefinition, r row) (any, error) {
+	c := composite{fields: []any{}}
+	for _, arg := range re.Args {
+		v, err := evalExpr(arg, tbl, r)
+		if err != nil {
+			return nil, err
+		}
+		c.fields = append(c.fields, v)
+	}
+	return c, nil
+}
+
+// The value of a field of a record, `(home).zip`.
+func recordField(v any, name string) (any, error) {
+	c, ok := v.(composite)
+	if !ok {
+		return nil, fmt.Errorf("column notation .%s applied to type %s, which is not a composite type", name, sqlTypeName(valueType(v)))
+	}
+	if c.typ == nil {
+		return nil, fmt.Errorf("could not identify column \"%s\" in record data type", name)
+	}
+
+	i, ok := c.typ.field(name)
+	if !ok {
+		return nil, fmt.Errorf("column \"%s\" not found in data type %s", name, c.typ.Name)
+	}
+	return c.fields[i], nil
+}
+
+// The composite type of an expression like `(home)` that `(home).*` expands, nil if it isn't known.
+func (tbl tableDefinition) exprComposite(n *pgquery.Node) *compositeType {
+	if c := n.GetColumnRef(); c != nil {
+		return tbl.columnComposite(c.Fields[len(c.Fields)-1].GetString_().GetStr())
+	}
+	return nil
+}
+
+/*
+
+Compare records field by field, like Postgres orders rows. NULL fields sort after all other
+values. A string is read as a record literal of the type of the other side.
+
+*/
+
+func cmpComposite(left, right any, coll *collation) (int, error) {
+	typ := func() *compositeType {
+		if c, ok := left.(composite); ok && c.typ != nil {
+			return c.typ
+		}
+		if c, ok := right.(composite); ok {
+			return c.typ
+		}
+		return nil
+	}()
+
+	operand := func(v any) (composite, error) {
+		switch value := v.(type) {
+		case composite:
+			return value, nil
+		case string:
+			if typ == nil {
+				return composite{}, fmt.Errorf("input of anonymous composite types is not implemented")
+			}
+			return typ.parse(value)
+		}
+		return composite{}, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	if len(l.fields) != len(r.fields) {
+		return 0, fmt.Errorf("cannot compare record types with different numbers of columns")
+	}
+
+	for i := range l.fields {
+		switch {
+		case l.fields[i] == nil && r.fields[i] == nil:
+			continue
+		case l.fields[i] == nil:
+			return 1, nil
+		case r.fields[i] == nil:
+			return -1, nil
+		}
+
+		c, err := compareValues(l.fields[i], r.fields[i], coll)
+		if err != nil || c != 0 {
+			return c, err
+		}
+	}
+	return 0, nil
+}
+
+func encodeComposite(c composite) tuple.Tuple {
+	fields := tuple.Tuple{}
+	for _, f := range c.fields {
+		fields = append(fields, cellElement(f))
+	}
+	return tuple.Tuple{"record", fields}
+}
+
+func decodeComposite(t tuple.Tuple) (composite, bool) {
+	if len(t) != 2 || t[0] != "record" {
+		return composite{}, false
+	}
+	fields, ok := t[1].(tuple.Tuple)
+	if !ok {
+		return composite{}, false
+	}
+
+	c := composite{fields: []any{}}
+	for _, f := range fields {
+		c.fields = append(c.fields, decodeCellElement(f))
+	}
+	return c, true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+
+Value compression.
+
+A text, varchar, char, json or jsonb cell of at least -compress-min-size bytes once encoded is
+compressed with zstd before it is written, if that makes it smaller. The compressed cell starts
+with a marker byte, which no encoded cell starts with since it isn't the code of a tuple
+element, and is followed by the zstd frame of the encoded cell:
+
+```
+data/table_data/doc/c/body/72746a7f-727f-4e0a-88f1-d983fea5c158: 0xfe <zstd frame of ("{\"name\": ...}",)>
+```
+
+Cells are decompressed where they are read (see readCell), so the rows read from either layout
+and from the chunks of large cells, which are compressed before they are split, are the same.
+Cells written before compression, or smaller than the threshold, are read as they are.
+
+*/
+
+// The byte compressed cells start with.
+const compressedCellMarker = 0xfe
+
+// The size of the encoded cells that are compressed, set by -compress-min-size, 0 to not compress.
+var compressMinSize = 1024
+
+// Note: EncodeAll and DecodeAll can be called concurrently, one encoder and decoder serve every session
+var (
+	cellEncoder, _ = zstd.NewWriter(nil)
+	cellDecoder, _ = zstd.NewReader(nil)
+)
+
+// Whether the cells of columns of the type may be compressed.
+func compressibleType(colType string) bool {
+	return colType == "text" || isCharacterType(colType) || isJSONType(colType)
+}
+
+// The cell as it is written for a column of the type, compressed if that is worth it.
+func compressCell(colType string, cell []byte) []byte {
+	if compressMinSize <= 0 || len(cell) < compressMinSize || !compressibleType(colType) {
+		return cell
+	}
+	compressed := cellEncoder.EncodeAll(cell, []byte{compressedCellMarker})
+	if len(compressed) >= len(cell) {
+		return cell
+	}
+	return compressed
+}
+
+// The encoded cell of a value as it was written, decompressed if it was compressed.
+func decompressCell(value []byte) []byte {
+	if len(value) == 0 || value[0] != compressedCellMarker {
+		return value
+	}
+	// Note: like decodeCell, a value that can't be decoded is read as the bytes it is
+	cell, err := cellDecoder.DecodeAll(value[1:], nil)
+	if err != nil {
+		return value
+	}
+	return cell
+}
@@ -3,20 +3,125 @@ package main
 import (
 	"flag"
 	"log"
+	"time"
 )
 
 type config struct {
-	columnar bool
-	reset    bool
-	pgPort   string
+	reset                    bool
+	pgPort                   string
+	listenAddr               string
+	unixSocketDir            string
+	proxyProtocol            bool
+	proxyTrusted             string
+	tcpKeepAlive             bool
+	tcpKeepAliveIdle         time.Duration
+	tcpKeepAliveInterval     time.Duration
+	tcpKeepAliveCount        int
+	tcpNoDelay               bool
+	socketReadBuffer         int
+	socketWriteBuffer        int
+	collation                string
+	timezone                 string
+	tlsCert                  string
+	tlsKey                   string
+	tlsOnly                  bool
+	tlsClientCA              string
+	auth                     string
+	users                    string
+	usersFile                string
+	certMap                  string
+	maxConnections           int
+	startupTimeout           time.Duration
+	idleTimeout              time.Duration
+	statementTimeout         time.Duration
+	connQueriesPerSecond     float64
+	hostQueriesPerSecond     float64
+	hostMaxConcurrentQueries int
+	auditLog                 string
+	auditRedact              bool
+	serializableSelects      bool
+	readVersionCache         time.Duration
+	tenants                  bool
+	clusterFile              string
+	fdbAPIVersion            int
+	fdbRetryLimit            int
+	fdbTransactionTimeout    time.Duration
+	scanWorkers              int
+	ttlReapInterval          time.Duration
+	compressMinSize          int
+	tombstoneDeleteRows      int64
+	tombstoneReapInterval    time.Duration
+	copyDir                  string
+	dump                     string
+	dumpDatabase             string
+	restore                  string
+	restoreDatabases         string
+	restoreSchemas           string
+	restoreSkipExisting      bool
 }
 
 func getConfig() config {
 	cfg := config{}
-	flag.BoolVar(&cfg.columnar, "columnar", false, "Open the database in columnar mode")
 	flag.BoolVar(&cfg.reset, "reset", false, "Reset the database on startup")
 	flag.StringVar(&cfg.pgPort, "pg-port", "6000", "Port to listen on for PostgreSQL connections")
+	flag.StringVar(&cfg.listenAddr, "listen-addr", "localhost", "Address to listen on for TCP connections, 0.0.0.0 for every interface")
+	flag.StringVar(&cfg.unixSocketDir, "unix-socket-dir", "/tmp", "Directory of the Unix domain socket, empty for none")
+	flag.BoolVar(&cfg.proxyProtocol, "proxy-protocol", false, "Read the client address from the PROXY header TCP connections start with, behind a load balancer")
+	flag.StringVar(&cfg.proxyTrusted, "proxy-trusted", "", "Networks of the load balancers PROXY headers are accepted from with -proxy-protocol, as 10.0.0.0/8,...")
+	flag.BoolVar(&cfg.tcpKeepAlive, "tcp-keepalive", true, "Send TCP keepalive probes on idle client connections")
+	flag.DurationVar(&cfg.tcpKeepAliveIdle, "tcp-keepalive-idle", 15*time.Second, "Time a client connection is idle before keepalive probes are sent")
+	flag.DurationVar(&cfg.tcpKeepAliveInterval, "tcp-keepalive-interval", 0, "Time between keepalive probes, 0 for the system default")
+	flag.IntVar(&cfg.tcpKeepAliveCount, "tcp-keepalive-count", 0, "Unanswered keepalive probes before a client connection is closed, 0 for the system default")
+	flag.BoolVar(&cfg.tcpNoDelay, "tcp-nodelay", true, "Send small writes right away, turning off Nagle's algorithm")
+	flag.IntVar(&cfg.socketReadBuffer, "socket-read-buffer", 0, "Size in bytes of the receive buffer of client connections, 0 for the system default")
+	flag.IntVar(&cfg.socketWriteBuffer, "socket-write-buffer", 0, "Size in bytes of the send buffer of client connections, 0 for the system default")
+	flag.StringVar(&cfg.collation, "collation", "C", "Default collation for comparing and sorting text, C for byte order")
+	flag.StringVar(&cfg.timezone, "timezone", "UTC", "Time zone timestamps with time zone are read and written in")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", "", "Certificate file to accept TLS connections with")
+	flag.StringVar(&cfg.tlsKey, "tls-key", "", "Private key file of the TLS certificate")
+	flag.BoolVar(&cfg.tlsOnly, "tls-only", false, "Refuse connections that don't use TLS")
+	flag.StringVar(&cfg.tlsClientCA, "tls-client-ca", "", "CA certificates file to verify client certificates with")
+	flag.StringVar(&cfg.auth, "auth", "trust", "How clients authenticate: trust, password (clear text), md5 or cert")
+	flag.StringVar(&cfg.users, "users", "", "Users that can log in besides the roles in the catalog, as user:password,...")
+	flag.StringVar(&cfg.usersFile, "users-file", "", "File of users that can log in besides the roles in the catalog, a user:password per line, which keeps the passwords off the command line")
+	flag.StringVar(&cfg.certMap, "cert-map", "", "Roles that certificate common names log in as with -auth=cert, as cn:role,...")
+	flag.IntVar(&cfg.maxConnections, "max-connections", 100, "Maximum number of concurrent sessions, 0 for no limit")
+	flag.DurationVar(&cfg.startupTimeout, "startup-timeout", time.Minute, "Time a client has to complete the startup and authentication, 0 for no limit")
+	flag.DurationVar(&cfg.idleTimeout, "idle-timeout", 0, "Time a session may wait for its next message before it is closed, 0 for no limit")
+	flag.DurationVar(&cfg.statementTimeout, "statement-timeout", 0, "Default statement_timeout of sessions, 0 for no limit")
+	flag.Float64Var(&cfg.connQueriesPerSecond, "conn-queries-per-second", 0, "Queries a session may run a second, 0 for no limit")
+	flag.Float64Var(&cfg.hostQueriesPerSecond, "host-queries-per-second", 0, "Queries the sessions of a client address may run a second together, 0 for no limit")
+	flag.IntVar(&cfg.hostMaxConcurrentQueries, "host-max-concurrent-queries", 0, "Queries the sessions of a client address may run at once, 0 for no limit")
+	flag.StringVar(&cfg.auditLog, "audit-log", "", "File to append the audit log of connections and statements to, fdb to record it in the database, empty for none")
+	flag.BoolVar(&cfg.auditRedact, "audit-redact", false, "Replace the constants of statements in the audit log with $1, $2...")
+	flag.BoolVar(&cfg.serializableSelects, "serializable-selects", false, "Read selects with normal reads, which make serializable transaction blocks fail on conflicting writes, instead of snapshot reads")
+	flag.DurationVar(&cfg.readVersionCache, "read-version-cache", 0, "Time selects outside of transaction blocks reuse a read version for, seeing data that much older, 0 to get one for every select")
+	flag.BoolVar(&cfg.tenants, "tenants", false, "Keep every database in a FoundationDB tenant of its own, needs FoundationDB 7.1 or later")
+	flag.StringVar(&cfg.clusterFile, "cluster-file", "", "FoundationDB cluster file to connect with, empty for the default one")
+	flag.IntVar(&cfg.fdbAPIVersion, "fdb-api-version", 710, "FoundationDB API version the client uses")
+	flag.IntVar(&cfg.fdbRetryLimit, "fdb-retry-limit", -1, "Times a FoundationDB transaction is retried before it fails, -1 for no limit")
+	flag.DurationVar(&cfg.fdbTransactionTimeout, "fdb-transaction-timeout", 0, "Time after which FoundationDB transactions are canceled, 0 for no limit")
+	flag.IntVar(&cfg.scanWorkers, "scan-workers", 4, "Parts of a table a full scan reads at once, split at the boundaries of its shards, 1 to read it in one range")
+	flag.DurationVar(&cfg.ttlReapInterval, "ttl-reap-interval", time.Minute, "Time between the runs of the reaper of expired rows of tables with a ttl, 0 for none")
+	flag.IntVar(&cfg.compressMinSize, "compress-min-size", 1024, "Size in bytes from which text and json cells are compressed with zstd, 0 to not compress")
+	flag.Int64Var(&cfg.tombstoneDeleteRows, "tombstone-delete-rows", 100000, "Rows from which a DELETE writes tombstones for a reaper to clear the rows later, 0 to always clear them")
+	flag.DurationVar(&cfg.tombstoneReapInterval, "tombstone-reap-interval", 10*time.Second, "Time between the runs of the reaper of rows deleted with tombstones, 0 for none")
+	flag.StringVar(&cfg.copyDir, "copy-dir", "", "Directory COPY TO may write files of the server to, empty to only allow COPY TO STDOUT")
+	flag.StringVar(&cfg.dump, "dump", "", "Write the catalog and rows of the databases to this file as SQL, - for stdout, and exit instead of serving")
+	flag.StringVar(&cfg.dumpDatabase, "dump-database", "", "Database -dump writes, empty for every database")
+	flag.StringVar(&cfg.restore, "restore", "", "Load a dump written with -dump from this file, - for stdin, and exit instead of serving")
+	flag.StringVar(&cfg.restoreDatabases, "restore-databases", "", "Databases -restore loads under other names, as old=new,...")
+	flag.StringVar(&cfg.restoreSchemas, "restore-schemas", "", "Schemas -restore loads under other names, as old=new,...")
+	flag.BoolVar(&cfg.restoreSkipExisting, "restore-skip-existing", false, "Skip the tables of the dump that exist, with their rows, comments and indexes, instead of failing")
 	flag.Parse()
-	log.Println("cfg: ", cfg)
+	log.Println("cfg: ", cfg.redacted())
+	return cfg
+}
+
+// The config without its secrets, to be logged.
+func (cfg config) redacted() config {
+	if cfg.users != "" {
+		cfg.users = "<redacted>"
+	}
 	return cfg
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+Connection limits.
+
+At most -max-connections sessions run at once. The session after them is refused once it has
+logged in, with a FATAL 53300 like Postgres sends:
+
+```
+psql: error: FATAL:  sorry, too many clients already
+```
+
+Clients that connect and never finish the startup are closed after -startup-timeout, which
+covers the PROXY header, the TLS handshake and the password exchange too. With -idle-timeout, sessions that
+send nothing for that long are closed with a FATAL, 57P05 or, inside a transaction block,
+25P03:
+
+```bash
+$ ./fakegres-fdb -max-connections=20 -startup-timeout=10s -idle-timeout=10m
+```
+
+So a client that goes away without closing its connection doesn't keep its goroutine forever.
+
+*/
+
+// Handle the startup of the session within -startup-timeout.
+func (pgs *pgServer) startup(pgc *pgproto3.Backend) (*pgproto3.Backend, error) {
+	if pgs.cfg.startupTimeout > 0 {
+		if err := pgs.conn.SetDeadline(time.Now().Add(pgs.cfg.startupTimeout)); err != nil {
+			return nil, fmt.Errorf("could not set startup timeout: %w", err)
+		}
+	}
+
+	// Note: local clients of the Unix domain socket don't come through the load balancer
+	if _, local := pgs.conn.(*net.UnixConn); pgs.cfg.proxyProtocol && !local {
+		if err := pgs.acceptProxyHeader(); err != nil {
+			return nil, err
+		}
+	}
+	pgs.audit(auditEvent{Event: "connect"})
+
+	pgc, err := pgs.handleStartupMessage(pgc)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("startup timeout of backend %d: %w", pgs.pid, err)
+		}
+		return nil, err
+	}
+
+	// Note: pgs.conn is the TLS connection if the startup upgraded it, which clears the deadline of the connection it wraps
+	if err := pgs.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("could not clear startup timeout: %w", err)
+	}
+	return pgc, nil
+}
+
+// Receive the next message of the session, closing it with a FATAL if none comes within -idle-timeout.
+func (pgs *pgServer) receive(pgc *pgproto3.Backend) (pgproto3.FrontendMessage, error) {
+	if pgs.cfg.idleTimeout > 0 {
+		if err := pgs.conn.SetReadDeadline(time.Now().Add(pgs.cfg.idleTimeout)); err != nil {
+			return nil, fmt.Errorf("could not set idle timeout: %w", err)
+		}
+	}
+
+	msg, err := pgc.Receive()
+	if err == nil {
+		return msg, nil
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, fmt.Errorf("error receiving message: %w", err)
+	}
+
+	code, message := sqlStateIdleSessionTimeout, "terminating connection due to idle-session timeout"
+	if pgs.tx != nil {
+		code, message = sqlStateIdleInTransactionSessionTimeout, "terminating connection due to idle-in-transaction timeout"
+	}
+	if err := pgs.writeFatal(code, message); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("idle timeout of backend %d", pgs.pid)
+}
+
+// Send a refusal of the session to the client as a FATAL, and return it as the error that ends the session.
+func (pgs *pgServer) refuseConnection(err error) error {
+	var pgErr *pgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	if err := pgs.writeFatal(pgErr.Code, pgErr.Message); err != nil {
+		return err
+	}
+	return fmt.Errorf("refused connection of backend %d: %w", pgs.pid, err)
+}
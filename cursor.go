@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+A server-side cursor over a select.
+
+Cursors don't hold on to a transaction or buffer results. They remember the key right after
+the last row they returned, and every FETCH continues the row layout scan from there in a
+new, bounded range read.
+
+Example:
+
+```sql
+declare c cursor for select name from customer where age > 18;
+fetch 100 from c;
+fetch 100 from c;
+close c;
+```
+
+Cursors belong to the connection that declared them and only move forward. Like in Postgres,
+cursors declared in a transaction block are closed when it ends, unless they are declared WITH
+HOLD. Cursors declared outside of a block are kept until they are closed.
+
+*/
+
+type cursor struct {
+	stmt    *pgquery.SelectStmt
+	tbl     *tableDefinition
+	results *pgResult
+	// The tables left to scan, partitions for partitioned tables
+	tables []string
+	// The key to continue the scan of tables[0] from, nil to start at the beginning
+	next fdb.Key
+	// The layout the cursor scans, see planLayout, and the columns it reads from the columnar layout
+	layout  string
+	columns []string
+	// Whether the cursor outlives the transaction block it was declared in
+	holdable bool
+	// The schema of the table, FETCH names no table to tell it
+	schema string
+}
+
+// CURSOR_OPT_HOLD of the options of DECLARE, set by WITH HOLD.
+const cursorOptHold = 0x0020
+
+func (pe pgEngine) declareCursor(stmt *pgquery.DeclareCursorStmt) (*cursor, error) {
+	s := stmt.Query.GetSelectStmt()
+	if s == nil {
+		return nil, fmt.Errorf("cursors are only supported over select")
+	}
+
+	if _, ok := lookupCatalogRelation(s.FromClause[0].GetRangeVar()); ok {
+		return nil, fmt.Errorf("cursors over catalog relations are not supported")
+	}
+
+	// Note: rows are returned in key order as they are scanned, there is nothing to sort them in
+	if len(s.SortClause) > 0 {
+		return nil, fmt.Errorf("cursors with ORDER BY are not supported")
+	}
+
+	tbl, err := pe.getTableDefinition(s.FromClause[0].GetRangeVar().Relname)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := selectTargets(s, tbl)
+	if err != nil {
+		return nil, err
+	}
+	if err := results.setLimit(s); err != nil {
+		return nil, err
+	}
+
+	tables, err := pe.selectTables(s, tbl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cursor{stmt: s, tbl: tbl, results: results, tables: tables, layout: planLayout(s, tbl), columns: projectedColumns(s, tbl), schema: pe.schema}, nil
+}
+
+// Return up to count rows of the cursor and advance it past them.
+func (pe pgEngine) fetchCursor(c *cursor, count int64) (*pgResult, error) {
+	pe = pe.withSchema(c.schema)
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	results := &pgResult{fieldNames: c.results.fieldNames, fieldTypes: append([]string{}, c.results.fieldTypes...), fieldExprs: c.results.fieldExprs}
+	// Note: the rows of the LIMIT and OFFSET are counted across fetches
+	results.offset, results.limit, results.limited, results.produced = c.results.offset, c.results.limit, c.results.limited, c.results.produced
+	defer func() { c.results.produced = results.produced }()
+	for int64(len(results.rows)) < count && len(c.tables) > 0 && !results.limitReached() {
+		kr := scanRange(tableDataSS, c.tbl, c.tables[0], c.layout, c.columns)
+		if c.next != nil {
+			kr.Begin = c.next
+		}
+
+		// Note: the rows asked for and the rows the LIMIT still lets the cursor return bound the batch
+		need, limited := results.scanLimit(c.stmt)
+		if !limited || need > count-int64(len(results.rows)) {
+			need, limited = count-int64(len(results.rows)), true
+		}
+		keysPerRow := len(c.columns)
+		if c.layout == layoutRow {
+			keysPerRow = 1
+		}
+		limit := batchKeys(c.stmt, need, limited, keysPerRow)
+
+		var rows []row
+		var rowEnds []fdb.Key
+		var complete bool
+		_, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+			_, rows, rowEnds, complete = readTableRows(rtr, tableDataSS, c.tbl, c.tables[0], c.layout, c.columns, kr, limit)
+			return nil, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch from cursor: %w", err)
+		}
+
+		consumed := 0
+		for i, r := range rows {
+			if int64(len(results.rows)) == count || results.limitReached() {
+				break
+			}
+
+			if err := results.addRows(c.stmt, c.tbl, []row{r}); err != nil {
+				return nil, err
+			}
+			c.next = rowEnds[i]
+			consumed++
+		}
+
+		// Note: move on to the next table once every row of this one was returned
+		if complete && consumed == len(rows) {
+			c.tables = c.tables[1:]
+			c.next = nil
+		}
+	}
+
+	return results, nil
+}
+
+// Handle the cursor statements, which keep state on the connection. Returns false for any other statement.
+func (pgs *pgServer) handleCursorStmt(stmt *pgquery.Node) (bool, error) {
+	pe := pgs.engine.withTransactor(pgs.transactor())
+
+	if d := stmt.GetDeclareCursorStmt(); d != nil {
+		if _, ok := pgs.cursors[d.Portalname]; ok {
+			return true, fmt.Errorf("cursor \"%s\" already exists", d.Portalname)
+		}
+
+		c, err := pe.declareCursor(d)
+		if err != nil {
+			return true, err
+		}
+
+		c.holdable = pgs.tx == nil || d.Options&cursorOptHold != 0
+		pgs.cursors[d.Portalname] = c
+		pgs.done(nil, "DECLARE CURSOR")
+		return true, nil
+	}
+
+	if f := stmt.GetFetchStmt(); f != nil {
+		c, ok := pgs.cursors[f.Portalname]
+		if !ok {
+			return true, fmt.Errorf("cursor \"%s\" does not exist", f.Portalname)
+		}
+
+		if f.Direction != pgquery.FetchDirection_FETCH_FORWARD {
+			return true, fmt.Errorf("cursor can only scan forward")
+		}
+
+		res, err := pgs.engine.withTransactor(pgs.selectTransactor()).fetchCursor(c, f.HowMany)
+		if err != nil {
+			return true, err
+		}
+
+		if f.Ismove {
+			pgs.done(nil, fmt.Sprintf("MOVE %d", len(res.rows)))
+			return true, nil
+		}
+
+		return true, pgs.writePgResult(res, "FETCH")
+	}
+
+	if cl := stmt.GetClosePortalStmt(); cl != nil {
+		// Note: an empty portal name is CLOSE ALL
+		if cl.Portalname == "" {
+			for name := range pgs.cursors {
+				delete(pgs.cursors, name)
+			}
+		} else if _, ok := pgs.cursors[cl.Portalname]; !ok {
+			return true, fmt.Errorf("cursor \"%s\" does not exist", cl.Portalname)
+		}
+
+		delete(pgs.cursors, cl.Portalname)
+		pgs.done(nil, "CLOSE CURSOR")
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Databases.
+
+A session is bound to the database named in its StartupMessage, or to the database named
+like its user if it names none, like Postgres. Every directory of the engine is opened
+within the directory of that database, so databases don't see each other's tables:
+
+```sql
+create database shop;
+```
+
+```
+database/shop/catalog/table/customer: ""
+database/shop/data/table_data/customer/...
+```
+
+The default database, postgres, always exists and keeps its directories at the root, where
+they were before there were databases. The directories of schemas are within the directory of
+the database, see schema.go. Dropping a database removes its directory with
+everything in it. Roles belong to no database, they are kept in the root catalog.
+
+Sessions asking for a database that doesn't exist are refused with a FATAL 3D000.
+
+*/
+
+const defaultDatabase = "postgres"
+
+// The path of a directory of the engine within the directory of its database, and of its schema for the directories of schemas.
+func (pe pgEngine) dirPath(name string) []string {
+	path := pe.databasePath()
+	if schemaDirectories[name] {
+		path = append(path, pe.schemaPath()...)
+	}
+	return append(path, name)
+}
+
+// The path of the directory of the database of the engine, the root for the default database.
+func (pe pgEngine) databasePath() []string {
+	if pe.tenants != nil || pe.database == "" || pe.database == defaultDatabase {
+		return nil
+	}
+	return []string{"database", pe.database}
+}
+
+func (pe pgEngine) databaseExists(name string) (bool, error) {
+	if name == defaultDatabase {
+		return true, nil
+	}
+	if pe.tenants != nil {
+		return pe.tenantExists(name)
+	}
+	exists, err := directory.Exists(pe.db, []string{"database", name})
+	if err != nil {
+		return false, fmt.Errorf("could not get database: %w", err)
+	}
+	return exists, nil
+}
+
+func (pe pgEngine) executeCreateDatabase(stmt *pgquery.CreatedbStmt) error {
+	exists, err := pe.databaseExists(stmt.Dbname)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return &pgError{Code: sqlStateDuplicateDatabase, Message: fmt.Sprintf("database \"%s\" already exists", stmt.Dbname)}
+	}
+
+	// Note: a new database is kept in the current format, it has nothing to migrate
+	created := pgEngine{db: pe.db, database: stmt.Dbname}
+	if pe.tenants != nil {
+		if err := pe.createTenant(stmt.Dbname); err != nil {
+			return err
+		}
+		t, err := pe.tenants.OpenTenant(tenantName(stmt.Dbname))
+		if err != nil {
+			return fmt.Errorf("could not create database: %w", err)
+		}
+		created = pgEngine{db: t, tenants: pe.tenants, database: stmt.Dbname}
+	} else if _, err := directory.Create(pe.db, []string{"database", stmt.Dbname}, nil); err != nil {
+		return fmt.Errorf("could not create database: %w", err)
+	}
+	return created.setFormatVersion(formatVersion)
+}
+
+func (pe pgEngine) executeDropDatabase(stmt *pgquery.DropdbStmt) error {
+	if stmt.Dbname == pe.database || stmt.Dbname == defaultDatabase {
+		return &pgError{Code: sqlStateObjectInUse, Message: fmt.Sprintf("cannot drop the currently open database \"%s\"", stmt.Dbname)}
+	}
+
+	var removed bool
+	var err error
+	if pe.tenants != nil {
+		removed, err = pe.deleteTenant(stmt.Dbname)
+	} else {
+		removed, err = directory.Root().Remove(pe.db, []string{"database", stmt.Dbname})
+	}
+	if err != nil {
+		return fmt.Errorf("could not drop database: %w", err)
+	}
+	if !removed {
+		if stmt.MissingOk {
+			pe.raiseNotice(sqlStateSuccessfulCompletion, "database \"%s\" does not exist, skipping", stmt.Dbname)
+			return nil
+		}
+		return &pgError{Code: sqlStateInvalidCatalogName, Message: fmt.Sprintf("database \"%s\" does not exist", stmt.Dbname)}
+	}
+	return nil
+}
+
+// Bind the session to the database of its startup parameters, refusing it if the database doesn't exist.
+func (pgs *pgServer) selectDatabase(params map[string]string) error {
+	database := params["database"]
+	if database == "" {
+		database = params["user"]
+	}
+	if database == "" {
+		database = defaultDatabase
+	}
+
+	exists, err := pgs.newEngine(pgs.db, database).databaseExists(database)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := pgs.writeFatal(sqlStateInvalidCatalogName, fmt.Sprintf("database \"%s\" does not exist", database)); err != nil {
+			return err
+		}
+		return fmt.Errorf("refused connection to unknown database %s", database)
+	}
+
+	ks, err := openKeyspace(pgs.db, pgs.cfg, database)
+	if err != nil {
+		return err
+	}
+	pgs.database = database
+	pgs.keyspace = ks
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Calendar dates.
+
+A date is the number of days since 1970-01-01, so dates compare and sort as integers and
+adding or subtracting days is integer arithmetic:
+
+```sql
+create table event (name text, day date);
+insert into event values ('launch', '2024-03-01'), ('review', date '2024-03-15');
+select name from event where day between '2024-03-01' and current_date - 7;
+```
+
+Cells hold the days in a tagged nested tuple, which the tuple encoding keeps in the order
+of the dates:
+
+```
+data/table_data/event/r/72746a7f-727f-4e0a-88f1-d983fea5c158/day: (("date", 19783),)
+```
+
+Like Postgres, the dates 'infinity' and '-infinity' are later and earlier than any other
+date, and dates are written in ISO 8601 format.
+
+*/
+
+type date int64
+
+const (
+	dateInfinity    date = math.MaxInt64
+	dateNegInfinity date = math.MinInt64
+	secondsPerDay        = 24 * 60 * 60
+)
+
+// The layouts a date can be written in, tried in order.
+var dateLayouts = []string{
+	"2006-01-02",
+	"20060102",
+	"2006/01/02",
+	"Jan 2 2006",
+	"January 2 2006",
+	"2-Jan-2006",
+	"2 Jan 2006",
+}
+
+func dateFromTime(t time.Time) date {
+	y, m, d := t.Date()
+	return date(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / secondsPerDay)
+}
+
+func (d date) time() time.Time {
+	return time.Unix(int64(d)*secondsPerDay, 0).UTC()
+}
+
+func (d date) String() string {
+	switch d {
+	case dateInfinity:
+		return "infinity"
+	case dateNegInfinity:
+		return "-infinity"
+	}
+	return d.time().Format("2006-01-02")
+}
+
+func parseDate(s string) (date, error) {
+	value := strings.TrimSpace(s)
+	today := dateFromTime(time.Now().In(defaultTimeZone))
+	switch strings.ToLower(value) {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today - 1, nil
+	case "tomorrow":
+		return today + 1, nil
+	case "epoch":
+		return 0, nil
+	case "infinity", "+infinity":
+		return dateInfinity, nil
+	case "-infinity":
+		return dateNegInfinity, nil
+	}
+
+	// Note: a time of day after the date is allowed and ignored
+	if i := strings.IndexAny(value, " T"); i == 10 {
+		value = value[:i]
+	}
+	value = strings.ReplaceAll(value, ",", "")
+
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return dateFromTime(t), nil
+		}
+
+		var pe *time.ParseError
+		if errors.As(err, &pe) && strings.Contains(pe.Message, "out of range") {
+			return 0, fmt.Errorf("date/time field value out of range: %q", s)
+		}
+	}
+
+	return 0, fmt.Errorf("invalid input syntax for type date: %q", s)
+}
+
+func castDate(v any) (string, error) {
+	switch value := v.(type) {
+	case date:
+		return value.String(), nil
+	case string:
+		d, err := parseDate(value)
+		if err != nil {
+			return "", err
+		}
+		return d.String(), nil
+	case timestamp, timestamptz:
+		d, _ := dateFromTimestamp(value)
+		return d.String(), nil
+	}
+	return "", fmt.Errorf("cannot cast type %T to date", v)
+}
+
+func isDate(v any) bool {
+	_, ok := v.(date)
+	return ok
+}
+
+// Compare dates with each other or with string constants, which are read as dates.
+func cmpDate(left, right any) (int, error) {
+	operand := func(v any) (date, error) {
+		switch value := v.(type) {
+		case date:
+			return value, nil
+		case string:
+			return parseDate(value)
+		}
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return cmpInt(int64(l), int64(r)), nil
+}
+
+// date + integer and date - integer are dates, the difference of two dates is a number of days.
+func dateArithmeticOp(op string, left, right any) (any, error) {
+	l, lok := left.(date)
+	switch r := right.(type) {
+	case int64:
+		if lok && (op == "+" || op == "-") {
+			if op == "-" {
+				r = -r
+			}
+			return addDays(l, r)
+		}
+	case date:
+		if i, ok := left.(int64); ok && op == "+" {
+			return addDays(r, i)
+		}
+		if lok && op == "-" {
+			if l == dateInfinity || l == dateNegInfinity || r == dateInfinity || r == dateNegInfinity {
+				return nil, fmt.Errorf("cannot subtract infinite dates")
+			}
+			return int64(l - r), nil
+		}
+	}
+
+	return nil, fmt.Errorf("operator does not exist: %T %s %T", left, op, right)
+}
+
+func addDays(d date, days int64) (date, error) {
+	if d == dateInfinity || d == dateNegInfinity {
+		return d, nil
+	}
+
+	sum := d + date(days)
+	if (days > 0 && sum < d) || (days < 0 && sum > d) || sum == dateInfinity || sum == dateNegInfinity {
+		return 0, fmt.Errorf("date out of range")
+	}
+	return sum, nil
+}
+
+func encodeDate(d date) tuple.Tuple {
+	return tuple.Tuple{"date", int64(d)}
+}
+
+func decodeDate(t tuple.Tuple) (date, bool) {
+	if len(t) != 2 || t[0] != "date" {
+		return 0, false
+	}
+	days, ok := t[1].(int64)
+	return date(days), ok
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Objects that depend on a table are recorded in the catalog, keyed by the table they depend
+on, so that dropping a table can find them with a single range read.
+
+Example:
+
+```
+catalog/depend/measurement/table/measurement_1: a
+catalog/depend/customer/index/customer_age_idx: n
+```
+
+The value is the dependency type, like pg_depend.deptype:
+
+- n (normal): the dependent must be dropped with CASCADE before the table can be dropped
+- a (auto): the dependent is dropped together with the table, partitions are auto dependencies
+
+*/
+
+const (
+	dependencyNormal = "n"
+	dependencyAuto   = "a"
+)
+
+type dependency struct {
+	Kind    string
+	Name    string
+	DepType string
+}
+
+func (pe pgEngine) recordDependency(tr fdb.Transaction, table string, kind string, name string, depType string) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dependSS := catalogDir.Sub("depend")
+	writes(tr).Set(dependSS.Pack(tuple.Tuple{table, kind, name}), []byte(depType))
+}
+
+func (pe pgEngine) getDependencies(tr fdb.ReadTransaction, table string) []dependency {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dependSS := catalogDir.Sub("depend")
+
+	var deps []dependency
+	ri := tr.GetRange(dependSS.Sub(table), fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).Iterator()
+	for ri.Advance() {
+		kv := ri.MustGet()
+		t, _ := dependSS.Unpack(kv.Key)
+		deps = append(deps, dependency{Kind: t[1].(string), Name: t[2].(string), DepType: string(kv.Value)})
+	}
+	return deps
+}
+
+/*
+
+Parse the drop statement and drop the tables with everything stored for them: catalog
+entries, partitions, comments and data.
+
+Dropping a table that others depend on fails unless CASCADE is given, in which case the
+dependents are dropped as well. Auto dependencies like partitions are always dropped.
+
+*/
+
+func (pe pgEngine) executeDrop(stmt *pgquery.DropStmt) error {
+	if stmt.RemoveType == pgquery.ObjectType_OBJECT_DOMAIN {
+		return pe.dropDomains(stmt)
+	}
+
+	if stmt.RemoveType == pgquery.ObjectType_OBJECT_TYPE {
+		return pe.dropTypes(stmt)
+	}
+
+	if stmt.RemoveType != pgquery.ObjectType_OBJECT_TABLE {
+		return fmt.Errorf("unsupported DROP: %s", stmt.RemoveType)
+	}
+
+	cascade := stmt.Behavior == pgquery.DropBehavior_DROP_CASCADE
+	_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, o := range stmt.Objects {
+			items := o.GetList().GetItems()
+			name := items[len(items)-1].GetString_().GetStr()
+
+			if !pe.tableExists(tr, name) {
+				if stmt.MissingOk {
+					pe.raiseNotice(sqlStateSuccessfulCompletion, "table \"%s\" does not exist, skipping", name)
+					continue
+				}
+				return nil, &pgError{Code: sqlStateUndefinedTable, Message: fmt.Sprintf("table \"%s\" does not exist", name)}
+			}
+
+			if err := pe.dropTable(tr, name, cascade); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not drop table: %w", err)
+	}
+
+	return nil
+}
+
+func (pe pgEngine) tableExists(tr fdb.ReadTransaction, name string) bool {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableSS := catalogDir.Sub("table")
+	return tr.Get(tableSS.Pack(tuple.Tuple{name})).MustGet() != nil
+}
+
+func (pe pgEngine) dropTable(tr fdb.Transaction, name string, cascade bool) error {
+	deps := pe.getDependencies(tr, name)
+
+	if !cascade {
+		var blocking []string
+		for _, d := range deps {
+			if d.DepType == dependencyNormal {
+				blocking = append(blocking, fmt.Sprintf("%s %s depends on table %s", d.Kind, d.Name, name))
+			}
+		}
+		if len(blocking) > 0 {
+			return fmt.Errorf("cannot drop table %s because other objects depend on it: %s", name, strings.Join(blocking, ", "))
+		}
+	}
+
+	for _, d := range deps {
+		switch d.Kind {
+		case "table":
+			if err := pe.dropTable(tr, d.Name, cascade); err != nil {
+				return err
+			}
+		case "index":
+			// Note: indexes are keyed by the table, they are cleared with it below
+		default:
+			return fmt.Errorf("cannot drop dependent %s %s", d.Kind, d.Name)
+		}
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	// Note: every subspace keyed by the table name, the table key itself and everything below it
+	for _, ss := range []string{"table", "partition", "comment", "depend", "index", "layout", "ttl", "column_id", "primary_key", "layout_build"} {
+		key := catalogDir.Sub(ss).Pack(tuple.Tuple{name})
+		writes(tr).Clear(key)
+		writes(tr).ClearRange(catalogDir.Sub(ss).Sub(name))
+	}
+	rangeQuery, _ := fdb.PrefixRange(tableDataSS.Pack(tuple.Tuple{name}))
+	writes(tr).ClearRange(rangeQuery)
+	writes(tr).ClearRange(dataDir.Sub("stats").Sub(name))
+	writes(tr).ClearRange(dataDir.Sub("ttl").Sub(name))
+	writes(tr).Clear(dataDir.Sub("tombstoned").Pack(tuple.Tuple{name}))
+	pe.clearTextIndexes(tr, name)
+
+	// Note: dropping a partition detaches it from its parent
+	ri := tr.GetRange(catalogDir.Sub("depend"), fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).Iterator()
+	for ri.Advance() {
+		kv := ri.MustGet()
+		t, _ := catalogDir.Sub("depend").Unpack(kv.Key)
+		if t[1].(string) == "table" && t[2].(string) == name {
+			writes(tr).Clear(kv.Key)
+			writes(tr).Clear(catalogDir.Sub("partition").Pack(tuple.Tuple{t[0], name}))
+		}
+	}
+
+	log.Printf("Dropped table %s", name)
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Describing prepared statements.
+
+Drivers like pgx and JDBC Describe a statement after Parse and before they Bind it, to learn
+the types of its parameters, which decide how they send them, and the fields of its rows. The
+answer is a ParameterDescription followed by a RowDescription, or by NoData for statements
+that return no rows.
+
+Parameters Parse didn't give a type for take the type of what they are compared with or
+stored in, like in Postgres:
+
+```sql
+select * from person where age = $1;            -- $1 int4, the type of age
+insert into person (name, age) values ($1, $2);  -- $1 text, $2 int4
+select * from person limit $1;                   -- $1 int8
+select $1::date;                                 -- $1 date
+```
+
+The inferred types are kept with the statement, so Bind casts the values to them. Parameters
+whose type can't be told are described as text and bound as untyped constants.
+
+The fields of a select are known from its target list without running it. Expressions whose
+type is only known from their values are described as text.
+
+*/
+
+const textOID = 25
+
+// The table the rows of the statement are in, nil if it has none or it doesn't exist.
+func (pe pgEngine) statementTable(n *pgquery.Node) *tableDefinition {
+	var rv *pgquery.RangeVar
+	switch {
+	case n.GetInsertStmt() != nil:
+		rv = n.GetInsertStmt().Relation
+	case n.GetDeleteStmt() != nil:
+		rv = n.GetDeleteStmt().Relation
+	case n.GetUpdateStmt() != nil:
+		rv = n.GetUpdateStmt().Relation
+	case n.GetSelectStmt() != nil && len(n.GetSelectStmt().FromClause) > 0:
+		rv = n.GetSelectStmt().FromClause[0].GetRangeVar()
+	}
+	if rv == nil {
+		return nil
+	}
+	if _, ok := lookupCatalogRelation(rv); ok {
+		return nil
+	}
+
+	tbl, err := pe.getTableDefinition(rv.Relname)
+	if err != nil {
+		return nil
+	}
+	return tbl
+}
+
+// The types of the parameters of the statement that can be told from where they are used, empty for the
+// others. tbl is the table of the statement, nil if it has none.
+func inferParamTypes(stmt *pgquery.RawStmt, tbl *tableDefinition, count int) []string {
+	types := make([]string, count)
+	set := func(n *pgquery.Node, t string) {
+		if p := n.GetParamRef(); p != nil && int(p.Number) <= count && types[p.Number-1] == "" {
+			types[p.Number-1] = t
+		}
+	}
+
+	n := stmt.GetStmt()
+	columnType := func(n *pgquery.Node) string {
+		fields := n.GetColumnRef().GetFields()
+		if tbl == nil || len(fields) == 0 {
+			return ""
+		}
+		t, _ := tbl.columnType(fields[len(fields)-1].GetString_().GetStr())
+		return t
+	}
+
+	if ins := n.GetInsertStmt(); ins != nil && tbl != nil {
+		columns := tbl.ColumnNames
+		if len(ins.Cols) > 0 {
+			columns = nil
+			for _, c := range ins.Cols {
+				columns = append(columns, c.GetResTarget().Name)
+			}
+		}
+		for _, values := range ins.GetSelectStmt().GetSelectStmt().GetValuesLists() {
+			for i, item := range values.GetList().GetItems() {
+				if i < len(columns) {
+					if t, ok := tbl.columnType(columns[i]); ok {
+						set(item, t)
+					}
+				}
+			}
+		}
+	}
+
+	if s := n.GetSelectStmt(); s != nil {
+		set(s.LimitCount, "pg_catalog.int8")
+		set(s.LimitOffset, "pg_catalog.int8")
+	}
+
+	walkNodes(stmt.ProtoReflect(), func(n *pgquery.Node) {
+		if tc := n.GetTypeCast(); tc != nil {
+			set(tc.Arg, typeNameString(tc.TypeName))
+		}
+
+		// Note: the parameter takes the type of the column on the other side, or of the column IN compares
+		if e := n.GetAExpr(); e != nil {
+			if t := columnType(e.Lexpr); t != "" {
+				set(e.Rexpr, t)
+				for _, item := range e.Rexpr.GetList().GetItems() {
+					set(item, t)
+				}
+			}
+			if t := columnType(e.Rexpr); t != "" {
+				set(e.Lexpr, t)
+			}
+		}
+	})
+	return types
+}
+
+// Fill in the types of the parameters of the statement Parse didn't give one for, where they can be told.
+func (pgs *pgServer) inferParamOIDs(ps *preparedStatement) {
+	if ps.stmt == nil {
+		return
+	}
+	if err := pgs.useSchema(ps.stmt.GetStmt()); err != nil {
+		return
+	}
+	tbl := pgs.engine.withTransactor(pgs.transactor()).statementTable(ps.stmt.GetStmt())
+	for i, t := range inferParamTypes(ps.stmt, tbl, len(ps.paramOIDs)) {
+		if ps.paramOIDs[i] != 0 || t == "" {
+			continue
+		}
+		paramType, _ := splitTypeMods(t)
+		ps.paramOIDs[i], _ = pgs.typeInfo(paramType)
+	}
+}
+
+// The parameter types of the statement as Describe sends them, text for the ones of unknown type.
+func describedParamOIDs(ps *preparedStatement) []uint32 {
+	oids := make([]uint32, len(ps.paramOIDs))
+	for i, oid := range ps.paramOIDs {
+		oids[i] = oid
+		if oid == 0 {
+			oids[i] = textOID
+		}
+	}
+	return oids
+}
+
+// The fields of the rows the statement returns, without running it. Nil for statements that return none.
+func (pgs *pgServer) describeStatement(stmt *pgquery.RawStmt) (*pgResult, error) {
+	if stmt == nil || !returnsRows(stmt) {
+		return nil, nil
+	}
+	n := stmt.GetStmt()
+
+	if show := n.GetVariableShowStmt(); show != nil {
+		if show.Name == "all" {
+			return &pgResult{fieldNames: []string{"name", "setting"}, fieldTypes: []string{"text", "text"}}, nil
+		}
+		return &pgResult{fieldNames: []string{show.Name}, fieldTypes: []string{"text"}}, nil
+	}
+
+	// Note: FETCH returns the fields of the select of its cursor
+	if f := n.GetFetchStmt(); f != nil {
+		c, ok := pgs.cursors[f.Portalname]
+		if !ok {
+			return nil, &pgError{Code: sqlStateInvalidCursorName, Message: fmt.Sprintf("cursor \"%s\" does not exist", f.Portalname)}
+		}
+		return describedFields(c.results), nil
+	}
+
+	if err := pgs.useSchema(n); err != nil {
+		return nil, err
+	}
+	s := n.GetSelectStmt()
+	pe := pgs.engine.withTransactor(pgs.transactor())
+	if q, fields, ok := matchDriverQuery(s); ok {
+		res, err := pe.executeDriverQuery(s, q, fields)
+		if err != nil {
+			return nil, err
+		}
+		return describedFields(res), nil
+	}
+	if len(s.FromClause) > 0 {
+		if rel, ok := lookupCatalogRelation(s.FromClause[0].GetRangeVar()); ok {
+			// Note: the fields of catalog relations are built with their rows, which are read from the catalog
+			res, err := pe.executeCatalogSelect(s, rel)
+			if err != nil {
+				return nil, err
+			}
+			return describedFields(res), nil
+		}
+		if _, ok := matchCountStar(s); ok {
+			return &pgResult{fieldNames: []string{targetName(s.TargetList[0].GetResTarget())}, fieldTypes: []string{"pg_catalog.int8"}}, nil
+		}
+	}
+
+	tbl := &tableDefinition{}
+	if len(s.FromClause) > 0 {
+		var err error
+		if tbl, err = pe.getTableDefinition(s.FromClause[0].GetRangeVar().Relname); err != nil {
+			return nil, err
+		}
+	}
+	res, err := selectTargets(s, tbl)
+	if err != nil {
+		return nil, err
+	}
+	return describedFields(res), nil
+}
+
+// The fields of a result without its rows, with the types that are only known from values as text.
+func describedFields(res *pgResult) *pgResult {
+	described := &pgResult{fieldNames: res.fieldNames}
+	for _, t := range res.fieldTypes {
+		if t == "" {
+			t = "text"
+		}
+		described.fieldTypes = append(described.fieldTypes, t)
+	}
+	return described
+}
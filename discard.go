@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Resetting sessions for connection poolers.
+
+A pooler like PgBouncer in transaction pooling mode hands a server connection to a different
+client for every transaction, and cleans up the session in between with DISCARD ALL, or with
+the statements it stands for:
+
+```sql
+close all;
+reset all;
+deallocate all;
+unlisten *;
+select pg_advisory_unlock_all();
+discard plans;
+```
+
+State that only lives as long as a transaction block ends with it, so it doesn't leak into the
+transactions of other clients: settings changed with SET LOCAL and cursors declared without
+WITH HOLD. The cached table definitions are checked against the catalog version on every use,
+so they are never stale on a connection that changes hands.
+
+DISCARD PLANS drops the cached table definitions, there are no plans. fakegres has no temporary
+tables or sequences, so DISCARD TEMP and DISCARD SEQUENCES have nothing to do.
+
+*/
+
+var discardTags = map[pgquery.DiscardMode]string{
+	pgquery.DiscardMode_DISCARD_ALL:       "DISCARD ALL",
+	pgquery.DiscardMode_DISCARD_PLANS:     "DISCARD PLANS",
+	pgquery.DiscardMode_DISCARD_SEQUENCES: "DISCARD SEQUENCES",
+	pgquery.DiscardMode_DISCARD_TEMP:      "DISCARD TEMP",
+}
+
+// Handle DISCARD and DEALLOCATE. Returns false for any other statement.
+func (pgs *pgServer) handleDiscardStmt(stmt *pgquery.Node) (bool, error) {
+	if d := stmt.GetDeallocateStmt(); d != nil {
+		// Note: an empty name is DEALLOCATE ALL
+		if d.Name == "" {
+			pgs.statements = map[string]*preparedStatement{}
+			pgs.done(nil, "DEALLOCATE ALL")
+			return true, nil
+		}
+
+		if _, ok := pgs.statements[d.Name]; !ok {
+			return true, &pgError{Code: sqlStateInvalidSQLStatementName, Message: fmt.Sprintf("prepared statement \"%s\" does not exist", d.Name)}
+		}
+		delete(pgs.statements, d.Name)
+		pgs.done(nil, "DEALLOCATE")
+		return true, nil
+	}
+
+	d := stmt.GetDiscardStmt()
+	if d == nil {
+		return false, nil
+	}
+
+	switch d.Target {
+	case pgquery.DiscardMode_DISCARD_ALL:
+		if pgs.tx != nil {
+			return true, &pgError{Code: sqlStateActiveSQLTransaction, Message: "DISCARD ALL cannot run inside a transaction block"}
+		}
+		if err := pgs.discardAll(); err != nil {
+			return true, err
+		}
+	case pgquery.DiscardMode_DISCARD_PLANS:
+		pgs.forgetTables()
+	}
+
+	pgs.done(nil, discardTags[d.Target])
+	return true, nil
+}
+
+// Put the session back the way it was after its startup.
+func (pgs *pgServer) discardAll() error {
+	pgs.cursors = map[string]*cursor{}
+	pgs.resetSettings()
+	pgs.defaultIsolation = defaultIsolation
+	// Note: the portal running DISCARD ALL is held by its Execute, which still sends its completion
+	pgs.statements = map[string]*preparedStatement{}
+	pgs.portals = map[string]*portal{}
+	pgs.unlisten("")
+	if err := pgs.unlockAllAdvisory(); err != nil {
+		return err
+	}
+	pgs.forgetTables()
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Domains, named base types with constraints.
+
+Example:
+
+```sql
+create domain posint as int not null check (value > 0);
+create table account (id posint, name text);
+```
+
+Will produce the following KV structure, check constraints are kept as SQL:
+
+```
+catalog/domain/posint: ("pg_catalog.int4", true, (("posint_check", "SELECT value > 0"),))
+catalog/table/account/id: posint
+```
+
+Columns keep the domain as their type in the catalog. When the table definition is read,
+the domain is resolved so the column behaves like its base type everywhere else, including
+the type OID sent to clients. Values are checked against the constraints of the domain on
+INSERT and ALTER COLUMN TYPE. A domain over a domain takes over the base type and the
+constraints of the other domain.
+
+*/
+
+type domain struct {
+	Name     string
+	BaseType string
+	NotNull  bool
+	Checks   []domainCheck
+}
+
+type domainCheck struct {
+	Name string
+	Expr *pgquery.Node
+}
+
+// Domains are created in the public schema, references may be qualified with it.
+func domainName(typeName string) string {
+	return strings.TrimPrefix(typeName, "public.")
+}
+
+func (pe pgEngine) executeCreateDomain(stmt *pgquery.CreateDomainStmt) error {
+	name := stmt.Domainname[len(stmt.Domainname)-1].GetString_().GetStr()
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainSS := catalogDir.Sub("domain")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if typeExists(tr, catalogDir, name) {
+			return nil, &pgError{Code: sqlStateDuplicateObject, Message: fmt.Sprintf("type \"%s\" already exists", name)}
+		}
+
+		d := &domain{Name: name, BaseType: typeNameString(stmt.TypeName)}
+		base, err := pe.getDomain(tr, d.BaseType)
+		if err != nil {
+			return nil, err
+		}
+		if base != nil {
+			d.BaseType, d.NotNull, d.Checks = base.BaseType, base.NotNull, base.Checks
+		}
+
+		for _, c := range stmt.Constraints {
+			con := c.GetConstraint()
+			switch con.Contype {
+			case pgquery.ConstrType_CONSTR_NOTNULL:
+				d.NotNull = true
+			case pgquery.ConstrType_CONSTR_NULL:
+			case pgquery.ConstrType_CONSTR_CHECK:
+				checkName := con.Conname
+				if checkName == "" {
+					checkName = name + "_check"
+				}
+				d.Checks = append(d.Checks, domainCheck{Name: checkName, Expr: con.RawExpr})
+			default:
+				return nil, fmt.Errorf("unsupported domain constraint: %s", con.Contype)
+			}
+		}
+
+		// Note: check the constraints against a NULL, so broken expressions fail now instead of on insert
+		probe := *d
+		probe.NotNull = false
+		if err := probe.validate(nil); err != nil {
+			return nil, err
+		}
+
+		var checks tuple.Tuple
+		for _, c := range d.Checks {
+			sql, err := deparseExpr(c.Expr)
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, tuple.Tuple{c.Name, sql})
+		}
+		writes(tr).Set(domainSS.Pack(tuple.Tuple{name}), tuple.Tuple{d.BaseType, d.NotNull, checks}.Pack())
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not create domain: %w", err)
+	}
+
+	return nil
+}
+
+// Get the domain with the given name, nil if the type is not a domain.
+func (pe pgEngine) getDomain(tr fdb.ReadTransaction, typeName string) (*domain, error) {
+	return pe.readDomain(tr, typeName)()
+}
+
+// Start reading the domain with the given name, the returned function waits for it, see getDomain.
+func (pe pgEngine) readDomain(tr fdb.ReadTransaction, typeName string) func() (*domain, error) {
+	name := domainName(typeName)
+	value := pe.readTypeKey(tr, "domain", name)
+	return func() (*domain, error) {
+		return decodeDomain(name, value())
+	}
+}
+
+func decodeDomain(name string, value []byte) (*domain, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &domain{Name: name, BaseType: t[0].(string), NotNull: t[1].(bool)}
+	for _, c := range t[2].(tuple.Tuple) {
+		check := c.(tuple.Tuple)
+		expr, err := parseExpr(check[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		d.Checks = append(d.Checks, domainCheck{Name: check[0].(string), Expr: expr})
+	}
+	return d, nil
+}
+
+// List all domains, ordered by name.
+func (pe pgEngine) getDomains() ([]*domain, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainSS := catalogDir.Sub("domain")
+
+	var domains []*domain
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		ri := rtr.GetRange(domainSS, fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			t, err := domainSS.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			d, err := decodeDomain(t[0].(string), kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			domains = append(domains, d)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+
+	return domains, nil
+}
+
+func (pe pgEngine) lookupDomain(typeName string) (*domain, error) {
+	d, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		return pe.getDomain(rtr, typeName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get domain: %w", err)
+	}
+	return d.(*domain), nil
+}
+
+// Check a value, in the text form it is stored in, against the constraints of the domain. nil is NULL.
+func (d *domain) validate(value *string) error {
+	if value == nil && d.NotNull {
+		return fmt.Errorf("domain %s does not allow null values", d.Name)
+	}
+
+	r := row{}
+	if value != nil {
+		if _, err := parseCell(d.BaseType, *value); err != nil {
+			return err
+		}
+		r["value"] = *value
+	}
+
+	// Note: constraints refer to the value being checked as VALUE
+	tbl := &tableDefinition{Name: d.Name, ColumnNames: []string{"value"}, ColumnTypes: []string{d.BaseType}}
+	for _, c := range d.Checks {
+		v, err := evalExpr(c.Expr, tbl, r)
+		if err != nil {
+			return err
+		}
+
+		// Note: like every check constraint, NULL passes
+		if b, ok := v.(bool); ok && !b {
+			return fmt.Errorf("value for domain %s violates check constraint \"%s\"", d.Name, c.Name)
+		}
+	}
+
+	return nil
+}
+
+/*
+
+Drop domains. A domain that is still the type of a column can't be dropped, with or without
+CASCADE, since columns can't be dropped.
+
+*/
+
+func (pe pgEngine) dropDomains(stmt *pgquery.DropStmt) error {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainSS := catalogDir.Sub("domain")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, o := range stmt.Objects {
+			name := domainName(typeNameString(o.GetTypeName()))
+			key := domainSS.Pack(tuple.Tuple{name})
+			if tr.Get(key).MustGet() == nil {
+				if stmt.MissingOk {
+					pe.raiseNotice(sqlStateSuccessfulCompletion, "type \"%s\" does not exist, skipping", name)
+					continue
+				}
+				return nil, &pgError{Code: sqlStateUndefinedObject, Message: fmt.Sprintf("type \"%s\" does not exist", name)}
+			}
+
+			if err := pe.checkTypeUnused(tr, name); err != nil {
+				return nil, err
+			}
+			writes(tr).Clear(key)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not drop domain: %w", err)
+	}
+
+	return nil
+}
+
+// Whether a domain, enum or composite type has the name. They share the names of types, like in Postgres.
+func typeExists(tr fdb.ReadTransaction, catalogDir directory.DirectorySubspace, name string) bool {
+	for _, kind := range []string{"domain", "enum", "composite"} {
+		if tr.Get(catalogDir.Sub(kind).Pack(tuple.Tuple{name})).MustGet() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Fail if the type is still the type of a column, which keeps it from being dropped.
+func (pe pgEngine) checkTypeUnused(tr fdb.ReadTransaction, name string) error {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableSS := catalogDir.Sub("table")
+
+	var columns []string
+	ri := tr.GetRange(tableSS, fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).Iterator()
+	for ri.Advance() {
+		kv := ri.MustGet()
+		t, _ := tableSS.Unpack(kv.Key)
+		if len(t) == 2 && domainName(string(kv.Value)) == name {
+			columns = append(columns, fmt.Sprintf("column %s of table %s depends on type %s", t[1], t[0], name))
+		}
+	}
+
+	// Note: fields of composite types depend on their types too
+	compositeSS := catalogDir.Sub("composite")
+	ri = tr.GetRange(compositeSS, fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).Iterator()
+	for ri.Advance() {
+		kv := ri.MustGet()
+		t, _ := compositeSS.Unpack(kv.Key)
+		ct, err := decodeCompositeType(t[0].(string), kv.Value)
+		if err != nil {
+			return err
+		}
+		for _, f := range ct.Fields {
+			if domainName(f.Type) == name {
+				columns = append(columns, fmt.Sprintf("column %s of composite type %s depends on type %s", f.Name, ct.Name, name))
+			}
+		}
+	}
+	if len(columns) > 0 {
+		return fmt.Errorf("cannot drop type %s because other objects depend on it: %s", name, strings.Join(columns, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+/*
+
+Dumps.
+
+With -dump the server writes the schemas, types, tables, comments and indexes of its databases,
+and the rows of their tables, to a file of SQL, like the plain format of pg_dump, and exits
+instead of serving:
+
+```
+fakegres -dump backup.sql
+fakegres -dump - -dump-database shop > shop.sql
+```
+
+```sql
+CREATE DATABASE "shop";
+\connect "shop"
+CREATE TYPE "mood" AS ENUM ('sad', 'ok');
+CREATE TABLE "person" ("name" text, "age" pg_catalog.int4) WITH (layout = 'row');
+COPY "person" ("name", "age") FROM stdin;
+garry	14
+ted	\N
+\.
+CREATE INDEX "person_age_idx" ON "person" ("age");
+```
+
+The dump is loaded back with -restore (see restore.go), or with psql. The rows of a table are
+read in batches of snapshot reads at batch priority, each batch as the table was at one point
+in time. Rows written while a table is dumped may or may not be in the dump, a dump of a
+database that isn't written meanwhile has all of it. Rows are in the text form the server sends
+them in, they get new row ids when they are loaded and rows of tables with a ttl expire that
+long after they are loaded. Indexes are created after the rows are loaded, like with pg_dump.
+Roles belong to no database and aren't dumped.
+
+*/
+
+// Write the databases of the keyspace, or the one of -dump-database, to the file of -dump.
+func dumpDatabases(db fdb.Database, cfg config) error {
+	out := io.Writer(os.Stdout)
+	if cfg.dump != "-" {
+		f, err := os.Create(cfg.dump)
+		if err != nil {
+			return fmt.Errorf("could not create dump file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	engines, err := databaseEngines(db, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "-- fakegres dump")
+	dumped := false
+	for _, pe := range engines {
+		if cfg.dumpDatabase != "" && pe.database != cfg.dumpDatabase {
+			continue
+		}
+		if err := pe.withPriority(priorityBatch).dumpDatabase(w); err != nil {
+			return fmt.Errorf("could not dump database %s: %w", pe.database, err)
+		}
+		dumped = true
+	}
+	if !dumped {
+		return fmt.Errorf("database \"%s\" does not exist", cfg.dumpDatabase)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not write dump file: %w", err)
+	}
+	if f, ok := out.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// Write the schemas of the database of the engine: types first, then tables, their rows and indexes last.
+func (pe pgEngine) dumpDatabase(w *bufio.Writer) error {
+	fmt.Fprintf(w, "\n-- Database %s\n\n", pe.database)
+	if pe.database != defaultDatabase {
+		fmt.Fprintf(w, "CREATE DATABASE %s;\n", quoteIdent(pe.database))
+	}
+	fmt.Fprintf(w, "\\connect %s\n", quoteIdent(pe.database))
+
+	schemas, err := pe.schemaNames()
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas[1:] {
+		fmt.Fprintf(w, "CREATE SCHEMA %s;\n", quoteIdent(schema))
+	}
+
+	// Note: types are looked up in public by the tables of every schema, they all come before the tables
+	for _, schema := range schemas {
+		if err := pe.withSchema(schema).dumpTypes(w); err != nil {
+			return err
+		}
+	}
+	for _, schema := range schemas {
+		if err := pe.withSchema(schema).dumpTables(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The name of an object of the schema of the engine, qualified unless it is in public.
+func (pe pgEngine) qualifiedName(name string) string {
+	if pe.schemaPath() == nil {
+		return quoteIdent(name)
+	}
+	return quoteIdent(pe.schema) + "." + quoteIdent(name)
+}
+
+func (pe pgEngine) dumpTypes(w *bufio.Writer) error {
+	enums, err := pe.getEnums()
+	if err != nil {
+		return err
+	}
+	for _, e := range enums {
+		var labels []string
+		for _, l := range e.Labels {
+			labels = append(labels, quoteLiteral(l))
+		}
+		fmt.Fprintf(w, "CREATE TYPE %s AS ENUM (%s);\n", pe.qualifiedName(e.Name), strings.Join(labels, ", "))
+	}
+
+	composites, err := pe.getComposites()
+	if err != nil {
+		return err
+	}
+	for _, ct := range composites {
+		var fields []string
+		for _, f := range ct.Fields {
+			fields = append(fields, quoteIdent(f.Name)+" "+f.Type)
+		}
+		fmt.Fprintf(w, "CREATE TYPE %s AS (%s);\n", pe.qualifiedName(ct.Name), strings.Join(fields, ", "))
+	}
+
+	domains, err := pe.getDomains()
+	if err != nil {
+		return err
+	}
+	for _, d := range domains {
+		sql := fmt.Sprintf("CREATE DOMAIN %s AS %s", pe.qualifiedName(d.Name), d.BaseType)
+		if d.NotNull {
+			sql += " NOT NULL"
+		}
+		for _, c := range d.Checks {
+			expr, err := deparseExpr(c.Expr)
+			if err != nil {
+				return err
+			}
+			sql += fmt.Sprintf(" CONSTRAINT %s CHECK (%s)", quoteIdent(c.Name), strings.TrimPrefix(expr, "SELECT "))
+		}
+		fmt.Fprintf(w, "%s;\n", sql)
+	}
+	return nil
+}
+
+// Write the tables of the schema of the engine with their partitions and comments, then their rows, then their indexes.
+func (pe pgEngine) dumpTables(w *bufio.Writer) error {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return err
+	}
+
+	// Note: partitions are created after their partitioned table, which has no rows of its own
+	var tables []*tableDefinition
+	specs := map[string]*partitionSpec{}
+	partitions := map[string]bool{}
+	for _, name := range names {
+		tbl, err := pe.getTableDefinition(name)
+		if err != nil {
+			return err
+		}
+		spec, err := pe.getPartitionSpec(name)
+		if err != nil {
+			return err
+		}
+		if spec != nil {
+			specs[name] = spec
+			for _, p := range spec.Partitions {
+				partitions[p.Name] = true
+			}
+		}
+		tables = append(tables, tbl)
+	}
+
+	for _, tbl := range tables {
+		if partitions[tbl.Name] {
+			continue
+		}
+		fmt.Fprintf(w, "%s;\n", pe.createTableSQL(tbl, specs[tbl.Name]))
+		if spec := specs[tbl.Name]; spec != nil {
+			for _, p := range spec.Partitions {
+				fmt.Fprintf(w, "CREATE TABLE %s PARTITION OF %s %s;\n", pe.qualifiedName(p.Name), pe.qualifiedName(tbl.Name), partitionBoundSQL(spec.Strategy, p))
+			}
+		}
+	}
+
+	comments, err := pe.getComments()
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if c.Column == "" {
+			fmt.Fprintf(w, "COMMENT ON TABLE %s IS %s;\n", pe.qualifiedName(c.Table), quoteLiteral(c.Description))
+		} else {
+			fmt.Fprintf(w, "COMMENT ON COLUMN %s.%s IS %s;\n", pe.qualifiedName(c.Table), quoteIdent(c.Column), quoteLiteral(c.Description))
+		}
+	}
+
+	for _, tbl := range tables {
+		if specs[tbl.Name] != nil {
+			continue
+		}
+		if err := pe.dumpRows(w, tbl); err != nil {
+			return fmt.Errorf("could not dump rows of %s: %w", tbl.Name, err)
+		}
+	}
+
+	indexes, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		var indexes []string
+		for _, tbl := range tables {
+			for _, idx := range pe.getTextIndexes(rtr, tbl.Name) {
+				indexes = append(indexes, pe.createIndexSQL(tbl.Name, idx))
+			}
+		}
+		return indexes, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, sql := range indexes.([]string) {
+		fmt.Fprintf(w, "%s;\n", sql)
+	}
+	return nil
+}
+
+func (pe pgEngine) createTableSQL(tbl *tableDefinition, spec *partitionSpec) string {
+	var columns []string
+	for i, name := range tbl.ColumnNames {
+		column := quoteIdent(name) + " " + tbl.declaredType(i)
+		if name == tbl.PrimaryKey {
+			column += " PRIMARY KEY"
+		}
+		columns = append(columns, column)
+	}
+	sql := fmt.Sprintf("CREATE TABLE %s (%s)", pe.qualifiedName(tbl.Name), strings.Join(columns, ", "))
+
+	if spec != nil {
+		sql += fmt.Sprintf(" PARTITION BY %s (%s)", strings.ToUpper(spec.Strategy), quoteIdent(spec.Column))
+	}
+	var options []string
+	if tbl.Layout != layoutHybrid {
+		options = append(options, fmt.Sprintf("layout = %s", quoteLiteral(tbl.Layout)))
+	}
+	if tbl.TTL != nil {
+		options = append(options, fmt.Sprintf("ttl = %s", quoteLiteral(tbl.TTL.String())))
+	}
+	if len(options) > 0 {
+		sql += fmt.Sprintf(" WITH (%s)", strings.Join(options, ", "))
+	}
+	return sql
+}
+
+func partitionBoundSQL(strategy string, p partitionBound) string {
+	if p.IsDefault {
+		return "DEFAULT"
+	}
+	if strategy == "list" {
+		var values []string
+		for _, v := range p.Values {
+			values = append(values, quoteLiteral(v))
+		}
+		return fmt.Sprintf("FOR VALUES IN (%s)", strings.Join(values, ", "))
+	}
+	lower, upper := "MINVALUE", "MAXVALUE"
+	if p.Lower != nil {
+		lower = quoteLiteral(*p.Lower)
+	}
+	if p.Upper != nil {
+		upper = quoteLiteral(*p.Upper)
+	}
+	return fmt.Sprintf("FOR VALUES FROM (%s) TO (%s)", lower, upper)
+}
+
+func (pe pgEngine) createIndexSQL(table string, idx textIndex) string {
+	sql := fmt.Sprintf("CREATE INDEX %s ON %s", quoteIdent(idx.Name), pe.qualifiedName(table))
+	switch idx.Config {
+	case btreeIndexConfig:
+		return sql + fmt.Sprintf(" (%s)", quoteIdent(idx.Column))
+	case jsonbIndexConfig:
+		return sql + fmt.Sprintf(" USING gin (%s)", quoteIdent(idx.Column))
+	case pointIndexConfig:
+		return sql + fmt.Sprintf(" USING gist (%s)", quoteIdent(idx.Column))
+	}
+	return sql + fmt.Sprintf(" USING gin (to_tsvector(%s, %s))", quoteLiteral(idx.Config), quoteIdent(idx.Column))
+}
+
+// Write the rows of a table as a COPY, reading them in batches of snapshot reads.
+func (pe pgEngine) dumpRows(w *bufio.Writer, tbl *tableDefinition) error {
+	var columns []string
+	for _, name := range tbl.ColumnNames {
+		columns = append(columns, quoteIdent(name))
+	}
+	fmt.Fprintf(w, "COPY %s (%s) FROM stdin;\n", pe.qualifiedName(tbl.Name), strings.Join(columns, ", "))
+
+	err := pe.readSnapshotBatches(tbl, tbl.Name, tbl.rowScanLayout(), tbl.ColumnNames, func(rows []row) error {
+		for _, r := range rows {
+			fields := make([]string, len(tbl.ColumnNames))
+			for i, name := range tbl.ColumnNames {
+				fields[i] = `\N`
+				if text := formatText(r[name]); text != nil {
+					fields[i] = copyEscape(string(text))
+				}
+			}
+			fmt.Fprintf(w, "%s\n", strings.Join(fields, "\t"))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, `\.`)
+	return nil
+}
+
+// An identifier as SQL. It is always quoted, so names that are keywords or have upper case letters stay as they are.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// A string constant as SQL.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
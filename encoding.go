@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+/*
+
+Encoding of cell values.
+
+Cells are stored with the FoundationDB tuple encoding of the Go value for their column type,
+int columns as integers, boolean columns as booleans and text columns as strings:
+
+```
+data/table_data/user/c/age/72746a7f-727f-4e0a-88f1-d983fea5c158: (14,)
+data/table_data/user/c/name/72746a7f-727f-4e0a-88f1-d983fea5c158: ("garry",)
+```
+
+The value of a row in the row layout holds the same cells, see rowFormat.go.
+
+The tuple encoding describes its own type, so cells are decoded without looking at the
+catalog and rows read from the database hold typed values that compare, sort and compute
+as their type.
+
+NULL is stored as a cell too, the tuple holding nil, so it is never mistaken for an empty
+string. Rows written before that have no cell for NULL columns, a missing cell reads as NULL:
+
+```
+data/table_data/user/c/nickname/72746a7f-727f-4e0a-88f1-d983fea5c158: (nil,)
+```
+
+*/
+
+func encodeCell(v any) []byte {
+	return tuple.Tuple{cellElement(v)}.Pack()
+}
+
+// The tuple element a value is stored as, which is also how the elements of arrays are stored.
+func cellElement(v any) tuple.TupleElement {
+	switch value := v.(type) {
+	case decimal.Decimal:
+		return encodeNumeric(value)
+	case date:
+		return encodeDate(value)
+	case timestamp, timestamptz:
+		t, _ := encodeTimestamp(value)
+		return t
+	case timeOfDay:
+		return encodeTimeOfDay(value)
+	case interval:
+		return encodeInterval(value)
+	case uuid.UUID:
+		return encodeUUID(value)
+	case inet:
+		return encodeNetwork(value)
+	case point, box:
+		return encodeGeometric(value)
+	case bpchar:
+		return string(value)
+	case enumValue:
+		return value.label
+	case jsonText, jsonb:
+		return encodeJSON(value)
+	case array:
+		return encodeArray(value)
+	case composite:
+		return encodeComposite(value)
+	}
+	return v
+}
+
+// Decode a stored cell. Values written before cells were tuple encoded are returned as the raw string.
+func decodeCell(b []byte) any {
+	t, err := tuple.Unpack(b)
+	if err != nil || len(t) != 1 {
+		return string(b)
+	}
+	return decodeCellElement(t[0])
+}
+
+func decodeCellElement(e tuple.TupleElement) any {
+	if id, ok := e.(tuple.UUID); ok {
+		return uuid.UUID(id)
+	}
+	if nested, ok := e.(tuple.Tuple); ok {
+		if d, ok := decodeNumeric(nested); ok {
+			return d
+		}
+		if d, ok := decodeDate(nested); ok {
+			return d
+		}
+		if ts, ok := decodeTimestamp(nested); ok {
+			return ts
+		}
+		if t, ok := decodeTimeOfDay(nested); ok {
+			return t
+		}
+		if iv, ok := decodeInterval(nested); ok {
+			return iv
+		}
+		if j, ok := decodeJSONCell(nested); ok {
+			return j
+		}
+		if a, ok := decodeArray(nested); ok {
+			return a
+		}
+		if c, ok := decodeComposite(nested); ok {
+			return c
+		}
+		if n, ok := decodeNetwork(nested); ok {
+			return n
+		}
+		if g, ok := decodeGeometric(nested); ok {
+			return g
+		}
+	}
+	return e
+}
+
+// The Go value of a cell for its column type. Rows of catalog relations and old cells hold strings, which are parsed.
+func cellValue(colType string, v any) (any, error) {
+	if s, ok := v.(string); ok {
+		return parseCell(colType, s)
+	}
+	return v, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Enum types, a fixed list of labels in the order they were declared.
+
+Example:
+
+```sql
+create type mood as enum ('sad', 'ok', 'happy');
+create table person (name text, current_mood mood);
+insert into person values ('garry', 'happy');
+select name from person where current_mood > 'sad' order by current_mood;
+```
+
+Will produce the following KV structure, with the OID the type was given when it was created:
+
+```
+catalog/enum/mood: (16384, ("sad", "ok", "happy"))
+catalog/next_oid: (16385,)
+catalog/table/person/current_mood: mood
+data/table_data/person/r/72746a7f-727f-4e0a-88f1-d983fea5c158/current_mood: ("happy",)
+```
+
+Cells hold the label. Columns keep the enum as their type, and when the table definition is
+read the enum is resolved so values of the column compare and sort by the position of their
+label in the declaration instead of alphabetically. Values are checked to be one of the
+labels on INSERT and ALTER COLUMN TYPE.
+
+*/
+
+type enumType struct {
+	Name   string
+	Oid    uint32
+	Labels []string
+}
+
+// A value of an enum column, which compares by the position of its label.
+type enumValue struct {
+	typ   *enumType
+	label string
+}
+
+func (v enumValue) String() string {
+	return v.label
+}
+
+// The position of a label in the declaration of the enum.
+func (e *enumType) order(label string) (int, error) {
+	for i, l := range e.Labels {
+		if l == label {
+			return i, nil
+		}
+	}
+	return 0, &pgError{
+		Code:    sqlStateInvalidTextRepresentation,
+		Message: fmt.Sprintf("invalid input value for enum %s: %q", e.Name, label),
+	}
+}
+
+func (pe pgEngine) executeCreateEnum(stmt *pgquery.CreateEnumStmt) error {
+	name := stmt.TypeName[len(stmt.TypeName)-1].GetString_().GetStr()
+
+	e := &enumType{Name: name}
+	seen := map[string]bool{}
+	for _, v := range stmt.Vals {
+		label := v.GetString_().GetStr()
+		if seen[label] {
+			return fmt.Errorf("enum label \"%s\" used more than once", label)
+		}
+		seen[label] = true
+		e.Labels = append(e.Labels, label)
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enumKey := catalogDir.Sub("enum").Pack(tuple.Tuple{name})
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if typeExists(tr, catalogDir, name) {
+			return nil, &pgError{Code: sqlStateDuplicateObject, Message: fmt.Sprintf("type \"%s\" already exists", name)}
+		}
+
+		e.Oid, err = nextOid(tr, catalogDir)
+		if err != nil {
+			return nil, err
+		}
+
+		var labels tuple.Tuple
+		for _, l := range e.Labels {
+			labels = append(labels, l)
+		}
+		writes(tr).Set(enumKey, tuple.Tuple{int64(e.Oid), labels}.Pack())
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not create type: %w", err)
+	}
+
+	return nil
+}
+
+// Note: OIDs are handed out from a counter in the catalog, so a type keeps its OID for good
+func nextOid(tr fdb.Transaction, catalogDir directory.DirectorySubspace) (uint32, error) {
+	nextOidKey := catalogDir.Pack(tuple.Tuple{"next_oid"})
+
+	oid := uint32(firstNormalOid)
+	if value := tr.Get(nextOidKey).MustGet(); value != nil {
+		t, err := tuple.Unpack(value)
+		if err != nil {
+			return 0, err
+		}
+		oid = uint32(t[0].(int64))
+	}
+	writes(tr).Set(nextOidKey, tuple.Tuple{int64(oid) + 1}.Pack())
+	return oid, nil
+}
+
+// Get the enum with the given name, nil if the type is not an enum.
+func (pe pgEngine) getEnum(tr fdb.ReadTransaction, typeName string) (*enumType, error) {
+	return pe.readEnum(tr, typeName)()
+}
+
+// Start reading the enum with the given name, the returned function waits for it, see getEnum.
+func (pe pgEngine) readEnum(tr fdb.ReadTransaction, typeName string) func() (*enumType, error) {
+	name := domainName(typeName)
+	f := pe.readTypeKey(tr, "enum", name)
+	return func() (*enumType, error) {
+		value := f()
+		if value == nil {
+			return nil, nil
+		}
+		return decodeEnum(name, value)
+	}
+}
+
+func decodeEnum(name string, value []byte) (*enumType, error) {
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &enumType{Name: name, Oid: uint32(t[0].(int64))}
+	for _, l := range t[1].(tuple.Tuple) {
+		e.Labels = append(e.Labels, l.(string))
+	}
+	return e, nil
+}
+
+// List all enums, ordered by name.
+func (pe pgEngine) getEnums() ([]*enumType, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enumSS := catalogDir.Sub("enum")
+
+	var enums []*enumType
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		ri := rtr.GetRange(enumSS, fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			t, err := enumSS.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := decodeEnum(t[0].(string), kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			enums = append(enums, e)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list enums: %w", err)
+	}
+
+	return enums, nil
+}
+
+func (pe pgEngine) lookupEnum(typeName string) (*enumType, error) {
+	e, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		return pe.getEnum(rtr, typeName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get enum: %w", err)
+	}
+	return e.(*enumType), nil
+}
+
+func isEnum(v any) bool {
+	_, ok := v.(enumValue)
+	return ok
+}
+
+// Compare enum values of the same type with each other or with string constants, which are read as labels.
+func cmpEnum(left, right any) (int, error) {
+	typ := func() *enumType {
+		if e, ok := left.(enumValue); ok {
+			return e.typ
+		}
+		return right.(enumValue).typ
+	}()
+
+	operand := func(v any) (int, error) {
+		switch value := v.(type) {
+		case enumValue:
+			if value.typ.Name != typ.Name {
+				return 0, fmt.Errorf("cannot compare %s with %s", typ.Name, value.typ.Name)
+			}
+			return typ.order(value.label)
+		case string:
+			return typ.order(value)
+		}
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return cmpInt(int64(l), int64(r)), nil
+}
+
+/*
+
+Drop enum and composite types. Like domains, a type that is still the type of a column can't
+be dropped.
+
+*/
+
+func (pe pgEngine) dropTypes(stmt *pgquery.DropStmt) error {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, o := range stmt.Objects {
+			name := domainName(typeNameString(o.GetTypeName()))
+			key := catalogDir.Sub("enum").Pack(tuple.Tuple{name})
+			if tr.Get(key).MustGet() == nil {
+				key = catalogDir.Sub("composite").Pack(tuple.Tuple{name})
+			}
+			if tr.Get(key).MustGet() == nil {
+				if stmt.MissingOk {
+					pe.raiseNotice(sqlStateSuccessfulCompletion, "type \"%s\" does not exist, skipping", name)
+					continue
+				}
+				return nil, &pgError{Code: sqlStateUndefinedObject, Message: fmt.Sprintf("type \"%s\" does not exist", name)}
+			}
+
+			if err := pe.checkTypeUnused(tr, name); err != nil {
+				return nil, err
+			}
+			writes(tr).Clear(key)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not drop type: %w", err)
+	}
+
+	return nil
+}
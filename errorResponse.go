@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Errors of a query are sent to the client as an ErrorResponse followed by ReadyForQuery, like
+Postgres does, so the client sees an SQL error and the session goes on with the next query.
+Only errors of the connection itself, like a message that can't be read, close it.
+
+Syntax errors carry the position in the query they are at, counted in characters from 1:
+
+```
+select * form person;
+ERROR:  syntax error at or near "form"
+LINE 1: select * form person;
+                 ^
+```
+
+A statement that fails inside a transaction block aborts the block. Until it ends every other
+statement fails, and COMMIT ends it like ROLLBACK does:
+
+```sql
+begin;
+insert into person values ('garry', 'not a number');  -- ERROR
+select * from person;  -- ERROR: current transaction is aborted, commands ignored until end of transaction block
+commit;                -- ROLLBACK
+```
+
+*/
+
+const internalErrorCode = "XX000"
+
+var syntaxErrorToken = regexp.MustCompile(`at or near "(.*)"$`)
+
+// A syntax error of the query, at the position of the token the parser stopped at.
+func syntaxError(query string, err error) error {
+	e := &pgError{Code: sqlStateSyntaxError, Message: err.Error()}
+	if m := syntaxErrorToken.FindStringSubmatch(e.Message); m != nil {
+		if i := strings.Index(query, m[1]); i >= 0 {
+			e.Position = int32(len([]rune(query[:i]))) + 1
+		}
+	} else if strings.HasSuffix(e.Message, "at end of input") {
+		e.Position = int32(len([]rune(query))) + 1
+	}
+	return e
+}
+
+func (pgs *pgServer) writeError(err error) {
+	resp := &pgproto3.ErrorResponse{
+		Severity: "ERROR",
+		Code:     internalErrorCode,
+		Message:  err.Error(),
+	}
+	var pgErr *pgError
+	if errors.As(err, &pgErr) {
+		resp.Code = pgErr.Code
+		resp.Message = pgErr.Message
+		resp.Position = pgErr.Position
+		resp.Detail = pgErr.Detail
+	}
+
+	resp.Message = pgs.clientEncoding().encodeMessage(resp.Message)
+	resp.Detail = pgs.clientEncoding().encodeMessage(resp.Detail)
+
+	// Note: the error aborts the transaction block, see txFailed
+	if pgs.tx != nil {
+		pgs.txFailed = true
+	}
+
+	if err := pgs.write(resp.Encode(nil)); err != nil {
+		log.Printf("failed to write error response: %s", err)
+	}
+}
+
+// Send a FATAL error, which ends the session. The caller closes the connection.
+func (pgs *pgServer) writeFatal(code, message string) error {
+	buf := (&pgproto3.ErrorResponse{
+		Severity: "FATAL",
+		Code:     code,
+		Message:  pgs.clientEncoding().encodeMessage(message),
+	}).Encode(nil)
+	if err := pgs.write(buf); err != nil {
+		return fmt.Errorf("error sending fatal error: %w", err)
+	}
+	return nil
+}
+
+// Whether a statement may run in an aborted transaction block, which only ending it may.
+func allowedInFailedTransaction(stmt *pgquery.Node) bool {
+	t := stmt.GetTransactionStmt()
+	return t != nil && (t.Kind == pgquery.TransactionStmtKind_TRANS_STMT_ROLLBACK || t.Kind == pgquery.TransactionStmtKind_TRANS_STMT_COMMIT)
+}
@@ -0,0 +1,831 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"github.com/shopspring/decimal"
+)
+
+// A row read back from the data subspace, keyed by column name. Values are the decoded
+// cells (see encoding.go), rows of catalog relations hold strings.
+type row map[string]any
+
+/*
+
+Evaluate an expression from the parse tree against a single row.
+
+Only the handful of node types that expressions over a single table need are
+supported: column references, constants, casts, comparison and arithmetic operators,
+string concatenation and AND/OR/NOT.
+Cells are stored as strings, so column values are converted to a comparable Go value
+based on the column type recorded in the catalog.
+
+*/
+
+func evalExpr(n *pgquery.Node, tbl *tableDefinition, r row) (any, error) {
+	if c := n.GetColumnRef(); c != nil {
+		name := c.Fields[len(c.Fields)-1].GetString_().GetStr()
+		colType, ok := tbl.columnType(name)
+		if !ok {
+			return nil, undefinedColumn(name)
+		}
+
+		value, ok := r[name]
+		if !ok || value == nil {
+			return nil, nil
+		}
+
+		// Note: values of enum columns know their enum, so they compare in the order of its labels
+		if e := tbl.columnEnum(name); e != nil {
+			return enumValue{typ: e, label: fmt.Sprint(value)}, nil
+		}
+
+		// Note: records of composite columns know their type, so their fields can be found by name
+		if ct := tbl.columnComposite(name); ct != nil {
+			if c, ok := value.(composite); ok && len(c.fields) == len(ct.Fields) {
+				return composite{typ: ct, fields: c.fields}, nil
+			}
+			return ct.value(value)
+		}
+
+		return cellValue(colType, value)
+	}
+
+	if c := n.GetAConst(); c != nil {
+		return constValue(c)
+	}
+
+	if b := n.GetBoolExpr(); b != nil {
+		return evalBoolExpr(b, tbl, r)
+	}
+
+	if tc := n.GetTypeCast(); tc != nil {
+		v, err := evalExpr(tc.Arg, tbl, r)
+		if err != nil || v == nil {
+			return nil, err
+		}
+
+		colType := typeNameString(tc.TypeName)
+		// Note: an explicit cast to a character type cuts the value down to its length instead of failing
+		if isCharacterType(colType) {
+			if v, err = truncateCharacter(v, colType); err != nil {
+				return nil, err
+			}
+		}
+
+		s, err := castValue(v, colType)
+		if err != nil {
+			return nil, err
+		}
+		return parseCell(colType, s)
+	}
+
+	if a := n.GetAExpr(); a != nil {
+		if a.Kind == pgquery.A_Expr_Kind_AEXPR_OP_ANY || a.Kind == pgquery.A_Expr_Kind_AEXPR_OP_ALL {
+			return evalArrayOp(a, tbl, r)
+		}
+
+		if a.Kind != pgquery.A_Expr_Kind_AEXPR_OP || len(a.Name) != 1 {
+			return nil, fmt.Errorf("unsupported expression: %s", a)
+		}
+
+		// Note: unary minus has no left operand
+		var left any = int64(0)
+		if a.Lexpr != nil {
+			var err error
+			left, err = evalExpr(a.Lexpr, tbl, r)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		right, err := evalExpr(a.Rexpr, tbl, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if comparisonOps[a.Name[0].GetString_().GetStr()] && a.Lexpr != nil {
+			left, right, err = coerceComparison(a, tbl, left, right)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Note: comparisons with NULL are NULL, which a WHERE clause treats as false
+		if left == nil || right == nil {
+			return nil, nil
+		}
+
+		coll, err := operatorCollation(a)
+		if err != nil {
+			return nil, err
+		}
+
+		return binaryOp(a.Name[0].GetString_().GetStr(), left, right, coll)
+	}
+
+	if fc := n.GetFuncCall(); fc != nil {
+		return evalFuncCall(fc, tbl, r)
+	}
+
+	if ae := n.GetAArrayExpr(); ae != nil {
+		return evalArrayExpr(ae, tbl, r)
+	}
+
+	if re := n.GetRowExpr(); re != nil {
+		return evalRowExpr(re, tbl, r)
+	}
+
+	if ind := n.GetAIndirection(); ind != nil {
+		return evalIndirection(ind, tbl, r)
+	}
+
+	if nt := n.GetNullTest(); nt != nil {
+		v, err := evalExpr(nt.Arg, tbl, r)
+		if err != nil {
+			return nil, err
+		}
+		return (v == nil) == (nt.Nulltesttype == pgquery.NullTestType_IS_NULL), nil
+	}
+
+	if bt := n.GetBooleanTest(); bt != nil {
+		return evalBooleanTest(bt, tbl, r)
+	}
+
+	if svf := n.GetSqlvalueFunction(); svf != nil {
+		return evalSQLValueFunction(svf)
+	}
+
+	if cc := n.GetCollateClause(); cc != nil {
+		if _, err := lookupCollation(exprCollation(n)); err != nil {
+			return nil, err
+		}
+		return evalExpr(cc.Arg, tbl, r)
+	}
+
+	return nil, fmt.Errorf("unsupported expression: %s", n)
+}
+
+func evalFuncCall(fc *pgquery.FuncCall, tbl *tableDefinition, r row) (any, error) {
+	switch name := funcName(fc); name {
+	case "to_tsvector", "to_tsquery", "plainto_tsquery":
+		return evalTextSearchFunc(name, fc, tbl, r)
+	case "now", "transaction_timestamp", "statement_timestamp", "clock_timestamp":
+		return evalTimestampFunc(name)
+	case "gen_random_uuid":
+		if len(fc.Args) != 0 {
+			return nil, fmt.Errorf("function gen_random_uuid takes no arguments")
+		}
+		return uuid.New(), nil
+	case "point", "box":
+		return evalGeometricFunc(name, fc, tbl, r)
+	case "host", "masklen", "family", "network":
+		return evalNetworkFunc(name, fc, tbl, r)
+	case "version", "current_schema", "current_schemas":
+		return evalServerInfoFunc(name, fc, tbl, r)
+	case "pg_size_pretty":
+		return evalSizePretty(fc, tbl, r)
+	case "unnest":
+		return nil, fmt.Errorf("set-returning function unnest is only allowed in the select list")
+	default:
+		return nil, &pgError{Code: sqlStateUndefinedFunction, Message: fmt.Sprintf("function %s does not exist", name)}
+	}
+}
+
+// The functions SQL calls without parentheses, like CURRENT_DATE and CURRENT_TIMESTAMP.
+func evalSQLValueFunction(svf *pgquery.SQLValueFunction) (any, error) {
+	switch svf.Op {
+	case pgquery.SQLValueFunctionOp_SVFOP_CURRENT_DATE:
+		d, _ := dateFromTimestamp(timestamptz(nowMicros()))
+		return d, nil
+	}
+	return evalTimestampValueFunction(svf)
+}
+
+func evalBoolExpr(b *pgquery.BoolExpr, tbl *tableDefinition, r row) (any, error) {
+	var sawNull bool
+	for _, arg := range b.Args {
+		v, err := evalExpr(arg, tbl, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if v == nil {
+			sawNull = true
+			continue
+		}
+
+		bv, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("argument of %s must be type boolean", b.Boolop)
+		}
+
+		switch b.Boolop {
+		case pgquery.BoolExprType_AND_EXPR:
+			if !bv {
+				return false, nil
+			}
+		case pgquery.BoolExprType_OR_EXPR:
+			if bv {
+				return true, nil
+			}
+		case pgquery.BoolExprType_NOT_EXPR:
+			return !bv, nil
+		}
+	}
+
+	if sawNull {
+		return nil, nil
+	}
+
+	return b.Boolop == pgquery.BoolExprType_AND_EXPR, nil
+}
+
+// IS [NOT] TRUE, IS [NOT] FALSE and IS [NOT] UNKNOWN, which are never NULL.
+func evalBooleanTest(bt *pgquery.BooleanTest, tbl *tableDefinition, r row) (any, error) {
+	v, err := evalExpr(bt.Arg, tbl, r)
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := v.(bool)
+	if v != nil && !ok {
+		return nil, fmt.Errorf("argument of IS %s must be type boolean", strings.ReplaceAll(strings.TrimPrefix(bt.Booltesttype.String(), "IS_"), "_", " "))
+	}
+
+	switch bt.Booltesttype {
+	case pgquery.BoolTestType_IS_TRUE:
+		return v != nil && b, nil
+	case pgquery.BoolTestType_IS_NOT_TRUE:
+		return v == nil || !b, nil
+	case pgquery.BoolTestType_IS_FALSE:
+		return v != nil && !b, nil
+	case pgquery.BoolTestType_IS_NOT_FALSE:
+		return v == nil || b, nil
+	case pgquery.BoolTestType_IS_UNKNOWN:
+		return v == nil, nil
+	}
+	return v != nil, nil
+}
+
+// Evaluate a WHERE clause. A missing clause matches every row and NULL counts as false.
+func evalWhere(where *pgquery.Node, tbl *tableDefinition, r row) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+
+	v, err := evalExpr(where, tbl, r)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if v != nil && !ok {
+		return false, fmt.Errorf("argument of WHERE must be type boolean")
+	}
+
+	return b, nil
+}
+
+func constValue(c *pgquery.A_Const) (any, error) {
+	if s := c.Val.GetString_(); s != nil {
+		return s.Str, nil
+	}
+
+	if i := c.Val.GetInteger(); i != nil {
+		return int64(i.Ival), nil
+	}
+
+	// Note: integers that don't fit 32 bits are parsed as Float too
+	if f := c.Val.GetFloat(); f != nil {
+		if i, err := strconv.ParseInt(f.Str, 10, 64); err == nil {
+			return i, nil
+		}
+		return parseNumeric(f.Str)
+	}
+
+	if c.Val.GetNull() != nil {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown value type: %s", c.Val)
+}
+
+// Text form of a constant, as it would be written into the database.
+func constString(n *pgquery.Node) (string, bool) {
+	c := n.GetAConst()
+	if c == nil {
+		return "", false
+	}
+
+	if s := c.Val.GetString_(); s != nil {
+		return s.Str, true
+	}
+
+	if i := c.Val.GetInteger(); i != nil {
+		return strconv.Itoa(int(i.Ival)), true
+	}
+
+	if f := c.Val.GetFloat(); f != nil {
+		return f.Str, true
+	}
+
+	return "", false
+}
+
+// The integer types, all of them are int64 in Go and only differ in the range of values they hold.
+var integerTypes = map[string]struct {
+	name     string
+	min, max int64
+}{
+	"pg_catalog.int2": {"smallint", math.MinInt16, math.MaxInt16},
+	"pg_catalog.int4": {"integer", math.MinInt32, math.MaxInt32},
+	"pg_catalog.int8": {"bigint", math.MinInt64, math.MaxInt64},
+}
+
+// Convert a stored cell into a Go value that can be compared according to its column type.
+func parseCell(colType string, s string) (any, error) {
+	if it, ok := integerTypes[colType]; ok {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type %s: %q", it.name, s)
+		}
+		return i, nil
+	}
+
+	if colType == "pg_catalog.bool" {
+		return parseBool(s)
+	}
+
+	if _, ok := floatTypes[colType]; ok {
+		return parseFloat(colType, s)
+	}
+
+	if isNumericType(colType) {
+		return parseNumeric(s)
+	}
+
+	if colType == "pg_catalog.date" {
+		return parseDate(s)
+	}
+
+	if isTimestampType(colType) {
+		return parseTimestamp(colType, s)
+	}
+
+	if isTimeType(colType) {
+		return parseTimeOfDay(s)
+	}
+
+	if isIntervalType(colType) {
+		return parseInterval(s)
+	}
+
+	if colType == "pg_catalog.uuid" {
+		return parseUUID(s)
+	}
+
+	if isNetworkType(colType) {
+		return parseInet(colType, s)
+	}
+
+	if isGeometricType(colType) {
+		return parseGeometric(colType, s)
+	}
+
+	if isJSONType(colType) {
+		return parseJSON(colType, s)
+	}
+
+	if isArrayType(colType) {
+		return arrayOf(s, colType)
+	}
+
+	if base, _ := splitTypeMods(colType); base == "pg_catalog.bpchar" {
+		return bpchar(s), nil
+	}
+
+	return s, nil
+}
+
+// The type of a value an expression computed, for targets of a select that aren't columns.
+func valueType(v any) string {
+	switch value := v.(type) {
+	case int64:
+		return "pg_catalog.int8"
+	case bool:
+		return "pg_catalog.bool"
+	case float32:
+		return "pg_catalog.float4"
+	case float64:
+		return "pg_catalog.float8"
+	case decimal.Decimal:
+		return "pg_catalog.numeric"
+	case date:
+		return "pg_catalog.date"
+	case timestamp:
+		return "pg_catalog.timestamp"
+	case timestamptz:
+		return "pg_catalog.timestamptz"
+	case timeOfDay:
+		return "pg_catalog.time"
+	case interval:
+		return "pg_catalog.interval"
+	case uuid.UUID:
+		return "pg_catalog.uuid"
+	case point:
+		return "pg_catalog.point"
+	case box:
+		return "pg_catalog.box"
+	case inet:
+		if value.cidr {
+			return "pg_catalog.cidr"
+		}
+		return "pg_catalog.inet"
+	case bpchar:
+		return "pg_catalog.bpchar"
+	case jsonText:
+		return "pg_catalog.json"
+	case jsonb:
+		return "pg_catalog.jsonb"
+	case array:
+		return value.elemType + "[]"
+	case enumValue:
+		return value.typ.Name
+	case composite:
+		if value.typ != nil {
+			return value.typ.Name
+		}
+		return "record"
+	}
+	return "text"
+}
+
+// Parse the spellings Postgres accepts for booleans.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "t", "true", "y", "yes", "on", "1":
+		return true, nil
+	case "f", "false", "n", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid input syntax for type boolean: %q", s)
+}
+
+func boolString(b bool) string {
+	if b {
+		return "t"
+	}
+	return "f"
+}
+
+// Convert a value to the text form stored for a column of the given type.
+func castValue(v any, colType string) (string, error) {
+	if it, ok := integerTypes[colType]; ok {
+		var i int64
+		switch value := v.(type) {
+		case int64:
+			i = value
+		case float32, float64:
+			// Note: like Postgres, floats are rounded to the nearest integer, halfway to even
+			f, _ := floatOperand(value)
+			f = math.RoundToEven(f)
+			if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return "", fmt.Errorf("%s out of range", it.name)
+			}
+			i = int64(f)
+		case decimal.Decimal:
+			// Note: numerics are rounded halfway away from zero instead
+			rounded := value.Round(0)
+			if rounded.Cmp(decimal.NewFromInt(math.MinInt64)) < 0 || rounded.Cmp(decimal.NewFromInt(math.MaxInt64)) > 0 {
+				return "", fmt.Errorf("%s out of range", it.name)
+			}
+			i = rounded.IntPart()
+		case string:
+			var err error
+			i, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if errors.Is(err, strconv.ErrRange) {
+				return "", fmt.Errorf("value %q is out of range for type %s", value, it.name)
+			}
+			if err != nil {
+				return "", fmt.Errorf("invalid input syntax for type %s: %q", it.name, value)
+			}
+		default:
+			return "", fmt.Errorf("cannot cast type %T to %s", v, it.name)
+		}
+
+		if i < it.min || i > it.max {
+			if s, ok := v.(string); ok {
+				return "", fmt.Errorf("value %q is out of range for type %s", s, it.name)
+			}
+			return "", fmt.Errorf("%s out of range", it.name)
+		}
+		return strconv.FormatInt(i, 10), nil
+	}
+
+	if colType == "pg_catalog.bool" {
+		switch value := v.(type) {
+		case bool:
+			return boolString(value), nil
+		case int64:
+			return boolString(value != 0), nil
+		case string:
+			b, err := parseBool(value)
+			return boolString(b), err
+		}
+		return "", fmt.Errorf("cannot cast type %T to boolean", v)
+	}
+
+	if _, ok := floatTypes[colType]; ok {
+		return castFloat(v, colType)
+	}
+
+	if isNumericType(colType) {
+		return castNumeric(v, colType)
+	}
+
+	if colType == "pg_catalog.date" {
+		return castDate(v)
+	}
+
+	if isTimestampType(colType) {
+		return castTimestamp(v, colType)
+	}
+
+	if isTimeType(colType) {
+		return castTimeOfDay(v, colType)
+	}
+
+	if isIntervalType(colType) {
+		return castInterval(v, colType)
+	}
+
+	if colType == "pg_catalog.uuid" {
+		return castUUID(v)
+	}
+
+	if isNetworkType(colType) {
+		return castNetwork(v, colType)
+	}
+
+	if isGeometricType(colType) {
+		return castGeometric(v, colType)
+	}
+
+	if isCharacterType(colType) {
+		return castCharacter(v, colType)
+	}
+
+	if isJSONType(colType) {
+		return castJSON(v, colType)
+	}
+
+	if isArrayType(colType) {
+		return castArray(v, colType)
+	}
+
+	switch n := v.(type) {
+	case float32:
+		return formatFloat(float64(n), 32), nil
+	case float64:
+		return formatFloat(n, 64), nil
+	case decimal.Decimal:
+		return numericString(n), nil
+	case date, timestamp, timestamptz, timeOfDay, interval, uuid.UUID, jsonText, jsonb, array:
+		return fmt.Sprint(n), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// Compare two values, converting string constants to numbers or booleans when compared against them.
+// Strings are compared by the collation, nil compares them in byte order.
+func compareValues(left, right any, coll *collation) (int, error) {
+	if isArray(left) || isArray(right) {
+		return cmpArray(left, right, coll)
+	}
+
+	if isComposite(left) || isComposite(right) {
+		return cmpComposite(left, right, coll)
+	}
+
+	if isEnum(left) || isEnum(right) {
+		return cmpEnum(left, right)
+	}
+
+	if isFloat(left) || isFloat(right) {
+		return compareFloats(left, right)
+	}
+
+	if isNumeric(left) || isNumeric(right) {
+		return cmpNumeric(left, right)
+	}
+
+	if isTimestamp(left) || isTimestamp(right) {
+		return cmpTimestamp(left, right)
+	}
+
+	if isTimeOfDay(left) || isTimeOfDay(right) {
+		return cmpTimeOfDay(left, right)
+	}
+
+	if isInterval(left) || isInterval(right) {
+		return cmpInterval(left, right)
+	}
+
+	if isUUID(left) || isUUID(right) {
+		return cmpUUID(left, right)
+	}
+
+	if isNetwork(left) || isNetwork(right) {
+		return cmpNetwork(left, right)
+	}
+
+	if isBpchar(left) || isBpchar(right) {
+		return cmpBpchar(left, right, coll)
+	}
+
+	if isJSON(left) || isJSON(right) {
+		return cmpJSONB(left, right)
+	}
+
+	if isDate(left) || isDate(right) {
+		return cmpDate(left, right)
+	}
+
+	switch l := left.(type) {
+	case int64:
+		switch r := right.(type) {
+		case int64:
+			return cmpInt(l, r), nil
+		case string:
+			ri, err := strconv.ParseInt(r, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid input syntax for type integer: %q", r)
+			}
+			return cmpInt(l, ri), nil
+		}
+	case string:
+		switch r := right.(type) {
+		case string:
+			return coll.compare(l, r), nil
+		case int64, bool:
+			c, err := compareValues(right, left, coll)
+			return -c, err
+		}
+	case bool:
+		r, ok := right.(bool)
+		if s, isString := right.(string); isString {
+			var err error
+			if r, err = parseBool(s); err != nil {
+				return 0, err
+			}
+			ok = true
+		}
+		if ok {
+			switch {
+			case l == r:
+				return 0, nil
+			case !l:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+}
+
+func cmpInt(l, r int64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func binaryOp(op string, left, right any, coll *collation) (any, error) {
+	// Note: the geometric types have operators of their own, some spelled like those of other types
+	if op != "||" && (isGeometric(left) || isGeometric(right)) {
+		return geometricOp(op, left, right)
+	}
+
+	switch op {
+	case "||":
+		return fmt.Sprint(left) + fmt.Sprint(right), nil
+	case "+", "-", "*", "/", "%":
+		return arithmeticOp(op, left, right)
+	case "@@":
+		return textSearchMatch(left, right)
+	case "->", "->>", "#>", "#>>", "@>", "<@", "?":
+		return jsonOp(op, left, right)
+	case "<<", "<<=", ">>", ">>=", "&&":
+		return networkOp(op, left, right)
+	}
+
+	return compareOp(op, left, right, coll)
+}
+
+func arithmeticOp(op string, left, right any) (any, error) {
+	for _, v := range []any{left, right} {
+		if isInterval(v) || isTimeOfDay(v) || isTimestamp(v) {
+			return intervalArithmeticOp(op, left, right)
+		}
+	}
+	if isFloat(left) || isFloat(right) {
+		return floatArithmeticOp(op, left, right)
+	}
+	if isNumeric(left) || isNumeric(right) {
+		return numericArithmeticOp(op, left, right)
+	}
+	if isDate(left) || isDate(right) {
+		return dateArithmeticOp(op, left, right)
+	}
+
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator does not exist: %T %s %T", left, op, right)
+	}
+
+	// Note: integers are 64 bit, results that don't fit are an error instead of wrapping around
+	outOfRange := fmt.Errorf("bigint out of range")
+	switch op {
+	case "+":
+		if (r > 0 && l > math.MaxInt64-r) || (r < 0 && l < math.MinInt64-r) {
+			return nil, outOfRange
+		}
+		return l + r, nil
+	case "-":
+		if (r < 0 && l > math.MaxInt64+r) || (r > 0 && l < math.MinInt64+r) {
+			return nil, outOfRange
+		}
+		return l - r, nil
+	case "*":
+		if l != 0 && r != 0 && ((l*r)/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64)) {
+			return nil, outOfRange
+		}
+		return l * r, nil
+	}
+
+	if r == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if r == -1 && l == math.MinInt64 {
+		if op == "%" {
+			return int64(0), nil
+		}
+		return nil, outOfRange
+	}
+	if op == "/" {
+		return l / r, nil
+	}
+	return l % r, nil
+}
+
+func compareOp(op string, left, right any, coll *collation) (any, error) {
+	c, err := compareValues(left, right, coll)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>", "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+
+	return nil, fmt.Errorf("operator does not exist: %s", op)
+}
+
+// Expressions kept in the catalog are stored as SQL, deparsed as the target of a select.
+func deparseExpr(n *pgquery.Node) (string, error) {
+	stmt := &pgquery.SelectStmt{TargetList: []*pgquery.Node{pgquery.MakeResTargetNodeWithVal(n, 0)}}
+	return pgquery.Deparse(&pgquery.ParseResult{
+		Stmts: []*pgquery.RawStmt{{Stmt: &pgquery.Node{Node: &pgquery.Node_SelectStmt{SelectStmt: stmt}}}},
+	})
+}
+
+func parseExpr(sql string) (*pgquery.Node, error) {
+	tree, err := pgquery.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse stored expression %q: %w", sql, err)
+	}
+	return tree.Stmts[0].Stmt.GetSelectStmt().TargetList[0].GetResTarget().Val, nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+
+The extended query protocol. Instead of a Query, the client sends:
+
+```
+Parse     name, query with $1, $2, ... parameters, parameter types
+Bind      portal name, statement name, parameter values
+Describe  the statement or the portal
+Execute   portal name, maximum number of rows to return, 0 for all
+Sync
+```
+
+Parse checks the query and keeps it as a prepared statement of the session. Bind puts the
+parameter values into the parse tree of the statement as constants, typed if Parse gave a
+type for them or it was inferred (see describe.go), and keeps the result as a portal. The unnamed statement and portal are
+replaced by every Parse and Bind.
+
+Execute runs the statement of the portal the first time, the rows of a select are kept in the
+portal. With a maximum number of rows only that many are sent, followed by PortalSuspended,
+and the next Execute of the portal continues where the previous one stopped:
+
+```
+Execute  "", 2    ->  DataRow, DataRow, PortalSuspended
+Execute  "", 2    ->  DataRow, CommandComplete "SELECT 3"
+```
+
+A select without ORDER BY that is first executed with a maximum number of rows isn't run at
+once, the portal scans it like a cursor (see cursor.go) and every Execute reads the rows it
+sends, at most the keys of them when there is no WHERE clause.
+
+After an error every message up to the next Sync is ignored. Sync ends the run of messages
+with a ReadyForQuery.
+
+*/
+
+type preparedStatement struct {
+	query string
+	stmt  *pgquery.RawStmt
+	// The types of the parameters, 0 where Parse didn't give one
+	paramOIDs []uint32
+}
+
+type portal struct {
+	stmt  *pgquery.RawStmt
+	query string
+	// Whether the statement ran, the rows it returned and how many of them were sent
+	executed bool
+	result   *pgResult
+	command  string
+	sent     int
+	// The maximum number of rows of the first Execute and the cursor that reads the rows of a select then, see fetchPortal
+	maxRows uint32
+	cursor  *cursor
+}
+
+func (pgs *pgServer) handleExtendedMessage(msg pgproto3.FrontendMessage) {
+	if _, ok := msg.(*pgproto3.Sync); ok {
+		pgs.skipUntilSync = false
+		pgs.readyForQuery()
+		return
+	}
+	if pgs.skipUntilSync {
+		return
+	}
+
+	var err error
+	switch m := msg.(type) {
+	case *pgproto3.Parse:
+		err = pgs.handleParse(m)
+	case *pgproto3.Bind:
+		err = pgs.handleBind(m)
+	case *pgproto3.Describe:
+		err = pgs.handleDescribe(m)
+	case *pgproto3.Execute:
+		err = pgs.handleExecute(m)
+	case *pgproto3.Close:
+		if m.ObjectType == 'S' {
+			delete(pgs.statements, m.Name)
+		} else {
+			delete(pgs.portals, m.Name)
+		}
+		err = pgs.write((&pgproto3.CloseComplete{}).Encode(nil))
+	case *pgproto3.Flush:
+		// Note: messages are written right away, there is nothing to flush
+	}
+
+	if err != nil {
+		pgs.writeError(err)
+		pgs.skipUntilSync = true
+	}
+}
+
+func (pgs *pgServer) handleParse(m *pgproto3.Parse) error {
+	query, err := pgs.clientEncoding().decode(m.Query)
+	if err != nil {
+		return err
+	}
+	tree, err := pgquery.Parse(query)
+	if err != nil {
+		return syntaxError(query, err)
+	}
+	if len(tree.GetStmts()) > 1 {
+		return &pgError{Code: sqlStateSyntaxError, Message: "cannot insert multiple commands into a prepared statement"}
+	}
+
+	ps := &preparedStatement{query: query, paramOIDs: m.ParameterOIDs}
+	if len(tree.GetStmts()) == 1 {
+		ps.stmt = tree.GetStmts()[0]
+	}
+	// Note: parameters Parse gave no type for get the type of where they are used, if it can be told
+	for n := paramCount(ps.stmt); len(ps.paramOIDs) < n; {
+		ps.paramOIDs = append(ps.paramOIDs, 0)
+	}
+	pgs.inferParamOIDs(ps)
+
+	if m.Name != "" {
+		if _, ok := pgs.statements[m.Name]; ok {
+			return &pgError{Code: sqlStateDuplicatePreparedStatement, Message: fmt.Sprintf("prepared statement \"%s\" already exists", m.Name)}
+		}
+	}
+	pgs.statements[m.Name] = ps
+	return pgs.write((&pgproto3.ParseComplete{}).Encode(nil))
+}
+
+func (pgs *pgServer) handleBind(m *pgproto3.Bind) error {
+	ps, ok := pgs.statements[m.PreparedStatement]
+	if !ok {
+		return &pgError{Code: sqlStateInvalidSQLStatementName, Message: fmt.Sprintf("prepared statement \"%s\" does not exist", m.PreparedStatement)}
+	}
+	if len(m.Parameters) != len(ps.paramOIDs) {
+		return &pgError{
+			Code:    sqlStateProtocolViolation,
+			Message: fmt.Sprintf("bind message supplies %d parameters, but prepared statement \"%s\" requires %d", len(m.Parameters), m.PreparedStatement, len(ps.paramOIDs)),
+		}
+	}
+
+	p := &portal{}
+	if ps.stmt != nil {
+		var err error
+		p.stmt, p.query, err = bindParams(ps, m, pgs.clientEncoding())
+		if err != nil {
+			return err
+		}
+	}
+	pgs.portals[m.DestinationPortal] = p
+	return pgs.write((&pgproto3.BindComplete{}).Encode(nil))
+}
+
+func (pgs *pgServer) handleDescribe(m *pgproto3.Describe) error {
+	if m.ObjectType == 'S' {
+		ps, ok := pgs.statements[m.Name]
+		if !ok {
+			return &pgError{Code: sqlStateInvalidSQLStatementName, Message: fmt.Sprintf("prepared statement \"%s\" does not exist", m.Name)}
+		}
+		res, err := pgs.describeStatement(ps.stmt)
+		if err != nil {
+			return err
+		}
+		buf := (&pgproto3.ParameterDescription{ParameterOIDs: describedParamOIDs(ps)}).Encode(nil)
+		if res == nil {
+			return pgs.write((&pgproto3.NoData{}).Encode(buf))
+		}
+		return pgs.write(pgs.rowDescription(res).Encode(buf))
+	}
+
+	p, ok := pgs.portals[m.Name]
+	if !ok {
+		return &pgError{Code: sqlStateInvalidCursorName, Message: fmt.Sprintf("portal \"%s\" does not exist", m.Name)}
+	}
+	// Note: the fields of a select are known once it ran, so the portal runs now instead of on Execute
+	if !p.executed && returnsRows(p.stmt) {
+		if err := pgs.runPortal(p); err != nil {
+			return err
+		}
+	}
+	if p.result == nil {
+		return pgs.write((&pgproto3.NoData{}).Encode(nil))
+	}
+	return pgs.write(pgs.rowDescription(p.result).Encode(nil))
+}
+
+func (pgs *pgServer) handleExecute(m *pgproto3.Execute) error {
+	p, ok := pgs.portals[m.Portal]
+	if !ok {
+		return &pgError{Code: sqlStateInvalidCursorName, Message: fmt.Sprintf("portal \"%s\" does not exist", m.Portal)}
+	}
+	if p.stmt == nil {
+		return pgs.write((&pgproto3.EmptyQueryResponse{}).Encode(nil))
+	}
+
+	if !p.executed {
+		p.maxRows = m.MaxRows
+		if err := pgs.runPortal(p); err != nil {
+			return err
+		}
+	}
+	if p.cursor != nil {
+		return pgs.fetchPortal(p, m.MaxRows)
+	}
+	if p.result == nil {
+		return nil
+	}
+
+	rows := p.result.rows[p.sent:]
+	if m.MaxRows > 0 && len(rows) > int(m.MaxRows) {
+		rows = rows[:m.MaxRows]
+	}
+	var buf []byte
+	for _, row := range rows {
+		dr, err := pgs.dataRow(row)
+		if err != nil {
+			return err
+		}
+		buf = dr.Encode(buf)
+	}
+	p.sent += len(rows)
+
+	if p.sent < len(p.result.rows) {
+		return pgs.write((&pgproto3.PortalSuspended{}).Encode(buf))
+	}
+	pgs.done(buf, fmt.Sprintf("%s %d", p.command, len(p.result.rows)))
+	return nil
+}
+
+// Send the next rows of a portal whose select is scanned by a cursor, up to maxRows of them, 0 for all.
+func (pgs *pgServer) fetchPortal(p *portal, maxRows uint32) error {
+	count := int64(math.MaxInt64)
+	if maxRows > 0 {
+		count = int64(maxRows)
+	}
+
+	pe := pgs.engine.withTransactor(pgs.selectTransactor())
+	res, err := pe.fetchCursor(p.cursor, count)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, row := range res.rows {
+		dr, err := pgs.dataRow(row)
+		if err != nil {
+			return err
+		}
+		buf = dr.Encode(buf)
+	}
+	p.sent += len(res.rows)
+
+	// Note: like Postgres, a portal that returned all the rows it was asked for is suspended, even if no row is left
+	if int64(len(res.rows)) == count {
+		return pgs.write((&pgproto3.PortalSuspended{}).Encode(buf))
+	}
+	pgs.done(buf, fmt.Sprintf("%s %d", p.command, p.sent))
+	return nil
+}
+
+// Run the statement of the portal. Statements that return rows keep them in the portal, the others complete right away.
+func (pgs *pgServer) runPortal(p *portal) error {
+	pgs.logQuery(p.query)
+	release, err := pgs.throttle()
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, done := pgs.startQuery()
+	defer done()
+
+	pgs.portal = p
+	defer func() { pgs.portal = nil }()
+	p.executed = true
+	err = pgs.handleStatement(ctx, p.stmt, p.query)
+	pgs.auditStatement(p.query, err)
+	return err
+}
+
+func returnsRows(stmt *pgquery.RawStmt) bool {
+	n := stmt.GetStmt()
+	// Note: MOVE is a fetch that returns no rows
+	return n.GetSelectStmt() != nil || n.GetVariableShowStmt() != nil || (n.GetFetchStmt() != nil && !n.GetFetchStmt().Ismove)
+}
+
+// Call fn for every node of the parse tree, and for the nodes below it.
+func walkNodes(m protoreflect.Message, fn func(*pgquery.Node)) {
+	walkMessages(m, func(m protoreflect.Message) {
+		if n, ok := m.Interface().(*pgquery.Node); ok {
+			fn(n)
+		}
+	})
+}
+
+func paramCount(stmt *pgquery.RawStmt) int {
+	if stmt == nil {
+		return 0
+	}
+	count := 0
+	walkNodes(stmt.ProtoReflect(), func(n *pgquery.Node) {
+		if p := n.GetParamRef(); p != nil && int(p.Number) > count {
+			count = int(p.Number)
+		}
+	})
+	return count
+}
+
+/*
+
+Put the parameter values of a Bind into a copy of the parse tree of the statement, as string
+constants cast to the type of the parameter when it has one, and return it with its SQL.
+Parameters in binary format are decoded into their text form first, see binaryParams.go.
+
+*/
+
+func bindParams(ps *preparedStatement, m *pgproto3.Bind, ce *clientEncoding) (*pgquery.RawStmt, string, error) {
+	values := make([]*pgquery.Node, len(m.Parameters))
+	for i, param := range m.Parameters {
+		format := int16(textFormatCode)
+		if len(m.ParameterFormatCodes) == 1 {
+			format = m.ParameterFormatCodes[0]
+		} else if i < len(m.ParameterFormatCodes) {
+			format = m.ParameterFormatCodes[i]
+		}
+
+		if param == nil {
+			values[i] = &pgquery.Node{Node: &pgquery.Node_AConst{AConst: &pgquery.A_Const{Val: &pgquery.Node{Node: &pgquery.Node_Null{Null: &pgquery.Null{}}}}}}
+			continue
+		}
+
+		text := string(param)
+		switch format {
+		case textFormatCode:
+		case binaryFormatCode:
+			var err error
+			if text, err = decodeBinaryParam(i+1, ps.paramOIDs[i], param); err != nil {
+				return nil, "", err
+			}
+		default:
+			return nil, "", &pgError{Code: sqlStateProtocolViolation, Message: fmt.Sprintf("unsupported format code: %d", format)}
+		}
+		// Note: binary values of other types than text are decoded to ASCII, which every encoding keeps as it is
+		text, err := ce.decode(text)
+		if err != nil {
+			return nil, "", err
+		}
+		values[i] = pgquery.MakeAConstStrNode(text, -1)
+
+		if t, ok := lookupTypeOID(ps.paramOIDs[i]); ok {
+			var names []*pgquery.Node
+			for _, name := range strings.Split(t.Name, ".") {
+				names = append(names, pgquery.MakeStrNode(name))
+			}
+			values[i] = &pgquery.Node{Node: &pgquery.Node_TypeCast{TypeCast: &pgquery.TypeCast{
+				Arg:      values[i],
+				TypeName: &pgquery.TypeName{Names: names, Typemod: -1},
+			}}}
+		}
+	}
+
+	tree, err := pgquery.Parse(ps.query)
+	if err != nil {
+		return nil, "", syntaxError(ps.query, err)
+	}
+	stmt := tree.GetStmts()[0]
+	walkNodes(stmt.ProtoReflect(), func(n *pgquery.Node) {
+		if p := n.GetParamRef(); p != nil {
+			n.Node = values[p.Number-1].Node
+		}
+	})
+
+	query, err := pgquery.Deparse(&pgquery.ParseResult{Stmts: []*pgquery.RawStmt{stmt}})
+	if err != nil {
+		log.Printf("could not deparse bound statement: %s", err)
+		query = ps.query
+	}
+	return stmt, query, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"maps"
 
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 )
@@ -9,8 +10,54 @@ import (
 func main() {
 	cfg := getConfig()
 
-	fdb.MustAPIVersion(710)
-	db := fdb.MustOpenDefault()
+	if _, err := lookupCollation(cfg.collation); err != nil {
+		log.Fatal(err)
+	}
+	defaultCollation = cfg.collation
+
+	tz, err := parseTimeZone(cfg.timezone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defaultTimeZone = tz
+	scanWorkers = max(1, cfg.scanWorkers)
+	compressMinSize = cfg.compressMinSize
+	tombstoneDeleteRows = cfg.tombstoneDeleteRows
+	copyDir = cfg.copyDir
+
+	if err := checkAuthMethod(cfg.auth); err != nil {
+		log.Fatal(err)
+	}
+	users, err := parseUsers(cfg.users)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.usersFile != "" {
+		fileUsers, err := readUsersFile(cfg.usersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		maps.Copy(users, fileUsers)
+	}
+	configuredUsers = users
+	roles, err := parseCertMap(cfg.certMap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	certRoles = roles
+	networks, err := parseProxyTrusted(cfg.proxyTrusted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.proxyProtocol && len(networks) == 0 {
+		log.Fatal("-proxy-protocol needs -proxy-trusted")
+	}
+	proxyTrusted = networks
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	if cfg.reset {
 		db.Transact(func(tr fdb.Transaction) (interface{}, error) {
@@ -20,5 +67,29 @@ func main() {
 		})
 	}
 
+	if cfg.tenants {
+		createDefaultTenant(db)
+	}
+
+	if err := migrateDatabases(db, cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.dump != "" {
+		if err := dumpDatabases(db, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if cfg.restore != "" {
+		if err := restoreDump(db, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	auditLog = openAuditLog(db, cfg)
+
 	runPgServer(cfg.pgPort, db, cfg)
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+/*
+
+Floating point columns, real and double precision.
+
+Values of real columns are float32 and of double precision columns float64, both in Go and
+in the tuple encoded cells, which sort floats in numeric order:
+
+```sql
+create table reading (sensor text, value real);
+insert into reading values ('a', 21.5), ('b', '-Infinity');
+```
+
+Like Postgres, NaN equals itself and sorts above every other value, and values are written
+in their shortest form that reads back exactly, switching to exponent notation for large
+and small values:
+
+```
+0.1::real            0.1
+1e15::float8         1e+15
+'nan'::float8        NaN
+```
+
+*/
+
+var floatTypes = map[string]struct {
+	name string
+	bits int
+}{
+	"pg_catalog.float4": {"real", 32},
+	"pg_catalog.float8": {"double precision", 64},
+}
+
+func parseFloat(colType string, s string) (any, error) {
+	ft := floatTypes[colType]
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), ft.bits)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("%q is out of range for type %s", s, ft.name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid input syntax for type %s: %q", ft.name, s)
+	}
+
+	if ft.bits == 32 {
+		return float32(f), nil
+	}
+	return f, nil
+}
+
+// Text form of a float like Postgres writes it, bits is the width of the type it comes from.
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+
+	// Note: exponent notation is used once the integer digits exceed the precision of the type
+	maxExp := 15
+	if bits == 32 {
+		maxExp = 6
+	}
+
+	e := strconv.FormatFloat(f, 'e', -1, bits)
+	exp, _ := strconv.Atoi(e[strings.IndexByte(e, 'e')+1:])
+	if exp < -4 || exp >= maxExp {
+		return e
+	}
+	return strconv.FormatFloat(f, 'f', -1, bits)
+}
+
+func castFloat(v any, colType string) (string, error) {
+	ft := floatTypes[colType]
+	var f float64
+	switch value := v.(type) {
+	case float32:
+		f = float64(value)
+	case float64:
+		f = value
+	case int64, decimal.Decimal:
+		f, _ = floatOperand(value)
+	case string:
+		parsed, err := parseFloat(colType, value)
+		if err != nil {
+			return "", err
+		}
+		return castFloat(parsed, colType)
+	default:
+		return "", fmt.Errorf("cannot cast type %T to %s", v, ft.name)
+	}
+
+	if ft.bits == 32 && !math.IsInf(f, 0) && math.IsInf(float64(float32(f)), 0) {
+		return "", fmt.Errorf("value out of range: overflow")
+	}
+	return formatFloat(f, ft.bits), nil
+}
+
+// The float value of a number and whether it is one, float32 results are widened.
+func floatOperand(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case decimal.Decimal:
+		return n.InexactFloat64(), true
+	}
+	return 0, false
+}
+
+func isFloat(v any) bool {
+	switch v.(type) {
+	case float32, float64:
+		return true
+	}
+	return false
+}
+
+// Arithmetic with at least one float operand. Like Postgres, real stays real unless the other operand is double precision.
+func floatArithmeticOp(op string, left, right any) (any, error) {
+	l, lok := floatOperand(left)
+	r, rok := floatOperand(right)
+	if !lok || !rok || op == "%" {
+		return nil, fmt.Errorf("operator does not exist: %T %s %T", left, op, right)
+	}
+
+	var f float64
+	switch op {
+	case "+":
+		f = l + r
+	case "-":
+		f = l - r
+	case "*":
+		f = l * r
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		f = l / r
+	}
+
+	_, lf64 := left.(float64)
+	_, rf64 := right.(float64)
+	if lf64 || rf64 {
+		if math.IsInf(f, 0) && !math.IsInf(l, 0) && !math.IsInf(r, 0) {
+			return nil, fmt.Errorf("value out of range: overflow")
+		}
+		return f, nil
+	}
+
+	f32 := float32(f)
+	if math.IsInf(float64(f32), 0) && !math.IsInf(l, 0) && !math.IsInf(r, 0) {
+		return nil, fmt.Errorf("value out of range: overflow")
+	}
+	return f32, nil
+}
+
+func cmpFloat(l, r float64) int {
+	// Note: NaN is equal to itself and larger than any other value
+	switch {
+	case math.IsNaN(l) && math.IsNaN(r):
+		return 0
+	case math.IsNaN(l):
+		return 1
+	case math.IsNaN(r):
+		return -1
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// Compare values of which at least one is a float, string constants are read as double precision.
+func compareFloats(left, right any) (int, error) {
+	operand := func(v any) (float64, error) {
+		if s, ok := v.(string); ok {
+			f, err := parseFloat("pg_catalog.float8", s)
+			if err != nil {
+				return 0, err
+			}
+			return f.(float64), nil
+		}
+		f, ok := floatOperand(v)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+		}
+		return f, nil
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return cmpFloat(l, r), nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+The point type, and boxes to ask for the points within them.
+
+Example:
+
+```sql
+create table place (name text, location point);
+create index place_location on place using gist (location);
+insert into place values ('home', '(1,2)'), ('work', point(4, 6));
+select name, location <-> point '(0,0)' from place where location <@ box '(0,0),(5,5)';
+```
+
+Points are written `(x,y)` and boxes by two opposite corners, `(x1,y1),(x2,y2)`, which are
+kept as the upper right and the lower left corner. The operators are:
+
+```
+<->  distance between points
+~=   same as
+<<   is left of           >>  is right of
+<^   is below             >^  is above
+<@   point is in box      @>  box contains point
+```
+
+Like Postgres, points have no = or ordering, they are compared with these operators instead.
+
+A gist index on a point column is a Z-order index: the bits of the x and y coordinates are
+interleaved into a single integer, which sorts points that are near each other close to
+each other. Its keys are in the same subspace as full text indexes, with the Z-order value
+instead of a lexeme:
+
+```
+catalog/index/place/place_location: ("location", "zorder")
+data/text_index/place/place_location/15756593163285823488/72746a7f-727f-4e0a-88f1-d983fea5c158: place
+```
+
+Every point within a box has a Z-order value between those of its lower left and its upper
+right corner, so a select with a top level `location <@ box` reads that range of the index
+and only fetches the rows in it. The range can hold points outside of the box, the WHERE
+clause is then checked against the rows as usual.
+
+*/
+
+const pointIndexConfig = "zorder"
+
+type point struct {
+	x, y float64
+}
+
+// A box, by its upper right and lower left corner.
+type box struct {
+	high, low point
+}
+
+func (p point) String() string {
+	return fmt.Sprintf("(%s,%s)", formatFloat(p.x, 64), formatFloat(p.y, 64))
+}
+
+func (b box) String() string {
+	return b.high.String() + "," + b.low.String()
+}
+
+func newBox(a, b point) box {
+	return box{
+		high: point{math.Max(a.x, b.x), math.Max(a.y, b.y)},
+		low:  point{math.Min(a.x, b.x), math.Min(a.y, b.y)},
+	}
+}
+
+func isGeometricType(colType string) bool {
+	return colType == "pg_catalog.point" || colType == "pg_catalog.box"
+}
+
+func isGeometric(v any) bool {
+	switch v.(type) {
+	case point, box:
+		return true
+	}
+	return false
+}
+
+// Read the coordinates of a point or a box, ignoring the parentheses around them.
+func parseCoordinates(typeName, s string, n int) ([]float64, error) {
+	fields := strings.Split(strings.NewReplacer("(", "", ")", "").Replace(s), ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("invalid input syntax for type %s: %q", typeName, s)
+	}
+
+	coords := make([]float64, n)
+	for i, f := range fields {
+		c, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type %s: %q", typeName, s)
+		}
+		coords[i] = c
+	}
+	return coords, nil
+}
+
+func parseGeometric(colType, s string) (any, error) {
+	if colType == "pg_catalog.point" {
+		c, err := parseCoordinates("point", s, 2)
+		if err != nil {
+			return nil, err
+		}
+		return point{c[0], c[1]}, nil
+	}
+
+	c, err := parseCoordinates("box", s, 4)
+	if err != nil {
+		return nil, err
+	}
+	return newBox(point{c[0], c[1]}, point{c[2], c[3]}), nil
+}
+
+func castGeometric(v any, colType string) (string, error) {
+	switch value := v.(type) {
+	case point, box:
+		if valueType(value) != colType {
+			return "", fmt.Errorf("cannot cast type %s to %s", sqlTypeName(valueType(value)), sqlTypeName(colType))
+		}
+		return fmt.Sprint(value), nil
+	case string:
+		g, err := parseGeometric(colType, value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(g), nil
+	}
+	return "", fmt.Errorf("cannot cast type %T to %s", v, sqlTypeName(colType))
+}
+
+// The operands of a geometric operator, strings are read as points or, for <@ and @>, as the box.
+func geometricOperand(v any, colType string) (any, error) {
+	if s, ok := v.(string); ok {
+		return parseGeometric(colType, s)
+	}
+	return v, nil
+}
+
+func geometricOp(op string, left, right any) (any, error) {
+	leftType, rightType := "pg_catalog.point", "pg_catalog.point"
+	switch op {
+	case "<@":
+		rightType = "pg_catalog.box"
+	case "@>":
+		leftType = "pg_catalog.box"
+	}
+
+	l, err := geometricOperand(left, leftType)
+	if err != nil {
+		return nil, err
+	}
+	r, err := geometricOperand(right, rightType)
+	if err != nil {
+		return nil, err
+	}
+
+	lp, lok := l.(point)
+	rp, rok := r.(point)
+	if lok && rok {
+		switch op {
+		case "<->":
+			return math.Hypot(lp.x-rp.x, lp.y-rp.y), nil
+		case "~=":
+			return lp == rp, nil
+		case "<<":
+			return lp.x < rp.x, nil
+		case ">>":
+			return lp.x > rp.x, nil
+		case "<^":
+			return lp.y < rp.y, nil
+		case ">^":
+			return lp.y > rp.y, nil
+		}
+	}
+
+	if b, ok := r.(box); ok && lok && op == "<@" {
+		return b.contains(lp), nil
+	}
+	if b, ok := l.(box); ok && rok && op == "@>" {
+		return b.contains(rp), nil
+	}
+
+	return nil, fmt.Errorf("operator does not exist: %s %s %s", sqlTypeName(valueType(l)), op, sqlTypeName(valueType(r)))
+}
+
+func (b box) contains(p point) bool {
+	return p.x >= b.low.x && p.x <= b.high.x && p.y >= b.low.y && p.y <= b.high.y
+}
+
+// The point(x, y) and box(point, point) functions.
+func evalGeometricFunc(name string, fc *pgquery.FuncCall, tbl *tableDefinition, r row) (any, error) {
+	if len(fc.Args) != 2 {
+		return nil, fmt.Errorf("function %s takes two arguments", name)
+	}
+
+	var args []any
+	for _, arg := range fc.Args {
+		v, err := evalExpr(arg, tbl, r)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	if name == "point" {
+		x, xok := floatOperand(args[0])
+		y, yok := floatOperand(args[1])
+		if !xok || !yok {
+			return nil, fmt.Errorf("function point(%s, %s) does not exist", sqlTypeName(valueType(args[0])), sqlTypeName(valueType(args[1])))
+		}
+		return point{x, y}, nil
+	}
+
+	a, err := geometricOperand(args[0], "pg_catalog.point")
+	if err != nil {
+		return nil, err
+	}
+	b, err := geometricOperand(args[1], "pg_catalog.point")
+	if err != nil {
+		return nil, err
+	}
+	ap, aok := a.(point)
+	bp, bok := b.(point)
+	if !aok || !bok {
+		return nil, fmt.Errorf("function box(%s, %s) does not exist", sqlTypeName(valueType(a)), sqlTypeName(valueType(b)))
+	}
+	return newBox(ap, bp), nil
+}
+
+func encodeGeometric(v any) tuple.Tuple {
+	switch g := v.(type) {
+	case point:
+		return tuple.Tuple{"point", g.x, g.y}
+	case box:
+		return tuple.Tuple{"box", g.high.x, g.high.y, g.low.x, g.low.y}
+	}
+	return nil
+}
+
+func decodeGeometric(t tuple.Tuple) (any, bool) {
+	if !(len(t) == 3 && t[0] == "point") && !(len(t) == 5 && t[0] == "box") {
+		return nil, false
+	}
+
+	var coords []float64
+	for _, e := range t[1:] {
+		c, ok := e.(float64)
+		if !ok {
+			return nil, false
+		}
+		coords = append(coords, c)
+	}
+
+	if len(coords) == 2 {
+		return point{coords[0], coords[1]}, true
+	}
+	return box{high: point{coords[0], coords[1]}, low: point{coords[2], coords[3]}}, true
+}
+
+// Map a coordinate to 32 bits that sort like the coordinate does.
+func sortableCoordinate(f float64) uint32 {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return uint32(bits >> 32)
+}
+
+// The Z-order value of a point, the bits of its coordinates interleaved with those of x first.
+func zOrder(p point) uint64 {
+	x, y := sortableCoordinate(p.x), sortableCoordinate(p.y)
+	var z uint64
+	for i := 31; i >= 0; i-- {
+		z = z<<1 | uint64(x>>i&1)
+		z = z<<1 | uint64(y>>i&1)
+	}
+	return z
+}
+
+/*
+
+Find a top level `column <@ box` in the WHERE clause that a Z-order index of the table can
+answer and read the rows in the range of the box from the index. Returns false if there is
+none and the table has to be scanned.
+
+*/
+
+func (pe pgEngine) pointIndexRows(rtr fdb.ReadTransaction, where *pgquery.Node, tbl *tableDefinition, tables []string, indexes []textIndex) ([]row, bool, error) {
+	for _, a := range topLevelPredicates(where, "<@") {
+		c := a.Lexpr.GetColumnRef()
+		if c == nil {
+			continue
+		}
+		column := c.Fields[len(c.Fields)-1].GetString_().GetStr()
+		if colType, _ := tbl.columnType(column); colType != "pg_catalog.point" {
+			continue
+		}
+
+		v, err := evalExpr(a.Rexpr, &tableDefinition{}, row{})
+		if err != nil {
+			continue
+		}
+		bv, err := geometricOperand(v, "pg_catalog.box")
+		if err != nil {
+			return nil, false, err
+		}
+		b, ok := bv.(box)
+		if !ok {
+			continue
+		}
+
+		for _, idx := range indexes {
+			if idx.Column != column || idx.Config != pointIndexConfig {
+				continue
+			}
+
+			dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			indexSS := dataDir.Sub("text_index").Sub(tbl.Name, idx.Name)
+
+			// Note: the range ends past every row id of the upper right corner
+			begin, _ := indexSS.Sub(zOrder(b.low)).FDBRangeKeys()
+			_, end := indexSS.Sub(zOrder(b.high)).FDBRangeKeys()
+
+			ids := map[string]string{}
+			ri := rtr.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{
+				Mode: fdb.StreamingModeWantAll,
+			}).Iterator()
+			for ri.Advance() {
+				kv := ri.MustGet()
+				t, _ := indexSS.Unpack(kv.Key)
+				ids[rowIDString(t[1])] = string(kv.Value)
+			}
+			return pe.readRowsById(rtr, tbl, ids, tables), true, nil
+		}
+	}
+
+	return nil, false, nil
+}
@@ -1,19 +1,29 @@
 module fakegres-fdb
 
-go 1.21.6
+go 1.22
 
 require (
 	github.com/apple/foundationdb/bindings/go v0.0.0-20240723142048-7aad24e407e6
 	github.com/google/uuid v1.6.0
 	github.com/jackc/pgproto3/v2 v2.3.2
+	github.com/klauspost/compress v1.18.0
+	github.com/parquet-go/parquet-go v0.24.0
 	github.com/pganalyze/pg_query_go/v2 v2.2.0
+	github.com/shopspring/decimal v1.4.0
+	golang.org/x/text v0.14.0
+	google.golang.org/protobuf v1.34.2
 )
 
 require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/jackc/chunkreader/v2 v2.0.0 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 )
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Index consistency checks.
+
+fakegres_check_index compares an index with the rows of its table, and its partitions, and
+reports the entries that are missing for a row and the orphaned entries that have no row or
+the wrong value. With true as the second argument it repairs the index too, adding the missing
+entries and clearing the orphaned ones:
+
+```sql
+select * from fakegres_check_index('person_age_idx');
+select * from fakegres_check_index('billing.invoice_total_idx', true);
+```
+
+```
+ missing | orphaned
+---------+----------
+       2 |        1
+```
+
+The table is scanned first and then the entries of the index, each in batches of its own
+transaction (see scan.go), so a check of a large table isn't cut short by the transaction
+limits and doesn't stop other sessions from writing. Every batch checks and repairs rows and
+entries as of one transaction, a write of another session commits either before or after it.
+The first problems are also raised as notices. It can't run in a transaction block.
+
+*/
+
+// The problems raised as notices, the others are only counted.
+const maxIndexCheckNotices = 20
+
+type indexCheck struct {
+	missing  int
+	orphaned int
+}
+
+// The problems found in a batch, counted once its transaction committed.
+type indexCheckBatch struct {
+	next     fdb.Key
+	problems []string
+}
+
+// The call of the function if the statement selects it alone, nil if it doesn't.
+func selectedFuncCall(stmt *pgquery.Node, name string) *pgquery.FuncCall {
+	s := stmt.GetSelectStmt()
+	if s == nil || len(s.TargetList) != 1 && len(s.FromClause) == 0 {
+		return nil
+	}
+
+	// Note: the function is called in the target list or in FROM, like other set returning functions
+	var fc *pgquery.FuncCall
+	switch {
+	case len(s.FromClause) == 0:
+		fc = s.TargetList[0].GetResTarget().GetVal().GetFuncCall()
+	case len(s.FromClause) == 1:
+		if f := s.FromClause[0].GetRangeFunction(); f != nil && len(f.Functions) == 1 && len(f.Functions[0].GetList().GetItems()) > 0 {
+			fc = f.Functions[0].GetList().GetItems()[0].GetFuncCall()
+		}
+	}
+	if fc == nil || len(fc.Funcname) == 0 || funcName(fc) != name {
+		return nil
+	}
+	return fc
+}
+
+func (pgs *pgServer) handleIndexCheckStmt(ctx context.Context, stmt *pgquery.Node) (bool, error) {
+	fc := selectedFuncCall(stmt, "fakegres_check_index")
+	if fc == nil {
+		return false, nil
+	}
+
+	if len(fc.Args) < 1 || len(fc.Args) > 2 {
+		return true, fmt.Errorf("fakegres_check_index takes an index name and whether to repair it")
+	}
+	var args []any
+	for _, a := range fc.Args {
+		v, err := evalExpr(a, &tableDefinition{}, row{})
+		if err != nil {
+			return true, err
+		}
+		args = append(args, v)
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return true, fmt.Errorf("fakegres_check_index takes an index name and whether to repair it")
+	}
+	repair := false
+	if len(args) == 2 {
+		if repair, ok = args[1].(bool); !ok {
+			return true, fmt.Errorf("fakegres_check_index takes an index name and whether to repair it")
+		}
+	}
+
+	if pgs.tx != nil {
+		return true, &pgError{Code: sqlStateActiveSQLTransaction, Message: "fakegres_check_index cannot run inside a transaction block"}
+	}
+
+	pe := pgs.engine.withTransactor(pgs.transactor()).withContext(ctx)
+	if schema, index, ok := strings.Cut(name, "."); ok {
+		exists, err := pe.schemaExists(schema)
+		if err != nil {
+			return true, err
+		}
+		if !exists {
+			return true, &pgError{Code: sqlStateInvalidSchemaName, Message: fmt.Sprintf("schema \"%s\" does not exist", schema)}
+		}
+		pe, name = pe.withSchema(schema), index
+	}
+
+	check, err := pe.checkIndex(name, repair)
+	if err != nil {
+		return true, err
+	}
+	if repair {
+		pgs.freshRead = true
+	}
+
+	return true, pgs.writePgResult(&pgResult{
+		fieldNames: []string{"missing", "orphaned"},
+		fieldTypes: []string{"pg_catalog.int8", "pg_catalog.int8"},
+		rows:       [][]any{{int64(check.missing), int64(check.orphaned)}},
+	}, "SELECT")
+}
+
+// The table and definition of the index with the given name.
+func (pe pgEngine) findIndex(name string) (string, textIndex, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	indexSS := catalogDir.Sub("index")
+
+	found, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		kr, err := fdb.PrefixRange(indexSS.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range rtr.GetRange(kr, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}).GetSliceOrPanic() {
+			k, _ := indexSS.Unpack(kv.Key)
+			if k[1].(string) != name {
+				continue
+			}
+			v, err := tuple.Unpack(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			return tuple.Tuple{k[0], textIndex{Name: name, Column: v[0].(string), Config: v[1].(string)}}, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return "", textIndex{}, fmt.Errorf("could not get index: %w", err)
+	}
+	if found == nil {
+		return "", textIndex{}, &pgError{Code: sqlStateUndefinedObject, Message: fmt.Sprintf("index \"%s\" does not exist", name)}
+	}
+	t := found.(tuple.Tuple)
+	return t[0].(string), t[1].(textIndex), nil
+}
+
+// Check the index with the given name against the rows of its table, repairing it if repair is set.
+func (pe pgEngine) checkIndex(name string, repair bool) (indexCheck, error) {
+	tblName, idx, err := pe.findIndex(name)
+	if err != nil {
+		return indexCheck{}, err
+	}
+	tbl, err := pe.getTableDefinition(tblName)
+	if err != nil {
+		return indexCheck{}, err
+	}
+
+	tables, err := pe.tableTargets(tblName)
+	if err != nil {
+		return indexCheck{}, err
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+	textIndexSS := dataDir.Sub("text_index")
+
+	var check indexCheck
+	notices := 0
+	report := func(problems []string) {
+		for _, p := range problems {
+			if notices < maxIndexCheckNotices {
+				pe.raiseNotice(sqlStateIndexCorrupted, "index \"%s\" %s", idx.Name, p)
+			}
+			notices++
+		}
+	}
+
+	for _, target := range tables {
+		n, err := pe.checkIndexEntries(tblName, tbl, idx, target, tableDataSS, textIndexSS, repair, report)
+		check.missing += n
+		if err != nil {
+			return check, err
+		}
+	}
+
+	n, err := pe.checkIndexOrphans(tblName, tbl, idx, tables, tableDataSS, textIndexSS, repair, report)
+	check.orphaned += n
+	if err != nil {
+		return check, err
+	}
+
+	if notices > maxIndexCheckNotices {
+		pe.raiseNotice(sqlStateIndexCorrupted, "index \"%s\" has %d more problems", idx.Name, notices-maxIndexCheckNotices)
+	}
+	return check, nil
+}
+
+// Scan the rows of target and count the index entries that are missing for them.
+func (pe pgEngine) checkIndexEntries(tblName string, tbl *tableDefinition, idx textIndex, target string, tableDataSS, textIndexSS subspace.Subspace, repair bool, report func([]string)) (int, error) {
+	layout := tbl.rowScanLayout()
+	rangeQuery := scanRange(tableDataSS, tbl, target, layout, tbl.ColumnNames)
+	begin := rangeQuery.Begin
+	missing := 0
+	for {
+		if err := pe.checkCanceled(); err != nil {
+			return missing, err
+		}
+
+		b, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			ids, rows, rowEnds, complete := readTableRows(tr, tableDataSS, tbl, target, layout, tbl.ColumnNames, fdb.KeyRange{Begin: begin, End: rangeQuery.End}, scanBatchKeys)
+			batch := indexCheckBatch{}
+			if !complete {
+				batch.next = rowEnds[len(rowEnds)-1]
+			}
+
+			type entry struct {
+				key    tuple.Tuple
+				future fdb.FutureByteSlice
+			}
+			var entries []entry
+			for i, r := range rows {
+				lexemes, err := indexLexemes(idx, r[idx.Column])
+				if err != nil {
+					return nil, err
+				}
+				for _, l := range lexemes {
+					key := tuple.Tuple{tblName, idx.Name, l, rowIDElement(ids[i])}
+					entries = append(entries, entry{key, tr.Get(textIndexSS.Pack(key))})
+				}
+			}
+
+			for _, e := range entries {
+				if value := e.future.MustGet(); value != nil && string(value) == target {
+					continue
+				}
+				batch.problems = append(batch.problems, fmt.Sprintf("is missing the entry %v of a row of %s", e.key[2], target))
+				if repair {
+					writes(tr).Set(textIndexSS.Pack(e.key), []byte(target))
+				}
+			}
+			return batch, nil
+		})
+		if err != nil {
+			return missing, fmt.Errorf("could not check index: %w", err)
+		}
+
+		batch := b.(indexCheckBatch)
+		missing += len(batch.problems)
+		report(batch.problems)
+		if batch.next == nil {
+			return missing, nil
+		}
+		begin = batch.next
+	}
+}
+
+// Scan the entries of the index and count the ones without a row of tables that has their value.
+func (pe pgEngine) checkIndexOrphans(tblName string, tbl *tableDefinition, idx textIndex, tables []string, tableDataSS, textIndexSS subspace.Subspace, repair bool, report func([]string)) (int, error) {
+	kr, err := fdb.PrefixRange(textIndexSS.Pack(tuple.Tuple{tblName, idx.Name}))
+	if err != nil {
+		return 0, err
+	}
+	isTarget := map[string]bool{}
+	for _, t := range tables {
+		isTarget[t] = true
+	}
+
+	begin := kr.Begin
+	orphaned := 0
+	for {
+		if err := pe.checkCanceled(); err != nil {
+			return orphaned, err
+		}
+
+		b, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			kvs := tr.GetRange(fdb.KeyRange{Begin: begin, End: kr.End}, fdb.RangeOptions{
+				Limit: scanBatchKeys,
+				Mode:  fdb.StreamingModeWantAll,
+			}).GetSliceOrPanic()
+			batch := indexCheckBatch{}
+			if len(kvs) == scanBatchKeys {
+				batch.next = keyAfter(kvs[len(kvs)-1].Key)
+			}
+
+			// Note: the cells of the entries are read at once and waited for after
+			cells := make([]fdb.FutureByteSlice, len(kvs))
+			for i, kv := range kvs {
+				t, _ := textIndexSS.Unpack(kv.Key)
+				target, id := string(kv.Value), rowIDString(t[3])
+				if !isTarget[target] {
+					continue
+				}
+				key := tableDataSS.Pack(rowKey(target, id))
+				if !tbl.storesRows() {
+					key = tableDataSS.Pack(tuple.Tuple{target, "c", tbl.columnKey(idx.Column), rowIDElement(id)})
+				}
+				cells[i] = tr.Get(key)
+			}
+
+			for i, kv := range kvs {
+				t, _ := textIndexSS.Unpack(kv.Key)
+				target, id := string(kv.Value), rowIDString(t[3])
+				if cells[i] != nil {
+					value := cells[i].MustGet()
+					// Note: the cell is in the value of the row in the row layout
+					if value != nil && tbl.storesRows() {
+						value = decodeRow(tbl, value)[idx.Column]
+					}
+					if value != nil {
+						lexemes, err := indexLexemes(idx, readCell(tr, tableDataSS, target, id, tbl.columnKey(idx.Column), value))
+						if err != nil {
+							return nil, err
+						}
+						if hasLexeme(lexemes, t[2]) {
+							continue
+						}
+					}
+				}
+				batch.problems = append(batch.problems, fmt.Sprintf("has the entry %v of a row %s doesn't have", t[2], target))
+				if repair {
+					writes(tr).Clear(kv.Key)
+				}
+			}
+			return batch, nil
+		})
+		if err != nil {
+			return orphaned, fmt.Errorf("could not check index: %w", err)
+		}
+
+		batch := b.(indexCheckBatch)
+		orphaned += len(batch.problems)
+		report(batch.problems)
+		if batch.next == nil {
+			return orphaned, nil
+		}
+		begin = batch.next
+	}
+}
+
+func hasLexeme(lexemes []tuple.TupleElement, l tuple.TupleElement) bool {
+	key := tuple.Tuple{l}.Pack()
+	for _, e := range lexemes {
+		if bytes.Equal(tuple.Tuple{e}.Pack(), key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Intervals, spans of time.
+
+Like Postgres, an interval keeps months, days and microseconds apart, since a month has no
+fixed number of days and a day in a time zone with daylight saving time no fixed number of
+hours. Adding an interval to a timestamp adds the months first, staying within the month,
+then the days and then the time:
+
+```sql
+select timestamp '2024-01-31 12:00' + interval '1 month 1 day 2 hours';  -- 2024-03-01 14:00:00
+select timestamp '2024-03-02 06:00' - timestamp '2024-03-01';           -- 1 day 06:00:00
+select interval '1 hour' * 2.5;                                         -- 02:30:00
+```
+
+Intervals are read in the verbose form Postgres writes them in (`1 year 2 mons 3 days
+04:05:06`, with `@` and `ago` allowed) and in ISO 8601 form (`P1Y2M3DT4H5M6S`). They
+compare by their length with 30 day months and 24 hour days.
+
+Cells hold the three parts in a tagged nested tuple:
+
+```
+data/table_data/shift/r/72746a7f-727f-4e0a-88f1-d983fea5c158/length: (("interval", 0, 0, 28800000000),)
+```
+
+*/
+
+type interval struct {
+	months int64
+	days   int64
+	micros int64
+}
+
+const (
+	daysPerMonth  = 30
+	microsPerHour = 3600 * 1e6
+)
+
+// Lengths of the units an interval can be written in, as a number of months, days or microseconds.
+var intervalUnits = map[string]interval{
+	"microsecond": {micros: 1},
+	"millisecond": {micros: 1e3},
+	"second":      {micros: 1e6},
+	"minute":      {micros: 60e6},
+	"hour":        {micros: microsPerHour},
+	"day":         {days: 1},
+	"week":        {days: 7},
+	"month":       {months: 1},
+	"year":        {months: 12},
+	"decade":      {months: 120},
+	"century":     {months: 1200},
+	"millennium":  {months: 12000},
+}
+
+var intervalUnitAliases = map[string]string{
+	"us": "microsecond", "usec": "microsecond", "usecs": "microsecond", "microseconds": "microsecond",
+	"ms": "millisecond", "msec": "millisecond", "msecs": "millisecond", "milliseconds": "millisecond",
+	"s": "second", "sec": "second", "secs": "second", "seconds": "second",
+	"m": "minute", "min": "minute", "mins": "minute", "minutes": "minute",
+	"h": "hour", "hr": "hour", "hrs": "hour", "hours": "hour",
+	"d": "day", "days": "day",
+	"w": "week", "weeks": "week",
+	"mon": "month", "mons": "month", "months": "month",
+	"y": "year", "yr": "year", "yrs": "year", "years": "year",
+	"decades": "decade", "centuries": "century", "millennia": "millennium", "millenniums": "millennium",
+}
+
+var intervalTokenRegexp = regexp.MustCompile(`^([+-]?\d*\.?\d+)\s*([a-z]*)`)
+
+var intervalClockRegexp = regexp.MustCompile(`^([+-]?)(\d+):(\d{2})(?::(\d{2})(\.\d+)?)?`)
+
+var isoIntervalRegexp = regexp.MustCompile(`^([+-]?\d*\.?\d+)([A-Z])`)
+
+func isIntervalType(colType string) bool {
+	base, _ := splitTypeMods(colType)
+	return base == "pg_catalog.interval"
+}
+
+// Scale an interval by a factor. Fractions of months spill into days and fractions of days into time, like Postgres.
+func (iv interval) mul(f float64) (interval, error) {
+	months := float64(iv.months) * f
+	days := float64(iv.days)*f + (months-math.Trunc(months))*daysPerMonth
+	micros := float64(iv.micros)*f + (days-math.Trunc(days))*microsPerDay
+
+	if math.IsNaN(micros) || math.Abs(months) > math.MaxInt32 || math.Abs(days) > math.MaxInt32 || math.Abs(micros) >= math.MaxInt64 {
+		return interval{}, fmt.Errorf("interval out of range")
+	}
+	return interval{months: int64(months), days: int64(days), micros: int64(math.Round(micros))}, nil
+}
+
+func (iv interval) add(other interval) interval {
+	return interval{months: iv.months + other.months, days: iv.days + other.days, micros: iv.micros + other.micros}
+}
+
+func (iv interval) neg() interval {
+	return interval{months: -iv.months, days: -iv.days, micros: -iv.micros}
+}
+
+// The length of an interval with 30 day months and 24 hour days, which is what intervals compare by.
+func (iv interval) span() float64 {
+	return float64(iv.months)*daysPerMonth*microsPerDay + float64(iv.days)*microsPerDay + float64(iv.micros)
+}
+
+func parseInterval(s string) (interval, error) {
+	value := strings.TrimSpace(s)
+	if strings.HasPrefix(value, "P") {
+		return parseISOInterval(s)
+	}
+
+	value = strings.TrimSpace(strings.TrimPrefix(strings.ToLower(value), "@"))
+	ago := strings.HasSuffix(value, " ago")
+	value = strings.TrimSuffix(value, " ago")
+
+	var iv interval
+	for value = strings.TrimSpace(value); value != ""; value = strings.TrimSpace(value) {
+		// Note: a time of day like 04:05:06 is a number of hours, minutes and seconds
+		if m := intervalClockRegexp.FindStringSubmatch(value); m != nil {
+			hours, _ := strconv.ParseInt(m[2], 10, 64)
+			minutes, _ := strconv.ParseInt(m[3], 10, 64)
+			seconds, _ := strconv.ParseFloat("0"+m[4]+m[5], 64)
+			if minutes > 59 || seconds >= 60 {
+				return interval{}, fmt.Errorf("interval field value out of range: %q", s)
+			}
+			micros := hours*microsPerHour + minutes*60e6 + int64(math.Round(seconds*1e6))
+			if m[1] == "-" {
+				micros = -micros
+			}
+			iv.micros += micros
+			value = value[len(m[0]):]
+			continue
+		}
+
+		m := intervalTokenRegexp.FindStringSubmatch(value)
+		if m == nil {
+			return interval{}, fmt.Errorf("invalid input syntax for type interval: %q", s)
+		}
+		n, _ := strconv.ParseFloat(m[1], 64)
+
+		// Note: a number without a unit is a number of seconds
+		unitName := m[2]
+		if unitName == "" {
+			unitName = "second"
+		}
+		if alias, ok := intervalUnitAliases[unitName]; ok {
+			unitName = alias
+		}
+		unit, ok := intervalUnits[unitName]
+		if !ok {
+			return interval{}, fmt.Errorf("invalid input syntax for type interval: %q", s)
+		}
+
+		part, err := unit.mul(n)
+		if err != nil {
+			return interval{}, err
+		}
+		iv = iv.add(part)
+		value = value[len(m[0]):]
+	}
+
+	if ago {
+		iv = iv.neg()
+	}
+	return iv, nil
+}
+
+// Parse an ISO 8601 duration like P1Y2M3DT4H5M6S, M is months before the T and minutes after it.
+func parseISOInterval(s string) (interval, error) {
+	value := strings.TrimSpace(s)[1:]
+	var iv interval
+	inTime := false
+	for value != "" {
+		if value[0] == 'T' {
+			inTime, value = true, value[1:]
+			continue
+		}
+
+		m := isoIntervalRegexp.FindStringSubmatch(value)
+		if m == nil {
+			return interval{}, fmt.Errorf("invalid input syntax for type interval: %q", s)
+		}
+		n, _ := strconv.ParseFloat(m[1], 64)
+
+		var unitName string
+		switch {
+		case !inTime && m[2] == "Y":
+			unitName = "year"
+		case !inTime && m[2] == "M":
+			unitName = "month"
+		case !inTime && m[2] == "W":
+			unitName = "week"
+		case !inTime && m[2] == "D":
+			unitName = "day"
+		case inTime && m[2] == "H":
+			unitName = "hour"
+		case inTime && m[2] == "M":
+			unitName = "minute"
+		case inTime && m[2] == "S":
+			unitName = "second"
+		default:
+			return interval{}, fmt.Errorf("invalid input syntax for type interval: %q", s)
+		}
+
+		part, err := intervalUnits[unitName].mul(n)
+		if err != nil {
+			return interval{}, err
+		}
+		iv = iv.add(part)
+		value = value[len(m[0]):]
+	}
+	return iv, nil
+}
+
+/*
+
+Write an interval like Postgres does with the default IntervalStyle:
+
+```
+1 year 2 mons 3 days 04:05:06.5
+-1 days +02:00:00
+00:00:00
+```
+
+*/
+
+func (iv interval) String() string {
+	var parts []string
+	negativeBefore := false
+	addPart := func(value int64, unit string) {
+		if value == 0 {
+			return
+		}
+		sign := ""
+		if negativeBefore && value > 0 {
+			sign = "+"
+		}
+		if value != 1 {
+			unit += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%s%d %s", sign, value, unit))
+		negativeBefore = value < 0
+	}
+	addPart(iv.months/12, "year")
+	addPart(iv.months%12, "mon")
+	addPart(iv.days, "day")
+
+	if len(parts) == 0 || iv.micros != 0 {
+		sign, micros := "", iv.micros
+		if micros < 0 {
+			sign, micros = "-", -micros
+		} else if negativeBefore {
+			sign = "+"
+		}
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, micros/microsPerHour, micros/60e6%60, micros/1e6%60)
+		if fraction := micros % 1e6; fraction != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", fraction), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+// The fields of `INTERVAL '1' DAY` are a bit mask in the type modifier, the last of them is the unit of a bare number.
+var intervalFieldUnits = []struct {
+	bit  int
+	unit string
+}{
+	{1 << 12, "second"},
+	{1 << 11, "minute"},
+	{1 << 10, "hour"},
+	{1 << 3, "day"},
+	{1 << 1, "month"},
+	{1 << 2, "year"},
+}
+
+func castInterval(v any, colType string) (string, error) {
+	switch value := v.(type) {
+	case interval:
+		return value.String(), nil
+	case timeOfDay:
+		return interval{micros: int64(value)}.String(), nil
+	case string:
+		if _, mods := splitTypeMods(colType); len(mods) > 0 {
+			if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				for _, f := range intervalFieldUnits {
+					if mods[0]&f.bit != 0 {
+						value += " " + f.unit
+						break
+					}
+				}
+			}
+		}
+
+		iv, err := parseInterval(value)
+		if err != nil {
+			return "", err
+		}
+		return iv.String(), nil
+	}
+	return "", fmt.Errorf("cannot cast type %T to interval", v)
+}
+
+func isInterval(v any) bool {
+	_, ok := v.(interval)
+	return ok
+}
+
+// Compare intervals with each other or with string constants, which are read as intervals.
+func cmpInterval(left, right any) (int, error) {
+	operand := func(v any) (interval, error) {
+		switch value := v.(type) {
+		case interval:
+			return value, nil
+		case string:
+			return parseInterval(value)
+		}
+		return interval{}, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return cmpFloat(l.span(), r.span()), nil
+}
+
+// Add an interval to a point in time in the given location: months first, clamped to the end of the month, then days, then time.
+func addIntervalToTime(t time.Time, iv interval) time.Time {
+	if iv.months != 0 {
+		y, m, d := t.Date()
+		first := time.Date(y, m+time.Month(iv.months), 1, 0, 0, 0, 0, t.Location())
+		lastDay := first.AddDate(0, 1, -1).Day()
+		if d > lastDay {
+			d = lastDay
+		}
+		t = time.Date(first.Year(), first.Month(), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+	if iv.days != 0 {
+		t = t.AddDate(0, 0, int(iv.days))
+	}
+	return t.Add(time.Duration(iv.micros) * time.Microsecond)
+}
+
+func addIntervalToTimestamp(v any, iv interval) (any, error) {
+	switch ts := v.(type) {
+	case timestamp:
+		if ts == timestampInfinity || ts == timestampNegInfinity {
+			return ts, nil
+		}
+		return timestamp(microsFromTime(addIntervalToTime(timeFromMicros(int64(ts)).UTC(), iv))), nil
+	case timestamptz:
+		if ts == timestampInfinity || ts == timestampNegInfinity {
+			return ts, nil
+		}
+		return timestamptz(microsFromTime(addIntervalToTime(timeFromMicros(int64(ts)).In(defaultTimeZone), iv))), nil
+	case date:
+		return addIntervalToTimestamp(timestamp(timestampMicrosFromDate(ts)), iv)
+	case timeOfDay:
+		return timeOfDay(((int64(ts)+iv.micros)%microsPerDay + microsPerDay) % microsPerDay), nil
+	}
+	return nil, fmt.Errorf("operator does not exist: %T + interval", v)
+}
+
+/*
+
+Arithmetic on timestamps, times and intervals:
+
+```
+timestamp ± interval    timestamp
+date ± interval         timestamp
+time ± interval         time
+date + time             timestamp
+timestamp - timestamp   interval
+time - time             interval
+interval ± interval     interval
+interval * number       interval
+interval / number       interval
+```
+
+*/
+
+func intervalArithmeticOp(op string, left, right any) (any, error) {
+	notExist := fmt.Errorf("operator does not exist: %T %s %T", left, op, right)
+
+	if r, ok := right.(interval); ok {
+		switch l := left.(type) {
+		case interval:
+			switch op {
+			case "+":
+				return l.add(r), nil
+			case "-":
+				return l.add(r.neg()), nil
+			}
+		case int64:
+			// Note: unary minus has a zero on the left
+			if op == "-" && l == 0 {
+				return r.neg(), nil
+			}
+			if op == "*" {
+				return r.mul(float64(l))
+			}
+		case float32, float64, string:
+			if op == "*" {
+				f, err := intervalFactor(l)
+				if err != nil {
+					return nil, err
+				}
+				return r.mul(f)
+			}
+		case timestamp, timestamptz, date, timeOfDay:
+			switch op {
+			case "+":
+				return addIntervalToTimestamp(l, r)
+			case "-":
+				return addIntervalToTimestamp(l, r.neg())
+			}
+		}
+		return nil, notExist
+	}
+
+	if l, ok := left.(interval); ok {
+		switch right.(type) {
+		case timestamp, timestamptz, date, timeOfDay:
+			if op == "+" {
+				return addIntervalToTimestamp(right, l)
+			}
+		}
+		if op == "*" || op == "/" {
+			f, err := intervalFactor(right)
+			if err != nil {
+				return nil, notExist
+			}
+			if op == "/" {
+				if f == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				f = 1 / f
+			}
+			return l.mul(f)
+		}
+		return nil, notExist
+	}
+
+	switch l := left.(type) {
+	case date:
+		if r, ok := right.(timeOfDay); ok && op == "+" {
+			return timestamp(timestampMicrosFromDate(l) + int64(r)), nil
+		}
+	case timeOfDay:
+		switch r := right.(type) {
+		case date:
+			if op == "+" {
+				return intervalArithmeticOp(op, r, l)
+			}
+		case timeOfDay:
+			if op == "-" {
+				return interval{micros: int64(l - r)}, nil
+			}
+		}
+	case timestamp, timestamptz:
+		if op == "-" && isTimestamp(right) {
+			zoned := false
+			if _, ok := l.(timestamptz); ok {
+				zoned = true
+			}
+			lus, _ := timestampValue(l, zoned)
+			rus, err := timestampValue(right, zoned)
+			if err != nil {
+				return nil, notExist
+			}
+			if lus == timestampInfinity || lus == timestampNegInfinity || rus == timestampInfinity || rus == timestampNegInfinity {
+				return nil, fmt.Errorf("cannot subtract infinite timestamps")
+			}
+			// Note: whole 24 hours become days, like Postgres
+			diff := lus - rus
+			return interval{days: diff / microsPerDay, micros: diff % microsPerDay}, nil
+		}
+	}
+
+	return nil, notExist
+}
+
+func intervalFactor(v any) (float64, error) {
+	if s, ok := v.(string); ok {
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+	f, ok := floatOperand(v)
+	if !ok {
+		return 0, fmt.Errorf("operator does not exist: interval * %T", v)
+	}
+	return f, nil
+}
+
+func encodeInterval(iv interval) tuple.Tuple {
+	return tuple.Tuple{"interval", iv.months, iv.days, iv.micros}
+}
+
+func decodeInterval(t tuple.Tuple) (interval, bool) {
+	if len(t) != 4 || t[0] != "interval" {
+		return interval{}, false
+	}
+	months, ok1 := t[1].(int64)
+	days, ok2 := t[2].(int64)
+	micros, ok3 := t[3].(int64)
+	return interval{months: months, days: days, micros: micros}, ok1 && ok2 && ok3
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Transaction isolation levels.
+
+FoundationDB transactions are serializable: every read adds a read conflict range and the
+commit fails if anything that was read changed in the meantime. The Postgres isolation levels
+are mapped onto that as follows:
+
+```
+serializable      normal reads, conflicts on anything read (the default)
+repeatable read   snapshot reads, no read conflicts
+read committed    snapshot reads, no read conflicts
+read uncommitted  same as read committed, like in Postgres
+```
+
+Snapshot reads see a consistent snapshot as of the start of the transaction block and the
+writes of the block itself, but don't make the commit fail when the rows read are changed by
+someone else. Only reads of selects are snapshot reads, the reads INSERT and DELETE do to
+find their rows still conflict. Note that read committed sees the snapshot of the whole block,
+not a new one for every statement like in Postgres.
+
+Selects read snapshots at every level unless the server runs with -serializable-selects, so a
+large scan in a serializable block doesn't make its commit fail whenever a row it read is
+written concurrently. That leaves write skew between blocks possible, like repeatable read
+does in Postgres, and -serializable-selects turns the normal reads of serializable back on.
+Outside of a block a select doesn't commit anything, snapshot reads only spare FoundationDB
+the read conflict ranges there.
+
+The level is set per block or as the default for the session:
+
+```sql
+begin isolation level repeatable read;
+set transaction isolation level read committed;
+set session characteristics as transaction isolation level read committed;
+set default_transaction_isolation = 'read committed';
+show transaction_isolation;
+```
+
+Outside of a transaction block every statement is its own FoundationDB transaction that only
+reads or only writes, so the level makes no difference there.
+
+*/
+
+const defaultIsolation = "serializable"
+
+var isolationLevels = map[string]bool{
+	"serializable":     true,
+	"repeatable read":  true,
+	"read committed":   true,
+	"read uncommitted": true,
+}
+
+// Selects in a snapshot transactor use snapshot reads of the transaction.
+type snapshotTransactor struct {
+	fdb.Transactor
+}
+
+func (st snapshotTransactor) ReadTransact(f func(fdb.ReadTransaction) (interface{}, error)) (interface{}, error) {
+	return st.Transactor.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		return f(rtr.Snapshot())
+	})
+}
+
+// The transactor of selects and fetches, which read snapshots unless -serializable-selects is given.
+func (pgs *pgServer) selectTransactor() fdb.Transactor {
+	if pgs.tx != nil {
+		if pgs.cfg.serializableSelects {
+			return pgs.transactor()
+		}
+		return snapshotTransactor{*pgs.tx}
+	}
+
+	var t fdb.Transactor = pgs.sessionKeyspace()
+	if pgs.cfg.readVersionCache > 0 {
+		t = cachedReadVersionTransactor{Transactor: t, maxAge: pgs.cfg.readVersionCache, fresh: &pgs.freshRead}
+	}
+	if !pgs.cfg.serializableSelects {
+		t = snapshotTransactor{t}
+	}
+	return t
+}
+
+// The isolation level of the open transaction block, or the session default outside of one.
+func (pgs *pgServer) isolation() string {
+	if pgs.tx != nil {
+		return pgs.txIsolation
+	}
+	return pgs.defaultIsolation
+}
+
+func isolationLevel(arg *pgquery.Node) (string, error) {
+	level := arg.GetAConst().GetVal().GetString_().GetStr()
+	if !isolationLevels[level] {
+		return "", fmt.Errorf("invalid value for parameter \"transaction_isolation\": \"%s\"", level)
+	}
+	return level, nil
+}
+
+// The isolation level in the options of BEGIN or SET TRANSACTION, empty if there is none.
+func isolationOption(options []*pgquery.Node) (string, error) {
+	for _, o := range options {
+		d := o.GetDefElem()
+		if d != nil && d.Defname == "transaction_isolation" {
+			return isolationLevel(d.Arg)
+		}
+	}
+	return "", nil
+}
+
+// Handle SET and SHOW of the isolation level. Returns false for any other statement.
+func (pgs *pgServer) handleIsolationStmt(stmt *pgquery.Node) (bool, error) {
+	if show := stmt.GetVariableShowStmt(); show != nil {
+		var value string
+		switch show.Name {
+		case "transaction_isolation":
+			value = pgs.isolation()
+		case "default_transaction_isolation":
+			value = pgs.defaultIsolation
+		default:
+			return false, nil
+		}
+
+		return true, pgs.writePgResult(&pgResult{
+			fieldNames: []string{show.Name},
+			fieldTypes: []string{"text"},
+			rows:       [][]any{{value}},
+		}, "SHOW")
+	}
+
+	set := stmt.GetVariableSetStmt()
+	if set == nil {
+		return false, nil
+	}
+
+	var level string
+	var err error
+	switch set.Name {
+	case "TRANSACTION", "SESSION CHARACTERISTICS":
+		level, err = isolationOption(set.Args)
+	case "transaction_isolation", "default_transaction_isolation":
+		if set.Kind == pgquery.VariableSetKind_VAR_SET_DEFAULT || set.Kind == pgquery.VariableSetKind_VAR_RESET {
+			level = defaultIsolation
+		} else if len(set.Args) == 1 {
+			level, err = isolationLevel(set.Args[0])
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	if level != "" {
+		switch set.Name {
+		case "TRANSACTION", "transaction_isolation":
+			// Note: like Postgres, setting the level of the transaction outside of a block has no effect
+			if pgs.tx == nil {
+				log.Println("SET TRANSACTION can only be used in transaction blocks")
+			} else {
+				pgs.txIsolation = level
+			}
+		default:
+			pgs.defaultIsolation = level
+		}
+	}
+
+	pgs.done(nil, "SET")
+	return true, nil
+}
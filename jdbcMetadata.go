@@ -0,0 +1,595 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+JDBC metadata queries.
+
+The PostgreSQL JDBC driver looks up the types it doesn't know, and answers DatabaseMetaData
+calls like getTables and getColumns, with queries that join pg_catalog relations, which the
+catalog emulation can't run on its own:
+
+```sql
+SELECT e.oid, n.nspname = ANY(current_schemas(true)), n.nspname, e.typname
+FROM pg_catalog.pg_type t JOIN pg_catalog.pg_namespace n ON t.typnamespace = n.oid
+JOIN pg_catalog.pg_type e ON t.typelem = e.oid WHERE t.oid = $1
+```
+
+These queries are told apart by the names of the fields they return, which the driver, and
+for most of them the JDBC API, fix. They are answered from the rows of the catalog, narrowed
+down by the comparisons of columns with constants in their WHERE clauses, `=`, LIKE and IN,
+joined with AND. Other conditions, like the ones that join the relations, are taken as given.
+
+Tools built on the driver, like DBeaver, use the same calls to show the schema.
+
+*/
+
+type driverQuery struct {
+	// The fields every query of the kind returns
+	key []string
+	// The types of all the fields queries of the kind may return, by name
+	fields map[string]string
+	// The rows of the answer that meet the conditions, with every field queries of the kind may return
+	rows func(pe pgEngine, conds driverConds) ([]row, error)
+}
+
+// The comparisons of columns with constants in a WHERE clause, by column name.
+type driverConds map[string][]driverCond
+
+type driverCond struct {
+	op     string
+	values []string
+}
+
+var typeInfoFields = map[string]string{
+	"oid":      "pg_catalog.int4",
+	"typname":  "text",
+	"typtype":  "text",
+	"typdelim": "text",
+	"is_array": "pg_catalog.bool",
+	"nspname":  "text",
+	// Note: whether the schema of the type is in the search path, the driver doesn't name this field
+	"?column?": "pg_catalog.bool",
+}
+
+var tableFields = map[string]string{
+	"table_cat":                 "text",
+	"table_schem":               "text",
+	"table_name":                "text",
+	"table_type":                "text",
+	"remarks":                   "text",
+	"type_cat":                  "text",
+	"type_schem":                "text",
+	"type_name":                 "text",
+	"self_referencing_col_name": "text",
+	"ref_generation":            "text",
+}
+
+var columnFields = map[string]string{
+	"nspname":      "text",
+	"relname":      "text",
+	"attname":      "text",
+	"atttypid":     "pg_catalog.int4",
+	"attnotnull":   "pg_catalog.bool",
+	"atttypmod":    "pg_catalog.int4",
+	"attlen":       "pg_catalog.int2",
+	"typtypmod":    "pg_catalog.int4",
+	"attnum":       "pg_catalog.int4",
+	"attidentity":  "text",
+	"attgenerated": "text",
+	"adsrc":        "text",
+	"description":  "text",
+	"typbasetype":  "pg_catalog.int4",
+	"typtype":      "text",
+}
+
+var primaryKeyFields = map[string]string{
+	"table_cat":   "text",
+	"table_schem": "text",
+	"table_name":  "text",
+	"column_name": "text",
+	"key_seq":     "pg_catalog.int2",
+	"pk_name":     "text",
+}
+
+var foreignKeyFields = map[string]string{
+	"pktable_cat":   "text",
+	"pktable_schem": "text",
+	"pktable_name":  "text",
+	"pkcolumn_name": "text",
+	"fktable_cat":   "text",
+	"fktable_schem": "text",
+	"fktable_name":  "text",
+	"fkcolumn_name": "text",
+	"key_seq":       "pg_catalog.int2",
+	"update_rule":   "pg_catalog.int2",
+	"delete_rule":   "pg_catalog.int2",
+	"fk_name":       "text",
+	"pk_name":       "text",
+	"deferrability": "pg_catalog.int2",
+}
+
+// Note: the first kind whose fields match is taken, so kinds come before the ones their fields include
+var driverQueries = []driverQuery{
+	// TypeInfoCache.getSQLType
+	{key: []string{"is_array", "typtype", "typname", "oid"}, fields: typeInfoFields, rows: typeInfoRows},
+	// TypeInfoCache.getPGArrayElement
+	{key: []string{"oid", "?column?", "nspname", "typname"}, fields: typeInfoFields, rows: arrayElementRows},
+	// TypeInfoCache.getPGType by OID
+	{key: []string{"?column?", "nspname", "typname"}, fields: typeInfoFields, rows: typeInfoRows},
+	// TypeInfoCache.getPGType by name
+	{key: []string{"oid", "typname"}, fields: typeInfoFields, rows: typeInfoRows},
+	// TypeInfoCache.getArrayDelimiter
+	{key: []string{"typdelim"}, fields: typeInfoFields, rows: typeInfoRows},
+	{key: []string{"table_cat", "table_schem", "table_name", "table_type"}, fields: tableFields, rows: jdbcTableRows},
+	{key: []string{"table_schem", "table_catalog"}, fields: map[string]string{"table_schem": "text", "table_catalog": "text"}, rows: jdbcSchemaRows},
+	{key: []string{"nspname", "relname", "attname", "atttypid"}, fields: columnFields, rows: jdbcColumnRows},
+	// Note: tables have no foreign keys
+	{key: []string{"table_name", "column_name", "key_seq", "pk_name"}, fields: primaryKeyFields, rows: jdbcPrimaryKeyRows},
+	{key: []string{"pktable_name", "fktable_name", "key_seq", "fk_name"}, fields: foreignKeyFields, rows: noRows},
+}
+
+// The kind of driver query the select is and the names of its fields, false if it isn't one. Only
+// selects of catalog relations that join them, read them through subqueries or rename the fields
+// they return are driver queries, the catalog emulation answers the others.
+func matchDriverQuery(stmt *pgquery.SelectStmt) (*driverQuery, []string, bool) {
+	if len(stmt.FromClause) == 0 || !readsCatalog(stmt) {
+		return nil, nil, false
+	}
+	if len(stmt.FromClause) == 1 && stmt.FromClause[0].GetRangeVar() != nil && !renamesFields(stmt) {
+		return nil, nil, false
+	}
+
+	fields := selectFieldNames(stmt)
+	for i := range driverQueries {
+		q := &driverQueries[i]
+		if hasFields(q, fields) {
+			return q, fields, true
+		}
+	}
+	return nil, nil, false
+}
+
+func hasFields(q *driverQuery, fields []string) bool {
+	names := map[string]bool{}
+	for _, f := range fields {
+		if _, ok := q.fields[f]; !ok {
+			return false
+		}
+		names[f] = true
+	}
+	for _, k := range q.key {
+		if !names[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func renamesFields(stmt *pgquery.SelectStmt) bool {
+	for _, t := range stmt.TargetList {
+		if t.GetResTarget().Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func readsCatalog(stmt *pgquery.SelectStmt) bool {
+	found := false
+	walkNodes(stmt.ProtoReflect(), func(n *pgquery.Node) {
+		if rv := n.GetRangeVar(); rv != nil {
+			if _, ok := lookupCatalogRelation(rv); ok {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// The names of the fields of the select, lower case, the fields of its subquery for `select *`.
+func selectFieldNames(stmt *pgquery.SelectStmt) []string {
+	if len(stmt.TargetList) == 1 && len(stmt.FromClause) == 1 {
+		if fields := stmt.TargetList[0].GetResTarget().Val.GetColumnRef().GetFields(); len(fields) == 1 && fields[0].GetAStar() != nil {
+			if sub := stmt.FromClause[0].GetRangeSubselect(); sub != nil {
+				return selectFieldNames(sub.Subquery.GetSelectStmt())
+			}
+		}
+	}
+
+	var names []string
+	for _, t := range stmt.TargetList {
+		names = append(names, strings.ToLower(targetName(t.GetResTarget())))
+	}
+	return names
+}
+
+// Answer a driver query.
+func (pe pgEngine) executeDriverQuery(stmt *pgquery.SelectStmt, q *driverQuery, fields []string) (*pgResult, error) {
+	res := &pgResult{fieldNames: fields}
+	for _, f := range fields {
+		res.fieldTypes = append(res.fieldTypes, q.fields[f])
+	}
+
+	conds := driverConds{}
+	conds.add(stmt)
+	rows, err := q.rows(pe, conds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		values := make([]any, len(fields))
+		for i, f := range fields {
+			values[i] = r[f]
+		}
+		res.rows = append(res.rows, values)
+	}
+	return res, nil
+}
+
+// Add the conditions of the WHERE clause of the select, and of the subqueries it reads from.
+func (conds driverConds) add(stmt *pgquery.SelectStmt) {
+	conds.addWhere(stmt.WhereClause)
+
+	var from func(n *pgquery.Node)
+	from = func(n *pgquery.Node) {
+		if sub := n.GetRangeSubselect(); sub != nil {
+			conds.add(sub.Subquery.GetSelectStmt())
+		}
+		if j := n.GetJoinExpr(); j != nil {
+			from(j.Larg)
+			from(j.Rarg)
+		}
+	}
+	for _, n := range stmt.FromClause {
+		from(n)
+	}
+}
+
+func (conds driverConds) addWhere(n *pgquery.Node) {
+	if n == nil {
+		return
+	}
+	if b := n.GetBoolExpr(); b != nil {
+		// Note: conditions under OR and NOT don't narrow the rows down on their own
+		if b.Boolop == pgquery.BoolExprType_AND_EXPR {
+			for _, arg := range b.Args {
+				conds.addWhere(arg)
+			}
+		}
+		return
+	}
+
+	e := n.GetAExpr()
+	if e == nil || len(e.Name) != 1 {
+		return
+	}
+	fields := e.Lexpr.GetColumnRef().GetFields()
+	if len(fields) == 0 {
+		return
+	}
+	column := fields[len(fields)-1].GetString_().GetStr()
+	op := e.Name[0].GetString_().GetStr()
+
+	var values []string
+	items := []*pgquery.Node{e.Rexpr}
+	if e.Kind == pgquery.A_Expr_Kind_AEXPR_IN {
+		items = e.Rexpr.GetList().GetItems()
+		op = "in"
+	} else if e.Kind != pgquery.A_Expr_Kind_AEXPR_LIKE && (e.Kind != pgquery.A_Expr_Kind_AEXPR_OP || op != "=") {
+		return
+	}
+	for _, item := range items {
+		if item.GetColumnRef() != nil {
+			return
+		}
+		v, err := evalExpr(item, &tableDefinition{}, row{})
+		if err != nil || v == nil {
+			return
+		}
+		values = append(values, string(formatText(v)))
+	}
+	if e.Kind == pgquery.A_Expr_Kind_AEXPR_LIKE && (op != "~~" || len(values) != 1) {
+		return
+	}
+	conds[column] = append(conds[column], driverCond{op: op, values: values})
+}
+
+func (conds driverConds) filter(rows []row) []row {
+	var matched []row
+	for _, r := range rows {
+		if conds.match(r) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// Whether the row meets the conditions on its columns. Conditions on columns the row doesn't have are ignored.
+func (conds driverConds) match(r row) bool {
+	for column, cs := range conds {
+		v, ok := r[column]
+		if !ok {
+			continue
+		}
+		value := string(formatText(v))
+		for _, c := range cs {
+			if !c.match(value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c driverCond) match(value string) bool {
+	if c.op == "~~" {
+		return likePattern(c.values[0]).MatchString(value)
+	}
+	for _, v := range c.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// A LIKE pattern as a regular expression: % is any text, _ any character, a backslash escapes the next character.
+func likePattern(pattern string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("^(?s:")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			re.WriteString(".*")
+		case c == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString(")$")
+	return regexp.MustCompile(re.String())
+}
+
+func noRows(pe pgEngine, conds driverConds) ([]row, error) {
+	return nil, nil
+}
+
+func typeInfoRows(pe pgEngine, conds driverConds) ([]row, error) {
+	types, err := allTypeInfoRows(pe)
+	if err != nil {
+		return nil, err
+	}
+	return conds.filter(types), nil
+}
+
+// The rows of pg_type, with the schema of every type and whether it is an array.
+func allTypeInfoRows(pe pgEngine) ([]row, error) {
+	types, err := pgTypeRows(pe)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range types {
+		t["nspname"] = "pg_catalog"
+		if t["typnamespace"] == oidString(publicNamespaceOid) {
+			t["nspname"] = "public"
+		}
+		t["is_array"] = boolString(t["typelem"] != "0")
+		t["typdelim"] = ","
+		t["?column?"] = "t"
+	}
+	return types, nil
+}
+
+// The element types of the array types the conditions are on.
+func arrayElementRows(pe pgEngine, conds driverConds) ([]row, error) {
+	types, err := allTypeInfoRows(pe)
+	if err != nil {
+		return nil, err
+	}
+
+	byOid := map[any]row{}
+	for _, t := range types {
+		byOid[t["oid"]] = t
+	}
+
+	var rows []row
+	for _, t := range conds.filter(types) {
+		if e, ok := byOid[t["typelem"]]; ok {
+			rows = append(rows, e)
+		}
+	}
+	return rows, nil
+}
+
+func jdbcTableRows(pe pgEngine, conds driverConds) ([]row, error) {
+	tables, err := pgClassRows(pe)
+	if err != nil {
+		return nil, err
+	}
+	remarks, err := tableRemarks(pe)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		name := fmt.Sprint(t["relname"])
+		t["nspname"] = "public"
+		t["table_schem"] = "public"
+		t["table_name"] = name
+		t["table_type"] = "TABLE"
+		if t["relkind"] == "p" {
+			t["table_type"] = "PARTITIONED TABLE"
+		}
+		if r, ok := remarks[name]; ok {
+			t["remarks"] = r
+		}
+	}
+	return conds.filter(tables), nil
+}
+
+// The comments on tables, by table name.
+func tableRemarks(pe pgEngine) (map[string]string, error) {
+	comments, err := pe.getComments()
+	if err != nil {
+		return nil, err
+	}
+
+	remarks := map[string]string{}
+	for _, c := range comments {
+		if c.Column == "" {
+			remarks[c.Table] = c.Description
+		}
+	}
+	return remarks, nil
+}
+
+func jdbcSchemaRows(pe pgEngine, conds driverConds) ([]row, error) {
+	rows, err := pgNamespaceRows(pe)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		r["table_schem"] = r["nspname"]
+	}
+	return conds.filter(rows), nil
+}
+
+func jdbcColumnRows(pe pgEngine, conds driverConds) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+	comments, err := pe.getComments()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, name := range names {
+		// Note: the columns of tables the conditions leave out aren't read
+		if !conds.match(row{"relname": name}) {
+			continue
+		}
+		tbl, err := pe.getTableDefinition(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, cn := range tbl.ColumnNames {
+			r := row{
+				"nspname":     "public",
+				"relname":     name,
+				"attname":     cn,
+				"atttypid":    oidString(columnTypeOid(tbl, cn)),
+				"attnotnull":  boolString(cn == tbl.PrimaryKey || i < len(tbl.ColumnDomains) && tbl.ColumnDomains[i] != nil && tbl.ColumnDomains[i].NotNull),
+				"atttypmod":   fmt.Sprint(typeModifier(tbl.ColumnTypes[i])),
+				"attlen":      fmt.Sprint(typeLen(tbl.ColumnTypes[i])),
+				"typtypmod":   "-1",
+				"attnum":      fmt.Sprint(i + 1),
+				"typbasetype": "0",
+				"typtype":     "b",
+			}
+			switch {
+			case tbl.columnEnum(cn) != nil:
+				r["typtype"] = "e"
+			case tbl.columnComposite(cn) != nil:
+				r["typtype"] = "c"
+			}
+			for _, c := range comments {
+				if c.Table == name && c.Column == cn {
+					r["description"] = c.Description
+				}
+			}
+			rows = append(rows, r)
+		}
+	}
+	return conds.filter(rows), nil
+}
+
+// The primary keys of the tables, a row of the key column of every table that has one.
+func jdbcPrimaryKeyRows(pe pgEngine, conds driverConds) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, name := range names {
+		if !conds.match(row{"relname": name, "table_name": name}) {
+			continue
+		}
+		tbl, err := pe.getTableDefinition(name)
+		if err != nil {
+			return nil, err
+		}
+		if tbl.PrimaryKey == "" {
+			continue
+		}
+		rows = append(rows, row{
+			"nspname":     "public",
+			"relname":     name,
+			"table_schem": "public",
+			"table_name":  name,
+			"column_name": tbl.PrimaryKey,
+			"attname":     tbl.PrimaryKey,
+			"key_seq":     "1",
+			"pk_name":     primaryKeyName(name),
+		})
+	}
+	return conds.filter(rows), nil
+}
+
+// The OID of the type of the column, of the base type for domains.
+func columnTypeOid(tbl *tableDefinition, name string) uint32 {
+	if e := tbl.columnEnum(name); e != nil {
+		return e.Oid
+	}
+	if ct := tbl.columnComposite(name); ct != nil {
+		return ct.Oid
+	}
+	colType, _ := tbl.columnType(name)
+	base, _ := splitTypeMods(colType)
+	oid, _ := builtinTypeOID(base)
+	return oid
+}
+
+// The functions tools call to learn about the server and the search path.
+func evalServerInfoFunc(name string, fc *pgquery.FuncCall, tbl *tableDefinition, r row) (any, error) {
+	switch name {
+	case "version":
+		return fmt.Sprintf("PostgreSQL %s on fakegres-fdb", serverVersion), nil
+	case "current_schema":
+		return "public", nil
+	}
+
+	// Note: current_schemas(true) includes pg_catalog, which is searched before the search path
+	if len(fc.Args) != 1 {
+		return nil, fmt.Errorf("function current_schemas takes one argument")
+	}
+	implicit, err := evalExpr(fc.Args[0], tbl, r)
+	if err != nil {
+		return nil, err
+	}
+	schemas := array{elemType: "text", elems: []any{"public"}}
+	if implicit == true {
+		schemas.elems = []any{"pg_catalog", "public"}
+	}
+	return schemas, nil
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"github.com/shopspring/decimal"
+)
+
+/*
+
+The json and jsonb types and their operators.
+
+Like Postgres, a json value keeps the text it was written as, while jsonb is parsed, so
+whitespace is dropped, the last of duplicate keys wins and keys are put in order:
+
+```sql
+create table doc (id int, body jsonb);
+insert into doc values (1, '{"name": "garry", "tags": ["a", "b"], "address": {"city": "Berlin"}}');
+select body->'address'->>'city' from doc;            -- Berlin
+select body #> '{tags,0}' from doc;                  -- "a"
+select id from doc where body @> '{"name": "garry"}';
+select id from doc where body ? 'tags';
+```
+
+-> and ->> take an object key or an array index, negative indexes count from the end. #> and
+#>> take a path of keys and indexes. ->> and #>> return text, the others the type of the
+left side. @>, <@ and ? only exist for jsonb.
+
+Cells hold the text in a tagged nested tuple, jsonb in its normalized form:
+
+```
+data/table_data/doc/r/72746a7f-727f-4e0a-88f1-d983fea5c158/body: (("jsonb", "{\"name\": \"garry\", ...}"),)
+```
+
+A gin index on a jsonb column indexes the top level keys of each object in the text_index
+subspace full text search uses, with the key in place of the lexeme:
+
+```sql
+create index doc_body_keys on doc using gin (body);
+```
+
+```
+catalog/index/doc/doc_body_keys: ("body", "jsonb_ops")
+data/text_index/doc/doc_body_keys/name/72746a7f-727f-4e0a-88f1-d983fea5c158: doc
+```
+
+A select whose WHERE clause has a top level `column @> '{...}'` or `column ? 'key'` reads the
+rows holding all of the keys from the index, the WHERE clause then checks the values.
+
+*/
+
+// A json value, the text as it was written.
+type jsonText string
+
+// A jsonb value, decoded with numbers kept as json.Number so they don't lose precision.
+type jsonb struct {
+	value any
+}
+
+// The config of a textIndex over the keys of a jsonb column.
+const jsonbIndexConfig = "jsonb_ops"
+
+func isJSONType(colType string) bool {
+	return colType == "pg_catalog.json" || colType == "pg_catalog.jsonb"
+}
+
+func decodeJSON(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid input syntax for type json: %q", s)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid input syntax for type json: %q", s)
+	}
+	return v, nil
+}
+
+func parseJSON(colType string, s string) (any, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return nil, err
+	}
+	if colType == "pg_catalog.json" {
+		return jsonText(s), nil
+	}
+	return jsonb{v}, nil
+}
+
+func castJSON(v any, colType string) (string, error) {
+	var s string
+	switch value := v.(type) {
+	case jsonText:
+		s = string(value)
+	case jsonb:
+		return value.String(), nil
+	case string:
+		s = value
+	default:
+		return "", fmt.Errorf("cannot cast type %T to %s", v, strings.TrimPrefix(colType, "pg_catalog."))
+	}
+
+	parsed, err := parseJSON(colType, s)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(parsed), nil
+}
+
+func (j jsonb) String() string {
+	var b strings.Builder
+	writeJSONB(&b, j.value)
+	return b.String()
+}
+
+// Write a decoded value the way Postgres writes jsonb, with a space after colons and commas.
+func writeJSONB(b *strings.Builder, v any) {
+	switch value := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(value))
+	case json.Number:
+		b.WriteString(jsonNumberString(value))
+	case string:
+		b.WriteString(jsonQuote(value))
+	case []any:
+		b.WriteByte('[')
+		for i, e := range value {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeJSONB(b, e)
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		b.WriteByte('{')
+		for i, k := range jsonbKeys(value) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(jsonQuote(k))
+			b.WriteString(": ")
+			writeJSONB(b, value[k])
+		}
+		b.WriteByte('}')
+	}
+}
+
+// Numbers are written like numerics, so 1e2 is 100 and 1.50 keeps its scale.
+func jsonNumberString(n json.Number) string {
+	d, err := decimal.NewFromString(string(n))
+	if err != nil {
+		return string(n)
+	}
+	return numericString(d)
+}
+
+func jsonQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Note: Postgres only escapes quotes, backslashes and control characters
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// The keys of an object in the order jsonb keeps them: shorter keys first, then byte order.
+func jsonbKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func isJSON(v any) bool {
+	switch v.(type) {
+	case jsonText, jsonb:
+		return true
+	}
+	return false
+}
+
+// The element of a json or jsonb value under an object key (string) or array index (int64), nil if there is none.
+func jsonElement(doc any, step any) any {
+	switch d := doc.(type) {
+	case jsonb:
+		switch value := d.value.(type) {
+		case map[string]any:
+			if key, ok := step.(string); ok {
+				if e, ok := value[key]; ok {
+					return jsonb{e}
+				}
+			}
+		case []any:
+			if i, ok := jsonIndex(step, len(value)); ok {
+				return jsonb{value[i]}
+			}
+		}
+	case jsonText:
+		// Note: json elements are returned as they were written, so they are taken as raw text
+		var object map[string]json.RawMessage
+		if key, ok := step.(string); ok && json.Unmarshal([]byte(d), &object) == nil {
+			if e, ok := object[key]; ok {
+				return jsonText(e)
+			}
+		}
+		var array []json.RawMessage
+		if json.Unmarshal([]byte(d), &array) == nil {
+			if i, ok := jsonIndex(step, len(array)); ok {
+				return jsonText(array[i])
+			}
+		}
+	}
+	return nil
+}
+
+func jsonIndex(step any, n int) (int, bool) {
+	i, ok := step.(int64)
+	if !ok {
+		return 0, false
+	}
+	if i < 0 {
+		i += int64(n)
+	}
+	return int(i), i >= 0 && i < int64(n)
+}
+
+// The text of an element for ->> and #>>, strings without their quotes and JSON null as NULL.
+func jsonElementText(e any) any {
+	var v any
+	switch value := e.(type) {
+	case jsonb:
+		v = value.value
+	case jsonText:
+		v, _ = decodeJSON(string(value))
+	default:
+		return nil
+	}
+
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return value
+	}
+	return fmt.Sprint(e)
+}
+
+// The keys and indexes of a #> path, written as a text array like '{address,city}'.
+func parseJSONPath(v any) ([]string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("a json path must be a text array, not %T", v)
+	}
+
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("malformed array literal: %q", s)
+	}
+
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return nil, nil
+	}
+
+	var path []string
+	for _, p := range strings.Split(s, ",") {
+		path = append(path, strings.Trim(strings.TrimSpace(p), `"`))
+	}
+	return path, nil
+}
+
+// The value of a jsonb operand, string constants are read as jsonb.
+func jsonbOperand(v any) (any, error) {
+	switch value := v.(type) {
+	case jsonb:
+		return value.value, nil
+	case string:
+		return decodeJSON(value)
+	}
+	return nil, fmt.Errorf("cannot use type %T as jsonb", v)
+}
+
+func jsonOp(op string, left, right any) (any, error) {
+	switch op {
+	case "->", "->>":
+		e := jsonElement(left, right)
+		if op == "->>" {
+			return jsonElementText(e), nil
+		}
+		return e, nil
+	case "#>", "#>>":
+		path, err := parseJSONPath(right)
+		if err != nil {
+			return nil, err
+		}
+
+		e := left
+		for _, p := range path {
+			next := jsonElement(e, p)
+			if i, err := strconv.ParseInt(p, 10, 64); next == nil && err == nil {
+				next = jsonElement(e, i)
+			}
+			if e = next; e == nil {
+				return nil, nil
+			}
+		}
+		if op == "#>>" {
+			return jsonElementText(e), nil
+		}
+		return e, nil
+	}
+
+	if _, ok := left.(jsonb); !ok {
+		return nil, fmt.Errorf("operator does not exist: %T %s %T", left, op, right)
+	}
+	l, _ := jsonbOperand(left)
+
+	switch op {
+	case "@>", "<@":
+		r, err := jsonbOperand(right)
+		if err != nil {
+			return nil, err
+		}
+		if op == "<@" {
+			return jsonbContains(r, l, true), nil
+		}
+		return jsonbContains(l, r, true), nil
+	case "?":
+		key, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("operator does not exist: jsonb ? %T", right)
+		}
+		return jsonbHasKey(l, key), nil
+	}
+
+	return nil, fmt.Errorf("operator does not exist: jsonb %s %T", op, right)
+}
+
+// Whether a contains b, the @> operator. Like Postgres, an array at the top level also contains a
+// scalar that is one of its elements.
+func jsonbContains(a, b any, top bool) bool {
+	switch bv := b.(type) {
+	case map[string]any:
+		av, ok := a.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, e := range bv {
+			ae, ok := av[k]
+			if !ok || !jsonbContains(ae, e, false) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		av, ok := a.([]any)
+		if !ok {
+			return false
+		}
+		for _, e := range bv {
+			found := false
+			for _, ae := range av {
+				if jsonbContains(ae, e, false) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	if av, ok := a.([]any); ok && top {
+		for _, ae := range av {
+			if cmpJSONBValues(ae, b) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return cmpJSONBValues(a, b) == 0
+}
+
+// Whether a string is a top level key of an object, an element of an array or the string itself, the ? operator.
+func jsonbHasKey(v any, key string) bool {
+	switch value := v.(type) {
+	case map[string]any:
+		_, ok := value[key]
+		return ok
+	case []any:
+		for _, e := range value {
+			if s, ok := e.(string); ok && s == key {
+				return true
+			}
+		}
+	case string:
+		return value == key
+	}
+	return false
+}
+
+// Compare jsonb values with each other or with string constants, which are read as jsonb.
+func cmpJSONB(left, right any) (int, error) {
+	l, err := jsonbOperand(left)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+	r, err := jsonbOperand(right)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+	return cmpJSONBValues(l, r), nil
+}
+
+// The order Postgres sorts jsonb in: objects > arrays > booleans > numbers > strings > null.
+// Containers with more entries are larger, otherwise the entries are compared in order.
+func cmpJSONBValues(a, b any) int {
+	rank := func(v any) int {
+		switch v.(type) {
+		case string:
+			return 1
+		case json.Number:
+			return 2
+		case bool:
+			return 3
+		case []any:
+			return 4
+		case map[string]any:
+			return 5
+		}
+		return 0
+	}
+	if c := cmpInt(int64(rank(a)), int64(rank(b))); c != 0 {
+		return c
+	}
+
+	switch av := a.(type) {
+	case string:
+		return strings.Compare(av, b.(string))
+	case json.Number:
+		ad, _ := decimal.NewFromString(string(av))
+		bd, _ := decimal.NewFromString(string(b.(json.Number)))
+		return ad.Cmp(bd)
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case []any:
+		bv := b.([]any)
+		if c := cmpInt(int64(len(av)), int64(len(bv))); c != 0 {
+			return c
+		}
+		for i := range av {
+			if c := cmpJSONBValues(av[i], bv[i]); c != 0 {
+				return c
+			}
+		}
+	case map[string]any:
+		bv := b.(map[string]any)
+		if c := cmpInt(int64(len(av)), int64(len(bv))); c != 0 {
+			return c
+		}
+		ak, bk := jsonbKeys(av), jsonbKeys(bv)
+		for i := range ak {
+			if c := cmpInt(int64(len(ak[i])), int64(len(bk[i]))); c != 0 {
+				return c
+			}
+			if c := strings.Compare(ak[i], bk[i]); c != 0 {
+				return c
+			}
+		}
+		for _, k := range ak {
+			if c := cmpJSONBValues(av[k], bv[k]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func encodeJSON(v any) tuple.Tuple {
+	switch value := v.(type) {
+	case jsonText:
+		return tuple.Tuple{"json", string(value)}
+	case jsonb:
+		return tuple.Tuple{"jsonb", value.String()}
+	}
+	return nil
+}
+
+func decodeJSONCell(t tuple.Tuple) (any, bool) {
+	if len(t) != 2 || (t[0] != "json" && t[0] != "jsonb") {
+		return nil, false
+	}
+	s, ok := t[1].(string)
+	if !ok {
+		return nil, false
+	}
+
+	v, err := parseJSON("pg_catalog."+t[0].(string), s)
+	return v, err == nil
+}
+
+// The top level keys of a jsonb cell, which a jsonb_ops index holds.
+func jsonbIndexKeys(cell any) []string {
+	if s, ok := cell.(string); ok {
+		v, err := decodeJSON(s)
+		if err != nil {
+			return nil
+		}
+		cell = jsonb{v}
+	}
+
+	j, ok := cell.(jsonb)
+	if !ok {
+		return nil
+	}
+	object, ok := j.value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return jsonbKeys(object)
+}
+
+/*
+
+Find a top level `column @> '{...}'` or `column ? 'key'` in the WHERE clause that a jsonb_ops
+index can answer and read the rows holding all of the keys through the index. Returns false
+if there is none.
+
+*/
+
+func (pe pgEngine) jsonbIndexRows(rtr fdb.ReadTransaction, where *pgquery.Node, tbl *tableDefinition, tables []string, indexes []textIndex) ([]row, bool, error) {
+	for _, a := range topLevelPredicates(where, "@>", "?") {
+		c := a.Lexpr.GetColumnRef()
+		text, isConst := constString(a.Rexpr)
+		if c == nil || !isConst {
+			continue
+		}
+		column := c.Fields[len(c.Fields)-1].GetString_().GetStr()
+
+		var keys []string
+		if a.Name[0].GetString_().GetStr() == "?" {
+			keys = []string{text}
+		} else {
+			v, err := decodeJSON(text)
+			if err != nil {
+				return nil, false, err
+			}
+			// Note: containment of an empty object or of an array can't be answered from the keys
+			object, ok := v.(map[string]any)
+			if !ok || len(object) == 0 {
+				continue
+			}
+			keys = jsonbKeys(object)
+		}
+
+		for _, idx := range indexes {
+			if idx.Column != column || idx.Config != jsonbIndexConfig {
+				continue
+			}
+
+			var q *tsquery
+			for _, k := range keys {
+				q = tsqueryOp("&", q, &tsquery{lexeme: k})
+			}
+			ids, _ := pe.textIndexLookup(rtr, tbl.Name, idx, q)
+			return pe.readRowsById(rtr, tbl, ids, tables), true, nil
+		}
+	}
+
+	return nil, false, nil
+}
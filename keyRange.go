@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Key range pushdown.
+
+A btree index over an integer or text column keeps the value of every row, in the order of the
+tuple encoding, which for integers and for text in the "C" collation is the order of the values:
+
+```sql
+create index person_age_idx on person (age);
+```
+
+```
+catalog/index/person/person_age_idx: ("age", "btree")
+data/text_index/person/person_age_idx/14/<row id>: person
+data/text_index/person/person_age_idx/20/<row id>: person
+```
+
+Comparisons of the column with constants that the WHERE clause ANDs together at its top level
+are turned into the bounds of a key range of the index, and only the rows in the range are read,
+instead of every row of the table:
+
+```sql
+select name from person where age >= 18 and age < 65;  -- [(18,), (65,)) of person_age_idx
+select name from person where age = 20;                -- the keys of (20,)
+```
+
+The WHERE clause is still checked against the rows that are read. Text is only looked up when
+the default collation is "C", other collations don't order text like its bytes. Rows have no
+primary key and their ids aren't visible to SQL, so indexed columns are the only ones a WHERE
+clause can narrow the keys that are read with.
+
+*/
+
+const btreeIndexConfig = "btree"
+
+// Whether a btree index can be created on a column of the type.
+func btreeIndexable(colType string) bool {
+	baseType, _ := splitTypeMods(colType)
+	_, isInteger := integerTypes[baseType]
+	return isInteger || baseType == "text" || baseType == "pg_catalog.varchar"
+}
+
+// One end of a key range, the index entries of value are in the range if inclusive.
+type keyBound struct {
+	value     tuple.TupleElement
+	inclusive bool
+}
+
+/*
+
+Find top level comparisons of a btree indexed column with constants in the WHERE clause and read
+the rows in the key range they bound from the index. Returns false if there are none and the
+table has to be scanned.
+
+*/
+
+func (pe pgEngine) btreeIndexRows(rtr fdb.ReadTransaction, where *pgquery.Node, tbl *tableDefinition, tables []string, indexes []textIndex) ([]row, bool, error) {
+	for _, idx := range indexes {
+		if idx.Config != btreeIndexConfig {
+			continue
+		}
+		colType, ok := tbl.columnType(idx.Column)
+		if !ok || !btreeIndexable(colType) {
+			continue
+		}
+		baseType, _ := splitTypeMods(colType)
+		_, isInteger := integerTypes[baseType]
+		if !isInteger && defaultCollation != "C" {
+			continue
+		}
+
+		var lower, upper *keyBound
+		for _, a := range topLevelPredicates(where, "=", "<", "<=", ">", ">=") {
+			op := a.Name[0].GetString_().GetStr()
+			column, constant := a.Lexpr, a.Rexpr
+			if column.GetColumnRef() == nil {
+				// Note: 18 <= age is age >= 18
+				column, constant = constant, column
+				op = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}[op]
+			}
+			c := column.GetColumnRef()
+			if c == nil || c.Fields[len(c.Fields)-1].GetString_().GetStr() != idx.Column {
+				continue
+			}
+			value, ok := btreeKey(constant, isInteger)
+			if !ok {
+				continue
+			}
+
+			switch op {
+			case "=":
+				lower = tighterBound(lower, &keyBound{value, true}, 1)
+				upper = tighterBound(upper, &keyBound{value, true}, -1)
+			case ">", ">=":
+				lower = tighterBound(lower, &keyBound{value, op == ">="}, 1)
+			case "<", "<=":
+				upper = tighterBound(upper, &keyBound{value, op == "<="}, -1)
+			}
+		}
+		if lower == nil && upper == nil {
+			continue
+		}
+
+		dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		indexSS := dataDir.Sub("text_index").Sub(tbl.Name, idx.Name)
+
+		// Note: the entries of a value are (value, id), the range of the value ends after all of them
+		begin, end := indexSS.FDBRangeKeys()
+		if lower != nil {
+			valueBegin, valueEnd := indexSS.Sub(lower.value).FDBRangeKeys()
+			begin = valueBegin
+			if !lower.inclusive {
+				begin = valueEnd
+			}
+		}
+		if upper != nil {
+			valueBegin, valueEnd := indexSS.Sub(upper.value).FDBRangeKeys()
+			end = valueEnd
+			if !upper.inclusive {
+				end = valueBegin
+			}
+		}
+
+		ids := map[string]string{}
+		if bytes.Compare(begin.FDBKey(), end.FDBKey()) < 0 {
+			ri := rtr.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{
+				Mode: fdb.StreamingModeWantAll,
+			}).Iterator()
+			for ri.Advance() {
+				kv := ri.MustGet()
+				t, _ := indexSS.Unpack(kv.Key)
+				ids[rowIDString(t[1])] = string(kv.Value)
+			}
+		}
+		return pe.readRowsById(rtr, tbl, ids, tables), true, nil
+	}
+
+	return nil, false, nil
+}
+
+// The index key of a constant compared with the column, false if it isn't of the type of the column.
+func btreeKey(n *pgquery.Node, isInteger bool) (tuple.TupleElement, bool) {
+	c := n.GetAConst()
+	if c == nil {
+		return nil, false
+	}
+	// Note: an integer column compared with 2.5 isn't compared with an integer, it is left to the WHERE clause
+	if isInteger {
+		if i := c.Val.GetInteger(); i != nil {
+			return int64(i.Ival), true
+		}
+		// Note: integers that don't fit in 32 bits are parsed as float constants
+		if f := c.Val.GetFloat(); f != nil {
+			if i, err := strconv.ParseInt(f.Str, 10, 64); err == nil {
+				return i, true
+			}
+		}
+		return nil, false
+	}
+	if s := c.Val.GetString_(); s != nil {
+		return s.Str, true
+	}
+	return nil, false
+}
+
+// The tighter of two bounds of the same end of a range, dir is 1 for lower bounds and -1 for upper ones.
+func tighterBound(current, b *keyBound, dir int) *keyBound {
+	if current == nil {
+		return b
+	}
+	cmp := bytes.Compare(tuple.Tuple{b.value}.Pack(), tuple.Tuple{current.value}.Pack()) * dir
+	if cmp > 0 || (cmp == 0 && !b.inclusive) {
+		return b
+	}
+	return current
+}
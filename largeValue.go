@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Large values.
+
+A FoundationDB value holds at most 100,000 bytes. A cell that is larger once encoded, a long
+text, bytea or jsonb, is split into chunks of that size under the row id and the column, and
+the cell in both layouts is a marker with the number of chunks:
+
+```
+data/table_data/doc/r/72746a7f-727f-4e0a-88f1-d983fea5c158: 0x01 ... <body> ("chunked", 3)
+data/table_data/doc/c/body/72746a7f-727f-4e0a-88f1-d983fea5c158: ("chunked", 3)
+data/table_data/doc/x/72746a7f-727f-4e0a-88f1-d983fea5c158/body/0: <bytes 0 to 99999 of the cell>
+data/table_data/doc/x/72746a7f-727f-4e0a-88f1-d983fea5c158/body/1: <bytes 100000 to 199999>
+data/table_data/doc/x/72746a7f-727f-4e0a-88f1-d983fea5c158/body/2: <the rest>
+```
+
+Scans of the layouts read the marker like any other cell and read the chunks of the cell when
+they find one, so the chunks are only read for the large cells of the rows that are read.
+Cells are tuples of a single element, so a marker, which has two, is never a cell. Cells that
+fit in a value can still be chunked when the value of their row (see rowFormat.go) doesn't.
+
+*/
+
+const maxValueSize = 100000
+
+// What the markers of chunked cells start with, and no other cell does.
+var chunkedMarkerPrefix = tuple.Tuple{"chunked"}.Pack()
+
+// The cell as it is stored for a column of the table, compressed, and chunked if it is too large for a value.
+func storeCell(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target, id, column string, cell []byte) []byte {
+	// Note: cells are compressed before they are split, see compression.go
+	colType, _ := tbl.columnType(column)
+	cell = compressCell(colType, cell)
+	if len(cell) > maxValueSize {
+		return chunkCell(tr, tableDataSS, target, id, tbl.columnKey(column), cell)
+	}
+	return cell
+}
+
+// Write the stored cell in chunks, returning the marker of the chunks. column is its element in keys, see columnKey.
+func chunkCell(tr fdb.Transaction, tableDataSS subspace.Subspace, target, id string, column tuple.TupleElement, cell []byte) []byte {
+	n := 0
+	for start := 0; start < len(cell); start += maxValueSize {
+		setRowKey(tr, tableDataSS, tuple.Tuple{target, "x", rowIDElement(id), column, int64(n)}, cell[start:min(start+maxValueSize, len(cell))])
+		n++
+	}
+	return tuple.Tuple{"chunked", int64(n)}.Pack()
+}
+
+// Set the cells of a new row in the layouts of the table, the encoded cells of its columns in order.
+func setRow(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target, id string, cells [][]byte) {
+	stored := map[string][]byte{}
+	for columnIndex, cell := range cells {
+		stored[tbl.ColumnNames[columnIndex]] = storeCell(tr, tableDataSS, tbl, target, id, tbl.ColumnNames[columnIndex], cell)
+	}
+	writeRow(tr, tableDataSS, tbl, target, id, stored)
+}
+
+// Set a cell of a row that exists in the layouts of the table, rewriting the value of the row.
+func setCell(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target, id, column string, cell []byte) {
+	stored := map[string][]byte{}
+	if tbl.writesRows() {
+		stored = decodeRow(tbl, tr.Get(tableDataSS.Pack(rowKey(target, id))).MustGet())
+	}
+	// Note: the cell may not need as many chunks as it had, or none
+	clearCellChunks(tr, tableDataSS, target, id, tbl.columnKey(column))
+	stored[column] = storeCell(tr, tableDataSS, tbl, target, id, column, cell)
+	writeRow(tr, tableDataSS, tbl, target, id, stored)
+}
+
+// Clear the chunks of a cell that is about to be set again. column is its element in keys, see columnKey.
+func clearCellChunks(tr fdb.Transaction, tableDataSS subspace.Subspace, target, id string, column tuple.TupleElement) {
+	writes(tr).ClearRange(tableDataSS.Sub(target, "x", rowIDElement(id), column))
+}
+
+// Whether a stored cell is the marker of chunks.
+func chunkedMarker(value []byte) bool {
+	if len(value) == len(chunkedMarkerPrefix) || !bytes.HasPrefix(value, chunkedMarkerPrefix) {
+		return false
+	}
+	// Note: a text cell that starts with "chunked\x00" has the prefix too, but is a single element
+	t, err := tuple.Unpack(value)
+	return err == nil && len(t) == 2
+}
+
+// Decode the value of a cell read from either layout, reading its chunks if it has them. column is its element in keys, see columnKey.
+func readCell(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, target, id string, column tuple.TupleElement, value []byte) any {
+	if !chunkedMarker(value) {
+		return decodeCell(decompressCell(value))
+	}
+
+	var cell []byte
+	for _, kv := range rtr.GetRange(tableDataSS.Sub(target, "x", rowIDElement(id), column), fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).GetSliceOrPanic() {
+		cell = append(cell, kv.Value...)
+	}
+	return decodeCell(decompressCell(cell))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Table layouts.
+
+Rows are stored in the row layout, where the cells of a row are in one value (see rowFormat.go),
+and in the columnar layout, where the cells of a column are next to each other. Writing both
+doubles the writes of every INSERT, so a table can keep only one of them:
+
+```sql
+create table event (at timestamp, kind text, payload jsonb) with (layout = 'row');
+create table metric (at timestamp, name text, value float8) with (layout = 'columnar');
+```
+
+The layout is recorded in the catalog:
+
+```
+catalog/layout/event: row
+catalog/layout/metric: columnar
+```
+
+hybrid, the layout of tables created without the option, keeps both, and selects choose the
+one they read (see planLayout). Scans that go through rows, like the ones of ALTER COLUMN TYPE
+and DELETE, read the columnar layout column by column for tables without the row layout.
+Partitions have the layout of their partitioned table. fakegres_set_layout changes the layout
+of a table, see layoutChange.go.
+
+*/
+
+const (
+	layoutRow      = "row"
+	layoutColumnar = "columnar"
+	layoutHybrid   = "hybrid"
+)
+
+// Whether the table keeps the row layout.
+func (tbl tableDefinition) storesRows() bool {
+	return tbl.Layout != layoutColumnar
+}
+
+// Whether the table keeps the columnar layout.
+func (tbl tableDefinition) storesColumns() bool {
+	return tbl.Layout != layoutRow
+}
+
+// The layout in the WITH options of CREATE TABLE, empty if there is none.
+func layoutOption(options []*pgquery.Node) (string, error) {
+	layout := ""
+	for _, o := range options {
+		d := o.GetDefElem()
+		// Note: the ttl is read by ttlOption, see ttl.go
+		if d.Defname == "ttl" {
+			continue
+		}
+		if d.Defname != "layout" {
+			return "", &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("unrecognized parameter \"%s\"", d.Defname)}
+		}
+
+		// Note: an unquoted value is parsed as a type name
+		layout = d.Arg.GetString_().GetStr()
+		if names := d.Arg.GetTypeName().GetNames(); len(names) == 1 {
+			layout = names[0].GetString_().GetStr()
+		}
+		if layout != layoutRow && layout != layoutColumnar && layout != layoutHybrid {
+			return "", &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("invalid value for parameter \"layout\": \"%s\"", layout)}
+		}
+	}
+	return layout, nil
+}
+
+func (pe pgEngine) setTableLayout(tr fdb.Transaction, name string, layout string) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writes(tr).Set(catalogDir.Sub("layout").Pack(tuple.Tuple{name}), []byte(layout))
+}
+
+// Start reading the layout of the table, the returned function waits for it. Tables created before layouts are hybrid.
+func (pe pgEngine) readTableLayout(rtr fdb.ReadTransaction, name string) func() string {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := rtr.Get(catalogDir.Sub("layout").Pack(tuple.Tuple{name}))
+	return func() string {
+		if layout := f.MustGet(); layout != nil {
+			return string(layout)
+		}
+		return layoutHybrid
+	}
+}
+
+// The layout scans of whole rows read, the row layout unless the table doesn't keep it.
+func (tbl tableDefinition) rowScanLayout() string {
+	if tbl.storesRows() {
+		return layoutRow
+	}
+	return layoutColumnar
+}
+
+// The keys a scan of the rows of a target reads in the layout, its row layout or the cells of the first of the columns.
+func scanRange(tableDataSS subspace.Subspace, tbl *tableDefinition, target string, layout string, columns []string) fdb.KeyRange {
+	prefix := tableDataSS.Pack(tuple.Tuple{target, "r"})
+	if layout == layoutColumnar {
+		prefix = tableDataSS.Pack(tuple.Tuple{target, "c", tbl.columnKey(columns[0])})
+	}
+	rangeQuery, _ := fdb.PrefixRange(prefix)
+	return fdb.KeyRange{Begin: rangeQuery.Begin, End: rangeQuery.End}
+}
+
+// Read rows of a target like readRows, from the layout kr is in. Rows read from the columnar
+// layout only have the columns.
+func readTableRows(tr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target string, layout string, columns []string, kr fdb.KeyRange, limit int) (ids []string, rows []row, rowEnds []fdb.Key, complete bool) {
+	if layout == layoutRow {
+		return readRows(tr, tableDataSS, tbl, target, kr, limit)
+	}
+	return readColumnarRows(tr, tableDataSS, tbl, target, columns, kr, limit)
+}
+
+/*
+
+Read rows from the columnar layout. kr is in the cells of the first column, every row has one,
+NULL or not, so the ids of the rows come from there and the cells of the other columns are read
+in the range between the first and the last of them. limit is the number of keys read, which
+is a row per column.
+
+*/
+
+func readColumnarRows(tr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target string, columns []string, kr fdb.KeyRange, limit int) (ids []string, rows []row, rowEnds []fdb.Key, complete bool) {
+	rowLimit := max(1, limit/len(columns))
+	tombstones := newTombstoneScan(tr, tableDataSS.Sub(target))
+	byID := map[string]row{}
+	// Note: like readRows, rows with a tombstone are skipped and a read of only such rows goes on after them
+	for len(ids) == 0 {
+		kvs := tr.GetRange(kr, fdb.RangeOptions{
+			Limit: rowLimit,
+			Mode:  fdb.StreamingModeWantAll,
+		}).GetSliceOrPanic()
+		complete = len(kvs) < rowLimit
+		for _, kv := range kvs {
+			t, _ := tableDataSS.Unpack(kv.Key)
+			id := rowIDString(t[3])
+			if tombstones.deleted(id) {
+				continue
+			}
+			r := row{columns[0]: readCell(tr, tableDataSS, target, id, tbl.columnKey(columns[0]), kv.Value)}
+			ids = append(ids, id)
+			rows = append(rows, r)
+			rowEnds = append(rowEnds, keyAfter(kv.Key))
+			byID[id] = r
+		}
+		if complete {
+			break
+		}
+		kr.Begin = keyAfter(kvs[len(kvs)-1].Key)
+	}
+	if len(ids) == 0 {
+		return nil, nil, nil, true
+	}
+
+	// Note: the other columns are read at once, see columnReads.go
+	first, last := rowIDElement(ids[0]), rowIDElement(ids[len(ids)-1])
+	var ranges []fdb.KeyRange
+	var opts []fdb.RangeOptions
+	for _, column := range columns[1:] {
+		ranges = append(ranges, fdb.KeyRange{
+			Begin: tableDataSS.Pack(tuple.Tuple{target, "c", tbl.columnKey(column), first}),
+			End:   keyAfter(tableDataSS.Pack(tuple.Tuple{target, "c", tbl.columnKey(column), last})),
+		})
+		opts = append(opts, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll})
+	}
+	for i, cells := range readColumnRanges(tr, tableDataSS, tbl, target, columns[1:], ranges, opts) {
+		for _, cell := range cells {
+			if r, ok := byID[cell.id]; ok {
+				r[columns[i+1]] = cell.value
+			}
+		}
+	}
+
+	return ids, rows, rowEnds, complete
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Layout changes.
+
+The layout of a table (see layout.go) can be changed after it was created, fakegres_set_layout
+builds the layout the table doesn't keep from the one it does and drops the layout it no longer
+keeps:
+
+```sql
+select * from fakegres_set_layout('event', 'columnar');
+```
+
+```
+ relname |  layout  | built
+---------+----------+-------
+ event   | columnar |  1042
+```
+
+The layout is built in batches of their own transactions, at batch priority, and each batch
+records how far it got, next to the layout being built:
+
+```
+catalog/layout_build/event: ("columnar", <key after the last row built>)
+```
+
+While a layout is built, rows are written to it as well as to the layouts of the table, but
+selects don't read it until it is complete. INSERTs read the layout of the table in each of
+their transactions, so the rows they write while it changes aren't missed. A change that stops
+in the middle, because of an error or a restart of the server, goes on from where it got when
+it is run again. Once the layout is built, the layout of the table is set and the layout it no
+longer keeps is cleared with one range clear, in one transaction. A change to hybrid only builds
+the missing layout.
+
+The layouts of the table and its partitions are changed together. The change can't run in a
+transaction block.
+
+*/
+
+// The layout a change of the layout of a target builds, and the key its next batch begins at, nil before the first.
+type layoutBuild struct {
+	layout string
+	next   fdb.Key
+}
+
+func (pe pgEngine) layoutBuildKey(target string) fdb.Key {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return catalogDir.Sub("layout_build").Pack(tuple.Tuple{target})
+}
+
+// Start reading the layout being built for the target, the returned function waits for it. Its layout is empty if there is none.
+func (pe pgEngine) readLayoutBuild(rtr fdb.ReadTransaction, target string) func() layoutBuild {
+	f := rtr.Get(pe.layoutBuildKey(target))
+	return func() layoutBuild {
+		t, err := tuple.Unpack(f.MustGet())
+		if err != nil || len(t) != 2 {
+			return layoutBuild{}
+		}
+		b := layoutBuild{}
+		b.layout, _ = t[0].(string)
+		if next, ok := t[1].([]byte); ok {
+			b.next = next
+		}
+		return b
+	}
+}
+
+// Whether rows are written to the row layout, which the table keeps or is building.
+func (tbl tableDefinition) writesRows() bool {
+	return tbl.storesRows() || tbl.Building == layoutRow
+}
+
+// Whether rows are written to the columnar layout, which the table keeps or is building.
+func (tbl tableDefinition) writesColumns() bool {
+	return tbl.storesColumns() || tbl.Building == layoutColumnar
+}
+
+// The layout of the table and its partitions after a change, and the rows built in it.
+type layoutChange struct {
+	target string
+	layout string
+	built  int64
+}
+
+// Change the layout of the table and its partitions, building the layout it doesn't keep first.
+func (pe pgEngine) changeLayout(name string, layout string) ([]layoutChange, error) {
+	if layout != layoutRow && layout != layoutColumnar && layout != layoutHybrid {
+		return nil, &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("invalid value for parameter \"layout\": \"%s\"", layout)}
+	}
+	tbl, err := pe.getTableDefinition(name)
+	if err != nil {
+		return nil, err
+	}
+	if layout == layoutColumnar && len(tbl.ColumnNames) == 0 {
+		return nil, fmt.Errorf("a table with the columnar layout must have columns")
+	}
+	targets, err := pe.tableTargets(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Note: a change that was interrupted finishes the layout it was building, whatever the layout asked for now
+	build := tbl.Building
+	if build == "" && layout != layoutColumnar && !tbl.storesRows() {
+		build = layoutRow
+	}
+	if build == "" && layout != layoutRow && !tbl.storesColumns() {
+		build = layoutColumnar
+	}
+
+	changes := make([]layoutChange, len(targets))
+	for i, target := range targets {
+		changes[i] = layoutChange{target: target, layout: layout}
+	}
+	if build == "" && tbl.Layout == layout {
+		return changes, nil
+	}
+
+	if build != "" {
+		err := pe.changeCatalog(func(pe pgEngine) error {
+			_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+				for _, target := range targets {
+					if pe.readLayoutBuild(tr, target)().layout == "" {
+						writes(tr).Set(pe.layoutBuildKey(target), tuple.Tuple{build, nil}.Pack())
+					}
+				}
+				return nil, nil
+			})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not start building the %s layout of %s: %w", build, name, err)
+		}
+
+		for i, target := range targets {
+			if changes[i].built, err = pe.buildLayout(tbl, target, build); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+	err = pe.changeCatalog(func(pe pgEngine) error {
+		_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			for _, target := range targets {
+				pe.setTableLayout(tr, target, layout)
+				writes(tr).Clear(pe.layoutBuildKey(target))
+				switch layout {
+				case layoutRow:
+					writes(tr).ClearRange(tableDataSS.Sub(target, "c"))
+				case layoutColumnar:
+					writes(tr).ClearRange(tableDataSS.Sub(target, "r"))
+				}
+			}
+			return nil, nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not set the layout of %s: %w", name, transactionLimitError(err))
+	}
+	return changes, nil
+}
+
+/*
+
+Build the layout of a target from the other layout of its table, in batches that commit once
+they wrote maxBatchBytes. Every batch records the key the next one begins at, the batches of a
+change that was interrupted go on from there. Returns the number of rows built.
+
+*/
+
+func (pe pgEngine) buildLayout(tbl *tableDefinition, target string, build string) (int64, error) {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	source := layoutRow
+	if build == layoutRow {
+		source = layoutColumnar
+	}
+	// Note: the rows are written to the layout being built alone, the other one has them
+	buildTbl := *tbl
+	buildTbl.Layout, buildTbl.Building = build, ""
+	rangeQuery := scanRange(tableDataSS, tbl, target, source, tbl.ColumnNames)
+
+	var built int64
+	for {
+		if err := pe.checkCanceled(); err != nil {
+			return built, err
+		}
+
+		type batch struct {
+			rows     int
+			complete bool
+		}
+		done, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			b := pe.readLayoutBuild(tr, target)()
+			// Note: a change that ran meanwhile finished the layout
+			if b.layout != build {
+				return batch{complete: true}, nil
+			}
+			begin := rangeQuery.Begin
+			if b.next != nil {
+				begin = b.next
+			}
+
+			ids, rows, rowEnds, complete := readTableRows(tr, tableDataSS, tbl, target, source, tbl.ColumnNames, fdb.KeyRange{Begin: begin, End: rangeQuery.End}, scanBatchKeys)
+			next := rangeQuery.End.FDBKey()
+			n := 0
+			for i, id := range ids {
+				cells := make([][]byte, len(tbl.ColumnNames))
+				for columnIndex, column := range tbl.ColumnNames {
+					cells[columnIndex] = encodeCell(rows[i][column])
+				}
+				setRow(tr, tableDataSS, &buildTbl, target, id, cells)
+				n++
+				if i < len(ids)-1 && tr.GetApproximateSize().MustGet() > maxBatchBytes {
+					next, complete = rowEnds[i], false
+					break
+				}
+			}
+			if !complete && n == len(ids) {
+				next = rowEnds[len(rowEnds)-1]
+			}
+			writes(tr).Set(pe.layoutBuildKey(target), tuple.Tuple{build, []byte(next)}.Pack())
+			return batch{rows: n, complete: complete}, nil
+		})
+		if err != nil {
+			return built, fmt.Errorf("could not build the %s layout of %s: %w", build, target, transactionLimitError(err))
+		}
+
+		b := done.(batch)
+		built += int64(b.rows)
+		log.Printf("Built %d rows of the %s layout of %s", built, build, target)
+		if b.complete {
+			return built, nil
+		}
+	}
+}
+
+func (pgs *pgServer) handleSetLayoutStmt(ctx context.Context, stmt *pgquery.Node) (bool, error) {
+	fc := selectedFuncCall(stmt, "fakegres_set_layout")
+	if fc == nil {
+		return false, nil
+	}
+
+	if len(fc.Args) != 2 {
+		return true, fmt.Errorf("fakegres_set_layout takes a table name and a layout")
+	}
+	var args []string
+	for _, a := range fc.Args {
+		v, err := evalExpr(a, &tableDefinition{}, row{})
+		if err != nil {
+			return true, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return true, fmt.Errorf("fakegres_set_layout takes a table name and a layout")
+		}
+		args = append(args, s)
+	}
+	name, layout := args[0], args[1]
+
+	if pgs.tx != nil {
+		return true, &pgError{Code: sqlStateActiveSQLTransaction, Message: "fakegres_set_layout cannot run inside a transaction block"}
+	}
+
+	pe := pgs.engine.withTransactor(pgs.transactor()).withContext(ctx)
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		exists, err := pe.schemaExists(schema)
+		if err != nil {
+			return true, err
+		}
+		if !exists {
+			return true, &pgError{Code: sqlStateInvalidSchemaName, Message: fmt.Sprintf("schema \"%s\" does not exist", schema)}
+		}
+		pe, name = pe.withSchema(schema), table
+	}
+
+	changes, err := pe.withPriority(priorityBatch).changeLayout(name, layout)
+	if err != nil {
+		return true, err
+	}
+	pgs.freshRead = true
+
+	res := &pgResult{
+		fieldNames: []string{"relname", "layout", "built"},
+		fieldTypes: []string{"text", "text", "pg_catalog.int8"},
+	}
+	for _, c := range changes {
+		res.rows = append(res.rows, []any{c.target, c.layout, c.built})
+	}
+	return true, pgs.writePgResult(res, "SELECT")
+}
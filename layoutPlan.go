@@ -0,0 +1,110 @@
+package main
+
+import (
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Layout planning.
+
+A hybrid table keeps both layouts (see layout.go), and every select of it chooses the one that
+reads less for it:
+
+```sql
+-- A point lookup of a whole row: the row layout has its cells in one value
+select * from person where email = 'garry@example.com';
+-- A few columns of every row: the columnar layout only has to read those columns
+select age from person where age > 18;
+```
+
+Selects that name every column with `*`, that use more than half of the columns of the table,
+or that look rows up by an equality of a column and a constant read the row layout. Selects of
+fewer columns read the columnar layout. Tables that keep one layout are read from it.
+
+*/
+
+func planLayout(stmt *pgquery.SelectStmt, tbl *tableDefinition) string {
+	if !tbl.storesColumns() {
+		return layoutRow
+	}
+	if !tbl.storesRows() {
+		return layoutColumnar
+	}
+
+	columns, all := selectColumns(stmt, tbl)
+	if all || 2*len(columns) > len(tbl.ColumnNames) {
+		return layoutRow
+	}
+
+	for _, a := range topLevelPredicates(stmt.WhereClause, "=") {
+		_, lconst := constString(a.Lexpr)
+		_, rconst := constString(a.Rexpr)
+		if (a.Lexpr.GetColumnRef() != nil && rconst) || (a.Rexpr.GetColumnRef() != nil && lconst) {
+			return layoutRow
+		}
+	}
+	return layoutColumnar
+}
+
+/*
+
+The columns a columnar scan for the select reads. Cells of a column are next to each other in
+the columnar layout, so every column is a range read of its own, and the columns the select
+doesn't use, in its targets, WHERE or ORDER BY, aren't read at all:
+
+```sql
+select name from person where age > 18;
+```
+
+```
+data/table_data/person/c/age/...: read
+data/table_data/person/c/email/...: not read
+data/table_data/person/c/name/...: read
+```
+
+A select that uses no column, like `select 1 from person`, still reads the first column of the
+table, every row has a cell there.
+
+*/
+
+func projectedColumns(stmt *pgquery.SelectStmt, tbl *tableDefinition) []string {
+	columns, all := selectColumns(stmt, tbl)
+	if all {
+		return tbl.ColumnNames
+	}
+	if len(columns) == 0 && len(tbl.ColumnNames) > 0 {
+		return tbl.ColumnNames[:1]
+	}
+	return columns
+}
+
+// The columns of the table the select uses anywhere in it, and whether it uses all of them with a *.
+func selectColumns(stmt *pgquery.SelectStmt, tbl *tableDefinition) ([]string, bool) {
+	var columns []string
+	seen := map[string]bool{}
+	all := false
+	walkNodes(stmt.ProtoReflect(), func(n *pgquery.Node) {
+		ref := n.GetColumnRef()
+		if ref == nil {
+			return
+		}
+		last := ref.Fields[len(ref.Fields)-1]
+		if last.GetAStar() != nil {
+			all = true
+			return
+		}
+		// Note: a composite field like (home).city is a column ref of the column
+		name := last.GetString_().GetStr()
+		if len(ref.Fields) > 1 {
+			if _, ok := tbl.columnType(name); !ok {
+				name = ref.Fields[0].GetString_().GetStr()
+			}
+		}
+		if _, ok := tbl.columnType(name); ok && !seen[name] {
+			seen[name] = true
+			columns = append(columns, name)
+		}
+	})
+	return columns, all
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+LIMIT and OFFSET.
+
+```sql
+select name from person limit 10 offset 20;
+```
+
+The rows of the result are counted as they are added, whatever number of scans add them, so a
+select that is read in batches (see streamingSelect.go) or fetched by a cursor skips the rows
+of the OFFSET and stops at the LIMIT across all of them.
+
+Scans stop reading once the select has its rows, when nothing but the scan decides which rows
+those are: without ORDER BY, which needs every row, and without unnest, which makes any number
+of rows of one. Rows that don't match the WHERE clause don't count. The range reads of such a
+select use StreamingModeIterator, which fetches small batches first, and without a WHERE clause
+they read at most the keys of the rows they need:
+
+```
+select * from person limit 10  ->  GetRange(data/table_data/person/r, Limit: 10)
+```
+
+Scans in batches (see scanBatchKeys) read smaller batches the same way, for the rows a select
+still needs and for the rows a FETCH or an Execute with a maximum number of rows asks for, see
+fetchPortal.
+
+*/
+
+// Take the LIMIT and OFFSET of the select, which are constants or bound parameters.
+func (results *pgResult) setLimit(stmt *pgquery.SelectStmt) error {
+	if stmt.LimitCount != nil {
+		v, err := evalExpr(stmt.LimitCount, &tableDefinition{}, row{})
+		if err != nil {
+			return err
+		}
+		// Note: LIMIT ALL and LIMIT NULL don't limit
+		if v != nil {
+			n, ok := v.(int64)
+			if !ok {
+				return fmt.Errorf("argument of LIMIT must be type bigint, not type %s", sqlTypeName(valueType(v)))
+			}
+			if n < 0 {
+				return &pgError{Code: sqlStateInvalidRowCountInLimitClause, Message: "LIMIT must not be negative"}
+			}
+			results.limit, results.limited = n, true
+		}
+	}
+
+	if stmt.LimitOffset != nil {
+		v, err := evalExpr(stmt.LimitOffset, &tableDefinition{}, row{})
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			n, ok := v.(int64)
+			if !ok {
+				return fmt.Errorf("argument of OFFSET must be type bigint, not type %s", sqlTypeName(valueType(v)))
+			}
+			if n < 0 {
+				return &pgError{Code: sqlStateInvalidRowCountInResultOffsetClause, Message: "OFFSET must not be negative"}
+			}
+			results.offset = n
+		}
+	}
+	return nil
+}
+
+// Whether the result has every row the LIMIT lets it have.
+func (results *pgResult) limitReached() bool {
+	return results.limited && results.produced >= results.offset+results.limit
+}
+
+// Add a row of the select to the result, unless the OFFSET skips it or it is past the LIMIT.
+func (results *pgResult) addLimited(targetRow []any) {
+	results.produced++
+	if results.produced <= results.offset || (results.limited && results.produced > results.offset+results.limit) {
+		return
+	}
+	results.rows = append(results.rows, targetRow)
+}
+
+/*
+
+The number of rows matching the WHERE clause a scan for the select needs, false if it has to
+read every row.
+
+*/
+
+func (results *pgResult) scanLimit(stmt *pgquery.SelectStmt) (int64, bool) {
+	if !results.limited || len(stmt.SortClause) > 0 {
+		return 0, false
+	}
+	for _, expr := range results.fieldExprs {
+		if fc := expr.GetFuncCall(); fc != nil && funcName(fc) == "unnest" {
+			return 0, false
+		}
+	}
+	return results.offset + results.limit - results.produced, true
+}
+
+// Scans that need more rows than this read every key, the keys of the rows wouldn't fit a range limit.
+const maxPushdownRows = 1 << 20
+
+// The options of a range read of a scan that needs need rows of keysPerRow keys each.
+func scanOptions(stmt *pgquery.SelectStmt, need int64, limited bool, keysPerRow int) fdb.RangeOptions {
+	if !limited {
+		return fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}
+	}
+	opts := fdb.RangeOptions{Mode: fdb.StreamingModeIterator}
+	// Note: a columnar row may lack the cells of columns added after it, so it has at most keysPerRow keys
+	if stmt.WhereClause == nil && need < maxPushdownRows {
+		opts.Limit = int(need) * max(1, keysPerRow)
+	}
+	return opts
+}
+
+// The number of keys of a batch of a scan that needs need rows, of keysPerRow keys each.
+func batchKeys(stmt *pgquery.SelectStmt, need int64, limited bool, keysPerRow int) int {
+	if !limited || stmt.WhereClause != nil {
+		return scanBatchKeys
+	}
+	return int(min(int64(scanBatchKeys), need*int64(max(1, keysPerRow))))
+}
+
+// Count the rows of a scan that match the WHERE clause, returning whether the scan has the rows it needs.
+type matchCounter struct {
+	stmt    *pgquery.SelectStmt
+	tbl     *tableDefinition
+	need    int64
+	matched int64
+}
+
+func (mc *matchCounter) add(r row) bool {
+	// Note: rows whose WHERE clause fails are counted, the error is raised when the result is made
+	if ok, err := evalWhere(mc.stmt.WhereClause, mc.tbl, r); ok || err != nil {
+		mc.matched++
+	}
+	return mc.done()
+}
+
+func (mc *matchCounter) done() bool {
+	return mc.matched >= mc.need
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Format versions and migrations.
+
+Every database records the version of the format its catalog and data are kept in, next to
+its other directories:
+
+```
+format/version: (2,)
+database/shop/format/version: (2,)
+```
+
+A change to how the catalog or the data is kept comes with a migration to its version, which
+upgrades the keys of a database kept in the versions before. When the server starts, before it
+accepts connections, it runs the migrations every database is missing in order, and records
+the version of every migration that completed:
+
+```
+migrating database shop from format version 0 to 1: key the cells of tables by column ids
+```
+
+A database without a version was kept before there were versions, version 0, and runs every
+migration, which does nothing for an empty one. CREATE DATABASE records the current version.
+A server refuses to start on a database in a version newer than it knows.
+
+Migrations work in batches of their own transactions, like DELETE, and must be able to run
+again from the start when a server stopped in the middle of one. Other servers on the same
+keyspace should be stopped while a newer one migrates it.
+
+*/
+
+type formatMigration struct {
+	version     int64
+	description string
+	// Upgrade the database of the engine from the version before
+	migrate func(pe pgEngine) error
+}
+
+var formatMigrations = []formatMigration{
+	{version: 1, description: "key the cells of tables by column ids", migrate: pgEngine.migrateColumnIDs},
+	{version: 2, description: "keep the cells of a row in one value", migrate: pgEngine.migrateRowValues},
+}
+
+// The version of the format the server keeps databases in, the version of its last migration.
+var formatVersion = formatMigrations[len(formatMigrations)-1].version
+
+// The keys a migration rewrites in a transaction at most.
+const migrationBatchKeys = 2000
+
+func (pe pgEngine) formatVersionKey() fdb.Key {
+	formatDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("format"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return formatDir.Pack(tuple.Tuple{"version"})
+}
+
+// The format version of the database of the engine, 0 if it has none.
+func (pe pgEngine) readFormatVersion() (int64, error) {
+	key := pe.formatVersionKey()
+	version, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		value := rtr.Get(key).MustGet()
+		if value == nil {
+			return int64(0), nil
+		}
+		t, err := tuple.Unpack(value)
+		if err != nil || len(t) != 1 {
+			return nil, fmt.Errorf("invalid format version %q", value)
+		}
+		version, ok := t[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("invalid format version %q", value)
+		}
+		return version, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not read format version: %w", err)
+	}
+	return version.(int64), nil
+}
+
+func (pe pgEngine) setFormatVersion(version int64) error {
+	key := pe.formatVersionKey()
+	_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		writes(tr).Set(key, tuple.Tuple{version}.Pack())
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not set format version: %w", err)
+	}
+	return nil
+}
+
+// Run the migrations the database of the engine is missing.
+func (pe pgEngine) migrateFormat() error {
+	version, err := pe.readFormatVersion()
+	if err != nil {
+		return err
+	}
+	if version > formatVersion {
+		return fmt.Errorf("database \"%s\" is in format version %d, this server knows format versions up to %d", pe.database, version, formatVersion)
+	}
+
+	for _, m := range formatMigrations {
+		if m.version <= version {
+			continue
+		}
+		log.Printf("migrating database %s from format version %d to %d: %s", pe.database, version, m.version, m.description)
+		if err := m.migrate(pe); err != nil {
+			return fmt.Errorf("could not migrate database \"%s\" to format version %d: %w", pe.database, m.version, err)
+		}
+		if err := pe.setFormatVersion(m.version); err != nil {
+			return err
+		}
+		version = m.version
+	}
+	return nil
+}
+
+// Run the migrations every database of the keyspace is missing.
+func migrateDatabases(db fdb.Database, cfg config) error {
+	engines, err := databaseEngines(db, cfg)
+	if err != nil {
+		return err
+	}
+	for _, pe := range engines {
+		if err := pe.migrateFormat(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The engines of every database of the keyspace, in their public schemas.
+func databaseEngines(db fdb.Database, cfg config) ([]pgEngine, error) {
+	names := []string{defaultDatabase}
+	if cfg.tenants {
+		tenants, err := db.ListTenants()
+		if err != nil {
+			return nil, fmt.Errorf("could not list databases: %w", err)
+		}
+		for _, t := range tenants {
+			if name, ok := strings.CutPrefix(string(t), string(tenantName(""))); ok && name != defaultDatabase {
+				names = append(names, name)
+			}
+		}
+	} else {
+		exists, err := directory.Exists(db, []string{"database"})
+		if err != nil {
+			return nil, fmt.Errorf("could not list databases: %w", err)
+		}
+		if exists {
+			databases, err := directory.List(db, []string{"database"})
+			if err != nil {
+				return nil, fmt.Errorf("could not list databases: %w", err)
+			}
+			names = append(names, databases...)
+		}
+	}
+
+	var engines []pgEngine
+	for _, name := range names {
+		pe, err := databaseEngine(db, cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		engines = append(engines, pe)
+	}
+	return engines, nil
+}
+
+// The engine of a database of the keyspace, in its public schema.
+func databaseEngine(db fdb.Database, cfg config, name string) (pgEngine, error) {
+	ks, err := openKeyspace(db, cfg, name)
+	if err != nil {
+		return pgEngine{}, err
+	}
+	pe := newPgEngine(ks, name)
+	if cfg.tenants {
+		pe.tenants = &db
+	}
+	return pe, nil
+}
+
+// The schemas of the database of the engine, public first.
+func (pe pgEngine) schemaNames() ([]string, error) {
+	path := append(pe.databasePath(), "schema")
+	exists, err := directory.Exists(pe.db, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not list schemas: %w", err)
+	}
+	if !exists {
+		return []string{defaultSchema}, nil
+	}
+	schemas, err := directory.List(pe.db, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not list schemas: %w", err)
+	}
+	return append([]string{defaultSchema}, schemas...), nil
+}
+
+/*
+
+Format version 1, column ids.
+
+Tables created before columns had ids (see columnId.go) get them in the order of the names of
+their columns, which are the same for a partitioned table and its partitions. The cells of the
+table and its partitions are keyed by the ids first, then the ids are recorded in the catalog,
+so a migration that stops in between finds the table without ids again and carries on.
+
+*/
+
+func (pe pgEngine) migrateColumnIDs() error {
+	schemas, err := pe.schemaNames()
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		spe := pe.withSchema(schema)
+		names, err := spe.getTableNames()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tbl, err := spe.getTableDefinition(name)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(tbl.ColumnIDs, 0) {
+				continue
+			}
+			if err := spe.internColumns(tbl); err != nil {
+				return fmt.Errorf("could not migrate table %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Key the cells of the table and its partitions by the ids of their columns and record them.
+func (pe pgEngine) internColumns(tbl *tableDefinition) error {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	keyed := *tbl
+	keyed.ColumnIDs = newColumnIDs(tbl.ColumnNames)
+	targets, err := pe.tableTargets(tbl.Name)
+	if err != nil {
+		return err
+	}
+
+	// Note: the column is the second element of the keys of the row layout and of chunks, and the first of the columnar layout
+	for _, target := range targets {
+		for _, l := range []struct {
+			layout string
+			at     int
+		}{{"r", 1}, {"x", 1}, {"c", 0}} {
+			if err := pe.internCellKeys(tableDataSS.Sub(target, l.layout), &keyed, l.at); err != nil {
+				return err
+			}
+		}
+	}
+
+	return pe.changeCatalog(func(pe pgEngine) error {
+		_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			for _, target := range targets {
+				keyed.Name = target
+				pe.setColumnIDs(tr, &keyed)
+			}
+			return nil, nil
+		})
+		return err
+	})
+}
+
+// Rewrite the keys of cells in ss that have the name of their column at the element at, with its id.
+func (pe pgEngine) internCellKeys(ss subspace.Subspace, tbl *tableDefinition, at int) error {
+	begin, end := ss.FDBRangeKeys()
+	for {
+		// Note: ids sort after names, keys already rewritten are read again further on and skipped
+		next, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			kvs := tr.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{
+				Limit: migrationBatchKeys,
+				Mode:  fdb.StreamingModeWantAll,
+			}).GetSliceOrPanic()
+			for _, kv := range kvs {
+				t, err := ss.Unpack(kv.Key)
+				if err != nil {
+					return nil, err
+				}
+				name, ok := t[at].(string)
+				if !ok {
+					continue
+				}
+				// Note: cells of columns the table doesn't have anymore keep their names
+				key := tbl.columnKey(name)
+				if _, ok := key.(int64); !ok {
+					continue
+				}
+				t[at] = key
+				writes(tr).Set(ss.Pack(t), kv.Value)
+				writes(tr).Clear(kv.Key)
+			}
+			if len(kvs) < migrationBatchKeys {
+				return nil, nil
+			}
+			return keyAfter(kvs[len(kvs)-1].Key), nil
+		})
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+		begin = next.(fdb.Key)
+	}
+}
+
+/*
+
+Format version 2, row values.
+
+The cells of a row in the row layout had a key each, they are packed into the value of the row
+(see rowFormat.go). A batch packs the rows whose cells it read completely and clears their
+cells, a row cut off by the end of the batch is packed by the next one. Packed rows have no
+column in their key and are skipped, so a migration that stops in between carries on from the
+rows that still have cells.
+
+*/
+
+func (pe pgEngine) migrateRowValues() error {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	schemas, err := pe.schemaNames()
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		spe := pe.withSchema(schema)
+		names, err := spe.getTableNames()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tbl, err := spe.getTableDefinition(name)
+			if err != nil {
+				return err
+			}
+			if !tbl.storesRows() {
+				continue
+			}
+			targets, err := spe.tableTargets(name)
+			if err != nil {
+				return err
+			}
+			for _, target := range targets {
+				if err := spe.packRows(tableDataSS, tbl, target); err != nil {
+					return fmt.Errorf("could not migrate table %s: %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Pack the cells of the rows of a target of tableDataSS that have a key each into the values of the rows.
+func (pe pgEngine) packRows(tableDataSS subspace.Subspace, tbl *tableDefinition, target string) error {
+	rowSS := tableDataSS.Sub(target, "r")
+	begin, end := rowSS.FDBRangeKeys()
+	for {
+		next, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			kvs := tr.GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{
+				Limit: migrationBatchKeys,
+				Mode:  fdb.StreamingModeWantAll,
+			}).GetSliceOrPanic()
+			complete := len(kvs) < migrationBatchKeys
+
+			var ids []string
+			cells := map[string]map[string][]byte{}
+			for _, kv := range kvs {
+				t, err := rowSS.Unpack(kv.Key)
+				if err != nil {
+					return nil, err
+				}
+				if len(t) != 2 {
+					continue
+				}
+				id := rowIDString(t[0])
+				if len(ids) == 0 || ids[len(ids)-1] != id {
+					ids = append(ids, id)
+					cells[id] = map[string][]byte{}
+				}
+				// Note: cells of columns the table doesn't have anymore are dropped
+				if name := tbl.columnOfKey(t[1]); name != "" {
+					cells[id][name] = kv.Value
+				}
+			}
+			// Note: a row whose cells the batch ends in may go on, the next batch starts at it. A row has
+			// at most 1600 cells, fewer than a batch has keys
+			var next fdb.Key
+			if !complete {
+				next = keyAfter(kvs[len(kvs)-1].Key)
+				if t, _ := rowSS.Unpack(kvs[len(kvs)-1].Key); len(t) == 2 {
+					next = rowSS.Pack(tuple.Tuple{rowIDElement(ids[len(ids)-1])})
+					ids = ids[:len(ids)-1]
+				}
+			}
+
+			for _, id := range ids {
+				writes(tr).ClearRange(rowSS.Sub(rowIDElement(id)))
+				writeRow(tr, tableDataSS, tbl, target, id, cells[id])
+			}
+			if complete {
+				return nil, nil
+			}
+			return next, nil
+		})
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+		begin = next.(fdb.Key)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+The network address types. An inet is a host address with an optional netmask length, a cidr
+is a network, whose bits to the right of the netmask must be zero:
+
+```sql
+create table visit (ip inet, path text);
+insert into visit values ('10.1.2.3', '/'), ('10.1.2.3/16', '/about'), ('2001:db8::1', '/');
+select path from visit where ip << '10.0.0.0/8' order by ip;
+```
+
+Cells hold the family, the network part of the address, the netmask length and the whole
+address, so the tuple encoding of cells sorts like Postgres orders addresses: IPv4 before
+IPv6, then by network, by netmask length and by host:
+
+```
+data/table_data/visit/r/72746a7f-727f-4e0a-88f1-d983fea5c158/ip: (("inet", 4, b"\x0a\x01\x02\x03", 32, b"\x0a\x01\x02\x03"),)
+data/table_data/visit/r/34e7ff77-1bed-4ebd-be56-4b966e67c595/ip: (("inet", 4, b"\x0a\x01\x00\x00", 16, b"\x0a\x01\x02\x03"),)
+```
+
+Containment is tested with `<<` (is contained by), `<<=` (is contained by or equals), `>>`
+(contains), `>>=` (contains or equals) and `&&` (contains or is contained by).
+
+*/
+
+type inet struct {
+	prefix netip.Prefix
+	cidr   bool
+}
+
+func (n inet) String() string {
+	if !n.cidr && n.prefix.Bits() == n.prefix.Addr().BitLen() {
+		return n.prefix.Addr().String()
+	}
+	return n.prefix.String()
+}
+
+func isNetworkType(colType string) bool {
+	base, _ := splitTypeMods(colType)
+	return base == "pg_catalog.inet" || base == "pg_catalog.cidr"
+}
+
+func isNetwork(v any) bool {
+	_, ok := v.(inet)
+	return ok
+}
+
+// Parse an address, with the netmask length when it has one. Addresses without one are a single host.
+func parseInet(colType, s string) (inet, error) {
+	base, _ := splitTypeMods(colType)
+	typeName := strings.TrimPrefix(base, "pg_catalog.")
+	invalid := &pgError{
+		Code:    sqlStateInvalidTextRepresentation,
+		Message: fmt.Sprintf("invalid input syntax for type %s: %q", typeName, s),
+	}
+
+	value := strings.TrimSpace(s)
+	var prefix netip.Prefix
+	if strings.Contains(value, "/") {
+		p, err := netip.ParsePrefix(value)
+		if err != nil {
+			return inet{}, invalid
+		}
+		prefix = p
+	} else {
+		addr, err := netip.ParseAddr(value)
+		if err != nil || addr.Zone() != "" {
+			return inet{}, invalid
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	n := inet{prefix: prefix, cidr: base == "pg_catalog.cidr"}
+	if n.cidr && prefix.Masked() != prefix {
+		return inet{}, &pgError{
+			Code:    sqlStateInvalidTextRepresentation,
+			Message: fmt.Sprintf("invalid cidr value: %q", s),
+		}
+	}
+	return n, nil
+}
+
+// The text of an address for the type. A cast of an inet to cidr drops the bits right of the netmask.
+func castNetwork(v any, colType string) (string, error) {
+	base, _ := splitTypeMods(colType)
+	switch value := v.(type) {
+	case inet:
+		value.cidr = base == "pg_catalog.cidr"
+		if value.cidr {
+			value.prefix = value.prefix.Masked()
+		}
+		return value.String(), nil
+	case string:
+		n, err := parseInet(colType, value)
+		if err != nil {
+			return "", err
+		}
+		return n.String(), nil
+	}
+	return "", fmt.Errorf("cannot cast type %T to %s", v, strings.TrimPrefix(base, "pg_catalog."))
+}
+
+// The address of an operand. Strings are read as the type of the other side.
+func networkOperands(left, right any) (inet, inet, error) {
+	colType := "pg_catalog.inet"
+	for _, v := range []any{left, right} {
+		if n, ok := v.(inet); ok && n.cidr {
+			colType = "pg_catalog.cidr"
+		}
+	}
+
+	operand := func(v any) (inet, error) {
+		switch value := v.(type) {
+		case inet:
+			return value, nil
+		case string:
+			return parseInet(colType, value)
+		}
+		return inet{}, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return inet{}, inet{}, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return inet{}, inet{}, err
+	}
+	return l, r, nil
+}
+
+// Compare addresses like Postgres, by family, network, netmask length and then the whole address.
+func cmpNetwork(left, right any) (int, error) {
+	l, r, err := networkOperands(left, right)
+	if err != nil {
+		return 0, err
+	}
+
+	if c := cmpInt(int64(l.prefix.Addr().BitLen()), int64(r.prefix.Addr().BitLen())); c != 0 {
+		return c, nil
+	}
+	if c := l.prefix.Masked().Addr().Compare(r.prefix.Masked().Addr()); c != 0 {
+		return c, nil
+	}
+	if c := cmpInt(int64(l.prefix.Bits()), int64(r.prefix.Bits())); c != 0 {
+		return c, nil
+	}
+	return l.prefix.Addr().Compare(r.prefix.Addr()), nil
+}
+
+// Whether the network of outer contains the address of inner.
+func networkContains(outer, inner inet) bool {
+	return outer.prefix.Addr().BitLen() == inner.prefix.Addr().BitLen() &&
+		outer.prefix.Masked().Contains(inner.prefix.Addr())
+}
+
+func networkOp(op string, left, right any) (any, error) {
+	if !isNetwork(left) && !isNetwork(right) {
+		return nil, fmt.Errorf("operator does not exist: %s %s %s", sqlTypeName(valueType(left)), op, sqlTypeName(valueType(right)))
+	}
+
+	l, r, err := networkOperands(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "<<":
+		return l.prefix.Bits() > r.prefix.Bits() && networkContains(r, l), nil
+	case "<<=":
+		return l.prefix.Bits() >= r.prefix.Bits() && networkContains(r, l), nil
+	case ">>":
+		return r.prefix.Bits() > l.prefix.Bits() && networkContains(l, r), nil
+	case ">>=":
+		return r.prefix.Bits() >= l.prefix.Bits() && networkContains(l, r), nil
+	case "&&":
+		return networkContains(l, r) || networkContains(r, l), nil
+	}
+	return nil, fmt.Errorf("unknown operator: %s", op)
+}
+
+func evalNetworkFunc(name string, fc *pgquery.FuncCall, tbl *tableDefinition, r row) (any, error) {
+	if len(fc.Args) != 1 {
+		return nil, fmt.Errorf("function %s takes one argument", name)
+	}
+	v, err := evalExpr(fc.Args[0], tbl, r)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	if s, ok := v.(string); ok {
+		if v, err = parseInet("pg_catalog.inet", s); err != nil {
+			return nil, err
+		}
+	}
+	n, ok := v.(inet)
+	if !ok {
+		return nil, fmt.Errorf("function %s(%s) does not exist", name, sqlTypeName(valueType(v)))
+	}
+
+	switch name {
+	case "host":
+		return n.prefix.Addr().String(), nil
+	case "masklen":
+		return int64(n.prefix.Bits()), nil
+	case "family":
+		if n.prefix.Addr().Is4() {
+			return int64(4), nil
+		}
+		return int64(6), nil
+	}
+	// Note: network is the cidr of the address
+	return inet{prefix: n.prefix.Masked(), cidr: true}, nil
+}
+
+func encodeNetwork(n inet) tuple.Tuple {
+	tag := "inet"
+	if n.cidr {
+		tag = "cidr"
+	}
+	family := int64(4)
+	if !n.prefix.Addr().Is4() {
+		family = 6
+	}
+	return tuple.Tuple{tag, family, n.prefix.Masked().Addr().AsSlice(), int64(n.prefix.Bits()), n.prefix.Addr().AsSlice()}
+}
+
+func decodeNetwork(t tuple.Tuple) (inet, bool) {
+	if len(t) != 5 || (t[0] != "inet" && t[0] != "cidr") {
+		return inet{}, false
+	}
+	b, ok := t[4].([]byte)
+	bits, bitsOk := t[3].(int64)
+	if !ok || !bitsOk {
+		return inet{}, false
+	}
+	addr, ok := netip.AddrFromSlice(b)
+	if !ok {
+		return inet{}, false
+	}
+	return inet{prefix: netip.PrefixFrom(addr, int(bits)), cidr: t[0] == "cidr"}, true
+}
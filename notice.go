@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+Notices, conditions that don't fail the statement but that the client should know about.
+They are sent as a NoticeResponse while the statement runs, before its CommandComplete:
+
+```sql
+drop table if exists person;
+NOTICE:  table "person" does not exist, skipping
+begin;
+begin;
+WARNING:  there is already a transaction in progress
+```
+
+The engine raises notices through the notice function the server gives it. Engines without
+one, like those running prepared transactions, only log them.
+
+*/
+
+type notice struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+const (
+	sqlStateSuccessfulCompletion = "00000"
+	sqlStateWarning              = "01000"
+	noticeSeverity               = "NOTICE"
+	warningSeverity              = "WARNING"
+)
+
+func (pe pgEngine) withNotices(fn func(notice)) pgEngine {
+	pe.notice = fn
+	return pe
+}
+
+func (pe pgEngine) raiseNotice(code string, format string, args ...any) {
+	n := notice{Severity: noticeSeverity, Code: code, Message: fmt.Sprintf(format, args...)}
+	if pe.notice == nil {
+		log.Printf("%s: %s", n.Severity, n.Message)
+		return
+	}
+	pe.notice(n)
+}
+
+func (pgs *pgServer) sendNotice(n notice) {
+	buf := (&pgproto3.NoticeResponse{
+		Severity: n.Severity,
+		Code:     n.Code,
+		Message:  pgs.clientEncoding().encodeMessage(n.Message),
+	}).Encode(nil)
+	if err := pgs.write(buf); err != nil {
+		log.Printf("failed to write notice: %s", err)
+	}
+}
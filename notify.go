@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/jackc/pgproto3/v2"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+LISTEN / NOTIFY.
+
+NOTIFY appends the notification to the channel, keyed by a versionstamp so notifications are
+ordered by commit, and bumps a per channel counter:
+
+```
+notify/message/jobs/<versionstamp>: (<sender pid>, "payload")
+notify/head/jobs: <counter>
+```
+
+Every channel a connection listens on has a goroutine that watches the counter. When it
+changes, the goroutine reads the notifications after the last one it delivered and sends
+them to the client as NotificationResponse messages.
+
+NOTIFY inside a transaction block is written by the block's transaction, so listeners only
+see it once the block commits. Notifications are kept for a minute, which is plenty for
+listeners to catch up, and older ones are cleared by the next NOTIFY on the channel.
+
+*/
+
+// How long notifications are kept, FoundationDB commit versions advance by about a million a second.
+const notificationRetention = time.Minute
+
+type listener struct {
+	channel string
+	stop    chan struct{}
+}
+
+func (pe pgEngine) notify(pid uint32, channel string, payload string) error {
+	notifyDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("notify"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	messageSS := notifyDir.Sub("message")
+	headSS := notifyDir.Sub("head")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		key, err := messageSS.PackWithVersionstamp(tuple.Tuple{channel, tuple.IncompleteVersionstamp(0)})
+		if err != nil {
+			return nil, err
+		}
+		writes(tr).SetVersionstampedKey(key, tuple.Tuple{int64(pid), payload}.Pack())
+
+		one := make([]byte, 8)
+		binary.LittleEndian.PutUint64(one, 1)
+		writes(tr).Add(headSS.Pack(tuple.Tuple{channel}), one)
+
+		// Note: clear notifications older than the retention, using the read version as the clock
+		readVersion := tr.GetReadVersion().MustGet()
+		cutoff := readVersion - int64(notificationRetention/time.Microsecond)
+		if cutoff > 0 {
+			var tv [10]byte
+			binary.BigEndian.PutUint64(tv[:8], uint64(cutoff))
+			channelRange := messageSS.Sub(channel)
+			begin, _ := channelRange.FDBRangeKeys()
+			writes(tr).ClearRange(fdb.KeyRange{Begin: begin, End: channelRange.Pack(tuple.Tuple{tuple.Versionstamp{TransactionVersion: tv}})})
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not notify: %w", err)
+	}
+
+	return nil
+}
+
+// The key of the last notification on the channel, listening starts after it.
+func (pe pgEngine) lastNotification(channel string) (fdb.Key, error) {
+	notifyDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("notify"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	channelSS := notifyDir.Sub("message").Sub(channel)
+
+	last, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		kvs := rtr.GetRange(channelSS, fdb.RangeOptions{Limit: 1, Reverse: true}).GetSliceOrPanic()
+		if len(kvs) == 0 {
+			begin, _ := channelSS.FDBRangeKeys()
+			return begin.FDBKey(), nil
+		}
+		return kvs[0].Key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not listen: %w", err)
+	}
+
+	return last.(fdb.Key), nil
+}
+
+/*
+
+Wait for notifications after the key after and return them along with the key of the last
+one. Blocks on a watch of the channel counter until there is at least one, or stop is closed.
+
+*/
+
+func (pe pgEngine) waitNotifications(channel string, after fdb.Key, stop chan struct{}) ([]*pgproto3.NotificationResponse, fdb.Key, error) {
+	notifyDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("notify"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	messageSS := notifyDir.Sub("message")
+	channelSS := messageSS.Sub(channel)
+	headKey := notifyDir.Sub("head").Pack(tuple.Tuple{channel})
+
+	for {
+		var watch fdb.FutureNil
+		var notifications []*pgproto3.NotificationResponse
+		last := after
+		_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			notifications = nil
+			last = after
+
+			_, end := channelSS.FDBRangeKeys()
+			ri := tr.GetRange(fdb.KeyRange{Begin: keyAfter(after), End: end}, fdb.RangeOptions{
+				Mode: fdb.StreamingModeWantAll,
+			}).Iterator()
+			for ri.Advance() {
+				kv := ri.MustGet()
+				v, err := tuple.Unpack(kv.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				notifications = append(notifications, &pgproto3.NotificationResponse{
+					PID:     uint32(v[0].(int64)),
+					Channel: channel,
+					Payload: v[1].(string),
+				})
+				last = kv.Key
+			}
+
+			if len(notifications) == 0 {
+				watch = tr.Watch(headKey)
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read notifications: %w", err)
+		}
+
+		if len(notifications) > 0 {
+			return notifications, last, nil
+		}
+
+		fired := make(chan error, 1)
+		go func() {
+			fired <- watch.Get()
+		}()
+		select {
+		case <-stop:
+			watch.Cancel()
+			return nil, nil, nil
+		case err := <-fired:
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not watch notifications: %w", err)
+			}
+		}
+	}
+}
+
+func (pgs *pgServer) listen(channel string) error {
+	if _, ok := pgs.listeners[channel]; ok {
+		return nil
+	}
+
+	pe := pgs.engine
+	after, err := pe.lastNotification(channel)
+	if err != nil {
+		return err
+	}
+
+	l := &listener{channel: channel, stop: make(chan struct{})}
+	pgs.listeners[channel] = l
+
+	go func() {
+		for {
+			notifications, last, err := pe.waitNotifications(channel, after, l.stop)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			if notifications == nil {
+				return
+			}
+			after = last
+
+			var buf []byte
+			ce := pgs.clientEncoding()
+			for _, n := range notifications {
+				n.Channel, n.Payload = ce.encodeMessage(n.Channel), ce.encodeMessage(n.Payload)
+				buf = n.Encode(buf)
+			}
+			if err := pgs.write(buf); err != nil {
+				log.Printf("failed to write notification: %s", err)
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (pgs *pgServer) unlisten(channel string) {
+	for name, l := range pgs.listeners {
+		if channel == "" || channel == name {
+			close(l.stop)
+			delete(pgs.listeners, name)
+		}
+	}
+}
+
+// Handle LISTEN, UNLISTEN and NOTIFY. Returns false for any other statement.
+func (pgs *pgServer) handleNotifyStmt(stmt *pgquery.Node) (bool, error) {
+	if l := stmt.GetListenStmt(); l != nil {
+		if err := pgs.listen(l.Conditionname); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "LISTEN")
+		return true, nil
+	}
+
+	if u := stmt.GetUnlistenStmt(); u != nil {
+		// Note: an empty channel name is UNLISTEN *
+		pgs.unlisten(u.Conditionname)
+		pgs.done(nil, "UNLISTEN")
+		return true, nil
+	}
+
+	if n := stmt.GetNotifyStmt(); n != nil {
+		pe := pgs.engine.withTransactor(pgs.transactor())
+		if err := pe.notify(pgs.pid, n.Conditionname, n.Payload); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "NOTIFY")
+		return true, nil
+	}
+
+	return false, nil
+}
+
+var (
+	pidMu   sync.Mutex
+	lastPid uint32
+)
+
+// Process ids are handed out per connection, they identify the sender of notifications.
+func nextPid() uint32 {
+	pidMu.Lock()
+	defer pidMu.Unlock()
+	lastPid += 1
+	return lastPid
+}
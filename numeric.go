@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/shopspring/decimal"
+)
+
+/*
+
+Arbitrary precision numbers, numeric and its alias decimal.
+
+Values are decimal.Decimal in Go. The exponent of the decimal is kept through arithmetic,
+so like Postgres the number of digits after the decimal point (the display scale) of a
+value is part of it:
+
+```sql
+create table invoice (id int, amount numeric(10, 2));
+insert into invoice values (1, 19.999);  -- stored as 20.00
+select 1.50 + 1;                         -- 2.50
+select 1 / 3.0;                          -- 0.33333333333333333333
+```
+
+A declared precision and scale, kept with the type as `pg_catalog.numeric(10,2)`, rounds
+values to the scale and rejects values with more integer digits than the precision allows.
+
+Cells hold the coefficient and the exponent of the decimal in a nested tuple, tagged so it
+is told apart from other values:
+
+```
+data/table_data/invoice/r/72746a7f-727f-4e0a-88f1-d983fea5c158/amount: (("numeric", 2000, -2),)
+```
+
+*/
+
+const (
+	numericMaxPrecision = 1000
+	// Note: Postgres computes at least this many significant digits for a division
+	numericMinSigDigits = 16
+)
+
+func isNumericType(colType string) bool {
+	base, _ := splitTypeMods(colType)
+	return base == "pg_catalog.numeric"
+}
+
+// The declared precision and scale of a numeric type, a precision of 0 when the type has none.
+func numericTypeMods(colType string) (int, int, error) {
+	_, mods := splitTypeMods(colType)
+	switch len(mods) {
+	case 0:
+		return 0, 0, nil
+	case 1, 2:
+		precision, scale := mods[0], 0
+		if len(mods) == 2 {
+			scale = mods[1]
+		}
+		if precision < 1 || precision > numericMaxPrecision {
+			return 0, 0, fmt.Errorf("NUMERIC precision %d must be between 1 and %d", precision, numericMaxPrecision)
+		}
+		if scale < 0 || scale > precision {
+			return 0, 0, fmt.Errorf("NUMERIC scale %d must be between 0 and precision %d", scale, precision)
+		}
+		return precision, scale, nil
+	}
+	return 0, 0, fmt.Errorf("invalid NUMERIC type modifier")
+}
+
+func parseNumeric(s string) (decimal.Decimal, error) {
+	d, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return decimal.Decimal{}, fmt.Errorf("invalid input syntax for type numeric: %q", s)
+	}
+	return d, nil
+}
+
+// Text form of a numeric, with as many digits after the decimal point as its scale.
+func numericString(d decimal.Decimal) string {
+	if d.Exponent() < 0 {
+		return d.StringFixed(-d.Exponent())
+	}
+	return d.String()
+}
+
+func numericScale(d decimal.Decimal) int32 {
+	if d.Exponent() < 0 {
+		return -d.Exponent()
+	}
+	return 0
+}
+
+// The numeric value of a number or string. Floats go through their text form with the
+// digits their type is precise to, like Postgres casts them.
+func numericOperand(v any) (decimal.Decimal, error) {
+	switch n := v.(type) {
+	case decimal.Decimal:
+		return n, nil
+	case int64:
+		return decimal.NewFromInt(n), nil
+	case string:
+		return parseNumeric(n)
+	case float32, float64:
+		f, _ := floatOperand(n)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return decimal.Decimal{}, fmt.Errorf("cannot convert %s to numeric", formatFloat(f, 64))
+		}
+		digits := 15
+		if _, ok := n.(float32); ok {
+			digits = 6
+		}
+		return parseNumeric(strconv.FormatFloat(f, 'g', digits, 64))
+	}
+	return decimal.Decimal{}, fmt.Errorf("cannot cast type %T to numeric", v)
+}
+
+func castNumeric(v any, colType string) (string, error) {
+	d, err := numericOperand(v)
+	if err != nil {
+		return "", err
+	}
+
+	precision, scale, err := numericTypeMods(colType)
+	if err != nil {
+		return "", err
+	}
+	if precision == 0 {
+		return numericString(d), nil
+	}
+
+	d = d.Round(int32(scale))
+	if d.Abs().Cmp(decimal.New(1, int32(precision-scale))) >= 0 {
+		return "", fmt.Errorf("numeric field overflow: a field with precision %d, scale %d must round to an absolute value less than 10^%d", precision, scale, precision-scale)
+	}
+	return d.StringFixed(int32(scale)), nil
+}
+
+func numericArithmeticOp(op string, left, right any) (any, error) {
+	l, lerr := numericOperand(left)
+	r, rerr := numericOperand(right)
+	if lerr != nil || rerr != nil {
+		return nil, fmt.Errorf("operator does not exist: %T %s %T", left, op, right)
+	}
+
+	switch op {
+	case "+":
+		return l.Add(r), nil
+	case "-":
+		return l.Sub(r), nil
+	case "*":
+		return l.Mul(r), nil
+	}
+
+	if r.IsZero() {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if op == "%" {
+		return l.Mod(r), nil
+	}
+	return l.DivRound(r, numericDivScale(l, r)), nil
+}
+
+/*
+
+The scale of the quotient of a division, chosen like Postgres does: enough digits for at
+least 16 significant digits, and no fewer than the scale of either operand. Postgres keeps
+numbers as base 10000 digits, so the estimate of the magnitude of the quotient is made with
+those.
+
+*/
+
+func numericDivScale(l, r decimal.Decimal) int32 {
+	lweight, lfirst := numericWeight(l)
+	rweight, rfirst := numericWeight(r)
+
+	qweight := lweight - rweight
+	if lfirst <= rfirst {
+		qweight--
+	}
+
+	scale := numericMinSigDigits - qweight*4
+	for _, s := range []int32{numericScale(l), numericScale(r), 0} {
+		if scale < s {
+			scale = s
+		}
+	}
+	if scale > numericMaxPrecision {
+		scale = numericMaxPrecision
+	}
+	return scale
+}
+
+// The weight and value of the first base 10000 digit of a number.
+func numericWeight(d decimal.Decimal) (int32, int64) {
+	if d.IsZero() {
+		return 0, 0
+	}
+
+	leading := int32(d.NumDigits()) + d.Exponent() - 1
+	weight := leading / 4
+	if leading < 0 && leading%4 != 0 {
+		weight--
+	}
+	return weight, d.Abs().Shift(-4 * weight).IntPart()
+}
+
+func isNumeric(v any) bool {
+	_, ok := v.(decimal.Decimal)
+	return ok
+}
+
+func cmpNumeric(left, right any) (int, error) {
+	l, err := numericOperand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := numericOperand(right)
+	if err != nil {
+		return 0, err
+	}
+	return l.Cmp(r), nil
+}
+
+func encodeNumeric(d decimal.Decimal) tuple.Tuple {
+	return tuple.Tuple{"numeric", d.Coefficient(), int64(d.Exponent())}
+}
+
+// Decode a tagged numeric tuple, the coefficient comes back as int64 when it fits.
+func decodeNumeric(t tuple.Tuple) (decimal.Decimal, bool) {
+	if len(t) != 3 || t[0] != "numeric" {
+		return decimal.Decimal{}, false
+	}
+
+	var coefficient *big.Int
+	switch c := t[1].(type) {
+	case int64:
+		coefficient = big.NewInt(c)
+	case *big.Int:
+		coefficient = c
+	default:
+		return decimal.Decimal{}, false
+	}
+
+	exp, ok := t[2].(int64)
+	if !ok {
+		return decimal.Decimal{}, false
+	}
+	return decimal.NewFromBigInt(coefficient, int32(exp)), true
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Sort the rows of a select by its ORDER BY clause.
+
+Sort keys are expressions over the row or positions in the target list, text is sorted by
+the collation of the key:
+
+```sql
+select name, age from customer order by age desc, name collate "en_US";
+select name, age from customer order by 2, 1;
+```
+
+Like Postgres, NULLs sort as larger than any other value, so they come last in ascending
+and first in descending order unless NULLS FIRST or NULLS LAST says otherwise.
+
+*/
+
+type sortKey struct {
+	expr       *pgquery.Node
+	coll       *collation
+	desc       bool
+	nullsFirst bool
+}
+
+func sortKeys(sortClause []*pgquery.Node, fieldNames []string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, n := range sortClause {
+		sb := n.GetSortBy()
+		if sb.SortbyDir == pgquery.SortByDir_SORTBY_USING {
+			return nil, fmt.Errorf("ORDER BY USING is not supported")
+		}
+
+		expr := sb.Node
+		// Note: an integer constant is a position in the target list
+		if c := expr.GetAConst(); c != nil && c.Val.GetInteger() != nil {
+			pos := int(c.Val.GetInteger().Ival)
+			if pos < 1 || pos > len(fieldNames) {
+				return nil, fmt.Errorf("ORDER BY position %d is not in select list", pos)
+			}
+			expr = pgquery.MakeColumnRefNode([]*pgquery.Node{pgquery.MakeStrNode(fieldNames[pos-1])}, c.Location)
+		}
+
+		coll, err := lookupCollation(exprCollation(expr))
+		if err != nil {
+			return nil, err
+		}
+
+		desc := sb.SortbyDir == pgquery.SortByDir_SORTBY_DESC
+		nullsFirst := desc
+		switch sb.SortbyNulls {
+		case pgquery.SortByNulls_SORTBY_NULLS_FIRST:
+			nullsFirst = true
+		case pgquery.SortByNulls_SORTBY_NULLS_LAST:
+			nullsFirst = false
+		}
+
+		keys = append(keys, sortKey{expr: expr, coll: coll, desc: desc, nullsFirst: nullsFirst})
+	}
+
+	return keys, nil
+}
+
+func sortRows(sortClause []*pgquery.Node, fieldNames []string, tbl *tableDefinition, rows []row) error {
+	if len(sortClause) == 0 {
+		return nil
+	}
+
+	keys, err := sortKeys(sortClause, fieldNames)
+	if err != nil {
+		return err
+	}
+
+	// Note: evaluate the keys once per row instead of on every comparison
+	values := make([][]any, len(rows))
+	for i, r := range rows {
+		for _, k := range keys {
+			v, err := evalExpr(k.expr, tbl, r)
+			if err != nil {
+				return err
+			}
+			values[i] = append(values[i], v)
+		}
+	}
+
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+
+	var sortErr error
+	sort.SliceStable(order, func(a, b int) bool {
+		for i, k := range keys {
+			l, r := values[order[a]][i], values[order[b]][i]
+			if l == nil || r == nil {
+				if l == nil && r == nil {
+					continue
+				}
+				return (l == nil) == k.nullsFirst
+			}
+
+			c, err := compareValues(l, r, k.coll)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			if c == 0 {
+				continue
+			}
+			if k.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	if sortErr != nil {
+		return sortErr
+	}
+
+	sorted := make([]row, len(rows))
+	for i, j := range order {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"sync"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+)
+
+/*
+
+Parallel scans.
+
+FoundationDB keeps its keys in shards, ranges of keys that are stored by different storage
+servers. A select that reads every row of a table in one transaction, like a select with ORDER BY
+or one of a transaction block, splits the row layout of the table at the boundary keys of its
+shards into up to -scan-workers parts, reads them at once in the same transaction and appends
+their rows in key order:
+
+```
+data/table_data/person/r/...    shards: [begin, k1) [k1, k2) [k2, k3) [k3, end)
+fakegres -scan-workers=2   ->   worker 1: [begin, k2)   worker 2: [k2, end)
+                           ->   rows of worker 1, then rows of worker 2
+```
+
+The parts are read at the same version, so the rows are the rows the scan of a single range
+reads, in the same order. A row is one key (see rowFormat.go), so a boundary never splits one.
+
+The boundaries come from the locality API, which reads the shard map with the database. Tenants
+and transaction blocks can't read it, they split the table with GetRangeSplitPoints of their
+transaction instead, in parts of about scanSplitBytes. A table within a single shard is read
+in one range like before. Selects with a LIMIT read in order until they have their rows, and
+streaming selects send the rows of one batch after the other, neither is split.
+
+*/
+
+// Parts a full scan of a table reads at once, set by -scan-workers.
+var scanWorkers = 4
+
+// The boundary keys asked for when a table is split, the parts after the last one are read together.
+const scanBoundaryLimit = 1000
+
+// The size of the parts of GetRangeSplitPoints.
+const scanSplitBytes = 16 << 20
+
+// Read the rows of the row layout in kr, in parts at once if it spans shards.
+func (pe pgEngine) scanParallel(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target string, kr fdb.KeyRange) ([]row, error) {
+	parts := splitRange(kr, pe.scanBoundaries(rtr, kr), scanWorkers)
+
+	rows := make([][]row, len(parts))
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part fdb.KeyRange) {
+			defer wg.Done()
+			rows[i], errs[i] = pe.scanPart(rtr, tableDataSS, tbl, target, part)
+		}(i, part)
+	}
+	wg.Wait()
+
+	var merged []row
+	for i := range parts {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		merged = append(merged, rows[i]...)
+	}
+	return merged, nil
+}
+
+// Read the rows of a part in batches of scanBatchKeys keys, which can be canceled in between.
+func (pe pgEngine) scanPart(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target string, kr fdb.KeyRange) (rows []row, err error) {
+	// Note: reads panic with their FoundationDB error, which is returned for the transaction to retry
+	defer func() {
+		if r := recover(); r != nil {
+			fe, ok := r.(fdb.Error)
+			if !ok {
+				panic(r)
+			}
+			err = fe
+		}
+	}()
+
+	begin := kr.Begin
+	for {
+		if err := pe.checkCanceled(); err != nil {
+			return nil, err
+		}
+		_, batchRows, rowEnds, complete := readRows(rtr, tableDataSS, tbl, target, fdb.KeyRange{Begin: begin, End: kr.End}, scanBatchKeys)
+		rows = append(rows, batchRows...)
+		if complete {
+			return rows, nil
+		}
+		begin = rowEnds[len(rowEnds)-1]
+	}
+}
+
+// The keys kr can be split at, the boundaries of its shards or else split points of about scanSplitBytes.
+func (pe pgEngine) scanBoundaries(rtr fdb.ReadTransaction, kr fdb.KeyRange) []fdb.Key {
+	if scanWorkers < 2 {
+		return nil
+	}
+	if db, ok := unwrapTransactor(pe.db).(fdb.Database); ok {
+		keys, err := db.LocalityGetBoundaryKeys(kr, scanBoundaryLimit, 0)
+		if err == nil {
+			return keys
+		}
+		log.Printf("could not get shard boundaries: %s", err)
+	}
+
+	keys, err := rtr.GetRangeSplitPoints(kr, scanSplitBytes).Get()
+	if err != nil {
+		log.Printf("could not get range split points: %s", err)
+		return nil
+	}
+	return keys
+}
+
+// Split kr into at most n parts at the boundaries in it, with about as many boundaries in every part.
+func splitRange(kr fdb.KeyRange, boundaries []fdb.Key, n int) []fdb.KeyRange {
+	var inside []fdb.Key
+	for _, k := range boundaries {
+		if bytes.Compare(k, kr.Begin.FDBKey()) > 0 && bytes.Compare(k, kr.End.FDBKey()) < 0 {
+			inside = append(inside, k)
+		}
+	}
+
+	// Note: n-1 of the boundaries split the len(inside)+1 shards into n parts
+	var parts []fdb.KeyRange
+	begin := kr.Begin.FDBKey()
+	shards := len(inside) + 1
+	n = min(n, shards)
+	for i := 1; i < n; i++ {
+		cut := inside[i*shards/n-1]
+		if bytes.Compare(cut, begin) > 0 {
+			parts = append(parts, fdb.KeyRange{Begin: begin, End: cut})
+			begin = cut
+		}
+	}
+	return append(parts, fdb.KeyRange{Begin: begin, End: kr.End})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+The run-time parameters reported to clients at the start of a session, in ParameterStatus
+messages between AuthenticationOk and the first ReadyForQuery. Drivers read them to know how
+to talk to the server, JDBC for example refuses to go on without client_encoding UTF8 and
+parses server_version to decide which features it can use:
+
+```
+server_version               13.0
+server_encoding              UTF8
+client_encoding              UTF8
+DateStyle                    ISO, MDY
+TimeZone                     UTC
+integer_datetimes            on
+standard_conforming_strings  on
+application_name             psql
+```
+
+The version is that of the Postgres parser fakegres uses. TimeZone is the time zone of the
+-timezone flag. application_name is the one the client gave in its startup message, empty if
+it gave none, and client_encoding the encoding it asked for, UTF8 if it asked for none.
+
+*/
+
+const serverVersion = "13.0"
+
+func (pgs *pgServer) parameterStatuses() []pgproto3.ParameterStatus {
+	return []pgproto3.ParameterStatus{
+		{Name: "server_version", Value: serverVersion},
+		{Name: "server_encoding", Value: "UTF8"},
+		{Name: "client_encoding", Value: pgs.clientEncoding().name},
+		{Name: "DateStyle", Value: "ISO, MDY"},
+		{Name: "TimeZone", Value: defaultTimeZone.String()},
+		{Name: "integer_datetimes", Value: "on"},
+		{Name: "standard_conforming_strings", Value: "on"},
+		{Name: "application_name", Value: pgs.applicationName()},
+	}
+}
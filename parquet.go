@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/encoding"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+/*
+
+Parquet files.
+
+Exports (see parquetExport.go) write Parquet files, which keep the values of a column next to
+each other like the columnar layout does. The files are written with the writer of parquet-go,
+with a schema of a column for every column of the export, in its order:
+
+```
+message fakegres {
+	optional binary name (STRING);
+	optional int32 age (INTEGER(32,true));
+	...
+}
+```
+
+A row group has parquetRowGroupRows rows, the last one the rest. Column chunks are compressed
+with zstd. Columns are optional, any of them can be NULL.
+
+The types of the columns are the Parquet types of their Postgres types:
+
+```
+bool                      BOOLEAN
+int2, int4                INT32 (INT_16, INT_32)
+int8                      INT64 (INT_64)
+float4                    FLOAT
+float8                    DOUBLE
+date                      INT32 (DATE), days since 1970-01-01
+timestamp, timestamptz    INT64 (TIMESTAMP, microseconds), adjusted to UTC for timestamptz
+json, jsonb               BYTE_ARRAY (JSON)
+other types               BYTE_ARRAY (UTF8), the text form of the value
+```
+
+The dates infinity and -infinity are written as the largest and smallest dates Parquet has,
+timestamps as the largest and smallest microseconds.
+
+*/
+
+// The rows of a row group of a Parquet file.
+const parquetRowGroupRows = 1 << 16
+
+// A column of a Parquet file and the Postgres type of its values.
+type parquetColumn struct {
+	name    string
+	colType string
+	// The leaf of the column in the schema, before it is made optional
+	node parquet.Node
+}
+
+// The Parquet column of values of a Postgres type.
+func parquetColumnOf(name string, colType string) parquetColumn {
+	c := parquetColumn{name: name, colType: colType, node: parquet.String()}
+	base, _ := splitTypeMods(colType)
+	switch base {
+	case "pg_catalog.bool":
+		c.node = parquet.Leaf(parquet.BooleanType)
+	case "pg_catalog.int2":
+		c.node = parquet.Int(16)
+	case "pg_catalog.int4":
+		c.node = parquet.Int(32)
+	case "pg_catalog.int8":
+		c.node = parquet.Int(64)
+	case "pg_catalog.float4":
+		c.node = parquet.Leaf(parquet.FloatType)
+	case "pg_catalog.float8":
+		c.node = parquet.Leaf(parquet.DoubleType)
+	case "pg_catalog.date":
+		c.node = parquet.Date()
+	case "pg_catalog.timestamp":
+		// Note: parquet-go only has timestamps adjusted to UTC, see parquetLocalTimestamp
+		c.node = parquet.Leaf(parquetLocalTimestamp{parquet.Timestamp(parquet.Microsecond).Type()})
+	case "pg_catalog.timestamptz":
+		c.node = parquet.Timestamp(parquet.Microsecond)
+	case "pg_catalog.json", "pg_catalog.jsonb":
+		c.node = parquet.JSON()
+	}
+	return c
+}
+
+// The type of the microseconds of timestamps without time zone, the TIMESTAMP logical type not
+// adjusted to UTC. It has no converted type, TIMESTAMP_MICROS means adjusted to UTC.
+type parquetLocalTimestamp struct {
+	parquet.Type
+}
+
+func (parquetLocalTimestamp) LogicalType() *format.LogicalType {
+	return &format.LogicalType{Timestamp: &format.TimestampType{Unit: format.TimeUnit{Micros: &format.MicroSeconds{}}}}
+}
+
+func (parquetLocalTimestamp) ConvertedType() *deprecated.ConvertedType { return nil }
+
+// The root of a schema, whose fields are the columns in the order they were given. The fields of
+// parquet.Group are sorted by name.
+type parquetGroup []parquet.Field
+
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+func (f parquetField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+func (g parquetGroup) ID() int { return 0 }
+
+func (g parquetGroup) String() string {
+	s := new(strings.Builder)
+	parquet.PrintSchema(s, "", g)
+	return s.String()
+}
+
+func (g parquetGroup) Type() parquet.Type { return parquet.Group{}.Type() }
+
+func (g parquetGroup) Optional() bool { return false }
+
+func (g parquetGroup) Repeated() bool { return false }
+
+func (g parquetGroup) Required() bool { return true }
+
+func (g parquetGroup) Leaf() bool { return false }
+
+func (g parquetGroup) Fields() []parquet.Field { return g }
+
+func (g parquetGroup) Encoding() encoding.Encoding { return nil }
+
+func (g parquetGroup) Compression() compress.Codec { return nil }
+
+func (g parquetGroup) GoType() reflect.Type { return reflect.TypeOf(map[string]any{}) }
+
+// Writes a Parquet file, a row at a time.
+type parquetWriter struct {
+	w       *parquet.Writer
+	columns []parquetColumn
+	row     parquet.Row
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	group := make(parquetGroup, len(columns))
+	names := map[string]bool{}
+	for i, c := range columns {
+		// Note: a column is found by its name in a Parquet file, select a, a has no file
+		if names[c.name] {
+			return nil, &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("column \"%s\" appears more than once, Parquet files can't have columns of the same name", c.name)}
+		}
+		names[c.name] = true
+		group[i] = parquetField{Node: parquet.Optional(c.node), name: c.name}
+	}
+
+	pw := parquet.NewWriter(w,
+		parquet.NewSchema("fakegres", group),
+		parquet.Compression(&zstd.Codec{}),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupRows),
+	)
+	return &parquetWriter{w: pw, columns: columns, row: make(parquet.Row, len(columns))}, nil
+}
+
+// Add a row of the values of the columns, nil for NULL. A row group is written once it is full.
+func (pw *parquetWriter) writeRow(values []any) error {
+	for i, c := range pw.columns {
+		v, err := parquetValue(c, values[i])
+		if err != nil {
+			return err
+		}
+		// Note: the definition level of a column is 1 for a value and 0 for NULL
+		level := 1
+		if values[i] == nil {
+			level = 0
+		}
+		pw.row[i] = v.Level(0, level, i)
+	}
+	if _, err := pw.w.WriteRows([]parquet.Row{pw.row}); err != nil {
+		return fmt.Errorf("could not write parquet file: %w", err)
+	}
+	return nil
+}
+
+// The Parquet value of a value of the column, nil for NULL.
+func parquetValue(c parquetColumn, v any) (parquet.Value, error) {
+	if v == nil {
+		return parquet.NullValue(), nil
+	}
+
+	kind := c.node.Type().Kind()
+	// Note: cells written before they were tuple encoded are strings, see cellValue
+	if s, ok := v.(string); ok && kind != parquet.ByteArray {
+		parsed, err := cellValue(c.colType, s)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		v = parsed
+	}
+
+	switch kind {
+	case parquet.Boolean:
+		if b, ok := v.(bool); ok {
+			return parquet.BooleanValue(b), nil
+		}
+	case parquet.Int32:
+		if d, ok := v.(date); ok {
+			return parquet.Int32Value(int32(max(math.MinInt32, min(math.MaxInt32, int64(d))))), nil
+		}
+		if n, ok := parquetInteger(v); ok {
+			return parquet.Int32Value(int32(n)), nil
+		}
+	case parquet.Int64:
+		switch t := v.(type) {
+		case timestamp:
+			return parquet.Int64Value(int64(t)), nil
+		case timestamptz:
+			return parquet.Int64Value(int64(t)), nil
+		}
+		if n, ok := parquetInteger(v); ok {
+			return parquet.Int64Value(n), nil
+		}
+	case parquet.Float:
+		if f, ok := v.(float64); ok {
+			return parquet.FloatValue(float32(f)), nil
+		}
+	case parquet.Double:
+		if f, ok := v.(float64); ok {
+			return parquet.DoubleValue(f), nil
+		}
+	case parquet.ByteArray:
+		return parquet.ByteArrayValue([]byte(formatText(v))), nil
+	}
+	return parquet.Value{}, fmt.Errorf("could not write value %v of column %s as parquet", v, c.name)
+}
+
+func parquetInteger(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// Write the last row group and the footer.
+func (pw *parquetWriter) close() error {
+	if err := pw.w.Close(); err != nil {
+		return fmt.Errorf("could not write parquet file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Parquet exports.
+
+COPY TO writes a table, or the result of a select, as a Parquet file (see parquet.go), which
+DuckDB, Spark or pandas read as it is:
+
+```sql
+copy metric to '/exports/metric.parquet' (format parquet);
+copy metric (at, value) to '/exports/values.parquet' (format parquet);
+copy (select name, count(*) from event group by name) to '/exports/counts.parquet' (format parquet);
+COPY 31402
+```
+
+```sql
+-- duckdb
+select name, avg(value) from '/exports/metric.parquet' group by name;
+```
+
+A file name is a file of the server, like in Postgres. Clients aren't trusted with the files of
+the server, so COPY TO a file is only allowed with -copy-dir, and only into that directory: a
+relative name is taken in it, and a name that resolves outside of it, through .. or a symbolic
+link, fails with 42501 like a COPY TO a file without the privilege to in Postgres:
+
+```bash
+$ ./fakegres-fdb -copy-dir=/exports
+```
+
+COPY TO STDOUT sends the file to the client in CopyData messages instead, which psql's \copy
+writes to a file of the client, and is always allowed:
+
+```
+\copy metric to 'metric.parquet' (format parquet)
+```
+
+The columns of a table that keeps the columnar layout (see layout.go) are read from it, a range
+of cells per column, only the columns copied. Tables that only keep the row layout are read
+from their rows. The rows are read in batches of snapshot reads, each batch as the table was at
+one point in time, and are written to the file as they are read, a row group at a time. The
+rows of a select are those of its result. Only FORMAT parquet is supported for COPY TO.
+
+*/
+
+func (pgs *pgServer) handleCopyTo(ctx context.Context, cs *pgquery.CopyStmt) error {
+	if cs.IsProgram || cs.WhereClause != nil {
+		return &pgError{Code: sqlStateFeatureNotSupported, Message: "only COPY TO a file or STDOUT is supported"}
+	}
+	for _, o := range cs.Options {
+		de := o.GetDefElem()
+		value := de.GetArg().GetString_().GetStr()
+		if de.Defname != "format" {
+			return &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("COPY option \"%s\" is not supported", de.Defname)}
+		}
+		if value != "parquet" {
+			return &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("COPY TO format \"%s\" is not supported, only parquet", value)}
+		}
+	}
+	if len(cs.Options) == 0 {
+		return &pgError{Code: sqlStateFeatureNotSupported, Message: "COPY TO is only supported with FORMAT parquet"}
+	}
+
+	pe := pgs.engine.withTransactor(pgs.selectTransactor()).withContext(ctx)
+	var columns []parquetColumn
+	var rows func(pw *parquetWriter) (int64, error)
+	if cs.Query != nil {
+		s := cs.Query.GetSelectStmt()
+		if s == nil {
+			return &pgError{Code: sqlStateFeatureNotSupported, Message: "only selects can be copied"}
+		}
+		res, err := pe.executeSelect(s)
+		if err != nil {
+			return err
+		}
+		for i, name := range res.fieldNames {
+			columns = append(columns, parquetColumnOf(name, res.fieldTypes[i]))
+		}
+		rows = func(pw *parquetWriter) (int64, error) {
+			for _, r := range res.rows {
+				if err := pw.writeRow(r); err != nil {
+					return 0, err
+				}
+			}
+			return int64(len(res.rows)), nil
+		}
+	} else {
+		tbl, err := pe.getTableDefinition(cs.Relation.Relname)
+		if err != nil {
+			return err
+		}
+		indexes, err := copyColumns(cs, tbl)
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, i := range indexes {
+			names = append(names, tbl.ColumnNames[i])
+			columns = append(columns, parquetColumnOf(tbl.ColumnNames[i], tbl.ColumnTypes[i]))
+		}
+		rows = func(pw *parquetWriter) (int64, error) {
+			return pe.exportTable(pw, tbl, names)
+		}
+	}
+
+	if cs.Filename != "" {
+		path, err := copyFilePath(cs.Filename)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return &pgError{Code: sqlStateIOError, Message: fmt.Sprintf("could not open file \"%s\" for writing: %s", cs.Filename, err)}
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		copied, err := writeParquet(w, columns, rows)
+		if err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("could not write parquet file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("could not write parquet file: %w", err)
+		}
+		pgs.done(nil, fmt.Sprintf("COPY %d", copied))
+		return nil
+	}
+
+	// Note: the file is binary, the COPY is in the binary format of a single column
+	response := &pgproto3.CopyOutResponse{OverallFormat: 1, ColumnFormatCodes: []uint16{1}}
+	if err := pgs.write(response.Encode(nil)); err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(copyOutWriter{pgs}, copyOutDataSize)
+	copied, err := writeParquet(w, columns, rows)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	pgs.done((&pgproto3.CopyDone{}).Encode(nil), fmt.Sprintf("COPY %d", copied))
+	return nil
+}
+
+// The directory of -copy-dir, empty if COPY TO can't write files.
+var copyDir string
+
+// The path of the file COPY TO writes, which has to be in -copy-dir.
+func copyFilePath(name string) (string, error) {
+	if copyDir == "" {
+		return "", &pgError{Code: sqlStateInsufficientPrivilege, Message: "COPY to a file is not allowed without -copy-dir", Detail: "Use COPY TO STDOUT, or psql's \\copy, to write a file of the client."}
+	}
+	outside := &pgError{Code: sqlStateInsufficientPrivilege, Message: fmt.Sprintf("could not write file \"%s\": it is outside of -copy-dir", name)}
+
+	dir, err := filepath.EvalSymlinks(copyDir)
+	if err != nil {
+		return "", &pgError{Code: sqlStateIOError, Message: fmt.Sprintf("could not open -copy-dir: %s", err)}
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(copyDir, path)
+	}
+
+	// Note: the directory of the file is resolved, a link in it could point anywhere
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", &pgError{Code: sqlStateIOError, Message: fmt.Sprintf("could not open file \"%s\" for writing: %s", name, err)}
+	}
+	if rel, err := filepath.Rel(dir, parent); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", outside
+	}
+	path = filepath.Join(parent, filepath.Base(path))
+	// Note: an existing link would be followed when the file is created
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", outside
+	}
+	return path, nil
+}
+
+// Write a Parquet file of the columns with the rows rows writes, returning how many it wrote.
+func writeParquet(w *bufio.Writer, columns []parquetColumn, rows func(pw *parquetWriter) (int64, error)) (int64, error) {
+	pw, err := newParquetWriter(w, columns)
+	if err != nil {
+		return 0, err
+	}
+	copied, err := rows(pw)
+	if err != nil {
+		return 0, err
+	}
+	return copied, pw.close()
+}
+
+// Write the rows of the table and its partitions, from the columnar layout if the table keeps it.
+func (pe pgEngine) exportTable(pw *parquetWriter, tbl *tableDefinition, columns []string) (int64, error) {
+	targets, err := pe.tableTargets(tbl.Name)
+	if err != nil {
+		return 0, err
+	}
+	layout := tbl.rowScanLayout()
+	if tbl.storesColumns() && len(columns) > 0 {
+		layout = layoutColumnar
+	}
+
+	var copied int64
+	for _, target := range targets {
+		err := pe.readSnapshotBatches(tbl, target, layout, columns, func(rows []row) error {
+			for _, r := range rows {
+				values := make([]any, len(columns))
+				for i, name := range columns {
+					values[i] = r[name]
+				}
+				if err := pw.writeRow(values); err != nil {
+					return err
+				}
+			}
+			copied += int64(len(rows))
+			return nil
+		})
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// The size of the CopyData messages of a COPY TO STDOUT.
+const copyOutDataSize = 64 << 10
+
+// Sends what is written to it to the client as CopyData messages.
+type copyOutWriter struct {
+	pgs *pgServer
+}
+
+func (w copyOutWriter) Write(p []byte) (int, error) {
+	if err := w.pgs.write((&pgproto3.CopyData{Data: p}).Encode(nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/shopspring/decimal"
+)
+
+// The columns of the test file, one of every Postgres type with a Parquet type of its own and
+// two of the types written as text.
+var parquetTestColumns = []parquetColumn{
+	parquetColumnOf("flag", "pg_catalog.bool"),
+	parquetColumnOf("small", "pg_catalog.int2"),
+	parquetColumnOf("medium", "pg_catalog.int4"),
+	parquetColumnOf("big", "pg_catalog.int8"),
+	parquetColumnOf("ratio", "pg_catalog.float4"),
+	parquetColumnOf("score", "pg_catalog.float8"),
+	parquetColumnOf("day", "pg_catalog.date"),
+	parquetColumnOf("at", "pg_catalog.timestamp"),
+	parquetColumnOf("logged_at", "pg_catalog.timestamptz"),
+	parquetColumnOf("doc", "pg_catalog.jsonb"),
+	parquetColumnOf("name", "pg_catalog.text"),
+	parquetColumnOf("price", "pg_catalog.numeric"),
+}
+
+// The value of a column in a row of the test file, every column is NULL in some rows.
+func parquetTestValue(row, column int) any {
+	if (row+column)%7 == 0 {
+		return nil
+	}
+	switch column {
+	case 0:
+		return row%3 == 0
+	case 1:
+		return int64(row%30000 - 15000)
+	case 2:
+		return int64(row*31 - 1000000)
+	case 3:
+		return int64(row) * 1000000007
+	case 4:
+		return float64(row) / 4
+	case 5:
+		return float64(row) / 3
+	case 6:
+		return date(19000 + row%1000)
+	case 7:
+		return timestamp(int64(row) * 1000001)
+	case 8:
+		return timestamptz(1709281800000000 + int64(row))
+	case 9:
+		return jsonText(`{"row": ` + strconv.Itoa(row) + `}`)
+	case 10:
+		return "row " + strconv.Itoa(row)
+	}
+	return decimal.New(int64(row), -2)
+}
+
+// Write the rows with the writer of exports and read them back with parquet-go.
+func TestParquetRoundTrip(t *testing.T) {
+	rows := parquetRowGroupRows*2 + 100
+
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, parquetTestColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		values := make([]any, len(parquetTestColumns))
+		for j := range values {
+			values[j] = parquetTestValue(i, j)
+		}
+		if err := pw.writeRow(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NumRows() != int64(rows) {
+		t.Fatalf("file has %d rows, expected %d", f.NumRows(), rows)
+	}
+	if len(f.RowGroups()) != 3 {
+		t.Fatalf("file has %d row groups, expected 3", len(f.RowGroups()))
+	}
+
+	// Note: the columns are in the order they were written
+	for i, path := range f.Schema().Columns() {
+		if i >= len(parquetTestColumns) || path[0] != parquetTestColumns[i].name {
+			t.Fatalf("file has columns %v", f.Schema().Columns())
+		}
+	}
+	checkParquetSchema(t, f.Schema())
+
+	row := 0
+	for _, rg := range f.RowGroups() {
+		rr := rg.Rows()
+		batch := make([]parquet.Row, 1000)
+		for {
+			n, err := rr.ReadRows(batch)
+			for _, r := range batch[:n] {
+				for _, v := range r {
+					checkParquetValue(t, row, v.Column(), v)
+				}
+				row++
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rr.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if row != rows {
+		t.Fatalf("read %d rows, expected %d", row, rows)
+	}
+}
+
+func checkParquetSchema(t *testing.T, schema *parquet.Schema) {
+	t.Helper()
+	kinds := map[string]parquet.Kind{
+		"flag": parquet.Boolean, "small": parquet.Int32, "medium": parquet.Int32, "big": parquet.Int64,
+		"ratio": parquet.Float, "score": parquet.Double, "day": parquet.Int32, "at": parquet.Int64,
+		"logged_at": parquet.Int64, "doc": parquet.ByteArray, "name": parquet.ByteArray, "price": parquet.ByteArray,
+	}
+	for _, field := range schema.Fields() {
+		if !field.Optional() {
+			t.Errorf("column %s is not optional", field.Name())
+		}
+		if kind := field.Type().Kind(); kind != kinds[field.Name()] {
+			t.Errorf("column %s is of kind %s, expected %s", field.Name(), kind, kinds[field.Name()])
+		}
+
+		lt := field.Type().LogicalType()
+		var ok bool
+		switch field.Name() {
+		case "flag", "ratio", "score":
+			ok = lt == nil
+		case "small", "medium", "big":
+			bits := map[string]int8{"small": 16, "medium": 32, "big": 64}[field.Name()]
+			ok = lt != nil && lt.Integer != nil && lt.Integer.BitWidth == bits && lt.Integer.IsSigned
+		case "day":
+			ok = lt != nil && lt.Date != nil
+		case "at", "logged_at":
+			ok = lt != nil && lt.Timestamp != nil && lt.Timestamp.Unit.Micros != nil && lt.Timestamp.IsAdjustedToUTC == (field.Name() == "logged_at")
+		case "doc":
+			ok = lt != nil && lt.Json != nil
+		case "name", "price":
+			ok = lt != nil && lt.UTF8 != nil
+		}
+		if !ok {
+			t.Errorf("column %s has logical type %v", field.Name(), lt)
+		}
+	}
+}
+
+func checkParquetValue(t *testing.T, row, column int, v parquet.Value) {
+	t.Helper()
+	expected := parquetTestValue(row, column)
+	if expected == nil || v.IsNull() {
+		if expected != nil || !v.IsNull() {
+			t.Fatalf("row %d column %s is %v, expected %v", row, parquetTestColumns[column].name, v, expected)
+		}
+		return
+	}
+
+	var got any
+	switch e := expected.(type) {
+	case bool:
+		got = v.Boolean()
+	case int64:
+		if parquetTestColumns[column].node.Type().Kind() == parquet.Int32 {
+			got = int64(v.Int32())
+		} else {
+			got = v.Int64()
+		}
+	case float64:
+		if parquetTestColumns[column].node.Type().Kind() == parquet.Float {
+			got = float64(v.Float())
+		} else {
+			got = v.Double()
+		}
+	case date:
+		got, expected = int64(v.Int32()), int64(e)
+	case timestamp:
+		got, expected = v.Int64(), int64(e)
+	case timestamptz:
+		got, expected = v.Int64(), int64(e)
+	default:
+		got, expected = string(v.ByteArray()), string(formatText(e))
+	}
+	if got != expected {
+		t.Fatalf("row %d column %s is %v, expected %v", row, parquetTestColumns[column].name, got, expected)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+type partitionBound struct {
+	Name      string
+	IsDefault bool
+	// List partitions
+	Values []string
+	// Range partitions, nil means MINVALUE/MAXVALUE
+	Lower *string
+	Upper *string
+}
+
+type partitionSpec struct {
+	Strategy   string
+	Column     string
+	Partitions []partitionBound
+}
+
+/*
+
+Partitioned tables are regular tables with an extra catalog entry describing how rows
+are routed. Every partition is a table of its own, so its rows live in their own
+subspace of table_data and can be scanned (or skipped) independently.
+
+Example:
+
+```sql
+create table measurement (day int, value int) partition by range (day);
+create table measurement_1 partition of measurement for values from (minvalue) to (100);
+create table measurement_2 partition of measurement for values from (100) to (maxvalue);
+```
+
+Will produce the following KV structure, next to the regular table definitions
+
+```
+catalog/partition/measurement: ("range", "day")
+catalog/partition/measurement/measurement_1: ("r", nil, "100")
+catalog/partition/measurement/measurement_2: ("r", "100", nil)
+```
+
+List partitions are stored as ("l", value, ...) and the default partition as ("d").
+
+*/
+
+func (pe pgEngine) createPartitionSpec(tr fdb.Transaction, name string, spec *pgquery.PartitionSpec) error {
+	if spec.Strategy != "range" && spec.Strategy != "list" {
+		return fmt.Errorf("unsupported partitioning strategy: %s", spec.Strategy)
+	}
+
+	if len(spec.PartParams) != 1 || spec.PartParams[0].GetPartitionElem().GetName() == "" {
+		return fmt.Errorf("only a single partition key column is supported")
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	partitionSS := catalogDir.Sub("partition")
+	writes(tr).Set(partitionSS.Pack(tuple.Tuple{name}), tuple.Tuple{spec.Strategy, spec.PartParams[0].GetPartitionElem().Name}.Pack())
+	return nil
+}
+
+func (pe pgEngine) createPartition(tr fdb.Transaction, parent string, name string, bound *pgquery.PartitionBoundSpec) error {
+	var value tuple.Tuple
+	switch {
+	case bound.IsDefault:
+		value = tuple.Tuple{"d"}
+	case bound.Strategy == "l":
+		value = tuple.Tuple{"l"}
+		for _, d := range bound.Listdatums {
+			s, ok := constString(d)
+			if !ok {
+				return fmt.Errorf("invalid partition bound: %s", d)
+			}
+			value = append(value, s)
+		}
+	case bound.Strategy == "r":
+		if len(bound.Lowerdatums) != 1 || len(bound.Upperdatums) != 1 {
+			return fmt.Errorf("only a single partition key column is supported")
+		}
+		value = tuple.Tuple{"r", rangeDatum(bound.Lowerdatums[0]), rangeDatum(bound.Upperdatums[0])}
+	default:
+		return fmt.Errorf("unsupported partition bound: %s", bound.Strategy)
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	partitionSS := catalogDir.Sub("partition")
+	writes(tr).Set(partitionSS.Pack(tuple.Tuple{parent, name}), value.Pack())
+	return nil
+}
+
+// MINVALUE and MAXVALUE are parsed as column references and stored as nil.
+func rangeDatum(n *pgquery.Node) tuple.TupleElement {
+	if s, ok := constString(n); ok {
+		return s
+	}
+	return nil
+}
+
+/*
+
+Get the partitioning scheme of a table, or nil if the table is not partitioned. Like the
+table definition, this is a single range query.
+
+*/
+
+func (pe pgEngine) getPartitionSpec(name string) (*partitionSpec, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	partitionSS := catalogDir.Sub("partition")
+
+	spec, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		specValue := rtr.Get(partitionSS.Pack(tuple.Tuple{name})).MustGet()
+		if specValue == nil {
+			return nil, nil
+		}
+
+		specTuple, err := tuple.Unpack(specValue)
+		if err != nil {
+			return nil, err
+		}
+		spec := &partitionSpec{Strategy: specTuple[0].(string), Column: specTuple[1].(string)}
+
+		ri := rtr.GetRange(partitionSS.Sub(name), fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			t, _ := partitionSS.Unpack(kv.Key)
+			b, err := tuple.Unpack(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			// Note: deconstruct the key from catalog/partition/measurement/measurement_1
+			p := partitionBound{Name: t[1].(string)}
+			switch b[0].(string) {
+			case "d":
+				p.IsDefault = true
+			case "l":
+				for _, v := range b[1:] {
+					p.Values = append(p.Values, v.(string))
+				}
+			case "r":
+				if b[1] != nil {
+					lower := b[1].(string)
+					p.Lower = &lower
+				}
+				if b[2] != nil {
+					upper := b[2].(string)
+					p.Upper = &upper
+				}
+			}
+			spec.Partitions = append(spec.Partitions, p)
+		}
+		return spec, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get partition spec: %w", err)
+	}
+
+	if spec == nil {
+		return nil, nil
+	}
+	return spec.(*partitionSpec), nil
+}
+
+// Check if a partition key value belongs to the partition.
+func (p partitionBound) contains(colType string, value string) (bool, error) {
+	v, err := parseCell(colType, value)
+	if err != nil {
+		return false, err
+	}
+
+	for _, lv := range p.Values {
+		c, err := compareValues(v, lv, nil)
+		if err != nil {
+			return false, err
+		}
+		if c == 0 {
+			return true, nil
+		}
+	}
+
+	if p.Values != nil || (p.Lower == nil && p.Upper == nil) {
+		return false, nil
+	}
+
+	if p.Lower != nil {
+		if c, err := compareValues(v, *p.Lower, nil); err != nil || c < 0 {
+			return false, err
+		}
+	}
+
+	if p.Upper != nil {
+		if c, err := compareValues(v, *p.Upper, nil); err != nil || c >= 0 {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+/*
+
+Find the partition an inserted row belongs to. Rows that match no partition go to the
+default partition if there is one.
+
+*/
+
+func (spec partitionSpec) route(tbl *tableDefinition, value *string) (string, error) {
+	colType, _ := tbl.columnType(spec.Column)
+	defaultPartition := ""
+	for _, p := range spec.Partitions {
+		if p.IsDefault {
+			defaultPartition = p.Name
+			continue
+		}
+
+		// Note: a NULL key is in no range or list, it can only go to the default partition
+		if value == nil {
+			continue
+		}
+
+		ok, err := p.contains(colType, *value)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return p.Name, nil
+		}
+	}
+
+	if defaultPartition == "" {
+		return "", fmt.Errorf("no partition of relation \"%s\" found for row", tbl.Name)
+	}
+	return defaultPartition, nil
+}
+
+type partitionKeyConstraint struct {
+	op    string
+	value any
+	coll  *collation
+}
+
+// Flip the operator so that the partition key is always on the left side.
+var commutedOps = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+/*
+
+Collect the constraints the WHERE clause puts on the partition key. Only top level
+conjunctions of `key <op> constant` are considered, anything else is left to the filter
+that runs after the scan.
+
+*/
+
+func partitionKeyConstraints(where *pgquery.Node, column string) []partitionKeyConstraint {
+	if where == nil {
+		return nil
+	}
+
+	if b := where.GetBoolExpr(); b != nil {
+		if b.Boolop != pgquery.BoolExprType_AND_EXPR {
+			return nil
+		}
+
+		var constraints []partitionKeyConstraint
+		for _, arg := range b.Args {
+			constraints = append(constraints, partitionKeyConstraints(arg, column)...)
+		}
+		return constraints
+	}
+
+	a := where.GetAExpr()
+	if a == nil || a.Kind != pgquery.A_Expr_Kind_AEXPR_OP || len(a.Name) != 1 {
+		return nil
+	}
+
+	op := a.Name[0].GetString_().GetStr()
+	if _, ok := commutedOps[op]; !ok {
+		return nil
+	}
+
+	isKey := func(n *pgquery.Node) bool {
+		c := n.GetColumnRef()
+		return c != nil && c.Fields[len(c.Fields)-1].GetString_().GetStr() == column
+	}
+
+	col, constant := a.Lexpr, a.Rexpr
+	if !isKey(col) {
+		col, constant = a.Rexpr, a.Lexpr
+		op = commutedOps[op]
+	}
+
+	if !isKey(col) || constant.GetAConst() == nil {
+		return nil
+	}
+
+	value, err := constValue(constant.GetAConst())
+	if err != nil || value == nil {
+		return nil
+	}
+
+	coll, err := operatorCollation(a)
+	if err != nil {
+		return nil
+	}
+	return []partitionKeyConstraint{{op, value, coll}}
+}
+
+// Check if any row in the partition could satisfy the constraint. When unsure, keep the partition.
+func (p partitionBound) mayMatch(colType string, c partitionKeyConstraint) bool {
+	if p.IsDefault {
+		return true
+	}
+
+	value := c.value
+	if s, ok := value.(string); ok {
+		v, err := parseCell(colType, s)
+		if err != nil {
+			return true
+		}
+		value = v
+	}
+
+	// Note: bounds are in byte order, text ranges in another collation can't be pruned
+	if _, ok := value.(string); ok && c.coll != nil && c.op != "=" {
+		return true
+	}
+
+	if p.Values != nil {
+		for _, lv := range p.Values {
+			v, err := parseCell(colType, lv)
+			if err != nil {
+				return true
+			}
+			ok, err := compareOp(c.op, v, value, nil)
+			if err != nil || ok.(bool) {
+				return true
+			}
+		}
+		return false
+	}
+
+	lowerCmp, upperCmp := 1, -1
+	if p.Lower != nil {
+		lower, err := parseCell(colType, *p.Lower)
+		if err != nil {
+			return true
+		}
+		if lowerCmp, err = compareValues(value, lower, nil); err != nil {
+			return true
+		}
+	}
+	if p.Upper != nil {
+		upper, err := parseCell(colType, *p.Upper)
+		if err != nil {
+			return true
+		}
+		if upperCmp, err = compareValues(value, upper, nil); err != nil {
+			return true
+		}
+	}
+
+	// Partitions cover [lower, upper)
+	switch c.op {
+	case "=":
+		return lowerCmp >= 0 && upperCmp < 0
+	case "<":
+		return lowerCmp > 0
+	case "<=":
+		return lowerCmp >= 0
+	case ">", ">=":
+		return upperCmp < 0
+	}
+	return true
+}
+
+/*
+
+Partition pruning: return the partitions of a table that may contain rows matching the
+WHERE clause.
+
+Example:
+
+```sql
+select value from measurement where day >= 120;
+```
+
+Only scans data/table_data/measurement_2.
+
+*/
+
+func (spec partitionSpec) prune(tbl *tableDefinition, where *pgquery.Node) []string {
+	colType, _ := tbl.columnType(spec.Column)
+	constraints := partitionKeyConstraints(where, spec.Column)
+
+	var names []string
+	for _, p := range spec.Partitions {
+		keep := true
+		for _, c := range constraints {
+			if !p.mayMatch(colType, c) {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			names = append(names, p.Name)
+		} else {
+			log.Printf("Pruned partition %s", p.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Emulation of the pg_catalog and information_schema relations that tools query to
+discover the schema. These relations are not stored, their rows are built from the
+FoundationDB catalog on every select.
+
+*/
+
+type catalogRelation struct {
+	columnNames []string
+	columnTypes []string
+	rows        func(pe pgEngine) ([]row, error)
+}
+
+var catalogRelations = map[string]catalogRelation{
+	"pg_catalog.pg_class": {
+		columnNames: []string{"oid", "relname", "relnamespace", "relkind", "reltuples"},
+		columnTypes: []string{"pg_catalog.int4", "text", "pg_catalog.int4", "text", "pg_catalog.float4"},
+		rows:        pgClassRows,
+	},
+	"pg_catalog.pg_namespace": {
+		columnNames: []string{"oid", "nspname"},
+		columnTypes: []string{"pg_catalog.int4", "text"},
+		rows:        pgNamespaceRows,
+	},
+	"pg_catalog.pg_description": {
+		columnNames: []string{"objoid", "classoid", "objsubid", "description"},
+		columnTypes: []string{"pg_catalog.int4", "pg_catalog.int4", "pg_catalog.int4", "text"},
+		rows:        pgDescriptionRows,
+	},
+	"pg_catalog.pg_type": {
+		columnNames: []string{"oid", "typname", "typnamespace", "typlen", "typtype", "typelem", "typarray"},
+		columnTypes: []string{"pg_catalog.int4", "text", "pg_catalog.int4", "pg_catalog.int2", "text", "pg_catalog.int4", "pg_catalog.int4"},
+		rows:        pgTypeRows,
+	},
+	"pg_catalog.pg_prepared_xacts": {
+		columnNames: []string{"gid", "prepared"},
+		columnTypes: []string{"text", "text"},
+		rows:        pgPreparedXactsRows,
+	},
+	"pg_catalog.pg_stat_activity": {
+		columnNames: []string{"datname", "pid", "usename", "application_name", "client_addr", "backend_start", "query_start", "state", "query"},
+		columnTypes: []string{"text", "pg_catalog.int4", "text", "text", "text", "pg_catalog.timestamptz", "pg_catalog.timestamptz", "text", "text"},
+		rows:        pgStatActivityRows,
+	},
+	"pg_catalog.pg_stats": {
+		columnNames: []string{"schemaname", "tablename", "attname", "null_frac", "n_distinct", "histogram_bounds"},
+		columnTypes: []string{"text", "text", "text", "pg_catalog.float4", "pg_catalog.float4", "text"},
+		rows:        pgStatsRows,
+	},
+	"pg_catalog.pg_stat_user_tables": {
+		columnNames: []string{"relid", "schemaname", "relname", "n_live_tup"},
+		columnTypes: []string{"pg_catalog.int4", "text", "text", "pg_catalog.int8"},
+		rows:        pgStatUserTablesRows,
+	},
+	"information_schema.tables": {
+		columnNames: []string{"table_schema", "table_name", "table_type"},
+		columnTypes: []string{"text", "text", "text"},
+		rows:        informationSchemaTablesRows,
+	},
+	"information_schema.columns": {
+		columnNames: []string{"table_schema", "table_name", "column_name", "ordinal_position", "data_type", "domain_name"},
+		columnTypes: []string{"text", "text", "text", "pg_catalog.int4", "text", "text"},
+		rows:        informationSchemaColumnsRows,
+	},
+}
+
+const (
+	pgCatalogNamespaceOid = 11
+	publicNamespaceOid    = 2200
+	pgClassOid            = 1259
+	firstNormalOid        = 16384
+)
+
+// Note: information_schema gets its OID when Postgres is installed, any OID below firstNormalOid will do
+const informationSchemaNamespaceOid = 13000
+
+// Relations referenced without a schema resolve to pg_catalog, like the default search_path.
+func lookupCatalogRelation(rv *pgquery.RangeVar) (catalogRelation, bool) {
+	schema := rv.GetSchemaname()
+	if schema == "" {
+		schema = "pg_catalog"
+	}
+
+	rel, ok := catalogRelations[schema+"."+rv.GetRelname()]
+	return rel, ok
+}
+
+func (pe pgEngine) executeCatalogSelect(stmt *pgquery.SelectStmt, rel catalogRelation) (*pgResult, error) {
+	tbl := &tableDefinition{
+		Name:        stmt.FromClause[0].GetRangeVar().Relname,
+		ColumnNames: rel.columnNames,
+		ColumnTypes: rel.columnTypes,
+	}
+
+	results, err := selectTargets(stmt, tbl)
+	if err != nil {
+		return nil, err
+	}
+	if err := results.setLimit(stmt); err != nil {
+		return nil, err
+	}
+
+	rows, err := rel.rows(pe)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, results.addRows(stmt, tbl, rows)
+}
+
+// Tables don't have stored OIDs, derive a stable one from the name.
+func relationOid(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return firstNormalOid + h.Sum32()%(1<<31-firstNormalOid)
+}
+
+func oidString(oid uint32) string {
+	return strconv.FormatUint(uint64(oid), 10)
+}
+
+// Name of a type as information_schema reports it.
+func sqlTypeName(colType string) string {
+	colType, _ = splitTypeMods(colType)
+	if isArrayType(colType) {
+		return "ARRAY"
+	}
+	if t, ok := lookupType(colType); ok {
+		return t.SQLName
+	}
+	return strings.TrimPrefix(colType, "pg_catalog.")
+}
+
+/*
+
+List the names of all tables. Tables are marked by a key with just the table name in the
+catalog/table subspace, the column keys next to them are skipped.
+
+*/
+
+func (pe pgEngine) getTableNames() ([]string, error) {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableSS := catalogDir.Sub("table")
+
+	var names []string
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		ri := rtr.GetRange(tableSS, fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			t, _ := tableSS.Unpack(kv.Key)
+			if len(t) == 1 {
+				names = append(names, t[0].(string))
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list tables: %w", err)
+	}
+
+	return names, nil
+}
+
+func pgClassRows(pe pgEngine) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, name := range names {
+		relkind := "r"
+		spec, err := pe.getPartitionSpec(name)
+		if err != nil {
+			return nil, err
+		}
+		if spec != nil {
+			relkind = "p"
+		}
+		stats, err := pe.getTableStats(name)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, row{
+			"oid":          oidString(relationOid(name)),
+			"relname":      name,
+			"relnamespace": oidString(publicNamespaceOid),
+			"relkind":      relkind,
+			"reltuples":    strconv.FormatInt(stats.rows, 10),
+		})
+	}
+	return rows, nil
+}
+
+func pgNamespaceRows(pe pgEngine) ([]row, error) {
+	return []row{
+		{"oid": oidString(pgCatalogNamespaceOid), "nspname": "pg_catalog"},
+		{"oid": oidString(publicNamespaceOid), "nspname": "public"},
+		{"oid": oidString(informationSchemaNamespaceOid), "nspname": "information_schema"},
+	}, nil
+}
+
+func pgDescriptionRows(pe pgEngine) ([]row, error) {
+	comments, err := pe.getComments()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, c := range comments {
+		// Note: objsubid is the column number, 0 for comments on the table itself
+		objsubid := 0
+		if c.Column != "" {
+			tbl, err := pe.getTableDefinition(c.Table)
+			if err != nil {
+				return nil, err
+			}
+			for i, cn := range tbl.ColumnNames {
+				if cn == c.Column {
+					objsubid = i + 1
+				}
+			}
+		}
+
+		rows = append(rows, row{
+			"objoid":      oidString(relationOid(c.Table)),
+			"classoid":    oidString(pgClassOid),
+			"objsubid":    strconv.Itoa(objsubid),
+			"description": c.Description,
+		})
+	}
+	return rows, nil
+}
+
+/*
+
+The builtin types from the type registry, each followed by its array type, and the enums and
+composite types in the catalog. Array types are named after their element type with a leading
+underscore, like Postgres names them.
+
+*/
+
+func pgTypeRows(pe pgEngine) ([]row, error) {
+	var rows []row
+	for _, t := range pgTypes {
+		name := strings.TrimPrefix(t.Name, "pg_catalog.")
+		rows = append(rows, row{
+			"oid":          oidString(t.Oid),
+			"typname":      name,
+			"typnamespace": oidString(pgCatalogNamespaceOid),
+			"typlen":       strconv.Itoa(int(t.Len)),
+			"typtype":      "b",
+			"typelem":      "0",
+			"typarray":     oidString(t.ArrayOid),
+		})
+		if t.ArrayOid == 0 {
+			continue
+		}
+		rows = append(rows, row{
+			"oid":          oidString(t.ArrayOid),
+			"typname":      "_" + name,
+			"typnamespace": oidString(pgCatalogNamespaceOid),
+			"typlen":       "-1",
+			"typtype":      "b",
+			"typelem":      oidString(t.Oid),
+			"typarray":     "0",
+		})
+	}
+
+	enums, err := pe.getEnums()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range enums {
+		rows = append(rows, row{
+			"oid":          oidString(e.Oid),
+			"typname":      e.Name,
+			"typnamespace": oidString(publicNamespaceOid),
+			"typlen":       "4",
+			"typtype":      "e",
+			"typelem":      "0",
+			"typarray":     "0",
+		})
+	}
+
+	composites, err := pe.getComposites()
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range composites {
+		rows = append(rows, row{
+			"oid":          oidString(ct.Oid),
+			"typname":      ct.Name,
+			"typnamespace": oidString(publicNamespaceOid),
+			"typlen":       "-1",
+			"typtype":      "c",
+			"typelem":      "0",
+			"typarray":     "0",
+		})
+	}
+	return rows, nil
+}
+
+func pgPreparedXactsRows(pe pgEngine) ([]row, error) {
+	xacts, err := pe.getPreparedTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, x := range xacts {
+		rows = append(rows, row{"gid": x.Gid, "prepared": x.Prepared})
+	}
+	return rows, nil
+}
+
+func informationSchemaTablesRows(pe pgEngine) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, name := range names {
+		rows = append(rows, row{
+			"table_schema": "public",
+			"table_name":   name,
+			"table_type":   "BASE TABLE",
+		})
+	}
+	return rows, nil
+}
+
+func informationSchemaColumnsRows(pe pgEngine) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+	for _, name := range names {
+		tbl, err := pe.getTableDefinition(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, cn := range tbl.ColumnNames {
+			r := row{
+				"table_schema":     "public",
+				"table_name":       name,
+				"column_name":      cn,
+				"ordinal_position": strconv.Itoa(i + 1),
+				"data_type":        sqlTypeName(tbl.ColumnTypes[i]),
+			}
+			if d := tbl.ColumnDomains[i]; d != nil {
+				r["domain_name"] = d.Name
+			}
+			if tbl.ColumnEnums[i] != nil || tbl.ColumnComposites[i] != nil {
+				r["data_type"] = "USER-DEFINED"
+			}
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
@@ -1,30 +1,46 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
 	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
-	"github.com/google/uuid"
 	pgquery "github.com/pganalyze/pg_query_go/v2"
 )
 
 type pgEngine struct {
 	db fdb.Transactor
+	// The database tenants are created in with -tenants, nil without
+	tenants *fdb.Database
+	// The database and the schema the directories of the engine are in
+	database string
+	schema   string
+	// The context of the query the engine runs, nil if it can't be canceled
+	ctx context.Context
+	// Sends notices to the client, nil if there is none
+	notice func(notice)
+	// The session the engine runs for, nil if it runs for none
+	session *session
 }
 
-func newPgEngine(db fdb.Transactor) pgEngine {
-	return pgEngine{db}
+func newPgEngine(db fdb.Transactor, database string) pgEngine {
+	return pgEngine{db: db, database: database}
 }
 
-func (pe pgEngine) execute(tree pgquery.ParseResult) error {
+// Execute the statement, returning the number of rows it inserted or deleted. Statements that
+// change the catalog bump its version, see session.
+func (pe pgEngine) execute(tree *pgquery.ParseResult) (int, error) {
 	for _, stmt := range tree.GetStmts() {
 		n := stmt.GetStmt()
 		if c := n.GetCreateStmt(); c != nil {
-			return pe.executeCreate(c)
+			return 0, pe.changeCatalog(func(pe pgEngine) error { return pe.executeCreate(c) })
 		}
 
 		if c := n.GetInsertStmt(); c != nil {
@@ -35,19 +51,128 @@ func (pe pgEngine) execute(tree pgquery.ParseResult) error {
 			return pe.executeDelete(c)
 		}
 
+		if c := n.GetCommentStmt(); c != nil {
+			return 0, pe.changeCatalog(func(pe pgEngine) error { return pe.executeComment(c) })
+		}
+
+		if c := n.GetAlterTableStmt(); c != nil {
+			return 0, pe.executeAlterTable(c)
+		}
+
+		if c := n.GetDropStmt(); c != nil && c.RemoveType != pgquery.ObjectType_OBJECT_SCHEMA {
+			return 0, pe.changeCatalog(func(pe pgEngine) error { return pe.executeDrop(c) })
+		}
+
+		if c := n.GetIndexStmt(); c != nil {
+			return 0, pe.withPriority(priorityBatch).executeCreateIndex(c)
+		}
+
+		if c := n.GetCreateDomainStmt(); c != nil {
+			return 0, pe.changeCatalog(func(pe pgEngine) error { return pe.executeCreateDomain(c) })
+		}
+
+		if c := n.GetCreateEnumStmt(); c != nil {
+			return 0, pe.changeCatalog(func(pe pgEngine) error { return pe.executeCreateEnum(c) })
+		}
+
+		if c := n.GetCompositeTypeStmt(); c != nil {
+			return 0, pe.changeCatalog(func(pe pgEngine) error { return pe.executeCreateComposite(c) })
+		}
+
+		if c := n.GetCreateSchemaStmt(); c != nil {
+			return 0, pe.executeCreateSchema(c)
+		}
+
+		if c := n.GetDropStmt(); c != nil && c.RemoveType == pgquery.ObjectType_OBJECT_SCHEMA {
+			return 0, pe.executeDropSchema(c)
+		}
+
+		if c := n.GetCreatedbStmt(); c != nil {
+			return 0, pe.executeCreateDatabase(c)
+		}
+
+		if c := n.GetDropdbStmt(); c != nil {
+			return 0, pe.executeDropDatabase(c)
+		}
+
+		if c := n.GetCreateRoleStmt(); c != nil {
+			return 0, pe.executeCreateRole(c)
+		}
+
+		if c := n.GetDropRoleStmt(); c != nil {
+			return 0, pe.executeDropRole(c)
+		}
+
+		if c := n.GetVacuumStmt(); c != nil && isAnalyze(c) {
+			return 0, pe.withPriority(priorityBatch).executeAnalyze(c)
+		}
+
 		if c := n.GetSelectStmt(); c != nil {
 			_, err := pe.executeSelect(c)
-			return err
+			return 0, err
 		}
 	}
 
-	return nil
+	return 0, nil
 }
 
 type tableDefinition struct {
 	Name        string
 	ColumnNames []string
 	ColumnTypes []string
+	// The domain of every column, nil for columns of a plain type. ColumnTypes holds the base type of domains.
+	ColumnDomains []*domain
+	// The enum of every column, nil for columns that aren't of an enum type. ColumnTypes holds the enum name.
+	ColumnEnums []*enumType
+	// The composite type of every column, nil for columns that aren't of a composite type.
+	ColumnComposites []*compositeType
+	// Which of the row and columnar layouts the table keeps, see layout.go
+	Layout string
+	// The layout being built by a change of the layout, rows are written to it but not read from it, see layoutChange.go
+	Building string
+	// How long after they are inserted rows expire, nil if they don't, see ttl.go
+	TTL *interval
+	// The id of every column in the keys of its cells, 0 for tables keyed by column name, see columnId.go
+	ColumnIDs []int64
+	// The column of the primary key, empty if the table has none, see primaryKey.go
+	PrimaryKey string
+	// The catalog version the definition was read at, see catalogCache.go
+	Version int64
+}
+
+// The type as declared for the column, the domain name for domains.
+func (tbl tableDefinition) declaredType(i int) string {
+	if i < len(tbl.ColumnDomains) && tbl.ColumnDomains[i] != nil {
+		return tbl.ColumnDomains[i].Name
+	}
+	return tbl.ColumnTypes[i]
+}
+
+func (tbl tableDefinition) columnEnum(name string) *enumType {
+	for i, cn := range tbl.ColumnNames {
+		if cn == name && i < len(tbl.ColumnEnums) {
+			return tbl.ColumnEnums[i]
+		}
+	}
+	return nil
+}
+
+func (tbl tableDefinition) columnComposite(name string) *compositeType {
+	for i, cn := range tbl.ColumnNames {
+		if cn == name && i < len(tbl.ColumnComposites) {
+			return tbl.ColumnComposites[i]
+		}
+	}
+	return nil
+}
+
+func (tbl tableDefinition) columnType(name string) (string, bool) {
+	for i, cn := range tbl.ColumnNames {
+		if cn == name {
+			return tbl.ColumnTypes[i], true
+		}
+	}
+	return "", false
 }
 
 /*
@@ -76,7 +201,100 @@ func (pe pgEngine) executeCreate(stmt *pgquery.CreateStmt) error {
 	tbl := tableDefinition{}
 	tbl.Name = stmt.Relation.Relname
 
-	catalogDir, err := directory.CreateOrOpen(pe.db, []string{"catalog"}, nil)
+	layout, err := layoutOption(stmt.Options)
+	if err != nil {
+		return err
+	}
+	tbl.Layout = layout
+	if tbl.Layout == "" {
+		tbl.Layout = layoutHybrid
+	}
+	if tbl.TTL, err = ttlOption(stmt.Options); err != nil {
+		return err
+	}
+	if tbl.PrimaryKey, err = primaryKeyOption(stmt); err != nil {
+		return err
+	}
+
+	// Note: partitions don't declare columns, they take the columns of the partitioned table
+	parent := ""
+	if stmt.Partbound != nil {
+		parent = stmt.InhRelations[0].GetRangeVar().Relname
+		parentSpec, err := pe.getPartitionSpec(parent)
+		if err != nil {
+			return err
+		}
+		if parentSpec == nil {
+			return fmt.Errorf("table \"%s\" is not partitioned", parent)
+		}
+
+		parentTbl, err := pe.getTableDefinition(parent)
+		if err != nil {
+			return err
+		}
+		tbl.ColumnNames = parentTbl.ColumnNames
+		tbl.ColumnIDs = parentTbl.ColumnIDs
+		for i := range parentTbl.ColumnNames {
+			tbl.ColumnTypes = append(tbl.ColumnTypes, parentTbl.declaredType(i))
+		}
+
+		// Note: rows are written and read with the layout of the partitioned table
+		if layout != "" && layout != parentTbl.Layout {
+			return fmt.Errorf("partition must have the layout of its partitioned table \"%s\", %s", parent, parentTbl.Layout)
+		}
+		tbl.Layout = parentTbl.Layout
+
+		// Note: the rows of partitions expire with the ttl of the partitioned table
+		if tbl.TTL != nil {
+			return fmt.Errorf("partition can't have a ttl, its rows expire with the ttl of its partitioned table \"%s\"", parent)
+		}
+		tbl.PrimaryKey = parentTbl.PrimaryKey
+	}
+
+	for _, c := range stmt.TableElts {
+		cd := c.GetColumnDef()
+		// Note: table constraints, like a primary key, are read by their options
+		if cd == nil {
+			continue
+		}
+
+		colType := typeNameString(cd.TypeName)
+		if isNumericType(colType) {
+			if _, _, err := numericTypeMods(colType); err != nil {
+				return err
+			}
+		}
+
+		tbl.ColumnNames = append(tbl.ColumnNames, cd.Colname)
+		tbl.ColumnTypes = append(tbl.ColumnTypes, colType)
+	}
+
+	if parent == "" {
+		tbl.ColumnIDs = newColumnIDs(tbl.ColumnNames)
+	}
+
+	// Note: the rows of a columnar table are found in the cells of its first column
+	if tbl.Layout == layoutColumnar && len(tbl.ColumnNames) == 0 {
+		return fmt.Errorf("a table with the columnar layout must have columns")
+	}
+
+	if tbl.PrimaryKey != "" {
+		if _, ok := tbl.columnType(tbl.PrimaryKey); !ok {
+			return &pgError{Code: sqlStateUndefinedColumn, Message: fmt.Sprintf("column \"%s\" named in key does not exist", tbl.PrimaryKey)}
+		}
+	}
+
+	if stmt.Partspec != nil {
+		if _, ok := tbl.columnType(stmt.Partspec.PartParams[0].GetPartitionElem().GetName()); !ok {
+			return fmt.Errorf("column \"%s\" named in partition key does not exist", stmt.Partspec.PartParams[0].GetPartitionElem().GetName())
+		}
+		// Note: the entries of keys are kept per partition, see primaryKey.go
+		if tbl.PrimaryKey != "" && tbl.PrimaryKey != stmt.Partspec.PartParams[0].GetPartitionElem().GetName() {
+			return &pgError{Code: sqlStateFeatureNotSupported, Message: "unique constraint on partitioned table must include all partitioning columns"}
+		}
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -86,37 +304,94 @@ func (pe pgEngine) executeCreate(stmt *pgquery.CreateStmt) error {
 	_, err = pe.db.Transact(func(tr fdb.Transaction) (ret interface{}, err error) {
 
 		if tr.Get(tableKey).MustGet() != nil {
-			log.Printf("Table %s already exists", tbl.Name)
-			return
+			if stmt.IfNotExists {
+				pe.raiseNotice(sqlStateDuplicateTable, "relation \"%s\" already exists, skipping", tbl.Name)
+				return
+			}
+			return nil, &pgError{Code: sqlStateDuplicateTable, Message: fmt.Sprintf("relation \"%s\" already exists", tbl.Name)}
 		}
 
 		// Note: table exists, marked by empty value and table name as key
-		tr.Set(tableSS.Pack(tuple.Tuple{tbl.Name}), []byte(""))
+		writes(tr).Set(tableSS.Pack(tuple.Tuple{tbl.Name}), []byte(""))
 
-		for _, c := range stmt.TableElts {
-			cd := c.GetColumnDef()
+		for i, columnName := range tbl.ColumnNames {
+			writes(tr).Set(tableSS.Pack(tuple.Tuple{tbl.Name, columnName}), []byte(tbl.ColumnTypes[i]))
+		}
+		pe.setTableLayout(tr, tbl.Name, tbl.Layout)
+		pe.setTableTTL(tr, tbl.Name, tbl.TTL)
+		pe.setColumnIDs(tr, &tbl)
+		pe.setPrimaryKey(tr, tbl.Name, tbl.PrimaryKey)
 
-			// Names is namespaced. So `INT` is pg_catalog.int4. `BIGINT` is pg_catalog.int8.
-			var columnType string
-			for _, n := range cd.TypeName.Names {
-				if columnType != "" {
-					columnType += "."
-				}
-				columnType += n.GetString_().Str
-			}
-			tr.Set(tableSS.Pack(tuple.Tuple{tbl.Name, cd.Colname}), []byte(columnType))
+		if stmt.Partspec != nil {
+			err = pe.createPartitionSpec(tr, tbl.Name, stmt.Partspec)
+		}
+
+		if stmt.Partbound != nil {
+			err = pe.createPartition(tr, parent, tbl.Name, stmt.Partbound)
+			pe.recordDependency(tr, parent, "table", tbl.Name, dependencyAuto)
 		}
 
 		return
 	})
 
 	if err != nil {
-		return fmt.Errorf("could not create table: %s", err)
+		return fmt.Errorf("could not create table: %w", err)
 	}
 
 	return nil
 }
 
+// Names is namespaced. So `INT` is pg_catalog.int4. `BIGINT` is pg_catalog.int8.
+func typeNameString(tn *pgquery.TypeName) string {
+	var columnType string
+	for _, n := range tn.Names {
+		if columnType != "" {
+			columnType += "."
+		}
+		columnType += n.GetString_().Str
+	}
+
+	// Note: builtin types are stored by their normalized name, `INT4` and `INTEGER` are both pg_catalog.int4
+	if t, ok := lookupType(columnType); ok && (len(tn.Names) == 1 || strings.HasPrefix(columnType, "pg_catalog.")) {
+		columnType = t.Name
+	}
+
+	// Note: type modifiers are kept with the type, `NUMERIC(10, 2)` is pg_catalog.numeric(10,2)
+	if len(tn.Typmods) > 0 {
+		var mods []string
+		for _, m := range tn.Typmods {
+			mods = append(mods, strconv.Itoa(int(m.GetAConst().GetVal().GetInteger().GetIval())))
+		}
+		columnType += "(" + strings.Join(mods, ",") + ")"
+	}
+
+	// Note: like Postgres, the number of dimensions isn't part of an array type, `INT[][]` is pg_catalog.int4[]
+	if len(tn.ArrayBounds) > 0 {
+		columnType += "[]"
+	}
+	return columnType
+}
+
+// Split the type modifiers off a type as written by typeNameString. The mods of an array type are those of its elements.
+func splitTypeMods(colType string) (string, []int) {
+	if elemType, ok := arrayElemType(colType); ok {
+		base, mods := splitTypeMods(elemType)
+		return base + "[]", mods
+	}
+
+	i := strings.IndexByte(colType, '(')
+	if i < 0 || !strings.HasSuffix(colType, ")") {
+		return colType, nil
+	}
+
+	var mods []int
+	for _, m := range strings.Split(colType[i+1:len(colType)-1], ",") {
+		mod, _ := strconv.Atoi(m)
+		mods = append(mods, mod)
+	}
+	return colType[:i], mods
+}
+
 /*
 
 Get the table definition from the database. This can be done with a single range query.
@@ -125,35 +400,114 @@ Get the table definition from the database. This can be done with a single range
 
 func (pe pgEngine) getTableDefinition(name string) (*tableDefinition, error) {
 	var tbl tableDefinition
-
-	// TODO: check if table exists, etc.
 	tbl.Name = name
 
-	catalogDir, err := directory.CreateOrOpen(pe.db, []string{"catalog"}, nil)
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	tableSS := catalogDir.Sub("table")
 
-	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
-		ri := rtr.GetRange(tableSS.Sub(name), fdb.RangeOptions{
+	// Note: outside of transaction blocks the catalog cache of the server is current while its watch is set
+	var shared *catalogCache
+	if ks, ok := pe.catalogCacheKeyspace(); ok {
+		shared = catalogCaches.get(pe, ks)
+		if cached := shared.table(name); cached != nil {
+			return cached, nil
+		}
+	}
+
+	var version int64
+	cached, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		version = pe.catalogVersion(rtr)
+		if cached := pe.cachedTableDefinition(name, version); cached != nil {
+			return cached, nil
+		}
+
+		// Note: reads that don't depend on each other are issued together and waited for after,
+		// the definition takes a round trip for the table and one for the types of its columns
+		exists := rtr.Get(tableSS.Pack(tuple.Tuple{name}))
+		layout := pe.readTableLayout(rtr, name)
+		building := pe.readLayoutBuild(rtr, name)
+		ttl := pe.readTableTTL(rtr, name)
+		columnIDs := pe.readColumnIDs(rtr, name)
+		primaryKey := pe.readPrimaryKey(rtr, name)
+		kvs := rtr.GetRange(tableSS.Sub(name), fdb.RangeOptions{
 			Mode: fdb.StreamingModeWantAll,
-		}).Iterator()
-		for ri.Advance() {
-			kv := ri.MustGet()
+		}).GetSliceOrPanic()
+		if exists.MustGet() == nil {
+			return nil, undefinedTable(name)
+		}
+		tbl.Version = version
+		tbl.Layout = layout()
+		tbl.Building = building().layout
+		tbl.TTL = ttl()
+		tbl.PrimaryKey = primaryKey()
+		ids := columnIDs()
+
+		type columnType struct {
+			domain    func() (*domain, error)
+			enum      func() (*enumType, error)
+			composite func() (*compositeType, error)
+		}
+		readType := func(colType string) columnType {
+			return columnType{pe.readDomain(rtr, colType), pe.readEnum(rtr, colType), pe.readComposite(rtr, colType)}
+		}
+		var types []columnType
+		for _, kv := range kvs {
 			t, _ := tableSS.Unpack(kv.Key)
 
 			// Note: deconstruct the key from catalog/table/user/age and extract the column name
 			tbl.ColumnNames = append(tbl.ColumnNames, t[1].(string))
 			tbl.ColumnTypes = append(tbl.ColumnTypes, string(kv.Value))
+			tbl.ColumnIDs = append(tbl.ColumnIDs, ids[t[1].(string)])
+			types = append(types, readType(string(kv.Value)))
+		}
+
+		for i, ct := range types {
+			d, err := ct.domain()
+			if err != nil {
+				return nil, err
+			}
+			// Note: the enum or composite type of a domain is its base type's, which takes another round trip
+			if d != nil {
+				tbl.ColumnTypes[i] = d.BaseType
+				types[i] = readType(d.BaseType)
+			}
+			tbl.ColumnDomains = append(tbl.ColumnDomains, d)
+		}
+
+		for _, ct := range types {
+			e, err := ct.enum()
+			if err != nil {
+				return nil, err
+			}
+			tbl.ColumnEnums = append(tbl.ColumnEnums, e)
+
+			c, err := ct.composite()
+			if err != nil {
+				return nil, err
+			}
+			tbl.ColumnComposites = append(tbl.ColumnComposites, c)
 		}
 		return nil, nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not get table defn: %s", err)
+		return nil, fmt.Errorf("could not get table defn: %w", err)
+	}
+	if cached != nil {
+		if shared != nil {
+			shared.put(cached.(*tableDefinition), version)
+		}
+		return cached.(*tableDefinition), nil
+	}
+
+	pe.cacheTableDefinition(&tbl, version)
+	if shared != nil {
+		shared.put(&tbl, version)
 	}
-	return &tbl, err
+	return &tbl, nil
 }
 
 /*
@@ -202,132 +556,608 @@ data/table_data/user/name/34e7ff77-1bed-4ebd-be56-4b966e67c595: ted
 And reading them in select would be easier.
 */
 
-func (pe pgEngine) executeInsert(stmt *pgquery.InsertStmt) error {
+func (pe pgEngine) executeInsert(stmt *pgquery.InsertStmt) (int, error) {
 	tblName := stmt.Relation.Relname
 	slct := stmt.GetSelectStmt().GetSelectStmt()
 
 	tbl, err := pe.getTableDefinition(tblName)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	doNothing, err := onConflictDoNothing(stmt, tbl)
+	if err != nil {
+		return 0, err
+	}
+
+	var values [][]any
+	for _, list := range slct.ValuesLists {
+		if err := pe.checkCanceled(); err != nil {
+			return 0, err
+		}
+
+		items := list.GetList().Items
+		if len(items) > len(tbl.ColumnNames) {
+			return 0, fmt.Errorf("INSERT has more expressions than target columns")
+		}
+		vs := make([]any, len(items))
+		for columnIndex, value := range items {
+			if vs[columnIndex], err = evalExpr(value, &tableDefinition{}, row{}); err != nil {
+				return 0, err
+			}
+		}
+		values = append(values, vs)
 	}
 
-	catalogDir, err := directory.CreateOrOpen(pe.db, []string{"catalog"}, nil)
+	return pe.insertValues(tbl, values, doNothing)
+}
+
+/*
+
+Insert rows of values of the columns of the table, in the order of its columns, the columns
+after the last value of a row are NULL. The values are converted to the types of the columns
+and every row is checked before any is written, so a row that isn't valid writes none of them.
+Returns the number of rows inserted, without those of keys that exist with doNothing.
+
+*/
+
+func (pe pgEngine) insertValues(tbl *tableDefinition, values [][]any, doNothing bool) (int, error) {
+	tblName := tbl.Name
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	tableSS := catalogDir.Sub("table")
 	tableKey := tableSS.Pack(tuple.Tuple{tblName})
 
-	dataDir, err := directory.CreateOrOpen(pe.db, []string{"data"}, nil)
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	tableDataSS := dataDir.Sub("table_data")
+	versionKey := pe.catalogVersionKey()
 
-	_, err = pe.db.Transact(func(tr fdb.Transaction) (ret interface{}, err error) {
-		if tr.Get(tableKey).MustGet() == nil {
-			log.Printf("Table %s does not exist", tblName)
-			return
-		}
-
-		for _, values := range slct.ValuesLists {
-			id := uuid.New().String()
-			columnIndex := 0
-			maxColumnIndex := len(tbl.ColumnNames) - 1
-			for _, value := range values.GetList().Items {
-				if c := value.GetAConst(); c != nil {
-					if s := c.Val.GetString_(); s != nil {
-						// Columnar data
-						tr.Set(tableDataSS.Pack(tuple.Tuple{tblName, "c", tbl.ColumnNames[columnIndex], id}), []byte(s.Str))
-						log.Printf("Inserted key c: %s", tableDataSS.Pack(tuple.Tuple{tblName, "c", tbl.ColumnNames[columnIndex], id}))
-						// Row based data
-						tr.Set(tableDataSS.Pack(tuple.Tuple{tblName, "r", id, tbl.ColumnNames[columnIndex]}), []byte(s.Str))
-						log.Printf("Inserted key r: %s", tableDataSS.Pack(tuple.Tuple{tblName, "r", id, tbl.ColumnNames[columnIndex]}))
-
-						if columnIndex < maxColumnIndex {
-							columnIndex += 1
-						}
-						continue
-					}
+	// Note: rows of a partitioned table are stored in the partition they belong to
+	partitions, err := pe.getPartitionSpec(tblName)
+	if err != nil {
+		return 0, err
+	}
 
-					if i := c.Val.GetInteger(); i != nil {
-						// TODO: better convert in to byte[], with this conversion, it ends up being a string
-						valueJson, _ := json.Marshal(i.Ival)
-						// Columnar data
-						tr.Set(tableDataSS.Pack(tuple.Tuple{tblName, "c", tbl.ColumnNames[columnIndex], id}), valueJson)
-						log.Printf("Inserted key c: %s", tableDataSS.Pack(tuple.Tuple{tblName, "c", tbl.ColumnNames[columnIndex], id}))
-						// Row based data
-						tr.Set(tableDataSS.Pack(tuple.Tuple{tblName, "r", id, tbl.ColumnNames[columnIndex]}), valueJson)
-						log.Printf("Inserted key r: %s", tableDataSS.Pack(tuple.Tuple{tblName, "r", id, tbl.ColumnNames[columnIndex]}))
-
-						if columnIndex < maxColumnIndex {
-							columnIndex += 1
-						}
-						continue
-					}
+	var rows []insertRow
+	keys := map[string]bool{}
+	for _, items := range values {
+		if err := pe.checkCanceled(); err != nil {
+			return 0, err
+		}
+
+		// Note: values are converted to the column type, cells hold their encoding and texts their text form.
+		// Columns without a value are NULL, which is a cell of its own and has no text.
+		cells := make([][]byte, len(tbl.ColumnNames))
+		texts := make([]*string, len(tbl.ColumnNames))
+		r := row{}
+		for columnIndex := range tbl.ColumnNames {
+			cells[columnIndex] = encodeCell(nil)
+			r[tbl.ColumnNames[columnIndex]] = nil
+		}
+
+		for columnIndex, v := range items {
+			colType := tbl.ColumnTypes[columnIndex]
+			if v == nil {
+				continue
+			}
+
+			text, err := castValue(v, colType)
+			if err != nil {
+				return 0, err
+			}
+			typed, err := parseCell(colType, text)
+			if err != nil {
+				return 0, err
+			}
+
+			// Note: records are converted field by field to the types of the fields of their composite type
+			if ct := tbl.ColumnComposites[columnIndex]; ct != nil {
+				if typed, err = ct.value(v); err != nil {
+					return 0, err
+				}
+				text = fmt.Sprint(typed)
+			}
+
+			if e := tbl.ColumnEnums[columnIndex]; e != nil {
+				if _, err := e.order(text); err != nil {
+					return 0, err
 				}
+			}
+
+			texts[columnIndex] = &text
+			cells[columnIndex] = encodeCell(typed)
+			r[tbl.ColumnNames[columnIndex]] = typed
+		}
 
-				return nil, fmt.Errorf("unknown value type: %s", value)
+		for columnIndex, d := range tbl.ColumnDomains {
+			if d == nil {
+				continue
+			}
+
+			if err := d.validate(texts[columnIndex]); err != nil {
+				return 0, err
 			}
 		}
-		return nil, nil
+
+		target := tblName
+		if partitions != nil {
+			var key *string
+			for columnIndex, columnName := range tbl.ColumnNames {
+				if columnName == partitions.Column {
+					key = texts[columnIndex]
+				}
+			}
+
+			target, err = partitions.route(tbl, key)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		ir := insertRow{target: target, cells: cells, values: r}
+		if tbl.PrimaryKey != "" {
+			if ir.key, err = checkPrimaryKey(tableDataSS, tbl, target, r[tbl.PrimaryKey]); err != nil {
+				return 0, err
+			}
+			for columnIndex, columnName := range tbl.ColumnNames {
+				if columnName == tbl.PrimaryKey {
+					ir.keyText = *texts[columnIndex]
+				}
+			}
+			// Note: like a row inserted before, a later row of the statement with the same key conflicts
+			if keys[string(ir.key)] {
+				if doNothing {
+					continue
+				}
+				return 0, uniqueViolation(tbl, ir.keyText)
+			}
+			keys[string(ir.key)] = true
+		}
+		rows = append(rows, ir)
+	}
+
+	statsSS := pe.statsSubspace()
+	insertedAt := time.Now()
+	var indexes []textIndex
+	var rowIDs func(target string) (string, error)
+	var keyReads *primaryKeyReads
+	entries := make([]fdb.Key, len(rows))
+	for i, ir := range rows {
+		entries[i] = ir.key
+	}
+	// Note: the rows of keys that exist are skipped with ON CONFLICT DO NOTHING, by the last attempt of their batch
+	skipped := make([]bool, len(rows))
+	// Note: the rows are written to the layouts of the table as they are in each transaction, they may be changing, see layoutChange.go
+	writeTbl := *tbl
+	begin := func(tr fdb.Transaction) error {
+		// Note: the table and the catalog version are checked while its indexes and layouts are read. The
+		// version is read in the transaction, a change of the catalog committed before it does makes it conflict
+		exists, version := tr.Get(tableKey), tr.Get(versionKey)
+		layout, building := pe.readTableLayout(tr, tblName), pe.readLayoutBuild(tr, tblName)
+		indexes = pe.getTextIndexes(tr, tblName)
+		if exists.MustGet() == nil {
+			return undefinedTable(tblName)
+		}
+		if decodeCatalogVersion(version.MustGet()) != tbl.Version {
+			return errCatalogChanged
+		}
+		writeTbl.Layout, writeTbl.Building = layout(), building().layout
+		rowIDs = pe.newRowIDs(tr, tableDataSS, &writeTbl)
+		keyReads = newPrimaryKeyReads(tr, entries)
+		return nil
+	}
+	if len(rows) >= bulkInsertRows {
+		pe = pe.withPriority(priorityBatch)
+	}
+	inserted, err := pe.transactInBatches(len(rows), begin, func(tr fdb.Transaction, i int) error {
+		if err := pe.checkCanceled(); err != nil {
+			return err
+		}
+
+		ir := rows[i]
+		skipped[i] = false
+		if ir.key != nil && primaryKeyTaken(tr, tableDataSS, ir.target, keyReads.get(i)) {
+			if doNothing {
+				skipped[i] = true
+				return nil
+			}
+			return uniqueViolation(tbl, ir.keyText)
+		}
+
+		id, err := rowIDs(ir.target)
+		if err != nil {
+			return err
+		}
+		if ir.key != nil {
+			setPrimaryKeyEntry(tr, ir.key, id)
+		}
+		countRows(tr, statsSS, ir.target, 1)
+		if tbl.TTL != nil {
+			pe.expireRow(tr, tbl, ir.target, id, insertedAt)
+		}
+		for _, idx := range indexes {
+			if err := pe.indexText(tr, tblName, idx, ir.target, id, ir.values[idx.Column], true); err != nil {
+				return err
+			}
+		}
+
+		// Columnar and row based data
+		setRow(tr, tableDataSS, &writeTbl, ir.target, id, ir.cells)
+		log.Printf("Inserted row: %s", rowKey(ir.target, id))
+		return nil
 	})
+	// Note: the definition was cached before a change of the catalog, the rows are checked again with the current one
+	if errors.Is(err, errCatalogChanged) {
+		catalogCaches.invalidate(pe.database)
+		if tbl, err = pe.getTableDefinition(tblName); err != nil {
+			return 0, err
+		}
+		return pe.insertValues(tbl, values, doNothing)
+	}
 	if err != nil {
-		return fmt.Errorf("could not insert into the table table: %s", err)
+		return 0, fmt.Errorf("could not insert into the table table: %w", err)
 	}
 
-	return nil
+	for _, skip := range skipped[:inserted] {
+		if skip {
+			inserted--
+		}
+	}
+	return inserted, nil
+}
+
+// The error of a write whose table definition is from another catalog version than its transaction.
+var errCatalogChanged = &pgError{Code: sqlStateSerializationFailure, Message: "could not serialize access due to a concurrent change of the table definition"}
+
+// A row of an INSERT, checked and encoded before it is written. Its id is given when it is written.
+type insertRow struct {
+	target string
+	cells  [][]byte
+	values row
+	// The entry of its primary key and the key in its text form, nil if the table has none, see primaryKey.go
+	key     fdb.Key
+	keyText string
 }
 
 /*
 
-Parse the delete statement and delete data from the table.
+Parse the delete statement and delete data from the table, and from its partitions if it is
+partitioned, with range clears (see clearTable.go) or tombstones. Returns the number of rows deleted.
 Currently, this doesn't support where clause and deletes all the data from the table.
 
 */
 
-func (pe pgEngine) executeDelete(stmt *pgquery.DeleteStmt) error {
+func (pe pgEngine) executeDelete(stmt *pgquery.DeleteStmt) (int, error) {
 
-	catalogDir, err := directory.CreateOrOpen(pe.db, []string{"catalog"}, nil)
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	tableSS := catalogDir.Sub("table")
 	tableKey := tableSS.Pack(tuple.Tuple{stmt.Relation.Relname})
 
-	dataDir, err := directory.CreateOrOpen(pe.db, []string{"data"}, nil)
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	tableDataSS := dataDir.Sub("table_data")
 
+	targets := []string{stmt.Relation.Relname}
+	partitions, err := pe.getPartitionSpec(stmt.Relation.Relname)
+	if err != nil {
+		return 0, err
+	}
+	if partitions != nil {
+		for _, p := range partitions.Partitions {
+			targets = append(targets, p.Name)
+		}
+	}
+
+	tbl, err := pe.getTableDefinition(stmt.Relation.Relname)
+	if err != nil {
+		return 0, err
+	}
+
 	// TODO: implement where, delete for now deletes everything from the table
 
-	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
-		if tr.Get(tableKey).MustGet() == nil {
-			log.Printf("Table %s does not exist", stmt.Relation.Relname)
-			return nil, nil
+	// Note: large tables get tombstones for their rows, which a reaper clears later, see tombstone.go
+	tombstones, err := pe.deletesWithTombstones(targets)
+	if err != nil {
+		return 0, err
+	}
+	if !tombstones {
+		deleted, err := pe.clearTable(stmt.Relation.Relname, targets)
+		if err != nil {
+			return 0, fmt.Errorf("could not delete table: %w", err)
 		}
+		return deleted, nil
+	}
 
-		ri := tr.GetRange(tableDataSS, fdb.RangeOptions{
-			Mode: fdb.StreamingModeWantAll,
-		}).Iterator()
-		for ri.Advance() {
-			kv := ri.MustGet()
-			tr.Clear(kv.Key)
+	statsSS := pe.statsSubspace()
+	deleted := 0
+	for _, target := range targets {
+		n, err := pe.tombstoneRows(tableDataSS.Sub(target), rowCountKey(statsSS, target), pe.tombstonedKey(target), tbl)
+		deleted += n
+		if err != nil {
+			// Note: in a transaction block nothing is committed before the block is
+			if _, batched := pe.db.(keyspace); batched && deleted > 0 {
+				return 0, fmt.Errorf("could not delete table: %w", partialWriteError(err, deleted))
+			}
+			return 0, fmt.Errorf("could not delete table: %w", err)
+		}
+	}
+
+	// Note: a table dropped while the tombstones were written took them with it
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if tr.Get(tableKey).MustGet() == nil {
+			return nil, undefinedTable(stmt.Relation.Relname)
 		}
 		return nil, nil
 	})
 	if err != nil {
-		return fmt.Errorf("could not delete table: %s", err)
+		return 0, fmt.Errorf("could not delete table: %w", transactionLimitError(err))
 	}
-	return nil
+	return deleted, nil
 }
 
 type pgResult struct {
 	fieldNames []string
 	fieldTypes []string
+	// The expression of each field, nil for fields that are columns
+	fieldExprs []*pgquery.Node
 	rows       [][]any
+	// The OFFSET and LIMIT of the select and the rows it made so far, see limit.go
+	offset   int64
+	limit    int64
+	limited  bool
+	produced int64
+}
+
+/*
+
+Resolve the target list of the select against the table definition. `*` expands to every
+column of the table.
+
+Other targets are expressions, which are evaluated for every row. The type of a field that
+isn't a column or a cast is only known from its values, it is left empty until a row has one.
+
+*/
+
+func selectTargets(stmt *pgquery.SelectStmt, tbl *tableDefinition) (*pgResult, error) {
+	results := &pgResult{}
+	for _, c := range stmt.TargetList {
+		rt := c.GetResTarget()
+		// Note: like Postgres, `(home).*` is a target per field of the record
+		if ind := rt.Val.GetAIndirection(); ind != nil && len(ind.Indirection) == 1 && ind.Indirection[0].GetAStar() != nil {
+			ct := tbl.exprComposite(ind.Arg)
+			if ct == nil {
+				return nil, fmt.Errorf("type of %s is not composite", targetName(&pgquery.ResTarget{Val: ind.Arg}))
+			}
+			for _, f := range ct.Fields {
+				results.fieldNames = append(results.fieldNames, f.Name)
+				results.fieldTypes = append(results.fieldTypes, f.Type)
+				results.fieldExprs = append(results.fieldExprs, &pgquery.Node{Node: &pgquery.Node_AIndirection{
+					AIndirection: &pgquery.A_Indirection{Arg: ind.Arg, Indirection: []*pgquery.Node{pgquery.MakeStrNode(f.Name)}},
+				}})
+			}
+			continue
+		}
+
+		fields := rt.Val.GetColumnRef().GetFields()
+		if len(fields) == 0 {
+			results.fieldNames = append(results.fieldNames, targetName(rt))
+			results.fieldTypes = append(results.fieldTypes, exprType(rt.Val, tbl))
+			results.fieldExprs = append(results.fieldExprs, rt.Val)
+			continue
+		}
+
+		if fields[0].GetAStar() != nil {
+			results.fieldNames = append(results.fieldNames, tbl.ColumnNames...)
+			results.fieldTypes = append(results.fieldTypes, tbl.ColumnTypes...)
+			results.fieldExprs = append(results.fieldExprs, make([]*pgquery.Node, len(tbl.ColumnNames))...)
+			continue
+		}
+
+		fieldName := fields[0].GetString_().Str
+		results.fieldNames = append(results.fieldNames, fieldName)
+
+		fieldType, ok := tbl.columnType(fieldName)
+		if !ok {
+			return nil, undefinedColumn(fieldName)
+		}
+
+		results.fieldTypes = append(results.fieldTypes, fieldType)
+		results.fieldExprs = append(results.fieldExprs, nil)
+	}
+
+	return results, nil
+}
+
+// The name of an expression target, which like in Postgres is that of the column or function it is about.
+func targetName(rt *pgquery.ResTarget) string {
+	if rt.Name != "" {
+		return rt.Name
+	}
+
+	n := rt.Val
+	for {
+		switch {
+		case n.GetColumnRef() != nil:
+			fields := n.GetColumnRef().Fields
+			return fields[len(fields)-1].GetString_().GetStr()
+		case n.GetFuncCall() != nil:
+			return funcName(n.GetFuncCall())
+		case n.GetAIndirection() != nil:
+			ind := n.GetAIndirection()
+			if field := ind.Indirection[len(ind.Indirection)-1].GetString_(); field != nil {
+				return field.GetStr()
+			}
+			n = ind.Arg
+			continue
+		case n.GetTypeCast() != nil:
+			tc := n.GetTypeCast()
+			if tc.Arg.GetColumnRef() != nil || tc.Arg.GetFuncCall() != nil {
+				n = tc.Arg
+				continue
+			}
+			names := tc.TypeName.Names
+			return names[len(names)-1].GetString_().GetStr()
+		case n.GetAArrayExpr() != nil:
+			return "array"
+		case n.GetRowExpr() != nil:
+			return "row"
+		}
+		return "?column?"
+	}
+}
+
+// The type of an expression target when it is known before evaluating it, empty otherwise.
+func exprType(n *pgquery.Node, tbl *tableDefinition) string {
+	if tc := n.GetTypeCast(); tc != nil {
+		return typeNameString(tc.TypeName)
+	}
+
+	// Note: fields of records have the type of the field
+	if ind := n.GetAIndirection(); ind != nil && len(ind.Indirection) == 1 && ind.Indirection[0].GetString_() != nil {
+		if ct := tbl.exprComposite(ind.Arg); ct != nil {
+			if i, ok := ct.field(ind.Indirection[0].GetString_().GetStr()); ok {
+				return ct.Fields[i].Type
+			}
+		}
+	}
+
+	// Note: unnest returns the elements of its array
+	if fc := n.GetFuncCall(); fc != nil && funcName(fc) == "unnest" && len(fc.Args) == 1 {
+		if c := fc.Args[0].GetColumnRef(); c != nil {
+			colType, _ := tbl.columnType(c.Fields[len(c.Fields)-1].GetString_().GetStr())
+			elemType, _ := arrayElemType(colType)
+			return elemType
+		}
+	}
+	return ""
+}
+
+// Evaluate the targets of the select for a row. Like Postgres, unnest in the target list returns a
+// row per element, and several of them are unnested side by side to the length of the longest.
+func (results *pgResult) targetRows(tbl *tableDefinition, r row) ([][]any, error) {
+	targetRow := make([]any, len(results.fieldNames))
+	unnested := map[int][]any{}
+	rowCount := 1
+	for i, fieldName := range results.fieldNames {
+		expr := results.fieldExprs[i]
+		if expr == nil {
+			targetRow[i] = r[fieldName]
+			continue
+		}
+
+		if fc := expr.GetFuncCall(); fc != nil && funcName(fc) == "unnest" {
+			if len(fc.Args) != 1 {
+				return nil, fmt.Errorf("function unnest takes one argument")
+			}
+			v, err := evalExpr(fc.Args[0], tbl, r)
+			if err != nil {
+				return nil, err
+			}
+
+			a, ok := v.(array)
+			if v != nil && !ok {
+				return nil, fmt.Errorf("function unnest(%T) does not exist", v)
+			}
+			unnested[i] = a.flatten()
+			if len(unnested) == 1 || len(unnested[i]) > rowCount {
+				rowCount = len(unnested[i])
+			}
+			continue
+		}
+
+		v, err := evalExpr(expr, tbl, r)
+		if err != nil {
+			return nil, err
+		}
+		targetRow[i] = v
+	}
+
+	if len(unnested) == 0 {
+		return [][]any{targetRow}, nil
+	}
+
+	var rows [][]any
+	for n := 0; n < rowCount; n++ {
+		unnestedRow := append([]any{}, targetRow...)
+		for i, elems := range unnested {
+			if n < len(elems) {
+				unnestedRow[i] = elems[n]
+			}
+		}
+		rows = append(rows, unnestedRow)
+	}
+	return rows, nil
+}
+
+// The tables holding the rows of a select, which are the remaining partitions after pruning for partitioned tables.
+func (pe pgEngine) selectTables(stmt *pgquery.SelectStmt, tbl *tableDefinition) ([]string, error) {
+	partitions, err := pe.getPartitionSpec(tbl.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if partitions == nil {
+		return []string{tbl.Name}, nil
+	}
+
+	return partitions.prune(tbl, stmt.WhereClause), nil
+}
+
+// Filter the scanned rows with the WHERE clause, sort them by the ORDER BY clause and project the target list.
+func (results *pgResult) addRows(stmt *pgquery.SelectStmt, tbl *tableDefinition, rows []row) error {
+	var matched []row
+	for _, r := range rows {
+		ok, err := evalWhere(stmt.WhereClause, tbl, r)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			matched = append(matched, r)
+		}
+	}
+
+	if err := sortRows(stmt.SortClause, results.fieldNames, tbl, matched); err != nil {
+		return err
+	}
+
+	for _, r := range matched {
+		if results.limitReached() {
+			break
+		}
+		targetRows, err := results.targetRows(tbl, r)
+		if err != nil {
+			return err
+		}
+		for _, targetRow := range targetRows {
+			results.addLimited(targetRow)
+		}
+	}
+
+	// Note: fields of expressions get the type of their first value, or text if they have none
+	for i, fieldType := range results.fieldTypes {
+		if fieldType != "" {
+			continue
+		}
+		results.fieldTypes[i] = "text"
+		for _, targetRow := range results.rows {
+			if targetRow[i] != nil {
+				results.fieldTypes[i] = valueType(targetRow[i])
+				break
+			}
+		}
+	}
+
+	return nil
 }
 
 /*
@@ -354,167 +1184,245 @@ data/table_data/user/name/34e7ff77-1bed-4ebd-be56-4b966e67c595: ted
 The Select code collects them into [[14, garry], [20, ted]] and returns the result accordingly.
 */
 
+// A select of expressions, which returns one row.
+func (pe pgEngine) executeSelectWithoutFrom(stmt *pgquery.SelectStmt) (*pgResult, error) {
+	tbl := &tableDefinition{}
+	results, err := selectTargets(stmt, tbl)
+	if err != nil {
+		return nil, err
+	}
+	if err := results.setLimit(stmt); err != nil {
+		return nil, err
+	}
+	return results, results.addRows(stmt, tbl, []row{{}})
+}
+
 func (pe pgEngine) executeSelectColumnar(stmt *pgquery.SelectStmt) (*pgResult, error) {
+	if len(stmt.FromClause) == 0 {
+		return pe.executeSelectWithoutFrom(stmt)
+	}
+	if q, fields, ok := matchDriverQuery(stmt); ok {
+		return pe.executeDriverQuery(stmt, q, fields)
+	}
+	if rel, ok := lookupCatalogRelation(stmt.FromClause[0].GetRangeVar()); ok {
+		return pe.executeCatalogSelect(stmt, rel)
+	}
+
 	tblName := stmt.FromClause[0].GetRangeVar().Relname
 	tbl, err := pe.getTableDefinition(tblName)
 	if err != nil {
 		return nil, err
 	}
 
-	results := &pgResult{}
-	for _, c := range stmt.TargetList {
-		fieldName := c.GetResTarget().Val.GetColumnRef().Fields[0].GetString_().Str
-		results.fieldNames = append(results.fieldNames, fieldName)
-
-		fieldType := ""
-		for i, cn := range tbl.ColumnNames {
-			if cn == fieldName {
-				fieldType = tbl.ColumnTypes[i]
-			}
-		}
+	// Note: a table that only keeps the row layout is read from it
+	if !tbl.storesColumns() {
+		return pe.executeSelect(stmt)
+	}
 
-		if fieldType == "" {
-			return nil, fmt.Errorf("unknown field: %s", fieldName)
-		}
+	results, err := selectTargets(stmt, tbl)
+	if err != nil {
+		return nil, err
+	}
+	if err := results.setLimit(stmt); err != nil {
+		return nil, err
+	}
 
-		results.fieldTypes = append(results.fieldTypes, fieldType)
+	tables, err := pe.selectTables(stmt, tbl)
+	if err != nil {
+		return nil, err
 	}
 
-	dataDir, err := directory.CreateOrOpen(pe.db, []string{"data"}, nil)
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	tableDataSS := dataDir.Sub("table_data")
 
-	_, _ = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
-		query := tableDataSS.Pack(tuple.Tuple{tbl.Name, "c"})
-		rangeQuery, _ := fdb.PrefixRange(query)
-		ri := tr.GetRange(rangeQuery, fdb.RangeOptions{
-			Mode: fdb.StreamingModeWantAll,
-		}).Iterator()
-
-		var columnOrder []string
-		var targetRows [][]any
-		targetRows = append(targetRows, []any{})
-		rowIndex := -1
-		lastColumn := ""
-		for ri.Advance() {
-			kv := ri.MustGet()
-			t, _ := tableDataSS.Unpack(kv.Key)
-
-			currentTableName := t[0].(string)
-			currentColumnFormat := t[1].(string)
-			currentColumnName := t[2].(string)
-			currentInternalRowId := t[3].(string)
-			log.Println("fetching row metadata: ", currentTableName, currentColumnFormat, currentColumnName, currentInternalRowId)
-			if currentColumnName != lastColumn {
-				rowIndex = 0
-				lastColumn = currentColumnName
-				columnOrder = append(columnOrder, currentColumnName)
+	var rows []row
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		indexRows, ok, err := pe.textSearchRows(rtr, stmt, tbl, tables)
+		if ok || err != nil {
+			rows = indexRows
+			return nil, err
+		}
+
+		// Note: rows are only counted while they are read without a WHERE clause, where every row matches
+		columns := projectedColumns(stmt, tbl)
+		need, limited := results.scanLimit(stmt)
+		limited = limited && stmt.WhereClause == nil && need < maxPushdownRows
+		for _, name := range tables {
+			remaining := need - int64(len(rows))
+			if limited && remaining <= 0 {
+				break
+			}
+
+			if err := pe.checkCanceled(); err != nil {
+				return nil, err
+			}
+
+			// Note: the rows of a target with tombstones (see tombstone.go) are all read, a LIMIT would count the deleted ones
+			tombstones := newTombstoneScan(rtr, tableDataSS.Sub(name))
+			targetLimited := limited && tombstones.none()
+
+			ranges := make([]fdb.KeyRange, len(columns))
+			opts := make([]fdb.RangeOptions, len(columns))
+			for i, column := range columns {
+				rangeQuery, _ := fdb.PrefixRange(tableDataSS.Pack(tuple.Tuple{name, "c", tbl.columnKey(column)}))
+				ranges[i], opts[i] = fdb.KeyRange{Begin: rangeQuery.Begin, End: rangeQuery.End}, scanOptions(stmt, remaining, targetLimited, 1)
+			}
+
+			// Note: the columns are read at once (see columnReads.go), after the first one when it decides which rows are read
+			var cells [][]columnCell
+			if targetLimited {
+				cells = readColumnRanges(rtr, tableDataSS, tbl, name, columns[:1], ranges[:1], opts[:1])
+				if first := cells[0]; len(first) > 0 && len(columns) > 1 {
+					for i, column := range columns[1:] {
+						ranges[i+1].Begin = tableDataSS.Pack(tuple.Tuple{name, "c", tbl.columnKey(column), rowIDElement(first[0].id)})
+						ranges[i+1].End = keyAfter(tableDataSS.Pack(tuple.Tuple{name, "c", tbl.columnKey(column), rowIDElement(first[len(first)-1].id)}))
+						opts[i+1].Limit = 0
+					}
+					cells = append(cells, readColumnRanges(rtr, tableDataSS, tbl, name, columns[1:], ranges[1:], opts[1:])...)
+				}
 			} else {
-				targetRows = append(targetRows, []any{})
+				cells = readColumnRanges(rtr, tableDataSS, tbl, name, columns, ranges, opts)
 			}
 
-			for _, target := range results.fieldNames {
-				if target == currentColumnName {
-					targetRows[rowIndex] = append(targetRows[rowIndex], string(kv.Value))
+			// Note: cells arrive column by column, collect them by the internal row id
+			var rowIds []string
+			rowsById := map[string]row{}
+			for i, columnCells := range cells {
+				for _, cell := range columnCells {
+					log.Println("fetching row metadata: ", name, "c", columns[i], cell.id)
+
+					r, ok := rowsById[cell.id]
+					if !ok && targetLimited && i > 0 {
+						continue
+					}
+					if !ok {
+						r = row{}
+						rowsById[cell.id] = r
+						rowIds = append(rowIds, cell.id)
+					}
+					r[columns[i]] = cell.value
 				}
 			}
-			rowIndex += 1
-		}
-		results.fieldNames = columnOrder
 
-		// TODO: don't add empty arrays in the first place
-		var targetRowsFinal [][]any
-		targetRows = append(targetRows, []any{})
-		for _, row := range targetRows {
-			if len(row) > 0 {
-				targetRowsFinal = append(targetRowsFinal, row)
+			for _, id := range rowIds {
+				if !tombstones.deleted(id) {
+					rows = append(rows, rowsById[id])
+				}
 			}
 		}
-		results.rows = targetRowsFinal
-		return results, nil
+		return nil, nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("could not select from table: %w", err)
+	}
 
-	return results, nil
+	return results, results.addRows(stmt, tbl, rows)
 }
 
 func (pe pgEngine) executeSelect(stmt *pgquery.SelectStmt) (*pgResult, error) {
+	if len(stmt.FromClause) == 0 {
+		return pe.executeSelectWithoutFrom(stmt)
+	}
+	if q, fields, ok := matchDriverQuery(stmt); ok {
+		return pe.executeDriverQuery(stmt, q, fields)
+	}
+	if rel, ok := lookupCatalogRelation(stmt.FromClause[0].GetRangeVar()); ok {
+		return pe.executeCatalogSelect(stmt, rel)
+	}
+	if name, ok := matchCountStar(stmt); ok {
+		return pe.executeCountStar(stmt, name)
+	}
+
 	tblName := stmt.FromClause[0].GetRangeVar().Relname
 	tbl, err := pe.getTableDefinition(tblName)
 	if err != nil {
 		return nil, err
 	}
 
-	results := &pgResult{}
-	for _, c := range stmt.TargetList {
-		fieldName := c.GetResTarget().Val.GetColumnRef().Fields[0].GetString_().Str
-		results.fieldNames = append(results.fieldNames, fieldName)
-
-		fieldType := ""
-		for i, cn := range tbl.ColumnNames {
-			if cn == fieldName {
-				fieldType = tbl.ColumnTypes[i]
-			}
-		}
+	if planLayout(stmt, tbl) == layoutColumnar {
+		return pe.executeSelectColumnar(stmt)
+	}
 
-		if fieldType == "" {
-			return nil, fmt.Errorf("unknown field: %s", fieldName)
-		}
+	results, err := selectTargets(stmt, tbl)
+	if err != nil {
+		return nil, err
+	}
+	if err := results.setLimit(stmt); err != nil {
+		return nil, err
+	}
 
-		results.fieldTypes = append(results.fieldTypes, fieldType)
+	tables, err := pe.selectTables(stmt, tbl)
+	if err != nil {
+		return nil, err
 	}
 
-	dataDir, err := directory.CreateOrOpen(pe.db, []string{"data"}, nil)
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	tableDataSS := dataDir.Sub("table_data")
 
-	_, _ = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
-		query := tableDataSS.Pack(tuple.Tuple{tbl.Name, "r"})
-		rangeQuery, _ := fdb.PrefixRange(query)
-		ri := tr.GetRange(rangeQuery, fdb.RangeOptions{
-			Mode: fdb.StreamingModeWantAll,
-		}).Iterator()
-
-		var targetRows [][]any
-		targetRows = append(targetRows, []any{})
-		rowIndex := 0
-		columnOrder := []string{}
-		for ri.Advance() {
-			kv := ri.MustGet()
-			t, _ := tableDataSS.Unpack(kv.Key)
-
-			currentTableName := t[0].(string)
-			currentColumnFormat := t[1].(string)
-			currentInternalRowId := t[2].(string)
-			currentColumnName := t[3].(string)
-			log.Println("fetching row metadata: ", currentTableName, currentColumnFormat, currentColumnName, currentInternalRowId)
+	var rows []row
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		indexRows, ok, err := pe.textSearchRows(rtr, stmt, tbl, tables)
+		if ok || err != nil {
+			rows = indexRows
+			return nil, err
+		}
 
-			if len(columnOrder) < len(results.fieldNames) {
-				columnOrder = append(columnOrder, currentColumnName)
+		need, limited := results.scanLimit(stmt)
+		counter := &matchCounter{stmt: stmt, tbl: tbl, need: need}
+		for _, name := range tables {
+			if limited && counter.done() {
+				break
 			}
-			if len(targetRows[rowIndex]) == len(results.fieldNames) {
-				rowIndex += 1
-				targetRows = append(targetRows, []any{})
+			query := tableDataSS.Pack(tuple.Tuple{name, "r"})
+			rangeQuery, _ := fdb.PrefixRange(query)
+
+			// Note: a scan of every row reads the shards of the table at once, see parallelScan.go
+			if !limited && scanWorkers > 1 {
+				tableRows, err := pe.scanParallel(rtr, tableDataSS, tbl, name, rangeQuery)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, tableRows...)
+				continue
 			}
+			ri := rtr.GetRange(rangeQuery, scanOptions(stmt, need-counter.matched, limited, 1)).Iterator()
+			tombstones := newTombstoneScan(rtr, tableDataSS.Sub(name))
 
-			targetRows[rowIndex] = append(targetRows[rowIndex], string(kv.Value))
-		}
-		results.fieldNames = columnOrder
+			// Note: a row is one key, see rowFormat.go. Rows with a tombstone are dropped, see tombstone.go
+			for ri.Advance() {
+				if err := pe.checkCanceled(); err != nil {
+					return nil, err
+				}
+				kv := ri.MustGet()
+				t, _ := tableDataSS.Unpack(kv.Key)
+
+				currentTableName := t[0].(string)
+				currentColumnFormat := t[1].(string)
+				currentInternalRowId := rowIDString(t[2])
+				log.Println("fetching row metadata: ", currentTableName, currentColumnFormat, currentInternalRowId)
 
-		// TODO: don't add empty arrays in the first place
-		var targetRowsFinal [][]any
-		targetRows = append(targetRows, []any{})
-		for _, row := range targetRows {
-			if len(row) > 0 {
-				targetRowsFinal = append(targetRowsFinal, row)
+				if tombstones.deleted(currentInternalRowId) {
+					continue
+				}
+				r := readRowValue(rtr, tableDataSS, tbl, currentTableName, currentInternalRowId, kv.Value)
+				rows = append(rows, r)
+				// Note: a select with a LIMIT may have its rows
+				if limited && counter.add(r) {
+					break
+				}
 			}
 		}
-		results.rows = targetRowsFinal
-		return results, nil
+		return nil, nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("could not select from table: %w", err)
+	}
 
-	return results, nil
+	return results, results.addRows(stmt, tbl, rows)
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+)
+
+/*
+
+Errors that carry the SQLSTATE code Postgres reports for them, so clients can tell them apart
+without matching on the message. Drivers branch on these codes, for example to tell a missing
+table from a duplicate key:
+
+```
+42P01  undefined_table      relation "person" does not exist
+42703  undefined_column     column "agee" does not exist
+23505  unique_violation     duplicate key value violates unique constraint
+42601  syntax_error         syntax error at or near "form"
+```
+
+Errors are wrapped with %w on their way up, so the code is found by errors.As however deep the
+pgError is. The ErrorResponse carries the message of the pgError itself, like Postgres, without
+the context it was wrapped in. Errors without a code are reported as internal_error (XX000).
+
+*/
+
+const (
+	sqlStateStringDataRightTruncation           = "22001"
+	sqlStateInvalidParameterValue               = "22023"
+	sqlStateInvalidRowCountInLimitClause        = "2201W"
+	sqlStateInvalidRowCountInResultOffsetClause = "2201X"
+	sqlStateCharacterNotInRepertoire            = "22021"
+	sqlStateUntranslatableCharacter             = "22P05"
+	sqlStateInvalidBinaryRepresentation         = "22P03"
+	sqlStateInvalidTextRepresentation           = "22P02"
+	sqlStateBadCopyFileFormat                   = "22P04"
+	sqlStateFeatureNotSupported                 = "0A000"
+	sqlStateProtocolViolation                   = "08P01"
+	sqlStateNotNullViolation                    = "23502"
+	sqlStateUniqueViolation                     = "23505"
+	sqlStateActiveSQLTransaction                = "25001"
+	sqlStateNoActiveSQLTransaction              = "25P01"
+	sqlStateIdleInTransactionSessionTimeout     = "25P03"
+	sqlStateInFailedSQLTransaction              = "25P02"
+	sqlStateInvalidAuthorizationSpecification   = "28000"
+	sqlStateInvalidPassword                     = "28P01"
+	sqlStateInvalidSQLStatementName             = "26000"
+	sqlStateInvalidCursorName                   = "34000"
+	sqlStateInvalidCatalogName                  = "3D000"
+	sqlStateInvalidSchemaName                   = "3F000"
+	sqlStateSerializationFailure                = "40001"
+	sqlStateSyntaxError                         = "42601"
+	sqlStateUndefinedColumn                     = "42703"
+	sqlStateInvalidColumnReference              = "42P10"
+	sqlStateInvalidTableDefinition              = "42P16"
+	sqlStateUndefinedFunction                   = "42883"
+	sqlStateInsufficientPrivilege               = "42501"
+	sqlStateUndefinedObject                     = "42704"
+	sqlStateUndefinedTable                      = "42P01"
+	sqlStateDuplicateTable                      = "42P07"
+	sqlStateDuplicateDatabase                   = "42P04"
+	sqlStateDuplicateSchema                     = "42P06"
+	sqlStateDuplicatePreparedStatement          = "42P05"
+	sqlStateDuplicateObject                     = "42710"
+	sqlStateTooManyConnections                  = "53300"
+	sqlStateConfigurationLimitExceeded          = "53400"
+	sqlStateProgramLimitExceeded                = "54000"
+	sqlStateObjectInUse                         = "55006"
+	sqlStateQueryCanceled                       = "57014"
+	sqlStateIdleSessionTimeout                  = "57P05"
+	sqlStateIOError                             = "58030"
+	sqlStateIndexCorrupted                      = "XX002"
+)
+
+type pgError struct {
+	Code    string
+	Message string
+	// The position of the error in the query, counted in characters from 1, 0 if unknown
+	Position int32
+	// More about the error, on a line of its own after the message, empty if there is nothing more
+	Detail string
+}
+
+func (e *pgError) Error() string {
+	return e.Message
+}
+
+func undefinedTable(name string) error {
+	return &pgError{Code: sqlStateUndefinedTable, Message: fmt.Sprintf("relation \"%s\" does not exist", name)}
+}
+
+func undefinedColumn(name string) error {
+	return &pgError{Code: sqlStateUndefinedColumn, Message: fmt.Sprintf("column \"%s\" does not exist", name)}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 
@@ -13,143 +19,467 @@ import (
 	pgquery "github.com/pganalyze/pg_query_go/v2"
 )
 
-var dataTypeOIDMap = map[string]uint32{
-	"text":            25,
-	"pg_catalog.int4": 23,
-}
-
 type pgServer struct {
 	conn net.Conn
-	db   fdb.Transactor
-	cfg  config
+	// The reader of the connection, the startup message is read from it before the backend reads the rest
+	reader pgproto3.ChunkReader
+	// The backend messages are received with once the startup is done, see handle
+	backend *pgproto3.Backend
+	writeMu sync.Mutex
+	pid     uint32
+	// The key cancel requests for the session must have
+	secret uint32
+	// Cancels the running query, nil between queries
+	cancelMu    sync.Mutex
+	cancelQuery context.CancelFunc
+	db          fdb.Database
+	cfg         config
+	// The database the session is bound to and its keys, its tenant with -tenants
+	database string
+	keyspace keyspace
+	// The TLS configuration connections are upgraded with, nil without TLS
+	tlsConfig *tls.Config
+	locks     *advisoryLocks
+	listeners map[string]*listener
+	// What the session is doing, for pg_stat_activity
+	activityMu sync.Mutex
+	activity   backendActivity
+	// The encoding text is converted to for the client, see clientEncoding
+	encoding atomic.Pointer[clientEncoding]
+	// The queries the session may run before -conn-queries-per-second refuses them
+	queries tokenBucket
+	*session
 }
 
-func (pgs pgServer) done(buf []byte, msg string) {
-	buf = (&pgproto3.CommandComplete{CommandTag: []byte(msg)}).Encode(buf)
-	buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
+// Notifications are written from other goroutines, so writes to the connection are serialized.
+func (pgs *pgServer) write(buf []byte) error {
+	pgs.writeMu.Lock()
+	defer pgs.writeMu.Unlock()
 	_, err := pgs.conn.Write(buf)
+	return err
+}
+
+// Statements run inside the transaction block if there is one.
+func (pgs *pgServer) transactor() fdb.Transactor {
+	if pgs.tx != nil {
+		if pgs.txIsolation != "serializable" {
+			return snapshotTransactor{*pgs.tx}
+		}
+		return *pgs.tx
+	}
+	return pgs.sessionKeyspace()
+}
+
+func (pgs *pgServer) txStatus() byte {
+	if pgs.tx != nil {
+		if pgs.txFailed {
+			return 'E'
+		}
+		return 'T'
+	}
+	return 'I'
+}
+
+// Complete a statement. The ReadyForQuery follows once every statement of the query ran.
+func (pgs *pgServer) done(buf []byte, msg string) {
+	buf = (&pgproto3.CommandComplete{CommandTag: []byte(msg)}).Encode(buf)
+	err := pgs.write(buf)
 	if err != nil {
 		log.Printf("failed to write query response: %s", err)
 	}
 }
 
-func (pgs pgServer) writePgResult(res *pgResult) {
+func (pgs *pgServer) readyForQuery() {
+	pgs.idle()
+	err := pgs.write((&pgproto3.ReadyForQuery{TxStatus: pgs.txStatus()}).Encode(nil))
+	if err != nil {
+		log.Printf("failed to write ready for query: %s", err)
+	}
+}
+
+func (pgs *pgServer) writePgResult(res *pgResult, command string) error {
+	// Note: results of a portal are kept in it, Execute sends them
+	if pgs.portal != nil {
+		pgs.portal.result = res
+		pgs.portal.command = command
+		return nil
+	}
+
+	buf := pgs.rowDescription(res).Encode(nil)
+	for _, row := range res.rows {
+		dr, err := pgs.dataRow(row)
+		if err != nil {
+			return err
+		}
+		buf = dr.Encode(buf)
+	}
+
+	pgs.done(buf, fmt.Sprintf("%s %d", command, len(res.rows)))
+	return nil
+}
+
+func (pgs *pgServer) rowDescription(res *pgResult) *pgproto3.RowDescription {
 	rd := &pgproto3.RowDescription{}
+	ce := pgs.clientEncoding()
 	for i, field := range res.fieldNames {
+		fieldType, _ := splitTypeMods(res.fieldTypes[i])
+		oid, size := pgs.typeInfo(fieldType)
 		rd.Fields = append(rd.Fields, pgproto3.FieldDescription{
-			Name:        []byte(field),
-			DataTypeOID: dataTypeOIDMap[res.fieldTypes[i]],
+			Name:         []byte(ce.encodeMessage(field)),
+			DataTypeOID:  oid,
+			DataTypeSize: size,
+			TypeModifier: typeModifier(res.fieldTypes[i]),
+			Format:       textFormatCode,
 		})
 	}
-	buf := rd.Encode(nil)
-	for _, row := range res.rows {
-		dr := &pgproto3.DataRow{}
-		for _, value := range row {
-			bs, err := json.Marshal(value)
-			if err != nil {
-				log.Printf("Failed to marshal cell: %s\n", err)
-				return
-			}
+	return rd
+}
 
-			dr.Values = append(dr.Values, bs)
+func (pgs *pgServer) dataRow(row []any) (*pgproto3.DataRow, error) {
+	dr := &pgproto3.DataRow{}
+	ce := pgs.clientEncoding()
+	for _, value := range row {
+		text := formatText(value)
+		if text != nil {
+			var err error
+			if text, err = ce.encode(text); err != nil {
+				return nil, err
+			}
 		}
+		dr.Values = append(dr.Values, text)
+	}
+	return dr, nil
+}
 
-		buf = dr.Encode(buf)
+// The OID and length of a type. Enums and composite types have the OID they were given when they were
+// created, which is in the catalog.
+func (pgs *pgServer) typeInfo(colType string) (uint32, int16) {
+	if oid, ok := builtinTypeOID(colType); ok {
+		return oid, typeLen(colType)
 	}
 
-	pgs.done(buf, fmt.Sprintf("SELECT %d", len(res.rows)))
+	pe := pgs.engine.withTransactor(pgs.transactor())
+	if e, err := pe.lookupEnum(colType); err == nil && e != nil {
+		// Note: Postgres stores enums in 4 bytes
+		return e.Oid, 4
+	}
+	if ct, err := pe.lookupComposite(colType); err == nil && ct != nil {
+		return ct.Oid, -1
+	}
+	return 0, -1
 }
 
-func (pgs pgServer) handleStartupMessage(pgconn *pgproto3.Backend) error {
-	startupMessage, err := pgconn.ReceiveStartupMessage()
+// Handle the startup of the session and return the backend the rest of it is read from, which is
+// a new one if the connection was upgraded to TLS.
+func (pgs *pgServer) handleStartupMessage(pgconn *pgproto3.Backend) (*pgproto3.Backend, error) {
+	startupMessage, err := pgs.receiveStartupMessage()
 	if err != nil {
-		return fmt.Errorf("error receiving startup message: %s", err)
+		return nil, fmt.Errorf("error receiving startup message: %w", err)
 	}
 
-	switch startupMessage.(type) {
+	switch sm := startupMessage.(type) {
 	case *pgproto3.StartupMessage:
+		if _, ok := pgs.conn.(*tls.Conn); !ok && pgs.cfg.tlsOnly {
+			return nil, pgs.rejectPlainText()
+		}
+
+		err := pgs.authenticate(pgconn, sm.Parameters["user"])
+		pgs.auditAuthentication(sm.Parameters["user"], err)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pgs.selectDatabase(sm.Parameters); err != nil {
+			return nil, err
+		}
+		if err := pgs.applyStartupOptions(sm.Parameters["options"]); err != nil {
+			return nil, err
+		}
+		if err := pgs.applyStartupEncoding(sm.Parameters["client_encoding"]); err != nil {
+			return nil, err
+		}
+		pgs.engine = pgs.newEngine(pgs.keyspace, pgs.database).withSession(pgs.session).withNotices(pgs.sendNotice)
+		pgs.startupApplicationName = sm.Parameters["application_name"]
+		pgs.startActivity(sm)
+
 		buf := (&pgproto3.AuthenticationOk{}).Encode(nil)
+		for _, ps := range pgs.parameterStatuses() {
+			buf = ps.Encode(buf)
+		}
+		if err := pgs.registerBackend(); err != nil {
+			return nil, pgs.refuseConnection(err)
+		}
+		buf = (&pgproto3.BackendKeyData{ProcessID: pgs.pid, SecretKey: pgs.secret}).Encode(buf)
 		buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
-		_, err = pgs.conn.Write(buf)
+		err = pgs.write(buf)
 		if err != nil {
-			return fmt.Errorf("error sending ready for query: %s", err)
+			return nil, fmt.Errorf("error sending ready for query: %w", err)
 		}
 
-		return nil
+		return pgconn, nil
 	case *pgproto3.SSLRequest:
-		_, err = pgs.conn.Write([]byte("N"))
+		if pgs.tlsConfig != nil {
+			tlsconn, err := pgs.startTLS()
+			if err != nil {
+				return nil, err
+			}
+			return pgs.handleStartupMessage(tlsconn)
+		}
+
+		err = pgs.write([]byte("N"))
+		if err != nil {
+			return nil, fmt.Errorf("error sending deny SSL request: %w", err)
+		}
+
+		return pgs.handleStartupMessage(pgconn)
+	case *pgproto3.GSSEncRequest:
+		// Note: GSSAPI encryption isn't supported, the client goes on with an SSLRequest or a StartupMessage
+		err = pgs.write([]byte("N"))
 		if err != nil {
-			return fmt.Errorf("error sending deny SSL request: %s", err)
+			return nil, fmt.Errorf("error sending deny GSS encryption request: %w", err)
 		}
 
 		return pgs.handleStartupMessage(pgconn)
+	case *pgproto3.CancelRequest:
+		// Note: the connection of a cancel request only carries the request, it gets no response
+		canceled := cancelBackend(sm)
+		return nil, fmt.Errorf("cancel request for backend %d, canceled: %t", sm.ProcessID, canceled)
 	default:
-		return fmt.Errorf("unknown startup message: %#v", startupMessage)
+		return nil, fmt.Errorf("unknown startup message: %#v", startupMessage)
 	}
 }
 
-func (pgs pgServer) handleMessage(pgc *pgproto3.Backend) error {
-	msg, receive_err := pgc.Receive()
+func (pgs *pgServer) handleMessage(pgc *pgproto3.Backend) error {
+	msg, receive_err := pgs.receive(pgc)
 	if receive_err != nil {
-		return fmt.Errorf("error receiving message: %s", receive_err)
+		return receive_err
 	}
 
 	switch t := msg.(type) {
 	case *pgproto3.Query:
-		stmts, parse_err := pgquery.Parse(t.String)
-		if parse_err != nil {
-			return fmt.Errorf("error parsing query: %s", receive_err)
+		// Note: errors of the query are sent to the client, the connection stays open
+		query, err := pgs.clientEncoding().decode(t.String)
+		if err == nil {
+			err = pgs.handleQuery(query)
 		}
+		if err != nil {
+			pgs.writeError(err)
+		}
+		pgs.readyForQuery()
+	case *pgproto3.Parse, *pgproto3.Bind, *pgproto3.Describe, *pgproto3.Execute, *pgproto3.Close, *pgproto3.Flush, *pgproto3.Sync:
+		pgs.handleExtendedMessage(t)
+	case *pgproto3.Terminate:
+		return nil
+	default:
+		return fmt.Errorf("received unsupported message from client: %#v", msg)
+	}
+
+	return nil
+}
+
+/*
+
+Run the statements of a query one after the other, each completed with its own CommandComplete.
+The first statement that fails ends the query, the statements after it don't run. A query
+without statements gets an EmptyQueryResponse instead:
+
+```sql
+create table person (name text); insert into person values ('garry'); select * from person;
+```
+
+Like in Postgres, the statements of a query outside of a transaction block run in one implicit
+transaction block, committed after the last statement and rolled back if one fails, so either
+all of them are applied or none. BEGIN in the query turns the implicit block into a block of its
+own, which lasts past the query, and COMMIT or ROLLBACK in the query ends it early. Statements
+that can't run in a transaction block, like COPY FROM STDIN, can't run in a query of several
+statements either.
+
+*/
+
+func (pgs *pgServer) handleQuery(query string) error {
+	pgs.logQuery(query)
+	release, err := pgs.throttle()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	stmts, parse_err := pgquery.Parse(query)
+	if parse_err != nil {
+		err := syntaxError(query, parse_err)
+		pgs.auditStatement(query, err)
+		return err
+	}
 
-		if len(stmts.GetStmts()) > 1 {
-			return fmt.Errorf("only make one request at a time")
+	// Note: a query of only whitespace, comments or semicolons has no statements
+	if len(stmts.GetStmts()) == 0 {
+		if err := pgs.write((&pgproto3.EmptyQueryResponse{}).Encode(nil)); err != nil {
+			log.Printf("failed to write empty query response: %s", err)
 		}
+		return nil
+	}
 
-		stmt := stmts.GetStmts()[0]
+	ctx, done := pgs.startQuery()
+	defer done()
 
-		// Handle SELECTs here
-		s := stmt.GetStmt().GetSelectStmt()
-		var res *pgResult
-		var err error
-		if s != nil {
-			pe := newPgEngine(pgs.db)
-			if pgs.cfg.columnar {
-				res, err = pe.executeSelectColumnar(s)
+	if pgs.tx == nil && len(stmts.GetStmts()) > 1 {
+		if err := pgs.beginTransactionBlock(pgs.defaultIsolation); err != nil {
+			return err
+		}
+		pgs.txImplicit = true
+	}
 
-			} else {
-				res, err = pe.executeSelect(s)
+	for _, stmt := range stmts.GetStmts() {
+		text := statementText(query, stmt)
+		err := pgs.handleStatement(ctx, stmt, text)
+		pgs.auditStatement(text, err)
+		if err != nil {
+			if pgs.tx != nil && pgs.txImplicit {
+				pgs.rollbackTransactionBlock()
 			}
+			return err
+		}
+	}
+
+	if pgs.tx != nil && pgs.txImplicit {
+		return pgs.commitTransactionBlock()
+	}
+	return nil
+}
+
+// The text of a statement in the query it was parsed from.
+func statementText(query string, stmt *pgquery.RawStmt) string {
+	text := query[stmt.StmtLocation:]
+	if stmt.StmtLen > 0 {
+		text = text[:stmt.StmtLen]
+	}
+	return strings.TrimSpace(text)
+}
+
+func (pgs *pgServer) handleStatement(ctx context.Context, stmt *pgquery.RawStmt, query string) error {
+	if pgs.txFailed && !allowedInFailedTransaction(stmt.GetStmt()) {
+		return &pgError{
+			Code:    sqlStateInFailedSQLTransaction,
+			Message: "current transaction is aborted, commands ignored until end of transaction block",
+		}
+	}
+
+	if err := pgs.useSchema(stmt.GetStmt()); err != nil {
+		return err
+	}
+	stmt, err := pgs.engine.withTransactor(pgs.selectTransactor()).resolveRelationSizes(stmt)
+	if err != nil {
+		return err
+	}
+
+	// Note: the statement may write, a select after it has to see that
+	if stmt.GetStmt().GetSelectStmt() == nil {
+		pgs.freshRead = true
+	}
+
+	if handled, err := pgs.handleTransactionStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleCursorStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleAdvisoryLockStmt(ctx, stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleIndexCheckStmt(ctx, stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleRecountRowsStmt(ctx, stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleSetLayoutStmt(ctx, stmt.GetStmt()); handled {
+		return err
+	}
 
+	if handled, err := pgs.handleCopyStmt(ctx, stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleNotifyStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleIsolationStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleTimeZoneStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleSettingStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	if handled, err := pgs.handleDiscardStmt(stmt.GetStmt()); handled {
+		return err
+	}
+
+	// Handle SELECTs here
+	s := stmt.GetStmt().GetSelectStmt()
+	var res *pgResult
+	if s != nil {
+		pe := pgs.engine.withTransactor(pgs.selectTransactor()).withContext(ctx)
+		// Note: the selects of a transaction block read in its transaction, which can't be split
+		if pgs.tx == nil && pgs.portal == nil && streamableSelect(s) {
+			return pgs.streamSelect(pe, s)
+		}
+		// Note: a portal executed with a maximum number of rows reads them as they are sent, see fetchPortal
+		if p := pgs.portal; p != nil && p.maxRows > 0 && streamableSelect(s) {
+			p.cursor, err = pe.declareCursor(&pgquery.DeclareCursorStmt{Query: stmt.GetStmt()})
 			if err != nil {
 				return err
 			}
-
-			pgs.writePgResult(res)
+			p.result, p.command = p.cursor.results, "SELECT"
 			return nil
-		} else {
-			pe := newPgEngine(pgs.db)
-			pe.execute(*stmts)
 		}
+		res, err = pe.executeSelect(s)
 
-		pgs.done(nil, strings.ToUpper(strings.Split(t.String, " ")[0])+" ok")
-	case *pgproto3.Terminate:
+		if err != nil {
+			return err
+		}
+
+		return pgs.writePgResult(res, "SELECT")
+	} else {
+		pe := pgs.engine.withTransactor(pgs.transactor()).withContext(ctx)
+		rows, err := pe.execute(&pgquery.ParseResult{Stmts: []*pgquery.RawStmt{stmt}})
+		if err != nil {
+			return err
+		}
+
+		pgs.done(nil, commandTag(stmt.GetStmt(), query, rows))
 		return nil
-	default:
-		return fmt.Errorf("received message other than Query from client: %s", msg)
 	}
-
-	return nil
 }
 
-func (pgs pgServer) handle() {
-	pgc := pgproto3.NewBackend(pgproto3.NewChunkReader(pgs.conn), pgs.conn)
-	defer pgs.conn.Close()
+func (pgs *pgServer) handle() {
+	pgc := pgs.newBackend()
+	// Note: the TLS connection closes the connection it wraps
+	defer func() { pgs.conn.Close() }()
+	defer pgs.unregisterBackend()
+	defer pgs.auditDisconnect(time.Now())
 
-	err := pgs.handleStartupMessage(pgc)
+	pgc, err := pgs.startup(pgc)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	pgs.backend = pgc
+
+	// Note: locks and notifications are in the database of the session, which is known after the startup
+	go pgs.renewAdvisoryLocks()
+	defer pgs.releaseAdvisoryLocks()
+	defer pgs.unlisten("")
 
 	for {
 		err := pgs.handleMessage(pgc)
@@ -160,19 +490,71 @@ func (pgs pgServer) handle() {
 	}
 }
 
-func runPgServer(port string, db fdb.Transactor, cfg config) {
-	ln, err := net.Listen("tcp", "localhost:"+port)
+/*
+
+Listen for connections on TCP, at the -listen-addr address, and on a Unix domain socket in
+-unix-socket-dir, named like Postgres names it so local tools find it without a host:
+
+```bash
+$ ./fakegres-fdb -listen-addr=0.0.0.0 -pg-port=6000 -unix-socket-dir=/tmp
+$ psql -h /tmp -p 6000 postgres
+```
+
+An empty -unix-socket-dir turns the socket off.
+
+*/
+
+func runPgServer(port string, db fdb.Database, cfg config) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(cfg.listenAddr, port))
 	if err != nil {
 		log.Fatal(err)
 	}
+	tlsConfig := loadTLSConfig(cfg)
+	if cfg.ttlReapInterval > 0 {
+		go reapExpiredRows(db, cfg, cfg.ttlReapInterval)
+	}
+	if cfg.tombstoneReapInterval > 0 {
+		go reapTombstones(db, cfg, cfg.tombstoneReapInterval)
+	}
 
+	if cfg.unixSocketDir != "" {
+		socketPath := filepath.Join(cfg.unixSocketDir, ".s.PGSQL."+port)
+		// Note: a socket left behind by a server that didn't shut down cleanly would make the listen fail
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		unixLn, err := net.Listen("unix", socketPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go servePg(unixLn, db, cfg, tlsConfig)
+	}
+
+	servePg(ln, db, cfg, tlsConfig)
+}
+
+func servePg(ln net.Listener, db fdb.Database, cfg config, tlsConfig *tls.Config) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			log.Fatal(err)
 		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if err := tuneSocket(tcpConn, cfg); err != nil {
+				log.Printf("failed to tune connection from %s: %s", conn.RemoteAddr(), err)
+			}
+		}
 
-		pc := pgServer{conn, db, cfg}
+		pc := &pgServer{
+			conn:      conn,
+			pid:       nextPid(),
+			db:        db,
+			cfg:       cfg,
+			tlsConfig: tlsConfig,
+			locks:     newAdvisoryLocks(),
+			listeners: map[string]*listener{},
+			session:   newSession(),
+		}
 		go pc.handle()
 	}
 }
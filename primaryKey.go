@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Primary keys.
+
+A table can have a primary key of one column, which gives its rows a key chosen by the client:
+
+```sql
+create table payment (id uuid primary key, amount numeric);
+insert into payment values ('7d5c1a3e-...', 20);
+insert into payment values ('7d5c1a3e-...', 20) on conflict do nothing;  -- INSERT 0 0
+```
+
+Rows keep their internal ids (see rowId.go). The key of every row is an entry next to the rows
+of its table, which holds the id of the row:
+
+```
+catalog/primary_key/payment: id
+data/table_data/payment/k/<UUID(7d5c1a3e-...)>: (<row id>,)
+```
+
+An INSERT of a key a row already has fails with 23505 unique_violation, or skips the row with
+ON CONFLICT DO NOTHING, so a client that doesn't know whether its INSERT committed, after a lost
+connection or a commit_unknown_result, can send it again without duplicating its rows. The key
+of a row can't be NULL. Entries are checked in the transaction that writes the row, two INSERTs
+of the same key conflict and the one that retries sees the row of the other.
+
+The entries of rows deleted with tombstones (see tombstone.go) are there until the reaper clears
+the rows, an INSERT of their key takes them over. The primary key of a partitioned table must be
+its partition column, so the entries of a partition are the only ones that can have its keys.
+Partitions have the primary key of their partitioned table.
+
+*/
+
+// The size of the largest key FoundationDB stores.
+const maxKeySize = 10000
+
+// The entries of keys an INSERT reads ahead of the rows that check them.
+const primaryKeyReadAhead = 1000
+
+// The primary key column in the elements of CREATE TABLE, empty if there is none.
+func primaryKeyOption(stmt *pgquery.CreateStmt) (string, error) {
+	var keys []string
+	for _, c := range stmt.TableElts {
+		if cd := c.GetColumnDef(); cd != nil {
+			for _, cc := range cd.Constraints {
+				if cc.GetConstraint().GetContype() == pgquery.ConstrType_CONSTR_PRIMARY {
+					keys = append(keys, cd.Colname)
+				}
+			}
+			continue
+		}
+		if cc := c.GetConstraint(); cc != nil && cc.Contype == pgquery.ConstrType_CONSTR_PRIMARY {
+			if len(cc.Keys) != 1 {
+				return "", &pgError{Code: sqlStateFeatureNotSupported, Message: "primary keys of more than one column are not supported"}
+			}
+			keys = append(keys, cc.Keys[0].GetString_().GetStr())
+		}
+	}
+	if len(keys) > 1 {
+		return "", &pgError{Code: sqlStateInvalidTableDefinition, Message: fmt.Sprintf("multiple primary keys for table \"%s\" are not allowed", stmt.Relation.Relname)}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	return keys[0], nil
+}
+
+func (pe pgEngine) setPrimaryKey(tr fdb.Transaction, name string, column string) {
+	if column == "" {
+		return
+	}
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writes(tr).Set(catalogDir.Sub("primary_key").Pack(tuple.Tuple{name}), []byte(column))
+}
+
+// Start reading the primary key column of the table, the returned function waits for it. It is empty if the table has none.
+func (pe pgEngine) readPrimaryKey(rtr fdb.ReadTransaction, name string) func() string {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := rtr.Get(catalogDir.Sub("primary_key").Pack(tuple.Tuple{name}))
+	return func() string {
+		return string(f.MustGet())
+	}
+}
+
+// The name Postgres gives the constraint of the primary key of the table.
+func primaryKeyName(table string) string {
+	return table + "_pkey"
+}
+
+// The key of the entry of a value of the primary key, in a target of tableDataSS.
+func primaryKeyEntry(tableDataSS subspace.Subspace, target string, value any) fdb.Key {
+	return tableDataSS.Pack(tuple.Tuple{target, "k", cellElement(value)})
+}
+
+// Check the value of the primary key of a row of an INSERT, returning the key of its entry.
+func checkPrimaryKey(tableDataSS subspace.Subspace, tbl *tableDefinition, target string, value any) (fdb.Key, error) {
+	if value == nil {
+		return nil, &pgError{Code: sqlStateNotNullViolation, Message: fmt.Sprintf("null value in column \"%s\" of relation \"%s\" violates not-null constraint", tbl.PrimaryKey, tbl.Name)}
+	}
+	entry := primaryKeyEntry(tableDataSS, target, value)
+	if len(entry) > maxKeySize {
+		return nil, &pgError{Code: sqlStateProgramLimitExceeded, Message: fmt.Sprintf("index row size %d exceeds maximum %d for index \"%s\"", len(entry), maxKeySize, primaryKeyName(tbl.Name))}
+	}
+	return entry, nil
+}
+
+// The error of an INSERT of a key a row already has, text is the key in its text form.
+func uniqueViolation(tbl *tableDefinition, text string) error {
+	return &pgError{
+		Code:    sqlStateUniqueViolation,
+		Message: fmt.Sprintf("duplicate key value violates unique constraint \"%s\"", primaryKeyName(tbl.Name)),
+		Detail:  fmt.Sprintf("Key (%s)=(%s) already exists.", tbl.PrimaryKey, text),
+	}
+}
+
+// Whether the INSERT skips the rows of keys that exist, with ON CONFLICT DO NOTHING.
+func onConflictDoNothing(stmt *pgquery.InsertStmt, tbl *tableDefinition) (bool, error) {
+	oc := stmt.OnConflictClause
+	if oc == nil {
+		return false, nil
+	}
+	if oc.Action != pgquery.OnConflictAction_ONCONFLICT_NOTHING {
+		return false, &pgError{Code: sqlStateFeatureNotSupported, Message: "ON CONFLICT DO UPDATE is not supported"}
+	}
+	// Note: the columns of the conflict target must be those of the primary key
+	if elems := oc.GetInfer().GetIndexElems(); len(elems) > 0 {
+		if len(elems) != 1 || tbl.PrimaryKey == "" || elems[0].GetIndexElem().GetName() != tbl.PrimaryKey {
+			return false, &pgError{Code: sqlStateInvalidColumnReference, Message: "there is no unique or exclusion constraint matching the ON CONFLICT specification"}
+		}
+	}
+	return true, nil
+}
+
+// Set the entry of the key of a row, whose id may be the incomplete versionstamp of a row that is being inserted.
+func setPrimaryKeyEntry(tr fdb.Transaction, entry fdb.Key, id string) {
+	t := tuple.Tuple{rowIDElement(id)}
+	incomplete, err := t.HasIncompleteVersionstamp()
+	if err != nil {
+		panic(err)
+	}
+	if !incomplete {
+		writes(tr).Set(entry, t.Pack())
+		return
+	}
+
+	value, err := t.PackWithVersionstamp(nil)
+	if err != nil {
+		panic(err)
+	}
+	writes(tr).SetVersionstampedValue(entry, value)
+}
+
+// Whether the entry of a key, as read, belongs to a row that exists. Rows with a tombstone don't.
+func primaryKeyTaken(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, target string, entry []byte) bool {
+	if entry == nil {
+		return false
+	}
+	t, err := tuple.Unpack(entry)
+	if err != nil || len(t) != 1 {
+		return false
+	}
+	return readTombstone(rtr, tableDataSS, target, rowIDString(t[0])).MustGet() == nil
+}
+
+// Clear the entry of the key of a row that is cleared, unless a row inserted since took it over.
+func clearPrimaryKeyEntry(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target, id string, r row) {
+	if tbl.PrimaryKey == "" || r[tbl.PrimaryKey] == nil {
+		return
+	}
+	entry := primaryKeyEntry(tableDataSS, target, r[tbl.PrimaryKey])
+	if t, err := tuple.Unpack(tr.Get(entry).MustGet()); err == nil && len(t) == 1 && rowIDString(t[0]) == id {
+		writes(tr).Clear(entry)
+	}
+}
+
+// Reads of the entries of the keys of the rows of an INSERT, in a transaction. They are issued
+// primaryKeyReadAhead rows ahead of the row that waits for its entry.
+type primaryKeyReads struct {
+	tr      fdb.Transaction
+	entries []fdb.Key
+	futures map[int]fdb.FutureByteSlice
+	next    int
+}
+
+func newPrimaryKeyReads(tr fdb.Transaction, entries []fdb.Key) *primaryKeyReads {
+	return &primaryKeyReads{tr: tr, entries: entries, futures: map[int]fdb.FutureByteSlice{}}
+}
+
+// The entry of the key of row i, nil if there is none.
+func (r *primaryKeyReads) get(i int) []byte {
+	r.next = max(r.next, i)
+	for ; r.next < len(r.entries) && r.next <= i+primaryKeyReadAhead; r.next++ {
+		r.futures[r.next] = r.tr.Get(r.entries[r.next])
+	}
+	f, ok := r.futures[i]
+	if !ok {
+		f = r.tr.Get(r.entries[i])
+	}
+	delete(r.futures, i)
+	return f.MustGet()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+/*
+
+Transaction priorities.
+
+FoundationDB starts the transactions of a priority before those of lower ones when the cluster
+is saturated. The work the server does on its own and the statements that write or read a
+whole table run at batch priority, so they slow down instead of the queries of clients:
+
+```
+batch      the ttl and tombstone reapers, CREATE INDEX, ANALYZE, fakegres_recount_rows,
+           INSERTs and COPYs of at least bulkInsertRows rows
+default    every other statement
+```
+
+A session can run its statements at another priority, outside of a transaction block and in
+the blocks it begins from then on, which batch priority doesn't override:
+
+```sql
+set fakegres.transaction_priority = batch;  -- default, batch or immediate
+```
+
+Immediate is the priority of the system transactions of FoundationDB, which are never held
+back, and should only be used to repair a cluster that is too busy for anything else. The
+statements of a transaction block run in the transaction of the block, at the priority it
+began with.
+
+*/
+
+const (
+	priorityDefault   = "default"
+	priorityBatch     = "batch"
+	priorityImmediate = "immediate"
+)
+
+// INSERTs and COPYs of at least this many rows are bulk loads, which run at batch priority.
+const bulkInsertRows = 10000
+
+// The transactions of a priority keyspace are started at its priority.
+type priorityKeyspace struct {
+	keyspace
+	priority string
+}
+
+func (pk priorityKeyspace) Transact(f func(fdb.Transaction) (interface{}, error)) (interface{}, error) {
+	return pk.keyspace.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := setPriority(tr, pk.priority); err != nil {
+			return nil, err
+		}
+		return f(tr)
+	})
+}
+
+func (pk priorityKeyspace) ReadTransact(f func(fdb.ReadTransaction) (interface{}, error)) (interface{}, error) {
+	return pk.keyspace.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		if tr, ok := rtr.(fdb.Transaction); ok {
+			if err := setPriority(tr, pk.priority); err != nil {
+				return nil, err
+			}
+		}
+		return f(rtr)
+	})
+}
+
+func (pk priorityKeyspace) CreateTransaction() (fdb.Transaction, error) {
+	tr, err := pk.keyspace.CreateTransaction()
+	if err != nil {
+		return tr, err
+	}
+	return tr, setPriority(tr, pk.priority)
+}
+
+// Set the priority of a transaction before its first read.
+func setPriority(tr fdb.Transaction, priority string) error {
+	switch priority {
+	case priorityBatch:
+		return tr.Options().SetPriorityBatch()
+	case priorityImmediate:
+		return tr.Options().SetPrioritySystemImmediate()
+	}
+	return nil
+}
+
+// The keyspace with its transactions at the priority.
+func withPriority(ks keyspace, priority string) keyspace {
+	if priority == priorityDefault {
+		return ks
+	}
+	if pk, ok := ks.(priorityKeyspace); ok {
+		ks = pk.keyspace
+	}
+	return priorityKeyspace{ks, priority}
+}
+
+// The engine with its transactions at the priority, unless the session set one. In a transaction
+// block the statements stay in the transaction of the block, at the priority it began with.
+func (pe pgEngine) withPriority(priority string) pgEngine {
+	if _, ok := pe.db.(priorityKeyspace); ok {
+		return pe
+	}
+	if ks, ok := pe.db.(keyspace); ok {
+		pe.db = withPriority(ks, priority)
+	}
+	return pe
+}
+
+func checkPriority(value string) (string, error) {
+	switch value {
+	case priorityDefault, priorityBatch, priorityImmediate:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid value for parameter \"fakegres.transaction_priority\": \"%s\"", value)
+}
+
+// The keyspace of the session, at the priority it set.
+func (pgs *pgServer) sessionKeyspace() keyspace {
+	priority, _ := pgs.setting("fakegres.transaction_priority")
+	return withPriority(pgs.keyspace, priority)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+Protocol versions.
+
+A StartupMessage starts with the version of the protocol the client speaks, the major version
+in the upper 16 bits and the minor version in the lower ones. fakegres speaks 3.0. A client
+asking for a later 3.x version, or for protocol options (parameters named _pq_.<option>), gets
+a NegotiateProtocolVersion with the newest minor version and the options it doesn't know, and
+the session goes on with 3.0 without them, like in Postgres:
+
+```
+StartupMessage            3.2, user=alice, _pq_.compression=on
+NegotiateProtocolVersion  0, [_pq_.compression]
+AuthenticationOk          ...
+```
+
+Other major versions are refused. Clients of protocol 2 get the error in the format of
+protocol 2, so they can show it:
+
+```
+FATAL:  unsupported frontend protocol 2.0: server supports 3.0 to 3.0
+```
+
+The request codes of SSLRequest, GSSENCRequest and CancelRequest take the place of the version
+in the messages that aren't a StartupMessage.
+
+GSSENCRequest is answered with 'N' like an SSLRequest without TLS, GSSAPI encryption isn't
+supported. libpq clients with GSS enabled send it first and go on with an SSLRequest.
+
+*/
+
+const (
+	protocolMajorVersion = 3
+	protocolMinorVersion = 0
+	maxStartupPacketLen  = 10000
+	cancelRequestCode    = 80877102
+	sslRequestCode       = 80877103
+	gssEncRequestCode    = 80877104
+)
+
+// A backend reading from the connection, with the reader the startup message is read from.
+func (pgs *pgServer) newBackend() *pgproto3.Backend {
+	pgs.reader = pgproto3.NewChunkReader(pgs.conn)
+	return pgproto3.NewBackend(pgs.reader, pgs.conn)
+}
+
+func (pgs *pgServer) receiveStartupMessage() (pgproto3.FrontendMessage, error) {
+	buf, err := pgs.reader.Next(4)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(buf)) - 4
+	if size < 4 || size > maxStartupPacketLen {
+		return nil, fmt.Errorf("invalid length of startup packet: %d", size)
+	}
+	buf, err = pgs.reader.Next(size)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg pgproto3.FrontendMessage
+	switch code := binary.BigEndian.Uint32(buf); code {
+	case cancelRequestCode:
+		msg = &pgproto3.CancelRequest{}
+	case sslRequestCode:
+		msg = &pgproto3.SSLRequest{}
+	case gssEncRequestCode:
+		msg = &pgproto3.GSSEncRequest{}
+	default:
+		return pgs.negotiateProtocolVersion(code, buf)
+	}
+	if err := msg.Decode(buf); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Decode a StartupMessage of any 3.x version, telling the client what of it isn't supported.
+func (pgs *pgServer) negotiateProtocolVersion(version uint32, buf []byte) (*pgproto3.StartupMessage, error) {
+	major, minor := version>>16, version&0xffff
+	if major != protocolMajorVersion {
+		return nil, pgs.rejectProtocolVersion(major, minor)
+	}
+
+	// Note: the parameters are the same in every 3.x version, the message is decoded as 3.0
+	binary.BigEndian.PutUint32(buf, pgproto3.ProtocolVersionNumber)
+	sm := &pgproto3.StartupMessage{}
+	if err := sm.Decode(buf); err != nil {
+		return nil, err
+	}
+
+	var unknownOptions []string
+	for name := range sm.Parameters {
+		if strings.HasPrefix(name, "_pq_.") {
+			unknownOptions = append(unknownOptions, name)
+			delete(sm.Parameters, name)
+		}
+	}
+	if minor <= protocolMinorVersion && len(unknownOptions) == 0 {
+		return sm, nil
+	}
+
+	sort.Strings(unknownOptions)
+	msg := []byte{'v', 0, 0, 0, 0}
+	msg = binary.BigEndian.AppendUint32(msg, protocolMinorVersion)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(unknownOptions)))
+	for _, name := range unknownOptions {
+		msg = append(msg, name...)
+		msg = append(msg, 0)
+	}
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(msg)-1))
+	if err := pgs.write(msg); err != nil {
+		return nil, fmt.Errorf("error sending negotiate protocol version: %w", err)
+	}
+	return sm, nil
+}
+
+func (pgs *pgServer) rejectProtocolVersion(major, minor uint32) error {
+	message := fmt.Sprintf("unsupported frontend protocol %d.%d: server supports %d.0 to %d.%d", major, minor, protocolMajorVersion, protocolMajorVersion, protocolMinorVersion)
+	if major < protocolMajorVersion {
+		// Note: an ErrorResponse of protocol 2 is the text of the error, ended by a newline and a null byte
+		buf := append([]byte{'E'}, "FATAL:  "+message+"\n"...)
+		if err := pgs.write(append(buf, 0)); err != nil {
+			return fmt.Errorf("error sending unsupported protocol: %w", err)
+		}
+	} else if err := pgs.writeFatal(sqlStateFeatureNotSupported, message); err != nil {
+		return err
+	}
+	return fmt.Errorf("refused protocol version %d.%d", major, minor)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+
+PROXY protocol.
+
+Behind a TCP load balancer every connection comes from the address of the load balancer. With
+-proxy-protocol the load balancer sends the address of the real client in a PROXY header before
+anything else, in the text format of version 1 or the binary format of version 2 of the HAProxy
+PROXY protocol, and the session takes that address as the address of its client:
+
+```
+PROXY TCP4 203.0.113.7 10.0.0.5 51234 6000\r\n
+```
+
+```
+\r\n\r\n\x00\r\nQUIT\n  0x21  0x11  12  cb 00 71 07  0a 00 00 05  c8 22  17 70
+signature               v2    TCP4 len  source      destination  ports
+```
+
+Anyone who can reach the port could send a header with any address, so headers are only
+accepted from the networks of -proxy-trusted, the load balancers:
+
+```bash
+$ ./fakegres-fdb -listen-addr=0.0.0.0 -proxy-protocol -proxy-trusted=10.0.0.0/24
+```
+
+Connections from other peers are closed before they are read from, and so are connections that
+don't start with a header. Headers that don't carry an address, PROXY UNKNOWN and the LOCAL
+command of version 2 the load balancer uses for health checks, keep the address of the
+connection.
+
+*/
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The networks of -proxy-trusted.
+var proxyTrusted []*net.IPNet
+
+func parseProxyTrusted(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	if s == "" {
+		return networks, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q, expected a CIDR like 10.0.0.0/8", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Whether the peer of the connection is in a network of -proxy-trusted.
+func trustedProxy(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range proxyTrusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// The longest header of version 1, with its \r\n.
+const maxProxyV1HeaderLen = 107
+
+// A connection with the client address of its PROXY header.
+type proxiedConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Read the PROXY header the connection starts with, and take the client address in it as the
+// address of the connection. The header is read without reading past it, the startup message
+// follows it on the connection.
+func (pgs *pgServer) acceptProxyHeader() error {
+	if !trustedProxy(pgs.conn.RemoteAddr()) {
+		return fmt.Errorf("connection from %s is not from a trusted proxy, see -proxy-trusted", pgs.conn.RemoteAddr())
+	}
+
+	start := make([]byte, 6)
+	if _, err := io.ReadFull(pgs.conn, start); err != nil {
+		return fmt.Errorf("error reading PROXY header: %w", err)
+	}
+
+	var addr net.Addr
+	var err error
+	switch {
+	case string(start) == "PROXY ":
+		addr, err = readProxyV1Header(pgs.conn)
+	case bytes.Equal(start, proxyV2Signature[:6]):
+		addr, err = readProxyV2Header(pgs.conn)
+	default:
+		return fmt.Errorf("connection from %s doesn't start with a PROXY header", pgs.conn.RemoteAddr())
+	}
+	if err != nil {
+		return fmt.Errorf("invalid PROXY header from %s: %w", pgs.conn.RemoteAddr(), err)
+	}
+
+	if addr != nil {
+		pgs.conn = &proxiedConn{Conn: pgs.conn, remoteAddr: addr}
+	}
+	return nil
+}
+
+// The source address of a version 1 header, after its "PROXY ". Nil for PROXY UNKNOWN.
+func readProxyV1Header(r io.Reader) (net.Addr, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) > maxProxyV1HeaderLen-len("PROXY ") {
+			return nil, fmt.Errorf("header is too long")
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) > 0 && fields[0] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("expected TCP4 or TCP6 with two addresses and ports, got %q", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[1])
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid source address %s port %s", fields[1], fields[3])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// The source address of a version 2 header, after the first 6 bytes of its signature. Nil for
+// the LOCAL command and for addresses that aren't TCP.
+func readProxyV2Header(r io.Reader) (net.Addr, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:6], proxyV2Signature[6:]) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	if header[6]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", header[6]>>4)
+	}
+
+	// Note: the addresses are followed by TLVs, which are skipped
+	payload := make([]byte, binary.BigEndian.Uint16(header[8:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	command, family := header[6]&0x0f, header[7]
+	switch {
+	case command == 0:
+		return nil, nil
+	case command != 1:
+		return nil, fmt.Errorf("unknown command %d", command)
+	case family == 0x11:
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("addresses of TCP4 are too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case family == 0x21:
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("addresses of TCP6 are too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	}
+	return nil, nil
+}
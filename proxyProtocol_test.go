@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// A version 2 header, with its whole signature, of the command and family and their payload.
+func proxyV2Header(command, family byte, payload []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// The payload of the TCP addresses and ports of a version 2 header.
+func proxyV2Addresses(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	payload := append(append([]byte(nil), src...), dst...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestReadProxyV1Header(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		addr   string
+		err    string
+	}{
+		{name: "tcp4", header: "PROXY TCP4 203.0.113.7 10.0.0.5 51234 6000\r\n", addr: "203.0.113.7:51234"},
+		{name: "tcp6", header: "PROXY TCP6 2001:db8::7 2001:db8::5 51234 6000\r\n", addr: "[2001:db8::7]:51234"},
+		{name: "unknown", header: "PROXY UNKNOWN\r\n"},
+		{name: "unknown with addresses", header: "PROXY UNKNOWN 203.0.113.7 10.0.0.5 51234 6000\r\n"},
+		{name: "longest header", header: "PROXY UNKNOWN ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"},
+		{name: "too long", header: "PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n", err: "header is too long"},
+		{name: "bad port", header: "PROXY TCP4 203.0.113.7 10.0.0.5 65536 6000\r\n", err: "invalid source address 203.0.113.7 port 65536"},
+		{name: "bad address", header: "PROXY TCP4 203.0.113 10.0.0.5 51234 6000\r\n", err: "invalid source address 203.0.113 port 51234"},
+		{name: "bad protocol", header: "PROXY UDP4 203.0.113.7 10.0.0.5 51234 6000\r\n", err: "expected TCP4 or TCP6"},
+		{name: "missing port", header: "PROXY TCP4 203.0.113.7 10.0.0.5 51234\r\n", err: "expected TCP4 or TCP6"},
+		{name: "truncated", header: "PROXY TCP4 203.0.113.7", err: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "longest header" && len(tt.header) != maxProxyV1HeaderLen {
+				t.Fatalf("header is %d bytes, expected %d", len(tt.header), maxProxyV1HeaderLen)
+			}
+			// Note: the reader is given the header after its "PROXY ", which acceptProxyHeader read, and
+			// the startup message after a complete header
+			rest := tt.header[len("PROXY "):]
+			if tt.err == "" {
+				rest += "startup"
+			}
+			r := strings.NewReader(rest)
+			addr, err := readProxyV1Header(r)
+			checkProxyHeader(t, addr, err, tt.addr, tt.err)
+			if err == nil {
+				if rest, _ := io.ReadAll(r); string(rest) != "startup" {
+					t.Fatalf("read past the header, %q is left", rest)
+				}
+			}
+		})
+	}
+}
+
+func TestReadProxyV2Header(t *testing.T) {
+	tcp4 := proxyV2Addresses(net.ParseIP("203.0.113.7").To4(), net.ParseIP("10.0.0.5").To4(), 51234, 6000)
+	tcp6 := proxyV2Addresses(net.ParseIP("2001:db8::7"), net.ParseIP("2001:db8::5"), 51234, 6000)
+	badSignature := proxyV2Header(1, 0x11, tcp4)
+	badSignature[8] = 'X'
+	badVersion := proxyV2Header(1, 0x11, tcp4)
+	badVersion[12] = 0x11
+	truncated := proxyV2Header(1, 0x11, tcp4)
+	truncated = truncated[:len(truncated)-4]
+
+	tests := []struct {
+		name   string
+		header []byte
+		addr   string
+		err    string
+	}{
+		{name: "tcp4", header: proxyV2Header(1, 0x11, tcp4), addr: "203.0.113.7:51234"},
+		{name: "tcp6", header: proxyV2Header(1, 0x21, tcp6), addr: "[2001:db8::7]:51234"},
+		{name: "tlvs", header: proxyV2Header(1, 0x11, append(tcp4, 0x04, 0x00, 0x01, 0x00)), addr: "203.0.113.7:51234"},
+		{name: "local", header: proxyV2Header(0, 0x00, nil)},
+		{name: "local with addresses", header: proxyV2Header(0, 0x11, tcp4)},
+		{name: "unix", header: proxyV2Header(1, 0x31, make([]byte, 216))},
+		{name: "unknown command", header: proxyV2Header(2, 0x11, tcp4), err: "unknown command 2"},
+		{name: "bad signature", header: badSignature, err: "invalid signature"},
+		{name: "bad version", header: badVersion, err: "unsupported version 1"},
+		{name: "short tcp4", header: proxyV2Header(1, 0x11, tcp4[:8]), err: "addresses of TCP4 are too short"},
+		{name: "short tcp6", header: proxyV2Header(1, 0x21, tcp4), err: "addresses of TCP6 are too short"},
+		{name: "truncated payload", header: truncated, err: "unexpected EOF"},
+		{name: "truncated header", header: proxyV2Signature, err: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Note: the reader is given the header after the first 6 bytes of its signature, which
+			// acceptProxyHeader read, and the startup message after a complete header
+			rest := append([]byte(nil), tt.header[6:]...)
+			if tt.err == "" {
+				rest = append(rest, "startup"...)
+			}
+			r := bytes.NewReader(rest)
+			addr, err := readProxyV2Header(r)
+			checkProxyHeader(t, addr, err, tt.addr, tt.err)
+			if err == nil {
+				if rest, _ := io.ReadAll(r); string(rest) != "startup" {
+					t.Fatalf("read past the header, %q is left", rest)
+				}
+			}
+		})
+	}
+}
+
+func checkProxyHeader(t *testing.T, addr net.Addr, err error, expectedAddr, expectedErr string) {
+	t.Helper()
+	if expectedErr != "" {
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("error is %v, expected %q", err, expectedErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expectedAddr == "" {
+		if addr != nil {
+			t.Fatalf("address is %s, expected none", addr)
+		}
+		return
+	}
+	if addr == nil || addr.String() != expectedAddr {
+		t.Fatalf("address is %v, expected %s", addr, expectedAddr)
+	}
+}
+
+func TestParseProxyTrusted(t *testing.T) {
+	tests := []struct {
+		value    string
+		networks []string
+		err      bool
+	}{
+		{value: ""},
+		{value: "10.0.0.0/24", networks: []string{"10.0.0.0/24"}},
+		{value: "10.0.0.0/24, 192.168.1.7/32,2001:db8::/32", networks: []string{"10.0.0.0/24", "192.168.1.7/32", "2001:db8::/32"}},
+		{value: "10.0.0.5/24", networks: []string{"10.0.0.0/24"}},
+		{value: "10.0.0.5", err: true},
+		{value: "10.0.0.0/24,", err: true},
+		{value: "10.0.0.0/33", err: true},
+	}
+	for _, tt := range tests {
+		networks, err := parseProxyTrusted(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tt.value, networks)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.value, err)
+			continue
+		}
+		var got []string
+		for _, network := range networks {
+			got = append(got, network.String())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.networks, ",") {
+			t.Errorf("%q: networks are %v, expected %v", tt.value, got, tt.networks)
+		}
+	}
+}
+
+// A TCP connection of the server from 127.0.0.1, with the client writing the bytes and closing its end.
+func proxyTestConn(t *testing.T, written []byte) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write(written); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestAcceptProxyHeader(t *testing.T) {
+	defer func(trusted []*net.IPNet) { proxyTrusted = trusted }(proxyTrusted)
+	header := []byte("PROXY TCP4 203.0.113.7 10.0.0.5 51234 6000\r\nstartup")
+
+	tests := []struct {
+		name    string
+		trusted string
+		written []byte
+		addr    string
+		err     string
+	}{
+		{name: "trusted", trusted: "127.0.0.0/8", written: header, addr: "203.0.113.7:51234"},
+		{name: "trusted v2", trusted: "10.0.0.0/8,127.0.0.1/32", written: proxyV2Header(1, 0x11, proxyV2Addresses(net.ParseIP("203.0.113.7").To4(), net.ParseIP("10.0.0.5").To4(), 51234, 6000)), addr: "203.0.113.7:51234"},
+		{name: "local", trusted: "127.0.0.0/8", written: proxyV2Header(0, 0x00, nil)},
+		{name: "untrusted", trusted: "10.0.0.0/8", written: header, err: "is not from a trusted proxy"},
+		{name: "none trusted", trusted: "", written: header, err: "is not from a trusted proxy"},
+		{name: "no header", trusted: "127.0.0.0/8", written: []byte("\x00\x00\x00\x08\x04\xd2\x16\x2f"), err: "doesn't start with a PROXY header"},
+		{name: "invalid header", trusted: "127.0.0.0/8", written: []byte("PROXY TCP4 203.0.113.7 10.0.0.5 99999 6000\r\n"), err: "invalid PROXY header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if proxyTrusted, err = parseProxyTrusted(tt.trusted); err != nil {
+				t.Fatal(err)
+			}
+			conn := proxyTestConn(t, tt.written)
+			pgs := &pgServer{conn: conn}
+			err = pgs.acceptProxyHeader()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error is %v, expected %q", err, tt.err)
+				}
+				// Note: a connection that isn't trusted is rejected before anything is read from it
+				if tt.name == "untrusted" || tt.name == "none trusted" {
+					if rest, _ := io.ReadAll(conn); !bytes.Equal(rest, tt.written) {
+						t.Fatalf("read %q from the connection before rejecting it", tt.written[:len(tt.written)-len(rest)])
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Note: a header without an address keeps the address of the connection
+			expected := tt.addr
+			if expected == "" {
+				expected = conn.RemoteAddr().String()
+			}
+			if addr := pgs.conn.RemoteAddr().String(); addr != expected {
+				t.Fatalf("address is %s, expected %s", addr, expected)
+			}
+		})
+	}
+}
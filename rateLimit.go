@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+
+Rate limits.
+
+So one client can't keep the FoundationDB cluster busy for everyone else, queries beyond a limit
+fail with 53400 instead of running:
+
+```bash
+$ ./fakegres-fdb -conn-queries-per-second=50 -host-queries-per-second=200 -host-max-concurrent-queries=8
+```
+
+```
+ERROR:  too many queries per second from host 203.0.113.7
+```
+
+-conn-queries-per-second limits every session, -host-queries-per-second and
+-host-max-concurrent-queries the sessions of one client address together, which is the address
+of the PROXY header behind a load balancer. Clients of the Unix domain socket count as one host.
+A session runs one query at a time, so only the sessions of a host together run queries
+concurrently.
+
+Rates are kept in token buckets that hold a second of queries, so a client that was quiet may
+send a burst of that many at once. A query is a simple Query message or an Execute of a portal
+that isn't running yet, whatever number of statements it has. Zero turns a limit off.
+
+*/
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Take a token for a query, if the bucket has one. It fills with rate tokens a second, up to a
+// second of them, and starts full.
+func (b *tokenBucket) take(rate float64, now time.Time) bool {
+	b.refill(rate, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) refill(rate float64, now time.Time) {
+	burst := math.Max(1, rate)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// The queries of the sessions of a client address.
+type hostQueries struct {
+	bucket  tokenBucket
+	running int
+}
+
+var (
+	hostsMu sync.Mutex
+	hosts   = map[string]*hostQueries{}
+	// Hosts that have no query running and a full bucket are dropped once a minute
+	lastHostsSweep time.Time
+)
+
+// The address of the client, without its port.
+func (pgs *pgServer) clientHost() string {
+	addr := pgs.conn.RemoteAddr()
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return "local"
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Count a query against the rate limits, failing with 53400 if it is over one of them. The
+// returned function ends the query.
+func (pgs *pgServer) throttle() (func(), error) {
+	now := time.Now()
+	if pgs.cfg.connQueriesPerSecond > 0 && !pgs.queries.take(pgs.cfg.connQueriesPerSecond, now) {
+		return nil, &pgError{Code: sqlStateConfigurationLimitExceeded, Message: "too many queries per second from this connection"}
+	}
+	if pgs.cfg.hostQueriesPerSecond <= 0 && pgs.cfg.hostMaxConcurrentQueries <= 0 {
+		return func() {}, nil
+	}
+
+	host := pgs.clientHost()
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	if now.Sub(lastHostsSweep) > time.Minute {
+		sweepHosts(pgs.cfg.hostQueriesPerSecond, now)
+		lastHostsSweep = now
+	}
+
+	h, ok := hosts[host]
+	if !ok {
+		h = &hostQueries{}
+		hosts[host] = h
+	}
+	if pgs.cfg.hostMaxConcurrentQueries > 0 && h.running >= pgs.cfg.hostMaxConcurrentQueries {
+		return nil, &pgError{Code: sqlStateConfigurationLimitExceeded, Message: fmt.Sprintf("too many concurrent queries from host %s", host)}
+	}
+	if pgs.cfg.hostQueriesPerSecond > 0 && !h.bucket.take(pgs.cfg.hostQueriesPerSecond, now) {
+		return nil, &pgError{Code: sqlStateConfigurationLimitExceeded, Message: fmt.Sprintf("too many queries per second from host %s", host)}
+	}
+
+	h.running++
+	return func() {
+		hostsMu.Lock()
+		defer hostsMu.Unlock()
+		h.running--
+	}, nil
+}
+
+// Drop the hosts a new entry would be the same as. Called with hostsMu held.
+func sweepHosts(rate float64, now time.Time) {
+	for host, h := range hosts {
+		if h.running > 0 {
+			continue
+		}
+		if rate > 0 {
+			h.bucket.refill(rate, now)
+			if h.bucket.tokens < math.Max(1, rate) {
+				continue
+			}
+		}
+		delete(hosts, host)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+/*
+
+Cached read versions.
+
+Every FoundationDB transaction starts by asking the cluster for a read version, a round trip
+before the first read. With -read-version-cache the selects outside of transaction blocks reuse
+a read version the server got less than that long ago instead, and only ask for a new one once
+it is older:
+
+```
+fakegres -read-version-cache=100ms
+```
+
+A select may then miss what other sessions committed in the last 100ms. A session always sees
+its own writes: the select after any other statement of the session gets a new read version,
+which every select of the server reuses from then on. Read versions are only ever replaced by
+newer ones. A select that fails with the cached version, for example because it is older than
+the 5 seconds FoundationDB keeps, is retried with a new one.
+
+*/
+
+type readVersionCache struct {
+	mu      sync.Mutex
+	version int64
+	at      time.Time
+}
+
+var readVersions readVersionCache
+
+// The cached read version, false if there is none that was got within maxAge.
+func (c *readVersionCache) get(maxAge time.Duration) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version == 0 || time.Since(c.at) > maxAge {
+		return 0, false
+	}
+	return c.version, true
+}
+
+func (c *readVersionCache) put(version int64, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version > c.version {
+		c.version, c.at = version, at
+	}
+}
+
+// Reads of a cached read version transactor use the cached read version if it is recent enough.
+type cachedReadVersionTransactor struct {
+	fdb.Transactor
+	maxAge time.Duration
+	// Whether the first read has to get a new read version, it is cleared once it did
+	fresh *bool
+}
+
+func (ct cachedReadVersionTransactor) ReadTransact(f func(fdb.ReadTransaction) (interface{}, error)) (interface{}, error) {
+	retry := false
+	return ct.Transactor.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		tr, ok := rtr.(fdb.Transaction)
+		if !ok {
+			return f(rtr)
+		}
+
+		// Note: a retry gets a new read version, the cached one may be why it failed
+		if version, ok := readVersions.get(ct.maxAge); ok && !retry && !*ct.fresh {
+			tr.SetReadVersion(version)
+		} else {
+			at := time.Now()
+			readVersions.put(tr.GetReadVersion().MustGet(), at)
+			*ct.fresh = false
+		}
+		retry = true
+		return f(rtr)
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+
+Relation sizes.
+
+pg_relation_size, pg_table_size, pg_indexes_size and pg_total_relation_size tell how many bytes
+a table or an index takes, from FoundationDB's estimate of the size of the key ranges that hold
+it, and pg_size_pretty formats them:
+
+```sql
+select pg_size_pretty(pg_total_relation_size('person'));
+select pg_relation_size('person_age_idx');
+```
+
+```
+pg_relation_size, pg_table_size   data/table_data/person/...        (the row and columnar layout)
+pg_indexes_size                   data/text_index/person/...        (every index of the table)
+pg_relation_size of an index      data/text_index/person/person_age_idx/...
+pg_total_relation_size            both
+```
+
+The estimates come from the byte samples the storage servers keep, so they are cheap however
+big the table is, but ranges of less than a few megabytes may be estimated at 0. The rows of
+a partitioned table are in its partitions, like in Postgres its own size is 0. Sizes need the
+engine, so they are computed before the statement is evaluated and replace the calls, which
+have to name the relation with a constant, its name or its OID.
+
+*/
+
+var relationSizeFuncs = map[string]bool{
+	"pg_relation_size":       true,
+	"pg_table_size":          true,
+	"pg_indexes_size":        true,
+	"pg_total_relation_size": true,
+}
+
+/*
+
+Replace the calls of relation size functions in the statement with their values. The statement
+is left as it is, it may be prepared and run again, a copy is returned if it has calls.
+
+*/
+
+func (pe pgEngine) resolveRelationSizes(stmt *pgquery.RawStmt) (*pgquery.RawStmt, error) {
+	if !hasRelationSizeCall(stmt.ProtoReflect()) {
+		return stmt, nil
+	}
+
+	resolved := proto.Clone(stmt).(*pgquery.RawStmt)
+	var err error
+	walkNodes(resolved.ProtoReflect(), func(n *pgquery.Node) {
+		fc := n.GetFuncCall()
+		if err != nil || fc == nil || !relationSizeFuncs[funcName(fc)] {
+			return
+		}
+
+		var size int64
+		if size, err = pe.relationSize(fc); err == nil {
+			n.Node = &pgquery.Node_AConst{AConst: &pgquery.A_Const{Val: &pgquery.Node{
+				// Note: integer constants are 32 bits, larger ones are Float nodes like in the parser
+				Node: &pgquery.Node_Float{Float: &pgquery.Float{Str: strconv.FormatInt(size, 10)}},
+			}}}
+		}
+	})
+	return resolved, err
+}
+
+func hasRelationSizeCall(m protoreflect.Message) bool {
+	found := false
+	walkNodes(m, func(n *pgquery.Node) {
+		if fc := n.GetFuncCall(); fc != nil && relationSizeFuncs[funcName(fc)] {
+			found = true
+		}
+	})
+	return found
+}
+
+// The size in bytes of the relation a size function is called with.
+func (pe pgEngine) relationSize(fc *pgquery.FuncCall) (int64, error) {
+	name := funcName(fc)
+	if len(fc.Args) != 1 {
+		return 0, fmt.Errorf("function %s takes one argument", name)
+	}
+
+	// Note: 'person'::regclass names the relation like 'person' does
+	arg := fc.Args[0]
+	if tc := arg.GetTypeCast(); tc != nil && typeNameString(tc.TypeName) == "regclass" {
+		arg = tc.Arg
+	}
+	v, err := evalExpr(arg, &tableDefinition{}, row{})
+	if err != nil {
+		return 0, fmt.Errorf("function %s takes the name or OID of a relation as a constant: %w", name, err)
+	}
+
+	table, index, err := pe.lookupRelation(v)
+	if err != nil {
+		return 0, err
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ranges []fdb.KeyRange
+	tableRange := func() {
+		ranges = append(ranges, rangeOf(dataDir.Sub("table_data").Sub(table)))
+	}
+	indexesRange := func() {
+		ranges = append(ranges, rangeOf(dataDir.Sub("text_index").Sub(table)))
+	}
+
+	switch {
+	case index != "":
+		// Note: an index is a relation of its own, it has no indexes
+		if name != "pg_indexes_size" {
+			ranges = append(ranges, rangeOf(dataDir.Sub("text_index").Sub(table, index)))
+		}
+	case name == "pg_relation_size", name == "pg_table_size":
+		tableRange()
+	case name == "pg_indexes_size":
+		indexesRange()
+	default:
+		tableRange()
+		indexesRange()
+	}
+
+	size, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		var estimates []fdb.FutureInt64
+		for _, kr := range ranges {
+			estimates = append(estimates, rtr.GetEstimatedRangeSizeBytes(kr))
+		}
+		var size int64
+		for _, e := range estimates {
+			size += e.MustGet()
+		}
+		return size, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not get relation size: %w", err)
+	}
+	return size.(int64), nil
+}
+
+func rangeOf(ss subspace.Subspace) fdb.KeyRange {
+	begin, end := ss.FDBRangeKeys()
+	return fdb.KeyRange{Begin: begin, End: end}
+}
+
+// The table, and the index if the relation is one, of a relation name or OID.
+func (pe pgEngine) lookupRelation(v any) (string, string, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return "", "", err
+	}
+
+	switch rel := v.(type) {
+	case string:
+		// Note: the schema of the statement is the schema of its tables, see schema.go
+		if _, name, ok := strings.Cut(rel, "."); ok {
+			rel = name
+		}
+		for _, name := range names {
+			if name == rel {
+				return name, "", nil
+			}
+		}
+		table, _, err := pe.findIndex(rel)
+		if err != nil {
+			return "", "", undefinedTable(rel)
+		}
+		return table, rel, nil
+	case int64:
+		for _, name := range names {
+			if int64(relationOid(name)) == rel {
+				return name, "", nil
+			}
+		}
+		return "", "", &pgError{Code: sqlStateUndefinedTable, Message: fmt.Sprintf("relation with OID %d does not exist", rel)}
+	}
+	return "", "", fmt.Errorf("a relation is named by its name or OID, not %v", v)
+}
+
+// pg_size_pretty: bytes, or kB, MB, GB or TB rounded to the nearest whole unit, from 10 units on.
+func sizePretty(size int64) string {
+	const limit = 10 * 1024
+	const limit2 = limit*2 - 1
+	abs := func(x int64) int64 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	halfRounded := func(x int64) int64 {
+		if x < 0 {
+			return (x - 1) / 2
+		}
+		return (x + 1) / 2
+	}
+
+	if abs(size) < limit {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	// Note: one extra bit is kept for rounding, like Postgres does
+	size >>= 9
+	for _, unit := range []string{"kB", "MB", "GB"} {
+		if abs(size) < limit2 {
+			return fmt.Sprintf("%d %s", halfRounded(size), unit)
+		}
+		size >>= 10
+	}
+	return fmt.Sprintf("%d TB", halfRounded(size))
+}
+
+func evalSizePretty(fc *pgquery.FuncCall, tbl *tableDefinition, r row) (any, error) {
+	if len(fc.Args) != 1 {
+		return nil, fmt.Errorf("function pg_size_pretty takes one argument")
+	}
+	v, err := evalExpr(fc.Args[0], tbl, r)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	size, ok := v.(int64)
+	if !ok {
+		return nil, fmt.Errorf("function pg_size_pretty takes a bigint, not %v", v)
+	}
+	return sizePretty(size), nil
+}
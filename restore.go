@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+
+Restores.
+
+With -restore the server runs the statements of a dump (see dump.go) and loads the rows of its
+COPYs, and exits instead of serving:
+
+```
+fakegres -restore backup.sql
+fakegres -restore shop.sql -restore-databases shop=shop_copy -restore-schemas billing=archive
+fakegres -restore - -restore-skip-existing < backup.sql
+```
+
+```
+2024/05/02 10:12:03 Restored 20000 rows of person
+2024/05/02 10:12:04 Restored 31402 rows of person
+2024/05/02 10:12:04 Restored 14 tables and 31402 rows, created 2 databases
+```
+
+The statements create the catalog entries of the dump like they would for a client: schemas,
+types, tables, partitions, comments and indexes. The rows of a COPY are read restoreChunkRows
+at a time, each chunk is loaded like a COPY FROM STDIN (see bulkLoad.go), in batches of
+transactions at batch priority. A chunk with a row that isn't valid stops the restore, the
+chunks before it stay loaded.
+
+-restore-databases and -restore-schemas restore databases and schemas under other names, as
+old=new,... A database or schema the dump creates or connects to that exists already is
+restored into, one that doesn't is created. A table that exists fails the restore, unless
+-restore-skip-existing skips it with its rows, comments and indexes.
+
+Only the statements a dump has are restored, dumps of Postgres may need to be edited first.
+
+*/
+
+// The rows of a COPY of a dump that are loaded together.
+const restoreChunkRows = bulkInsertRows
+
+// A restore of a dump, statement by statement.
+type restore struct {
+	db  fdb.Database
+	cfg config
+	in  *bufio.Reader
+	// The line of the dump that was read last
+	line int
+	// The names databases and schemas are restored under
+	databases map[string]string
+	schemas   map[string]string
+	// The engine of the database the dump connected to, in its public schema
+	pe pgEngine
+	// The tables -restore-skip-existing skipped, by schema and name
+	skipped map[[2]string]bool
+
+	createdDatabases, restoredTables, restoredRows int
+}
+
+// Restore the dump of the file of -restore into the keyspace.
+func restoreDump(db fdb.Database, cfg config) error {
+	in := io.Reader(os.Stdin)
+	if cfg.restore != "-" {
+		f, err := os.Open(cfg.restore)
+		if err != nil {
+			return fmt.Errorf("could not open dump file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	databases, err := parseRenames(cfg.restoreDatabases)
+	if err != nil {
+		return err
+	}
+	schemas, err := parseRenames(cfg.restoreSchemas)
+	if err != nil {
+		return err
+	}
+	r := &restore{db: db, cfg: cfg, in: bufio.NewReader(in), databases: databases, schemas: schemas}
+	if err := r.connect(defaultDatabase); err != nil {
+		return err
+	}
+	if err := r.run(); err != nil {
+		return fmt.Errorf("could not restore line %d of the dump: %w", r.line, err)
+	}
+	log.Printf("Restored %d tables and %d rows, created %d databases", r.restoredTables, r.restoredRows, r.createdDatabases)
+	return nil
+}
+
+// Names to restore objects under, from a list of old=new,...
+func parseRenames(s string) (map[string]string, error) {
+	renames := map[string]string{}
+	if s == "" {
+		return renames, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(entry, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid rename %q, expected old=new", entry)
+		}
+		renames[from] = to
+	}
+	return renames, nil
+}
+
+// The name a database or schema is restored under.
+func renamed(renames map[string]string, name string) string {
+	if to, ok := renames[name]; ok {
+		return to
+	}
+	return name
+}
+
+// Read the next line of the dump, without its line break. ok is false at the end of the dump.
+func (r *restore) readLine() (line string, ok bool, err error) {
+	line, err = r.in.ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return "", false, nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("could not read dump file: %w", err)
+	}
+	r.line++
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), true, nil
+}
+
+// Run the statements of the dump up to its end.
+func (r *restore) run() error {
+	// Note: a statement ends with a line that ends with a semicolon, and parses, string constants can span lines
+	var pending strings.Builder
+	var parseErr error
+	for {
+		line, ok, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if pending.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+			if name, ok := strings.CutPrefix(trimmed, `\connect `); ok {
+				if err := r.connect(unquoteIdent(strings.TrimSpace(name))); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.HasPrefix(trimmed, `\`) {
+				return &pgError{Code: sqlStateFeatureNotSupported, Message: fmt.Sprintf("meta-command %s is not supported", strings.Fields(trimmed)[0])}
+			}
+		}
+
+		pending.WriteString(line)
+		pending.WriteString("\n")
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			continue
+		}
+		tree, err := pgquery.Parse(pending.String())
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		pending.Reset()
+		parseErr = nil
+
+		for _, stmt := range tree.GetStmts() {
+			if err := r.statement(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	if parseErr != nil {
+		return parseErr
+	}
+	if pending.Len() > 0 {
+		return fmt.Errorf("the dump ends in the middle of a statement")
+	}
+	return nil
+}
+
+// An identifier as written by quoteIdent, or as it is if it isn't quoted.
+func unquoteIdent(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+}
+
+// Restore into a database of the dump, creating it if it doesn't exist.
+func (r *restore) connect(name string) error {
+	name = renamed(r.databases, name)
+	if err := r.createDatabase(name); err != nil {
+		return err
+	}
+	pe, err := databaseEngine(r.db, r.cfg, name)
+	if err != nil {
+		return err
+	}
+	r.pe = pe.withPriority(priorityBatch)
+	r.skipped = map[[2]string]bool{}
+	return nil
+}
+
+func (r *restore) createDatabase(name string) error {
+	root, err := databaseEngine(r.db, r.cfg, defaultDatabase)
+	if err != nil {
+		return err
+	}
+	exists, err := root.databaseExists(name)
+	if err != nil || exists {
+		return err
+	}
+	if err := root.executeCreateDatabase(&pgquery.CreatedbStmt{Dbname: name}); err != nil {
+		return err
+	}
+	r.createdDatabases++
+	return nil
+}
+
+// Run a statement of the dump in the database it connected to.
+func (r *restore) statement(raw *pgquery.RawStmt) error {
+	n := raw.GetStmt()
+	if c := n.GetCreatedbStmt(); c != nil {
+		return r.createDatabase(renamed(r.databases, c.Dbname))
+	}
+	if c := n.GetCreateSchemaStmt(); c != nil {
+		_, err := r.pe.withSchema(renamed(r.schemas, c.Schemaname)).ensureSchema()
+		return err
+	}
+
+	renameSchemas(n, r.schemas)
+	schema, err := statementSchema(n)
+	if err != nil {
+		return err
+	}
+	// Note: the objects of public aren't qualified in a dump, they are restored into the schema public is renamed to
+	if schema == defaultSchema {
+		schema = renamed(r.schemas, defaultSchema)
+	}
+	pe, err := r.pe.withSchema(schema).ensureSchema()
+	if err != nil {
+		return err
+	}
+
+	table := restoredTable(n)
+	if r.skipped[[2]string{schema, table}] {
+		if n.GetCopyStmt() != nil {
+			return r.skipCopyData()
+		}
+		return nil
+	}
+	if c := n.GetCreateStmt(); c != nil && r.cfg.restoreSkipExisting {
+		exists, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+			return pe.tableExists(rtr, table), nil
+		})
+		if err != nil {
+			return err
+		}
+		if exists.(bool) {
+			log.Printf("Skipping table %s, it exists", table)
+			r.skipped[[2]string{schema, table}] = true
+			return nil
+		}
+	}
+
+	if c := n.GetCopyStmt(); c != nil {
+		return r.copyRows(pe, c)
+	}
+	if _, err := pe.execute(&pgquery.ParseResult{Stmts: []*pgquery.RawStmt{raw}}); err != nil {
+		return err
+	}
+	if n.GetCreateStmt() != nil {
+		r.restoredTables++
+	}
+	return nil
+}
+
+// The engine in its schema, once the schema exists.
+func (pe pgEngine) ensureSchema() (pgEngine, error) {
+	exists, err := pe.schemaExists(pe.schema)
+	if err != nil || exists {
+		return pe, err
+	}
+	return pe, pe.executeCreateSchema(&pgquery.CreateSchemaStmt{Schemaname: pe.schema})
+}
+
+// Rename the schemas the statement names objects of.
+func renameSchemas(n *pgquery.Node, schemas map[string]string) {
+	// Note: the schema of a list of names is the one before the names in the schema
+	rename := func(items []*pgquery.Node, names int) {
+		if len(items) > names {
+			if s := items[len(items)-names-1].GetString_(); s != nil {
+				s.Str = renamed(schemas, s.Str)
+			}
+		}
+	}
+	walkMessages(n.ProtoReflect(), func(m protoreflect.Message) {
+		switch v := m.Interface().(type) {
+		case *pgquery.RangeVar:
+			if v.Schemaname != "" {
+				v.Schemaname = renamed(schemas, v.Schemaname)
+			}
+		case *pgquery.TypeName:
+			rename(v.Names, 1)
+		}
+	})
+
+	switch {
+	case n.GetCreateEnumStmt() != nil:
+		rename(n.GetCreateEnumStmt().TypeName, 1)
+	case n.GetCreateDomainStmt() != nil:
+		rename(n.GetCreateDomainStmt().Domainname, 1)
+	case n.GetCommentStmt() != nil:
+		names := 1
+		if n.GetCommentStmt().Objtype == pgquery.ObjectType_OBJECT_COLUMN {
+			names = 2
+		}
+		rename(n.GetCommentStmt().Object.GetList().GetItems(), names)
+	}
+}
+
+// The table a statement of a dump belongs to, empty for types.
+func restoredTable(n *pgquery.Node) string {
+	switch {
+	case n.GetCreateStmt() != nil:
+		return n.GetCreateStmt().Relation.Relname
+	case n.GetCopyStmt() != nil:
+		return n.GetCopyStmt().GetRelation().GetRelname()
+	case n.GetIndexStmt() != nil:
+		return n.GetIndexStmt().Relation.Relname
+	case n.GetCommentStmt() != nil:
+		items := n.GetCommentStmt().Object.GetList().GetItems()
+		if n.GetCommentStmt().Objtype == pgquery.ObjectType_OBJECT_COLUMN && len(items) > 1 {
+			items = items[:len(items)-1]
+		}
+		if len(items) > 0 {
+			return items[len(items)-1].GetString_().GetStr()
+		}
+	}
+	return ""
+}
+
+// Load the rows of a COPY of the dump, restoreChunkRows at a time.
+func (r *restore) copyRows(pe pgEngine, cs *pgquery.CopyStmt) error {
+	if !cs.IsFrom || cs.IsProgram || cs.Filename != "" || cs.Relation == nil {
+		return &pgError{Code: sqlStateFeatureNotSupported, Message: "only COPY table FROM STDIN is supported"}
+	}
+	options, err := copyOptionsOf(cs)
+	if err != nil {
+		return err
+	}
+	tbl, err := pe.getTableDefinition(cs.Relation.Relname)
+	if err != nil {
+		return err
+	}
+	columns, err := copyColumns(cs, tbl)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]any
+	copied := 0
+	load := func() error {
+		n, err := pe.bulkInsert(tbl.Name, rows)
+		copied += n
+		r.restoredRows += n
+		if err != nil {
+			return err
+		}
+		log.Printf("Restored %d rows of %s", copied, tbl.Name)
+		rows = rows[:0]
+		return nil
+	}
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, ok, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("the dump ends in the data of the COPY of %s", tbl.Name)
+		}
+		if line == `\.` {
+			break
+		}
+
+		values, err := copyRow(tbl, columns, copyFields(line, options), lineNumber)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, values)
+		if len(rows) == restoreChunkRows {
+			if err := load(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rows) > 0 {
+		return load()
+	}
+	return nil
+}
+
+// Read past the data of a COPY of a table that is skipped.
+func (r *restore) skipCopyData() error {
+	for {
+		line, ok, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("the dump ends in the data of a COPY")
+		}
+		if line == `\.` {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Row counts.
+
+The count of rows of every table and partition (see statistics.go) is kept by atomic adds,
+which don't conflict with each other: INSERT adds 1 for every row, DELETE takes the rows of
+every batch off and clears the count once the table is empty. count(*) of a whole table, the
+live tuples of pg_stat_user_tables and pg_class.reltuples read the count instead of the rows:
+
+```sql
+select count(*) from person;
+select relname, n_live_tup from pg_stat_user_tables;
+```
+
+Only a select of count(*) alone, without WHERE, GROUP BY, LIMIT or OFFSET, is answered from
+the count. Counts can drift from the rows, for tables written before they were kept or by an
+ANALYZE that ran while rows were written, fakegres_recount_rows counts the rows of a table and
+its partitions again and sets their counts:
+
+```sql
+select * from fakegres_recount_rows('person');
+```
+
+```
+ relname | recorded | counted
+---------+----------+---------
+ person  |     1040 |    1042
+```
+
+The rows are counted in batches of their own transactions, like ANALYZE, which sets the
+counts too. Rows written while they are counted may be missed, it can't run in a transaction
+block.
+
+*/
+
+// The table of a select of count(*) of a table alone, which is answered from its row count.
+func matchCountStar(stmt *pgquery.SelectStmt) (string, bool) {
+	if len(stmt.TargetList) != 1 || len(stmt.FromClause) != 1 || stmt.WhereClause != nil ||
+		len(stmt.GroupClause) > 0 || stmt.HavingClause != nil || len(stmt.DistinctClause) > 0 ||
+		stmt.LimitCount != nil || stmt.LimitOffset != nil {
+		return "", false
+	}
+	fc := stmt.TargetList[0].GetResTarget().GetVal().GetFuncCall()
+	if fc == nil || funcName(fc) != "count" || !fc.AggStar || fc.AggFilter != nil || fc.Over != nil {
+		return "", false
+	}
+	rv := stmt.FromClause[0].GetRangeVar()
+	if rv == nil {
+		return "", false
+	}
+	if _, ok := lookupCatalogRelation(rv); ok {
+		return "", false
+	}
+	return rv.Relname, true
+}
+
+func (pe pgEngine) executeCountStar(stmt *pgquery.SelectStmt, name string) (*pgResult, error) {
+	// Note: the definition is read for its error, the table may not exist
+	if _, err := pe.getTableDefinition(name); err != nil {
+		return nil, err
+	}
+	stats, err := pe.getTableStats(name)
+	if err != nil {
+		return nil, err
+	}
+	return &pgResult{
+		fieldNames: []string{targetName(stmt.TargetList[0].GetResTarget())},
+		fieldTypes: []string{"pg_catalog.int8"},
+		fieldExprs: []*pgquery.Node{nil},
+		rows:       [][]any{{max(0, stats.rows)}},
+	}, nil
+}
+
+// The count of rows recorded for a target before it was recounted, and the rows counted.
+type rowRecount struct {
+	target   string
+	recorded int64
+	counted  int64
+}
+
+// Count the rows of the table and its partitions and set their row counts.
+func (pe pgEngine) recountRows(name string) ([]rowRecount, error) {
+	if _, err := pe.getTableDefinition(name); err != nil {
+		return nil, err
+	}
+	targets, err := pe.tableTargets(name)
+	if err != nil {
+		return nil, err
+	}
+
+	statsSS := pe.statsSubspace()
+	var recounts []rowRecount
+	for _, target := range targets {
+		// Note: a batch that is retried reads its rows again, the rows up to the last one counted are skipped
+		var counted int64
+		lastID := ""
+		err := pe.scanRowBatches(target, func(tr fdb.Transaction, ids []string, rows []row) error {
+			for _, id := range ids {
+				if lastID != "" && !rowIDLess(lastID, id) {
+					continue
+				}
+				lastID = id
+				counted++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not count rows of %s: %w", target, err)
+		}
+
+		recorded, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			key := rowCountKey(statsSS, target)
+			recorded := decodeRowCount(tr.Get(key).MustGet())
+			writes(tr).Set(key, encodeRowCount(counted))
+			return recorded, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not set row count of %s: %w", target, err)
+		}
+		recounts = append(recounts, rowRecount{target: target, recorded: recorded.(int64), counted: counted})
+	}
+	return recounts, nil
+}
+
+func (pgs *pgServer) handleRecountRowsStmt(ctx context.Context, stmt *pgquery.Node) (bool, error) {
+	fc := selectedFuncCall(stmt, "fakegres_recount_rows")
+	if fc == nil {
+		return false, nil
+	}
+
+	if len(fc.Args) != 1 {
+		return true, fmt.Errorf("fakegres_recount_rows takes a table name")
+	}
+	v, err := evalExpr(fc.Args[0], &tableDefinition{}, row{})
+	if err != nil {
+		return true, err
+	}
+	name, ok := v.(string)
+	if !ok {
+		return true, fmt.Errorf("fakegres_recount_rows takes a table name")
+	}
+
+	if pgs.tx != nil {
+		return true, &pgError{Code: sqlStateActiveSQLTransaction, Message: "fakegres_recount_rows cannot run inside a transaction block"}
+	}
+
+	pe := pgs.engine.withTransactor(pgs.transactor()).withContext(ctx)
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		exists, err := pe.schemaExists(schema)
+		if err != nil {
+			return true, err
+		}
+		if !exists {
+			return true, &pgError{Code: sqlStateInvalidSchemaName, Message: fmt.Sprintf("schema \"%s\" does not exist", schema)}
+		}
+		pe, name = pe.withSchema(schema), table
+	}
+
+	recounts, err := pe.withPriority(priorityBatch).recountRows(name)
+	if err != nil {
+		return true, err
+	}
+	pgs.freshRead = true
+
+	res := &pgResult{
+		fieldNames: []string{"relname", "recorded", "counted"},
+		fieldTypes: []string{"text", "pg_catalog.int8", "pg_catalog.int8"},
+	}
+	for _, r := range recounts {
+		res.rows = append(res.rows, []any{r.target, r.recorded, r.counted})
+	}
+	return true, pgs.writePgResult(res, "SELECT")
+}
+
+// The live rows of the tables of the schema, from their row counts.
+func pgStatUserTablesRows(pe pgEngine) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+	schema := pe.schema
+	if schema == "" {
+		schema = defaultSchema
+	}
+
+	var rows []row
+	for _, name := range names {
+		stats, err := pe.getTableStats(name)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row{
+			"relid":      oidString(relationOid(name)),
+			"schemaname": schema,
+			"relname":    name,
+			"n_live_tup": strconv.FormatInt(max(0, stats.rows), 10),
+		})
+	}
+	return rows, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Row values.
+
+The row layout keeps a row in one value under the key of its id, instead of a key per cell,
+so the key prefix of the table and the id aren't repeated for every column and a row is read
+with one key:
+
+```
+data/table_data/person/r/<row id>: 0x01 <1> <2> (14,) <2> <7> ("garry",)
+```
+
+The value starts with the version of its format, which is 1, followed by the cells of the row
+in the order of their columns. A cell is the id of its column (see columnId.go) and the length
+of the cell as uvarints, then the cell as it is in the columnar layout: encoded, compressed
+(see compression.go) or the marker of its chunks (see largeValue.go). A row inserted before a
+column was added has no cell for it, and a reader skips the cells of columns it doesn't know,
+so columns can come and go without rewriting the rows.
+
+A value holds at most 100,000 bytes. The largest cells of a row that doesn't fit go to chunks
+of their own until it does, a marker is a few bytes. A row value in a format the server doesn't
+know is read as a row without cells, like decodeCell reads a cell it can't decode as its bytes.
+
+Databases whose rows had a key per cell get their rows packed when the server starts, see
+migration.go.
+
+*/
+
+// The version of the format of the row values the server writes.
+const rowFormatVersion = 1
+
+// The key of a row in the row layout.
+func rowKey(target, id string) tuple.Tuple {
+	return tuple.Tuple{target, "r", rowIDElement(id)}
+}
+
+// The value of a row in the row layout, of the stored cells of its columns by name.
+func encodeRow(tbl *tableDefinition, cells map[string][]byte) []byte {
+	value := []byte{rowFormatVersion}
+	for _, name := range tbl.ColumnNames {
+		cell, ok := cells[name]
+		if !ok {
+			continue
+		}
+		id, _ := tbl.columnKey(name).(int64)
+		value = binary.AppendUvarint(value, uint64(id))
+		value = binary.AppendUvarint(value, uint64(len(cell)))
+		value = append(value, cell...)
+	}
+	return value
+}
+
+// The stored cells of the columns of the table in the value of a row, by name.
+func decodeRow(tbl *tableDefinition, value []byte) map[string][]byte {
+	cells := map[string][]byte{}
+	if len(value) == 0 || value[0] != rowFormatVersion {
+		return cells
+	}
+	b := value[1:]
+	for len(b) > 0 {
+		id, n := binary.Uvarint(b)
+		if n <= 0 {
+			break
+		}
+		b = b[n:]
+		size, n := binary.Uvarint(b)
+		if n <= 0 || size > uint64(len(b)-n) {
+			break
+		}
+		b = b[n:]
+		if name := tbl.columnOfKey(int64(id)); name != "" {
+			cells[name] = b[:size:size]
+		}
+		b = b[size:]
+	}
+	return cells
+}
+
+// Decode the value of a row, reading the chunks of its large cells.
+func readRowValue(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target, id string, value []byte) row {
+	r := row{}
+	for name, cell := range decodeRow(tbl, value) {
+		r[name] = readCell(rtr, tableDataSS, target, id, tbl.columnKey(name), cell)
+	}
+	return r
+}
+
+/*
+
+Write the stored cells of a row in the layouts of the table, the cells of the row by name in the
+columnar layout and the value of the row in the row layout. Cells of the row go to chunks, the
+largest first, until its value fits.
+
+*/
+
+func writeRow(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target, id string, cells map[string][]byte) {
+	var value []byte
+	if tbl.writesRows() {
+		for value = encodeRow(tbl, cells); len(value) > maxValueSize; value = encodeRow(tbl, cells) {
+			largest := ""
+			for _, name := range tbl.ColumnNames {
+				if cell, ok := cells[name]; ok && !chunkedMarker(cell) && (largest == "" || len(cell) > len(cells[largest])) {
+					largest = name
+				}
+			}
+			if largest == "" {
+				break
+			}
+			cells[largest] = chunkCell(tr, tableDataSS, target, id, tbl.columnKey(largest), cells[largest])
+		}
+	}
+
+	if tbl.writesColumns() {
+		for name, cell := range cells {
+			setRowKey(tr, tableDataSS, tuple.Tuple{target, "c", tbl.columnKey(name), rowIDElement(id)}, cell)
+		}
+	}
+	if tbl.writesRows() {
+		setRowKey(tr, tableDataSS, rowKey(target, id), value)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Row ids.
+
+The internal id of a row is the versionstamp of the transaction that inserted it, with the
+position of the row in the transaction as its user version. FoundationDB fills the versionstamp
+in when the transaction commits, so rows are stored in the order they were inserted, and a scan
+of the row layout, which is how a select without ORDER BY reads, returns them in that order:
+
+```
+data/table_data/person/r/<versionstamp 0x00000002a4c31f500000 0>: <row of garry>
+data/table_data/person/r/<versionstamp 0x00000002a4c31f500000 1>: <row of ted>
+data/table_data/person/r/<versionstamp 0x00000002a4c8b0e20000 0>: <row of nick>
+```
+
+A versionstamp is 12 bytes, where the UUIDs rows used to have were 36. Rows inserted with a
+UUID keep it, they sort before every row with a versionstamp.
+
+The rows of a transaction block can't wait for the commit to have an id, since the statements
+after the INSERT read them. They get the read version of the block instead, which is before
+the commit version of every transaction that commits after the block started, and the key of the
+row is added to the read conflicts of the block so two blocks can't write the same rows. A
+transaction writes at most 65536 rows, the number of user versions, INSERTs outside of a block
+commit before that.
+
+In Go ids are strings, the 12 bytes of the versionstamp or the UUID, and rowIDElement gives the
+element of the tuple of keys.
+
+*/
+
+// The element of row keys for an id.
+func rowIDElement(id string) tuple.TupleElement {
+	if len(id) != 12 {
+		return id
+	}
+	vs := tuple.Versionstamp{UserVersion: binary.BigEndian.Uint16([]byte(id[10:]))}
+	copy(vs.TransactionVersion[:], id[:10])
+	return vs
+}
+
+// The id of the element of a row key.
+func rowIDString(e tuple.TupleElement) string {
+	if vs, ok := e.(tuple.Versionstamp); ok {
+		return string(vs.Bytes())
+	}
+	return e.(string)
+}
+
+// Whether id a sorts before id b in the keys of rows.
+func rowIDLess(a, b string) bool {
+	return bytes.Compare(tuple.Tuple{rowIDElement(a)}.Pack(), tuple.Tuple{rowIDElement(b)}.Pack()) < 0
+}
+
+// Set a key that may have the incomplete versionstamp of a row that is being inserted.
+func setRowKey(tr fdb.Transaction, ss subspace.Subspace, t tuple.Tuple, value []byte) {
+	incomplete, err := t.HasIncompleteVersionstamp()
+	if err != nil {
+		panic(err)
+	}
+	if !incomplete {
+		writes(tr).Set(ss.Pack(t), value)
+		return
+	}
+
+	key, err := ss.PackWithVersionstamp(t)
+	if err != nil {
+		panic(err)
+	}
+	writes(tr).SetVersionstampedKey(key, value)
+}
+
+/*
+
+Return a function giving the ids of the rows inserted in the transaction, in a target of
+tableDataSS. It is called at the start of every transaction of an INSERT.
+
+*/
+
+func (pe pgEngine) newRowIDs(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition) func(target string) (string, error) {
+	tooMany := &pgError{Code: sqlStateProgramLimitExceeded, Message: "transaction inserts too many rows, at most 65536 rows can be inserted in one transaction"}
+	if _, ok := pe.db.(keyspace); ok {
+		n := 0
+		return func(string) (string, error) {
+			if n > math.MaxUint16 {
+				return "", tooMany
+			}
+			id := string(tuple.IncompleteVersionstamp(uint16(n)).Bytes())
+			n++
+			return id, nil
+		}
+	}
+
+	// Note: the last 2 bytes of the versionstamps of commits order the transactions of a version, they are never 0xffff
+	var version [10]byte
+	binary.BigEndian.PutUint64(version[:8], uint64(tr.GetReadVersion().MustGet()))
+	version[8], version[9] = 0xff, 0xff
+	return func(target string) (string, error) {
+		if pe.session.txRows > math.MaxUint16 {
+			return "", tooMany
+		}
+		vs := tuple.Versionstamp{TransactionVersion: version, UserVersion: uint16(pe.session.txRows)}
+		pe.session.txRows++
+		// Note: every insert of the row writes its value or its first cell, whatever the layout, a conflict on it is enough
+		id := string(vs.Bytes())
+		key := tableDataSS.Pack(rowKey(target, id))
+		if !tbl.storesRows() && len(tbl.ColumnNames) > 0 {
+			key = tableDataSS.Pack(tuple.Tuple{target, "c", tbl.columnKey(tbl.ColumnNames[0]), vs})
+		}
+		if err := tr.AddReadConflictKey(key); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+}
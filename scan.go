@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+)
+
+// Number of keys read per transaction by batched scans, rows of the row layout or cells of a column.
+const scanBatchKeys = 2000
+
+/*
+
+Scan the rows of a table in batches, each batch in its own transaction, so that work
+over a whole table stays within FoundationDB's transaction size and time limits.
+
+fn is called with the transaction of the batch, the internal row ids and the rows read in it.
+
+*/
+
+func (pe pgEngine) scanRowBatches(tableName string, fn func(tr fdb.Transaction, ids []string, rows []row) error) error {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	tbl, err := pe.getTableDefinition(tableName)
+	if err != nil {
+		return err
+	}
+
+	rangeQuery := scanRange(tableDataSS, tbl, tableName, tbl.rowScanLayout(), tbl.ColumnNames)
+	begin := rangeQuery.Begin
+	for {
+		if err := pe.checkCanceled(); err != nil {
+			return err
+		}
+
+		next, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			ids, rows, rowEnds, complete := readTableRows(tr, tableDataSS, tbl, tableName, tbl.rowScanLayout(), tbl.ColumnNames, fdb.KeyRange{Begin: begin, End: rangeQuery.End}, scanBatchKeys)
+			if complete {
+				return nil, fn(tr, ids, rows)
+			}
+			return rowEnds[len(rowEnds)-1], fn(tr, ids, rows)
+		})
+		if err != nil {
+			return fmt.Errorf("could not scan table %s: %w", tableName, err)
+		}
+
+		if next == nil {
+			return nil
+		}
+		begin = next.(fdb.Key)
+	}
+}
+
+/*
+
+Read the rows of a target from the layout in batches of snapshot reads, each batch in its own
+transaction and as the target was at one point in time. fn is called with the rows of every
+batch once it is read, outside of its transaction, so a read that is retried doesn't call it
+twice.
+
+*/
+
+func (pe pgEngine) readSnapshotBatches(tbl *tableDefinition, target string, layout string, columns []string, fn func(rows []row) error) error {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	rangeQuery := scanRange(tableDataSS, tbl, target, layout, columns)
+	begin := rangeQuery.Begin
+	for {
+		if err := pe.checkCanceled(); err != nil {
+			return err
+		}
+
+		type batch struct {
+			rows []row
+			next fdb.KeyConvertible
+		}
+		read, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+			_, rows, rowEnds, complete := readTableRows(rtr.Snapshot(), tableDataSS, tbl, target, layout, columns, fdb.KeyRange{Begin: begin, End: rangeQuery.End}, scanBatchKeys)
+			if complete {
+				return batch{rows: rows}, nil
+			}
+			return batch{rows: rows, next: rowEnds[len(rowEnds)-1]}, nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not scan table %s: %w", target, err)
+		}
+
+		b := read.(batch)
+		if err := fn(b.rows); err != nil {
+			return err
+		}
+		if b.next == nil {
+			return nil
+		}
+		begin = b.next
+	}
+}
+
+/*
+
+Read at most limit rows of the row layout, a key each (see rowFormat.go). Along with every row
+the key right after it is returned, which is where a read continuing after that row begins.
+
+complete is false if the read stopped because of the limit.
+
+Rows with a tombstone (see tombstone.go) are skipped. A read of only such rows goes on after
+them, until it has a row or there are no more.
+
+*/
+
+func readRows(tr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, target string, kr fdb.KeyRange, limit int) (ids []string, rows []row, rowEnds []fdb.Key, complete bool) {
+	tombstones := newTombstoneScan(tr, tableDataSS.Sub(target))
+	for {
+		batchIDs, batchRows, batchEnds, complete := readRowBatch(tr, tableDataSS, tbl, kr, limit)
+		for i, id := range batchIDs {
+			if !tombstones.deleted(id) {
+				ids, rows, rowEnds = append(ids, id), append(rows, batchRows[i]), append(rowEnds, batchEnds[i])
+			}
+		}
+		if complete || len(ids) > 0 {
+			return ids, rows, rowEnds, complete
+		}
+		kr.Begin = batchEnds[len(batchEnds)-1]
+	}
+}
+
+// Read at most limit rows of the row layout like readRows, the rows with a tombstone too.
+func readRowBatch(tr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, kr fdb.KeyRange, limit int) (ids []string, rows []row, rowEnds []fdb.Key, complete bool) {
+	kvs := tr.GetRange(kr, fdb.RangeOptions{
+		Limit: limit,
+		Mode:  fdb.StreamingModeWantAll,
+	}).GetSliceOrPanic()
+
+	for _, kv := range kvs {
+		t, _ := tableDataSS.Unpack(kv.Key)
+		id := rowIDString(t[2])
+		ids = append(ids, id)
+		rows = append(rows, readRowValue(tr, tableDataSS, tbl, t[0].(string), id, kv.Value))
+		rowEnds = append(rowEnds, keyAfter(kv.Key))
+	}
+	return ids, rows, rowEnds, len(kvs) < limit
+}
+
+// The first key sorting after k.
+func keyAfter(k fdb.Key) fdb.Key {
+	return append(k[:len(k):len(k)], 0x00)
+}
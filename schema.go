@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+
+Schemas.
+
+Every schema of a database has its own catalog and data directories, within the directory of
+the database (see database.go), so its tables are apart from the tables of other schemas:
+
+```sql
+create schema billing;
+create table billing.invoice (id int);
+insert into billing.invoice values (1);
+drop schema billing cascade;
+```
+
+```
+database/shop/schema/billing/catalog/table/invoice: ""
+database/shop/schema/billing/data/table_data/invoice/...
+```
+
+The public schema always exists and keeps its directories where they were before there were
+schemas, directly in the directory of the database. Dropping a schema removes its directory
+with everything in it.
+
+A statement runs in the schema of the tables it names, public when it names none with a schema.
+The tables of a statement must all be in one schema. Types are looked up in the schema of the
+statement and then in public, like with the default search_path, so tables of every schema
+can use the types of public. Locks, notifications and prepared transactions belong to the
+database, not to a schema.
+
+*/
+
+const defaultSchema = "public"
+
+// The directories every schema has its own of, the others belong to the database.
+var schemaDirectories = map[string]bool{"catalog": true, "data": true}
+
+// The path of the directory of the schema of the engine, relative to the directory of its database.
+func (pe pgEngine) schemaPath() []string {
+	if pe.schema == "" || pe.schema == defaultSchema {
+		return nil
+	}
+	return []string{"schema", pe.schema}
+}
+
+// The path of the directory of a schema other than public.
+func (pe pgEngine) schemaDirPath(schema string) []string {
+	return append(pe.databasePath(), pe.withSchema(schema).schemaPath()...)
+}
+
+func (pe pgEngine) withSchema(schema string) pgEngine {
+	pe.schema = schema
+	return pe
+}
+
+/*
+
+The schema of the tables the statement names, the default schema if it names none with a
+schema. Schemas of catalog relations, like pg_catalog, don't count.
+
+*/
+
+func statementSchema(stmt *pgquery.Node) (string, error) {
+	schemas := map[string]bool{}
+	add := func(schema string) {
+		if schema != "" && schema != "pg_catalog" && schema != "information_schema" {
+			schemas[schema] = true
+		}
+	}
+	// Note: objects are named by lists of names, the schema first, or by range vars
+	addName := func(items []*pgquery.Node) {
+		if len(items) > 1 {
+			add(items[len(items)-2].GetString_().GetStr())
+		}
+	}
+
+	switch {
+	// Note: CREATE SCHEMA and DROP SCHEMA name schemas, they run in the database
+	case stmt.GetCreateSchemaStmt() != nil:
+		return defaultSchema, nil
+	case stmt.GetDropStmt() != nil:
+		if stmt.GetDropStmt().RemoveType == pgquery.ObjectType_OBJECT_SCHEMA {
+			return defaultSchema, nil
+		}
+		for _, o := range stmt.GetDropStmt().Objects {
+			addName(o.GetList().GetItems())
+			addName(o.GetTypeName().GetNames())
+		}
+	case stmt.GetCreateEnumStmt() != nil:
+		addName(stmt.GetCreateEnumStmt().TypeName)
+	case stmt.GetCreateDomainStmt() != nil:
+		addName(stmt.GetCreateDomainStmt().Domainname)
+	case stmt.GetCommentStmt() != nil:
+		items := stmt.GetCommentStmt().Object.GetList().GetItems()
+		if stmt.GetCommentStmt().Objtype == pgquery.ObjectType_OBJECT_COLUMN && len(items) > 0 {
+			items = items[:len(items)-1]
+		}
+		addName(items)
+	}
+	walkRangeVars(stmt.ProtoReflect(), func(rv *pgquery.RangeVar) { add(rv.Schemaname) })
+
+	if len(schemas) > 1 {
+		return "", &pgError{Code: sqlStateFeatureNotSupported, Message: "statements over tables of more than one schema are not supported"}
+	}
+	for schema := range schemas {
+		return schema, nil
+	}
+	return defaultSchema, nil
+}
+
+// Call fn for every range var of the parse tree, which are not all in nodes.
+func walkRangeVars(m protoreflect.Message, fn func(*pgquery.RangeVar)) {
+	walkMessages(m, func(m protoreflect.Message) {
+		if rv, ok := m.Interface().(*pgquery.RangeVar); ok {
+			fn(rv)
+		}
+	})
+}
+
+// Call fn for every message of the parse tree, outer messages before the messages in them.
+func walkMessages(m protoreflect.Message, fn func(protoreflect.Message)) {
+	visit := func(m protoreflect.Message) {
+		fn(m)
+		walkMessages(m, fn)
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				visit(l.Get(i).Message())
+			}
+		case fd.Message() != nil && !fd.IsMap():
+			visit(v.Message())
+		}
+		return true
+	})
+}
+
+// Run the statements of the session in the schema of stmt, which has to exist.
+func (pgs *pgServer) useSchema(stmt *pgquery.Node) error {
+	schema, err := statementSchema(stmt)
+	if err != nil {
+		return err
+	}
+	pgs.engine = pgs.engine.withSchema(schema)
+
+	exists, err := pgs.engine.withTransactor(pgs.transactor()).schemaExists(schema)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &pgError{Code: sqlStateInvalidSchemaName, Message: fmt.Sprintf("schema \"%s\" does not exist", schema)}
+	}
+	return nil
+}
+
+func (pe pgEngine) schemaExists(schema string) (bool, error) {
+	if schema == defaultSchema {
+		return true, nil
+	}
+	exists, err := directory.Exists(pe.db, pe.schemaDirPath(schema))
+	if err != nil {
+		return false, fmt.Errorf("could not get schema: %w", err)
+	}
+	return exists, nil
+}
+
+func (pe pgEngine) executeCreateSchema(stmt *pgquery.CreateSchemaStmt) error {
+	if len(stmt.SchemaElts) > 0 {
+		return &pgError{Code: sqlStateFeatureNotSupported, Message: "CREATE SCHEMA with schema elements is not supported"}
+	}
+
+	exists, err := pe.schemaExists(stmt.Schemaname)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if stmt.IfNotExists {
+			pe.raiseNotice(sqlStateSuccessfulCompletion, "schema \"%s\" already exists, skipping", stmt.Schemaname)
+			return nil
+		}
+		return &pgError{Code: sqlStateDuplicateSchema, Message: fmt.Sprintf("schema \"%s\" already exists", stmt.Schemaname)}
+	}
+
+	if _, err := directory.Create(pe.db, pe.schemaDirPath(stmt.Schemaname), nil); err != nil {
+		return fmt.Errorf("could not create schema: %w", err)
+	}
+	return nil
+}
+
+func (pe pgEngine) executeDropSchema(stmt *pgquery.DropStmt) error {
+	for _, o := range stmt.Objects {
+		name := o.GetString_().GetStr()
+		if name == defaultSchema {
+			return &pgError{Code: sqlStateFeatureNotSupported, Message: "cannot drop schema public"}
+		}
+
+		exists, err := pe.schemaExists(name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if stmt.MissingOk {
+				pe.raiseNotice(sqlStateSuccessfulCompletion, "schema \"%s\" does not exist, skipping", name)
+				continue
+			}
+			return &pgError{Code: sqlStateInvalidSchemaName, Message: fmt.Sprintf("schema \"%s\" does not exist", name)}
+		}
+
+		if stmt.Behavior != pgquery.DropBehavior_DROP_CASCADE {
+			tables, err := pe.withSchema(name).getTableNames()
+			if err != nil {
+				return err
+			}
+			if len(tables) > 0 {
+				return fmt.Errorf("cannot drop schema %s because other objects depend on it: %s", name, strings.Join(tables, ", "))
+			}
+		}
+
+		if _, err := directory.Root().Remove(pe.db, pe.schemaDirPath(name)); err != nil {
+			return fmt.Errorf("could not drop schema: %w", err)
+		}
+		catalogCaches.invalidate(pe.database)
+	}
+	return nil
+}
+
+/*
+
+Start reading the catalog key of the type with the given name in the catalog subspace of kind,
+in the schema of the engine and in public. The returned function waits for the value, the one
+of the schema of the engine if it has the type.
+
+*/
+
+func (pe pgEngine) readTypeKey(tr fdb.ReadTransaction, kind, name string) func() []byte {
+	var futures []fdb.FutureByteSlice
+	for _, schema := range []string{pe.schema, defaultSchema} {
+		catalogDir, err := directory.CreateOrOpen(pe.db, pe.withSchema(schema).dirPath("catalog"), nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		futures = append(futures, tr.Get(catalogDir.Sub(kind).Pack(tuple.Tuple{name})))
+		if pe.schemaPath() == nil {
+			break
+		}
+	}
+	return func() []byte {
+		for _, f := range futures {
+			if value := f.MustGet(); value != nil {
+				return value
+			}
+		}
+		return nil
+	}
+}
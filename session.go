@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Sessions.
+
+Every connection has one session, from its startup until it closes, with the state Postgres
+keeps per backend: the transaction block, prepared statements, portals and cursors, the
+settings changed with SET, and the engine the statements of the session run with. The engine
+is created once the session knows its database, every statement runs with a copy of it that
+has the transaction block and the context of the query.
+
+The engine of a session caches the table definitions it reads from the catalog, so running the
+same queries over and over doesn't read the columns of the table and their types every time.
+Statements that change the catalog bump its version in the same transaction, which also
+invalidates the catalog cache of the server (see catalogCache.go):
+
+```
+catalog/version: 42
+```
+
+A cached definition is used as long as the version is the one it was read at, which takes a
+single read. Ending a transaction block without committing it drops the cache, which may hold
+definitions the block changed.
+
+Settings are kept as text. SET LOCAL only holds until the end of the transaction block, RESET
+goes back to the value in the options startup parameter if it has one. SHOW of a setting that
+was never set shows the parameter reported at startup, if there is one:
+
+```sql
+set application_name = 'reports';
+show application_name;
+reset application_name;
+```
+
+*/
+
+type session struct {
+	engine pgEngine
+	// The table definitions the engine read by schema and name, with the catalog version they were read at
+	tables map[string]cachedTable
+	// Settings changed with SET, by lower case name, and with SET LOCAL until the transaction block ends
+	settings      map[string]string
+	localSettings map[string]string
+	// The settings of the options startup parameter, which RESET goes back to
+	startupSettings map[string]string
+	cursors         map[string]*cursor
+	// The application_name of the startup message, which RESET goes back to
+	startupApplicationName string
+	// The open transaction block, nil outside of a block
+	tx          *fdb.Transaction
+	txIsolation string
+	// The rows inserted in the transaction block, which number the ids of the next ones
+	txRows int
+	// A statement of the transaction block failed, which aborts the block until it ends
+	txFailed bool
+	// A statement of the transaction block changed the catalog, see catalogCache.go
+	txChangedCatalog bool
+	// The transaction block is the implicit block of a query of several statements, see handleQuery
+	txImplicit bool
+	// The isolation level transaction blocks start with
+	defaultIsolation string
+	// The prepared statements and portals of the extended protocol
+	statements map[string]*preparedStatement
+	portals    map[string]*portal
+	// The portal being run, results go into it instead of to the client
+	portal *portal
+	// An extended protocol message failed, messages are ignored until the next Sync
+	skipUntilSync bool
+	// The session ran a statement that isn't a select, the next select gets a new read version, see readVersion.go
+	freshRead bool
+}
+
+type cachedTable struct {
+	version int64
+	tbl     *tableDefinition
+}
+
+func newSession() *session {
+	return &session{
+		tables:           map[string]cachedTable{},
+		settings:         map[string]string{},
+		localSettings:    map[string]string{},
+		startupSettings:  map[string]string{},
+		cursors:          map[string]*cursor{},
+		statements:       map[string]*preparedStatement{},
+		portals:          map[string]*portal{},
+		defaultIsolation: defaultIsolation,
+	}
+}
+
+// Drop the cached table definitions, after a transaction block that changed them may have been rolled back.
+func (s *session) forgetTables() {
+	s.tables = map[string]cachedTable{}
+}
+
+func (pe pgEngine) withSession(s *session) pgEngine {
+	pe.session = s
+	return pe
+}
+
+func (pe pgEngine) withTransactor(db fdb.Transactor) pgEngine {
+	pe.db = db
+	return pe
+}
+
+func (pe pgEngine) catalogVersionKey() fdb.Key {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return catalogDir.Pack(tuple.Tuple{"version"})
+}
+
+func (pe pgEngine) catalogVersion(rtr fdb.ReadTransaction) int64 {
+	return decodeCatalogVersion(rtr.Get(pe.catalogVersionKey()).MustGet())
+}
+
+// Run a statement that changes the catalog, bumping the catalog version in the same transaction.
+func (pe pgEngine) changeCatalog(execute func(pgEngine) error) error {
+	_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := execute(pe.withTransactor(tr)); err != nil {
+			return nil, err
+		}
+
+		pe.bumpCatalogVersion(tr)
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pe.catalogChanged()
+	return nil
+}
+
+// Bump the catalog version in the transaction that changes the catalog. Statements that run in
+// several transactions, like ALTER TABLE and CREATE INDEX, bump it in the one that changes the
+// catalog instead of running in changeCatalog, which would put them in a single transaction.
+func (pe pgEngine) bumpCatalogVersion(tr fdb.Transaction) {
+	one := make([]byte, 8)
+	binary.LittleEndian.PutUint64(one, 1)
+	writes(tr).Add(pe.catalogVersionKey(), one)
+}
+
+// Drop the cached catalog once the transaction that bumped the catalog version committed.
+func (pe pgEngine) catalogChanged() {
+	// Note: the change is committed with the transaction block, which drops the catalog cache then
+	if pe.session != nil && pe.session.tx != nil {
+		pe.session.txChangedCatalog = true
+		return
+	}
+	catalogCaches.invalidate(pe.database)
+}
+
+// The definition of the table from the cache of the session, nil if it changed since it was read.
+func (pe pgEngine) cachedTableDefinition(name string, version int64) *tableDefinition {
+	if pe.session == nil {
+		return nil
+	}
+	if c, ok := pe.session.tables[pe.schema+"."+name]; ok && c.version == version {
+		return c.tbl
+	}
+	return nil
+}
+
+func (pe pgEngine) cacheTableDefinition(tbl *tableDefinition, version int64) {
+	if pe.session != nil {
+		pe.session.tables[pe.schema+"."+tbl.Name] = cachedTable{version: version, tbl: tbl}
+	}
+}
+
+// Handle SET, RESET and SHOW of the settings no other handler knows. Returns false for any other statement.
+func (pgs *pgServer) handleSettingStmt(stmt *pgquery.Node) (bool, error) {
+	if show := stmt.GetVariableShowStmt(); show != nil {
+		name := strings.ToLower(show.Name)
+		if name == "all" {
+			res := &pgResult{fieldNames: []string{"name", "setting"}, fieldTypes: []string{"text", "text"}}
+			for _, s := range pgs.allSettings() {
+				res.rows = append(res.rows, []any{s[0], s[1]})
+			}
+			return true, pgs.writePgResult(res, "SHOW")
+		}
+
+		value, ok := pgs.setting(name)
+		if !ok {
+			return true, &pgError{Code: sqlStateUndefinedObject, Message: fmt.Sprintf("unrecognized configuration parameter \"%s\"", show.Name)}
+		}
+		return true, pgs.writePgResult(&pgResult{
+			fieldNames: []string{show.Name},
+			fieldTypes: []string{"text"},
+			rows:       [][]any{{value}},
+		}, "SHOW")
+	}
+
+	set := stmt.GetVariableSetStmt()
+	if set == nil {
+		return false, nil
+	}
+
+	name := strings.ToLower(set.Name)
+	tag := "SET"
+	switch set.Kind {
+	case pgquery.VariableSetKind_VAR_SET_VALUE:
+		var values []string
+		for _, arg := range set.Args {
+			v, err := evalExpr(arg, &tableDefinition{}, row{})
+			if err != nil {
+				return true, err
+			}
+			values = append(values, string(formatText(v)))
+		}
+		value := strings.Join(values, ", ")
+		value, err := checkSetting(name, value)
+		if err != nil {
+			return true, err
+		}
+		if !set.IsLocal {
+			pgs.settings[name] = value
+		} else if pgs.tx != nil {
+			pgs.localSettings[name] = value
+		} else {
+			pgs.sendNotice(notice{Severity: warningSeverity, Code: sqlStateNoActiveSQLTransaction, Message: "SET LOCAL can only be used in transaction blocks"})
+		}
+	case pgquery.VariableSetKind_VAR_SET_DEFAULT:
+		delete(pgs.settings, name)
+		delete(pgs.localSettings, name)
+	case pgquery.VariableSetKind_VAR_RESET:
+		delete(pgs.settings, name)
+		delete(pgs.localSettings, name)
+		tag = "RESET"
+	case pgquery.VariableSetKind_VAR_RESET_ALL:
+		pgs.resetSettings()
+		pgs.done(nil, "RESET")
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	pgs.settingChanged(name)
+	pgs.done(nil, tag)
+	return true, nil
+}
+
+// Drop every setting, reporting the ones the client is told about.
+func (pgs *pgServer) resetSettings() {
+	changed := pgs.settings
+	for name, value := range pgs.localSettings {
+		changed[name] = value
+	}
+	pgs.settings = map[string]string{}
+	pgs.localSettings = map[string]string{}
+	for name := range changed {
+		pgs.settingChanged(name)
+	}
+}
+
+// The value the setting is kept as, or an error if it isn't valid for the setting. Settings the
+// server doesn't use take any value.
+func checkSetting(name, value string) (string, error) {
+	switch name {
+	case "statement_timeout":
+		_, err := parseTimeout(name, value)
+		return value, err
+	case "client_encoding":
+		return checkClientEncoding(value)
+	case "fakegres.transaction_priority":
+		return checkPriority(value)
+	}
+	return value, nil
+}
+
+// The values of the settings the server uses, before they are set.
+func (pgs *pgServer) defaultSettings() map[string]string {
+	return map[string]string{
+		"statement_timeout":             formatTimeout(pgs.cfg.statementTimeout),
+		"fakegres.transaction_priority": priorityDefault,
+	}
+}
+
+// The value the session set the setting to, with SET LOCAL, SET or the options of its startup.
+func (pgs *pgServer) sessionSetting(name string) (string, bool) {
+	if value, ok := pgs.localSettings[name]; ok {
+		return value, true
+	}
+	if value, ok := pgs.settings[name]; ok {
+		return value, true
+	}
+	value, ok := pgs.startupSettings[name]
+	return value, ok
+}
+
+// The value of a setting, or its default or the parameter reported at startup if it wasn't set.
+func (pgs *pgServer) setting(name string) (string, bool) {
+	if value, ok := pgs.sessionSetting(name); ok {
+		return value, true
+	}
+	if value, ok := pgs.defaultSettings()[name]; ok {
+		return value, true
+	}
+	for _, ps := range pgs.parameterStatuses() {
+		if strings.EqualFold(ps.Name, name) {
+			return ps.Value, true
+		}
+	}
+	return "", false
+}
+
+// The name and value of every setting, default and startup parameter, sorted by name.
+func (pgs *pgServer) allSettings() [][2]string {
+	values := map[string]string{}
+	for _, ps := range pgs.parameterStatuses() {
+		values[strings.ToLower(ps.Name)] = ps.Value
+	}
+	for name, value := range pgs.defaultSettings() {
+		values[name] = value
+	}
+	for name, value := range pgs.startupSettings {
+		values[name] = value
+	}
+	for name, value := range pgs.settings {
+		values[name] = value
+	}
+	for name, value := range pgs.localSettings {
+		values[name] = value
+	}
+
+	var settings [][2]string
+	for name, value := range values {
+		settings = append(settings, [2]string{name, value})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i][0] < settings[j][0] })
+	return settings
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+/*
+
+Socket tuning.
+
+TCP connections of clients send keepalive probes once they are idle for -tcp-keepalive-idle, so
+a connection through a NAT or a firewall that drops idle flows stays open, and a client that
+went away without closing its connection is noticed. -tcp-keepalive-interval and
+-tcp-keepalive-count set the time between the probes and how many go unanswered before the
+connection is closed, like tcp_keepalives_interval and tcp_keepalives_count of Postgres:
+
+```bash
+$ ./fakegres-fdb -tcp-keepalive-idle=1m -tcp-keepalive-interval=10s -tcp-keepalive-count=6
+$ ./fakegres-fdb -tcp-keepalive=false
+```
+
+Nagle's algorithm is off by default, -tcp-nodelay=false turns it on. -socket-read-buffer and
+-socket-write-buffer size the kernel buffers of the connection, a larger write buffer streams
+large results with fewer waits on the client:
+
+```bash
+$ ./fakegres-fdb -socket-write-buffer=4194304
+```
+
+Zero leaves the interval, the count and the buffer sizes to the system. Connections of the Unix
+domain socket are local and aren't tuned.
+
+*/
+
+// Set the options of the flags on the TCP connection of a client.
+func tuneSocket(conn *net.TCPConn, cfg config) error {
+	if err := conn.SetKeepAlive(cfg.tcpKeepAlive); err != nil {
+		return fmt.Errorf("could not set keepalive: %w", err)
+	}
+	if cfg.tcpKeepAlive {
+		if cfg.tcpKeepAliveIdle > 0 {
+			if err := conn.SetKeepAlivePeriod(cfg.tcpKeepAliveIdle); err != nil {
+				return fmt.Errorf("could not set keepalive idle time: %w", err)
+			}
+		}
+		// Note: set after the idle time, which sets the interval too on some systems
+		if err := setKeepAliveProbes(conn, cfg.tcpKeepAliveInterval, cfg.tcpKeepAliveCount); err != nil {
+			return fmt.Errorf("could not set keepalive probes: %w", err)
+		}
+	}
+
+	if err := conn.SetNoDelay(cfg.tcpNoDelay); err != nil {
+		return fmt.Errorf("could not set nodelay: %w", err)
+	}
+	if cfg.socketReadBuffer > 0 {
+		if err := conn.SetReadBuffer(cfg.socketReadBuffer); err != nil {
+			return fmt.Errorf("could not set read buffer size: %w", err)
+		}
+	}
+	if cfg.socketWriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(cfg.socketWriteBuffer); err != nil {
+			return fmt.Errorf("could not set write buffer size: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// Set the time between keepalive probes and how many are sent, leaving the ones that are zero to the system.
+func setKeepAliveProbes(conn *net.TCPConn, interval time.Duration, count int) error {
+	if interval <= 0 && count <= 0 {
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if interval > 0 {
+			// Note: the interval is in whole seconds, rounded up so a short one isn't turned into 0
+			secs := int((interval + time.Second - 1) / time.Second)
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); sockErr != nil {
+				return
+			}
+		}
+		if count > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Note: the interval and the count of keepalive probes can only be set on Linux
+func setKeepAliveProbes(conn *net.TCPConn, interval time.Duration, count int) error {
+	if interval <= 0 && count <= 0 {
+		return nil
+	}
+	return fmt.Errorf("-tcp-keepalive-interval and -tcp-keepalive-count are only supported on Linux")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+
+The options startup parameter.
+
+libpq sends the options of PGOPTIONS or of the options connection parameter in the options
+startup parameter, command-line switches of the server that set run-time parameters for the
+session:
+
+```bash
+$ PGOPTIONS="-c search_path=myschema -c statement_timeout=5000" psql -h localhost -p 6000
+$ psql "host=localhost port=6000 options=--statement-timeout=5s"
+```
+
+Switches are separated by white space, a backslash keeps the next character, a space for
+example, in the switch. Settings are given with -c name=value, -cname=value or --name=value,
+and a dash in the name stands for an underscore like in Postgres. Any other switch, or a value
+that isn't valid for its setting, refuses the connection.
+
+The settings are the ones of the session from its start, RESET goes back to them.
+
+*/
+
+// Split the options startup parameter into its settings, in the order they are given.
+func parseStartupOptions(options string) ([][2]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, escaped := false, false
+	for _, r := range options {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inArg, escaped = true, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	var settings [][2]string
+	for i := 0; i < len(args); i++ {
+		var setting string
+		switch {
+		case args[i] == "-c" && i+1 < len(args):
+			i++
+			setting = args[i]
+		case strings.HasPrefix(args[i], "-c") && len(args[i]) > 2:
+			setting = args[i][2:]
+		case strings.HasPrefix(args[i], "--") && len(args[i]) > 2:
+			setting = args[i][2:]
+		default:
+			return nil, &pgError{Code: sqlStateSyntaxError, Message: fmt.Sprintf("invalid command-line argument for server process: %s", args[i])}
+		}
+
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return nil, &pgError{Code: sqlStateSyntaxError, Message: fmt.Sprintf("-c %s requires a value", setting)}
+		}
+		name = strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+		value, err := checkSetting(name, value)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, [2]string{name, value})
+	}
+	return settings, nil
+}
+
+// Take the settings of the options startup parameter as the ones of the session.
+func (pgs *pgServer) applyStartupOptions(options string) error {
+	settings, err := parseStartupOptions(options)
+	if err != nil {
+		return pgs.refuseConnection(err)
+	}
+	for _, s := range settings {
+		pgs.startupSettings[s[0]] = s[1]
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Statement timeouts.
+
+A query that runs longer than statement_timeout is canceled like a cancel request cancels it,
+and fails with 57014. The default comes from -statement-timeout, sessions change it with SET.
+Like in Postgres a number without a unit is in milliseconds, 0 turns the timeout off:
+
+```sql
+set statement_timeout = '5s';
+select * from big_table;
+ERROR:  canceling statement due to statement timeout
+set statement_timeout = 0;
+```
+
+The timeout covers every statement of a simple query together, and every Execute of the
+extended protocol on its own.
+
+*/
+
+var timeoutValue = regexp.MustCompile(`^(\d+)\s*(ms|s|min|h|d)?$`)
+
+var timeoutUnits = map[string]time.Duration{
+	"":    time.Millisecond,
+	"ms":  time.Millisecond,
+	"s":   time.Second,
+	"min": time.Minute,
+	"h":   time.Hour,
+	"d":   24 * time.Hour,
+}
+
+func parseTimeout(name, value string) (time.Duration, error) {
+	m := timeoutValue.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return 0, &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("invalid value for parameter \"%s\": \"%s\"", name, value)}
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("invalid value for parameter \"%s\": \"%s\"", name, value)}
+	}
+	return time.Duration(n) * timeoutUnits[m[2]], nil
+}
+
+// The timeout the way Postgres shows it, in the largest unit it is a whole number of.
+func formatTimeout(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dmin", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// The statement_timeout of the session, 0 for none.
+func (pgs *pgServer) statementTimeout() time.Duration {
+	value, ok := pgs.sessionSetting("statement_timeout")
+	if !ok {
+		return pgs.cfg.statementTimeout
+	}
+	// Note: the value was checked when it was set
+	timeout, _ := parseTimeout("statement_timeout", value)
+	return timeout
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Planner statistics.
+
+Every table, and every partition, counts its rows. INSERT adds to the count with an atomic add,
+which doesn't conflict with other inserts, and DELETE clears it. ANALYZE scans the table, with
+its partitions, and stores the exact count and the statistics of every column: the fraction of
+rows where it is null, an estimate of its distinct values and its smallest and largest value:
+
+```sql
+analyze person;
+select attname, null_frac, n_distinct, histogram_bounds from pg_stats where tablename = 'person';
+```
+
+```
+data/stats/person/rows: 1042
+data/stats/person/column/age: (0.01, 81, 18, 99)
+```
+
+Statistics are kept in the data directory, next to the rows, so changing them doesn't bump the
+catalog version. They are read with getTableStats, pg_class.reltuples and pg_stats show them.
+The distinct values are estimated from the 1024 smallest hashes of the values of the column,
+so ANALYZE of a large table doesn't keep every value in memory. The smallest and largest value
+are shown as the bounds of a histogram of one bucket.
+
+*/
+
+// The hashes the distinct estimate of a column keeps.
+const distinctSketchSize = 1024
+
+type columnStats struct {
+	nullFrac  float64
+	nDistinct int64
+	// The smallest and largest value, nil if the column has no values or they can't be compared
+	min, max any
+}
+
+type tableStats struct {
+	rows int64
+	// The statistics of the columns, empty before the table is analyzed
+	columns map[string]columnStats
+}
+
+func (pe pgEngine) statsSubspace() subspace.Subspace {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dataDir.Sub("stats")
+}
+
+// Add n, which may be negative, to the count of rows of target.
+func countRows(tr fdb.Transaction, statsSS subspace.Subspace, target string, n int64) {
+	writes(tr).Add(rowCountKey(statsSS, target), encodeRowCount(n))
+}
+
+func rowCountKey(statsSS subspace.Subspace, target string) fdb.Key {
+	return statsSS.Pack(tuple.Tuple{target, "rows"})
+}
+
+// Row counts are little endian, the byte order of FoundationDB's atomic add.
+func encodeRowCount(n int64) []byte {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(n))
+	return value
+}
+
+func decodeRowCount(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(value))
+}
+
+// The statistics of the table, with the rows of its partitions.
+func (pe pgEngine) getTableStats(name string) (*tableStats, error) {
+	targets, err := pe.tableTargets(name)
+	if err != nil {
+		return nil, err
+	}
+	statsSS := pe.statsSubspace()
+
+	stats, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		var counts []fdb.FutureByteSlice
+		for _, target := range targets {
+			counts = append(counts, rtr.Get(rowCountKey(statsSS, target)))
+		}
+		columnsSS := statsSS.Sub(name, "column")
+		kvs := rtr.GetRange(columnsSS, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}).GetSliceOrPanic()
+
+		stats := &tableStats{columns: map[string]columnStats{}}
+		for _, c := range counts {
+			stats.rows += decodeRowCount(c.MustGet())
+		}
+		for _, kv := range kvs {
+			k, err := columnsSS.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := tuple.Unpack(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			cs := columnStats{nullFrac: v[0].(float64), nDistinct: v[1].(int64)}
+			if v[2] != nil {
+				cs.min, cs.max = decodeCell(v[2].([]byte)), decodeCell(v[3].([]byte))
+			}
+			stats.columns[k[0].(string)] = cs
+		}
+		return stats, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get statistics: %w", err)
+	}
+	return stats.(*tableStats), nil
+}
+
+// The table and its partitions, whose rows are the rows of the table.
+func (pe pgEngine) tableTargets(name string) ([]string, error) {
+	targets := []string{name}
+	partitions, err := pe.getPartitionSpec(name)
+	if err != nil {
+		return nil, err
+	}
+	if partitions != nil {
+		for _, p := range partitions.Partitions {
+			targets = append(targets, p.Name)
+		}
+	}
+	return targets, nil
+}
+
+// Analyze the tables of ANALYZE or VACUUM ANALYZE, every table of the schema if it names none.
+func (pe pgEngine) executeAnalyze(stmt *pgquery.VacuumStmt) error {
+	var names []string
+	for _, r := range stmt.Rels {
+		names = append(names, r.GetVacuumRelation().Relation.Relname)
+	}
+	if len(stmt.Rels) == 0 {
+		var err error
+		if names, err = pe.getTableNames(); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range names {
+		if err := pe.analyzeTable(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the VACUUM statement analyzes, which is all fakegres does of it.
+func isAnalyze(stmt *pgquery.VacuumStmt) bool {
+	if !stmt.IsVacuumcmd {
+		return true
+	}
+	for _, o := range stmt.Options {
+		if o.GetDefElem().GetDefname() == "analyze" {
+			return true
+		}
+	}
+	return false
+}
+
+type columnAccumulator struct {
+	nulls    int64
+	distinct distinctSketch
+	min, max any
+	// The values of the column can't be compared, it has no smallest or largest
+	incomparable bool
+}
+
+func (a *columnAccumulator) add(v any) {
+	if v == nil {
+		a.nulls++
+		return
+	}
+	a.distinct.add(encodeCell(v))
+	if a.incomparable {
+		return
+	}
+	if a.min == nil {
+		a.min, a.max = v, v
+		return
+	}
+	lower, err := compareValues(v, a.min, nil)
+	if err != nil {
+		a.incomparable, a.min, a.max = true, nil, nil
+		return
+	}
+	if lower < 0 {
+		a.min = v
+	}
+	if higher, _ := compareValues(v, a.max, nil); higher > 0 {
+		a.max = v
+	}
+}
+
+func (pe pgEngine) analyzeTable(name string) error {
+	tbl, err := pe.getTableDefinition(name)
+	if err != nil {
+		return err
+	}
+	targets, err := pe.tableTargets(name)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]columnAccumulator, len(tbl.ColumnNames))
+	for i := range columns {
+		columns[i].distinct = newDistinctSketch()
+	}
+	counts := map[string]int64{}
+	for _, target := range targets {
+		// Note: a batch that is retried reads its rows again, the rows up to the last one counted are skipped
+		lastID := ""
+		err := pe.scanRowBatches(target, func(tr fdb.Transaction, ids []string, rows []row) error {
+			for i, r := range rows {
+				if lastID != "" && !rowIDLess(lastID, ids[i]) {
+					continue
+				}
+				lastID = ids[i]
+				counts[target]++
+				for c, column := range tbl.ColumnNames {
+					columns[c].add(r[column])
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not analyze table %s: %w", name, err)
+		}
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+
+	statsSS := pe.statsSubspace()
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		for _, target := range targets {
+			writes(tr).Set(rowCountKey(statsSS, target), encodeRowCount(counts[target]))
+		}
+
+		writes(tr).ClearRange(statsSS.Sub(name, "column"))
+		for c, column := range tbl.ColumnNames {
+			a := columns[c]
+			nullFrac := 0.0
+			if total > 0 {
+				nullFrac = float64(a.nulls) / float64(total)
+			}
+			value := tuple.Tuple{nullFrac, a.distinct.estimate(), nil, nil}
+			if a.min != nil {
+				value[2], value[3] = encodeCell(a.min), encodeCell(a.max)
+			}
+			writes(tr).Set(statsSS.Pack(tuple.Tuple{name, "column", column}), value.Pack())
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not analyze table %s: %w", name, err)
+	}
+	return nil
+}
+
+/*
+
+An estimate of the number of distinct values added to it, from the smallest hashes of the
+values: if the k-th smallest of evenly spread hashes is h, about k/h of the hash space holds
+a distinct value. It keeps between k and 2k hashes, exact counts below k.
+
+*/
+
+type distinctSketch struct {
+	hashes map[uint64]bool
+}
+
+func newDistinctSketch() distinctSketch {
+	return distinctSketch{hashes: map[uint64]bool{}}
+}
+
+func (s *distinctSketch) add(value []byte) {
+	h := fnv.New64a()
+	h.Write(value)
+	s.hashes[h.Sum64()] = true
+
+	if len(s.hashes) > 2*distinctSketchSize {
+		for _, hash := range s.sorted()[distinctSketchSize:] {
+			delete(s.hashes, hash)
+		}
+	}
+}
+
+func (s *distinctSketch) sorted() []uint64 {
+	var hashes []uint64
+	for h := range s.hashes {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes
+}
+
+func (s *distinctSketch) estimate() int64 {
+	if len(s.hashes) < distinctSketchSize {
+		return int64(len(s.hashes))
+	}
+	kth := s.sorted()[distinctSketchSize-1]
+	return int64(math.Round(float64(distinctSketchSize-1) / (float64(kth) / math.MaxUint64)))
+}
+
+// The statistics of the analyzed columns of the tables of the schema.
+func pgStatsRows(pe pgEngine) ([]row, error) {
+	names, err := pe.getTableNames()
+	if err != nil {
+		return nil, err
+	}
+	schema := pe.schema
+	if schema == "" {
+		schema = defaultSchema
+	}
+
+	var rows []row
+	for _, name := range names {
+		tbl, err := pe.getTableDefinition(name)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := pe.getTableStats(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, column := range tbl.ColumnNames {
+			cs, ok := stats.columns[column]
+			if !ok {
+				continue
+			}
+			r := row{
+				"schemaname": schema,
+				"tablename":  name,
+				"attname":    column,
+				"null_frac":  strconv.FormatFloat(cs.nullFrac, 'g', -1, 64),
+				"n_distinct": strconv.FormatInt(cs.nDistinct, 10),
+			}
+			if cs.min != nil {
+				r["histogram_bounds"] = "{" + quoteArrayElement(arrayElementText(cs.min)) + "," + quoteArrayElement(arrayElementText(cs.max)) + "}"
+			}
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
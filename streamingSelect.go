@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Streaming selects.
+
+A FoundationDB transaction can't read for longer than 5 seconds, so a select that scans a large
+table in one transaction fails. Outside of a transaction block a select of a table reads the
+layout planLayout chooses in batches of scanBatchKeys keys instead, each batch in a new read transaction that
+begins at the key after the last row of the batch before it, and the rows of a batch are sent
+to the client before the next one is read:
+
+```
+read transaction 1: data/table_data/person/r/<first id> ... data/table_data/person/r/<id 1000>
+                    -> RowDescription, DataRow x 1000
+read transaction 2: data/table_data/person/r/<id 1001> ... data/table_data/person/r/<id 2000>
+                    -> DataRow x 1000
+...
+                    -> CommandComplete SELECT 200000
+```
+
+The server only holds the rows of one batch, whatever the size of the table. The batches are
+read at different versions, so rows written while the select runs may or may not be in its
+result, and an error, like a canceled query, may come after some of the rows were sent.
+
+Selects with ORDER BY need every row before they can send the first one, they run in one
+transaction like the selects of transaction blocks, of portals and of catalog relations.
+
+*/
+
+// Whether the select can be streamed. Others are run by executeSelect.
+func streamableSelect(stmt *pgquery.SelectStmt) bool {
+	if len(stmt.FromClause) == 0 || len(stmt.SortClause) > 0 {
+		return false
+	}
+	if _, _, ok := matchDriverQuery(stmt); ok {
+		return false
+	}
+	if _, ok := lookupCatalogRelation(stmt.FromClause[0].GetRangeVar()); ok {
+		return false
+	}
+	if _, ok := matchCountStar(stmt); ok {
+		return false
+	}
+	return true
+}
+
+/*
+
+Run a select of a table batch by batch, calling send with the result of every batch that has
+rows, or once with no rows if none of them has. Returns the number of rows sent.
+
+The types of fields that are expressions come from their first value, like in executeSelect,
+but from the first batch that has rows since the fields are described before the rows.
+
+*/
+
+func (pe pgEngine) executeSelectStreaming(stmt *pgquery.SelectStmt, send func(results *pgResult) error) (int, error) {
+	tblName := stmt.FromClause[0].GetRangeVar().Relname
+	tbl, err := pe.getTableDefinition(tblName)
+	if err != nil {
+		return 0, err
+	}
+
+	results, err := selectTargets(stmt, tbl)
+	if err != nil {
+		return 0, err
+	}
+	if err := results.setLimit(stmt); err != nil {
+		return 0, err
+	}
+
+	tables, err := pe.selectTables(stmt, tbl)
+	if err != nil {
+		return 0, err
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	// Note: a text search index finds the rows without a scan, they are read in one transaction
+	var indexRows []row
+	indexed, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		rows, ok, err := pe.textSearchRows(rtr, stmt, tbl, tables)
+		indexRows = rows
+		return ok, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not select from table: %w", err)
+	}
+	if indexed.(bool) {
+		if err := results.addRows(stmt, tbl, indexRows); err != nil {
+			return 0, err
+		}
+		return len(results.rows), send(results)
+	}
+
+	layout, columns := planLayout(stmt, tbl), projectedColumns(stmt, tbl)
+	keysPerRow := len(columns)
+	if layout == layoutRow {
+		keysPerRow = 1
+	}
+	fieldTypes := append([]string{}, results.fieldTypes...)
+	sent := 0
+	described := false
+	for _, name := range tables {
+		kr := scanRange(tableDataSS, tbl, name, layout, columns)
+		for !results.limitReached() {
+			if err := pe.checkCanceled(); err != nil {
+				return sent, err
+			}
+
+			need, limited := results.scanLimit(stmt)
+			limit := batchKeys(stmt, need, limited, keysPerRow)
+
+			var rows []row
+			var rowEnds []fdb.Key
+			var complete bool
+			_, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+				_, rows, rowEnds, complete = readTableRows(rtr, tableDataSS, tbl, name, layout, columns, kr, limit)
+				return nil, nil
+			})
+			if err != nil {
+				return sent, fmt.Errorf("could not select from table: %w", err)
+			}
+
+			results.rows = nil
+			if !described {
+				// Note: a batch without rows would leave the types of expressions as text
+				copy(results.fieldTypes, fieldTypes)
+			}
+			if err := results.addRows(stmt, tbl, rows); err != nil {
+				return sent, err
+			}
+			if len(results.rows) > 0 {
+				if err := send(results); err != nil {
+					return sent, err
+				}
+				sent += len(results.rows)
+				described = true
+			}
+
+			if complete {
+				break
+			}
+			kr.Begin = rowEnds[len(rowEnds)-1]
+		}
+	}
+
+	if !described {
+		return 0, send(results)
+	}
+	return sent, nil
+}
+
+// Stream the result of a select to the client, describing its fields before the first rows.
+func (pgs *pgServer) streamSelect(pe pgEngine, stmt *pgquery.SelectStmt) error {
+	described := false
+	count, err := pe.executeSelectStreaming(stmt, func(results *pgResult) error {
+		var buf []byte
+		if !described {
+			buf = pgs.rowDescription(results).Encode(nil)
+			described = true
+		}
+		for _, row := range results.rows {
+			dr, err := pgs.dataRow(row)
+			if err != nil {
+				return err
+			}
+			buf = dr.Encode(buf)
+		}
+		return pgs.write(buf)
+	})
+	if err != nil {
+		return err
+	}
+
+	pgs.done(nil, fmt.Sprintf("SELECT %d", count))
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+/*
+
+Tenants.
+
+With -tenants every database is a FoundationDB tenant instead of a directory of the keyspace
+(see database.go). The cluster has to run FoundationDB 7.1 or later with tenant_mode set to
+optional_experimental or required. The directories of a database are at the root of its tenant:
+
+```
+fakegres -tenants
+```
+
+```
+tenant fakegres/shop: catalog/table/customer: ""
+                      data/table_data/customer/...
+```
+
+The transactions of a session are transactions of its tenant, they can't read or write the keys
+of any other database, whatever the engine does, and FoundationDB can encrypt, throttle and
+account for tenants apart. CREATE DATABASE creates the tenant and DROP DATABASE clears its keys
+and deletes it. The default database is a tenant too, created when the server starts, so the
+data a server without -tenants kept at the root of the keyspace isn't seen by one with it.
+
+Roles and the audit log belong to the server and stay outside of tenants. Roles are created and
+dropped in a transaction of their own, even in a transaction block.
+
+*/
+
+// FoundationDB error codes of tenants.
+const (
+	fdbTenantNotFound = 2131
+	fdbTenantExists   = 2132
+)
+
+// The keys statements outside of transaction blocks run on, the database or a tenant of it.
+type keyspace interface {
+	fdb.Transactor
+	CreateTransaction() (fdb.Transaction, error)
+}
+
+func tenantName(database string) fdb.Key {
+	return fdb.Key("fakegres/" + database)
+}
+
+func isFDBError(err error, code int) bool {
+	var fdbErr fdb.Error
+	return errors.As(err, &fdbErr) && fdbErr.Code == code
+}
+
+// Create the tenant of the default database unless it exists.
+func createDefaultTenant(db fdb.Database) {
+	if err := db.CreateTenant(tenantName(defaultDatabase)); err != nil && !isFDBError(err, fdbTenantExists) {
+		log.Fatal(fmt.Errorf("could not create the tenant of the default database: %w", err))
+	}
+}
+
+// The keyspace of a database, its tenant with -tenants.
+func openKeyspace(db fdb.Database, cfg config, database string) (keyspace, error) {
+	if !cfg.tenants {
+		return db, nil
+	}
+	t, err := db.OpenTenant(tenantName(database))
+	if err != nil {
+		return nil, fmt.Errorf("could not open tenant: %w", err)
+	}
+	return t, nil
+}
+
+// The engine of a database, in the keyspace ks of it.
+func (pgs *pgServer) newEngine(ks keyspace, database string) pgEngine {
+	pe := newPgEngine(ks, database)
+	if pgs.cfg.tenants {
+		pe.tenants = &pgs.db
+	}
+	return pe
+}
+
+// Roles are kept outside of tenants.
+func (pe pgEngine) roleTransactor() fdb.Transactor {
+	if pe.tenants != nil {
+		return *pe.tenants
+	}
+	return pe.db
+}
+
+func (pe pgEngine) tenantExists(name string) (bool, error) {
+	tenants, err := pe.tenants.ListTenants()
+	if err != nil {
+		return false, fmt.Errorf("could not get database: %w", err)
+	}
+	for _, t := range tenants {
+		if bytes.Equal(t, tenantName(name)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (pe pgEngine) createTenant(name string) error {
+	err := pe.tenants.CreateTenant(tenantName(name))
+	if isFDBError(err, fdbTenantExists) {
+		return &pgError{Code: sqlStateDuplicateDatabase, Message: fmt.Sprintf("database \"%s\" already exists", name)}
+	}
+	if err != nil {
+		return fmt.Errorf("could not create database: %w", err)
+	}
+	return nil
+}
+
+// Clear the keys of the tenant of the database, which FoundationDB only deletes when it is empty, and delete it. Returns false if there is none.
+func (pe pgEngine) deleteTenant(name string) (bool, error) {
+	t, err := pe.tenants.OpenTenant(tenantName(name))
+	if err != nil {
+		return false, fmt.Errorf("could not drop database: %w", err)
+	}
+	_, err = t.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		tr.ClearRange(fdb.KeyRange{Begin: fdb.Key{}, End: fdb.Key{0xFF}})
+		return nil, nil
+	})
+	if err == nil {
+		err = pe.tenants.DeleteTenant(tenantName(name))
+	}
+	if isFDBError(err, fdbTenantNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not drop database: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+)
+
+/*
+
+The text format values are sent to clients in, the output Postgres gives for their type so
+psql and drivers can parse them:
+
+```
+bool         t, f
+int          42
+float8       0.1, 1e+100, Infinity, NaN
+numeric      1.50 (with the scale of the value, trailing zeros are kept)
+char(4)      ab   (padded to its length)
+timestamp    2024-01-02 03:04:05.123
+timestamptz  2024-01-02 03:04:05.123+00
+text         hello (as is, without quotes)
+```
+
+NULL has no text form, it is sent as a value without any bytes.
+
+*/
+
+func formatText(value any) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case bool:
+		return []byte(boolString(v))
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	case float32:
+		return []byte(formatFloat(float64(v), 32))
+	case float64:
+		return []byte(formatFloat(v, 64))
+	case decimal.Decimal:
+		return []byte(numericString(v))
+	case bpchar:
+		// Note: String drops the padding for comparisons, the output keeps it
+		return []byte(string(v))
+	case jsonText:
+		return []byte(string(v))
+	case fmt.Stringer:
+		return []byte(v.String())
+	}
+	return []byte(fmt.Sprint(value))
+}
@@ -0,0 +1,794 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Basic full text search with to_tsvector, to_tsquery and the @@ operator.
+
+Example:
+
+```sql
+create index docs_body_fts on docs using gin (to_tsvector('english', body));
+select title from docs where to_tsvector('english', body) @@ to_tsquery('english', 'cat & !dog');
+```
+
+Text is split into words, lowercased and, for the english configuration, stop words are
+dropped and plurals are folded to the singular. The simple configuration only lowercases.
+Queries combine words with & (and), | (or), ! (not) and parentheses.
+
+A full text index is an inverted index from every lexeme to the rows that contain it. The
+value is the table holding the row, which is a partition for partitioned tables:
+
+```
+catalog/index/docs/docs_body_fts: ("body", "english")
+data/text_index/docs/docs_body_fts/cat/72746a7f-727f-4e0a-88f1-d983fea5c158: docs
+```
+
+The index is maintained by INSERT, DELETE and ALTER COLUMN TYPE. A select whose WHERE clause
+has a top level `to_tsvector(...) @@ to_tsquery(...)` matching an index reads the row ids of
+the lexemes from the index and only fetches those rows, the WHERE clause is then checked
+against them as usual. Queries that need NOT at the top level can't be answered from the
+index and scan the table.
+
+*/
+
+const defaultTextSearchConfig = "english"
+
+// The lexemes of a document, sorted and without duplicates.
+type tsvector []string
+
+// A parsed text search query: a lexeme, or an operator (&, |, !) over sub queries.
+type tsquery struct {
+	op     string
+	lexeme string
+	args   []*tsquery
+}
+
+type textIndex struct {
+	Name   string
+	Column string
+	Config string
+}
+
+var englishStopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a an and are as at be but by for from has have he her his i if in into is it its
+		me my no not of on or our she so such that the their them then there these they this to was we were what when
+		which who will with you your`) {
+		englishStopWords[w] = true
+	}
+}
+
+// Fold the common english plural forms to the singular.
+func stemEnglish(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"):
+		return w[:len(w)-2]
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") &&
+		!strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// Normalize a word into its lexeme, empty for stop words.
+func normalizeWord(config string, w string) (string, error) {
+	w = strings.ToLower(w)
+	switch config {
+	case "simple":
+		return w, nil
+	case "english":
+		if englishStopWords[w] {
+			return "", nil
+		}
+		return stemEnglish(w), nil
+	}
+	return "", fmt.Errorf("text search configuration \"%s\" does not exist", config)
+}
+
+func toTsvector(config string, text string) (tsvector, error) {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := map[string]bool{}
+	var lexemes tsvector
+	for _, w := range words {
+		l, err := normalizeWord(config, w)
+		if err != nil {
+			return nil, err
+		}
+		if l != "" && !seen[l] {
+			seen[l] = true
+			lexemes = append(lexemes, l)
+		}
+	}
+
+	sort.Strings(lexemes)
+	return lexemes, nil
+}
+
+func (v tsvector) contains(lexeme string) bool {
+	i := sort.SearchStrings(v, lexeme)
+	return i < len(v) && v[i] == lexeme
+}
+
+func (v tsvector) String() string {
+	var quoted []string
+	for _, l := range v {
+		quoted = append(quoted, "'"+l+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
+/*
+
+Parse a query into a tsquery. ! binds tighter than &, which binds tighter than |. Stop
+words are dropped from the query, a query of only stop words is nil and matches nothing.
+
+*/
+
+func toTsquery(config string, text string) (*tsquery, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("&|!()", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(text) && strings.IndexByte("&|!() \t\n", text[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, strings.Trim(text[i:j], "'"))
+			i = j
+		}
+	}
+
+	p := &tsqueryParser{config: config, tokens: tokens}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("syntax error in tsquery: \"%s\"", text)
+	}
+	return q, nil
+}
+
+// The plainto_tsquery form, which ands all the words of the text.
+func plainToTsquery(config string, text string) (*tsquery, error) {
+	lexemes, err := toTsvector(config, text)
+	if err != nil {
+		return nil, err
+	}
+
+	var q *tsquery
+	for _, l := range lexemes {
+		q = tsqueryOp("&", q, &tsquery{lexeme: l})
+	}
+	return q, nil
+}
+
+type tsqueryParser struct {
+	config string
+	tokens []string
+	pos    int
+}
+
+func (p *tsqueryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tsqueryParser) parseOr() (*tsquery, error) {
+	q, err := p.parseAnd()
+	for err == nil && p.peek() == "|" {
+		p.pos++
+		var right *tsquery
+		right, err = p.parseAnd()
+		q = tsqueryOp("|", q, right)
+	}
+	return q, err
+}
+
+func (p *tsqueryParser) parseAnd() (*tsquery, error) {
+	q, err := p.parseNot()
+	for err == nil && p.peek() == "&" {
+		p.pos++
+		var right *tsquery
+		right, err = p.parseNot()
+		q = tsqueryOp("&", q, right)
+	}
+	return q, err
+}
+
+func (p *tsqueryParser) parseNot() (*tsquery, error) {
+	switch t := p.peek(); t {
+	case "!":
+		p.pos++
+		q, err := p.parseNot()
+		if err != nil || q == nil {
+			return nil, err
+		}
+		return &tsquery{op: "!", args: []*tsquery{q}}, nil
+	case "(":
+		p.pos++
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("syntax error in tsquery: missing )")
+		}
+		p.pos++
+		return q, nil
+	case "", "&", "|", ")":
+		return nil, fmt.Errorf("syntax error in tsquery")
+	default:
+		p.pos++
+		l, err := normalizeWord(p.config, t)
+		if err != nil || l == "" {
+			return nil, err
+		}
+		return &tsquery{lexeme: l}, nil
+	}
+}
+
+// Combine two queries, a nil side (only stop words) is left out.
+func tsqueryOp(op string, left, right *tsquery) *tsquery {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	return &tsquery{op: op, args: []*tsquery{left, right}}
+}
+
+func (q *tsquery) matches(v tsvector) bool {
+	if q == nil {
+		return false
+	}
+
+	switch q.op {
+	case "&":
+		return q.args[0].matches(v) && q.args[1].matches(v)
+	case "|":
+		return q.args[0].matches(v) || q.args[1].matches(v)
+	case "!":
+		return !q.args[0].matches(v)
+	}
+	return v.contains(q.lexeme)
+}
+
+func textSearchMatch(left, right any) (any, error) {
+	if q, ok := left.(*tsquery); ok {
+		left, right = right, q
+	}
+
+	v, vok := left.(tsvector)
+	q, qok := right.(*tsquery)
+	if !vok || !qok {
+		return nil, fmt.Errorf("operator does not exist: %T @@ %T", left, right)
+	}
+	return q.matches(v), nil
+}
+
+// Evaluate to_tsvector, to_tsquery and plainto_tsquery. The configuration is optional.
+func evalTextSearchFunc(name string, fc *pgquery.FuncCall, tbl *tableDefinition, r row) (any, error) {
+	var args []string
+	for _, a := range fc.Args {
+		v, err := evalExpr(a, tbl, r)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprint(v))
+	}
+
+	config := defaultTextSearchConfig
+	switch len(args) {
+	case 1:
+	case 2:
+		config, args = args[0], args[1:]
+	default:
+		return nil, fmt.Errorf("function %s takes one or two arguments", name)
+	}
+
+	switch name {
+	case "to_tsvector":
+		return toTsvector(config, args[0])
+	case "to_tsquery":
+		return toTsquery(config, args[0])
+	}
+	return plainToTsquery(config, args[0])
+}
+
+// The configuration and argument of a text search function call, if it has a constant configuration.
+func textSearchArgs(fc *pgquery.FuncCall) (string, *pgquery.Node, bool) {
+	switch len(fc.Args) {
+	case 1:
+		return defaultTextSearchConfig, fc.Args[0], true
+	case 2:
+		config, ok := constString(fc.Args[0])
+		return config, fc.Args[1], ok
+	}
+	return "", nil, false
+}
+
+func funcName(fc *pgquery.FuncCall) string {
+	return fc.Funcname[len(fc.Funcname)-1].GetString_().GetStr()
+}
+
+/*
+
+Create a full text index, only `using gin (to_tsvector(...))` over a single column is
+supported, or a jsonb or point index on a column. Existing rows are indexed in batches after the index is added to the catalog,
+rows inserted in the meantime are indexed by the insert itself.
+
+*/
+
+func (pe pgEngine) executeCreateIndex(stmt *pgquery.IndexStmt) error {
+	tblName := stmt.Relation.Relname
+	tbl, err := pe.getTableDefinition(tblName)
+	if err != nil {
+		return err
+	}
+
+	var fc *pgquery.FuncCall
+	var column string
+	if len(stmt.IndexParams) == 1 {
+		fc = stmt.IndexParams[0].GetIndexElem().GetExpr().GetFuncCall()
+		column = stmt.IndexParams[0].GetIndexElem().Name
+	}
+	isGist := stmt.AccessMethod == "gist" && column != ""
+	isBtree := stmt.AccessMethod == "btree" && column != ""
+	if !isGist && !isBtree && (stmt.AccessMethod != "gin" || (column == "" && (fc == nil || funcName(fc) != "to_tsvector"))) {
+		return fmt.Errorf("only full text search, jsonb, point and btree indexes are supported: CREATE INDEX ... USING gin (to_tsvector(...)), USING gin (column), USING gist (column) or (column)")
+	}
+
+	// Note: a gin index on a column is over the keys of a jsonb column, see json.go, a gist
+	// index is a Z-order index over a point column, see geometry.go, and a btree index is over
+	// the values of an integer or text column, see keyRange.go
+	config := jsonbIndexConfig
+	if isGist {
+		config = pointIndexConfig
+	}
+	if isBtree {
+		config = btreeIndexConfig
+	}
+	if column == "" {
+		var arg *pgquery.Node
+		var ok bool
+		config, arg, ok = textSearchArgs(fc)
+		if !ok || arg.GetColumnRef() == nil {
+			return fmt.Errorf("full text search indexes take a constant configuration and a column")
+		}
+		if _, err := normalizeWord(config, ""); err != nil {
+			return err
+		}
+
+		fields := arg.GetColumnRef().Fields
+		column = fields[len(fields)-1].GetString_().GetStr()
+	}
+
+	colType, ok := tbl.columnType(column)
+	if !ok {
+		return undefinedColumn(column)
+	}
+	if config == jsonbIndexConfig && colType != "pg_catalog.jsonb" {
+		return fmt.Errorf("data type %s has no default operator class for access method \"gin\"", sqlTypeName(colType))
+	}
+	if config == pointIndexConfig && colType != "pg_catalog.point" {
+		return fmt.Errorf("data type %s has no default operator class for access method \"gist\"", sqlTypeName(colType))
+	}
+	if config == btreeIndexConfig && !btreeIndexable(colType) {
+		return fmt.Errorf("btree indexes are only supported on integer and text columns, not %s", sqlTypeName(colType))
+	}
+
+	idx := textIndex{Name: stmt.Idxname, Column: column, Config: config}
+	if idx.Name == "" {
+		idx.Name = fmt.Sprintf("%s_%s_idx", tblName, column)
+	}
+
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	indexKey := catalogDir.Sub("index").Pack(tuple.Tuple{tblName, idx.Name})
+
+	created, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if tr.Get(indexKey).MustGet() != nil {
+			if stmt.IfNotExists {
+				pe.raiseNotice(sqlStateDuplicateTable, "relation \"%s\" already exists, skipping", idx.Name)
+				return false, nil
+			}
+			return nil, &pgError{Code: sqlStateDuplicateTable, Message: fmt.Sprintf("relation \"%s\" already exists", idx.Name)}
+		}
+
+		writes(tr).Set(indexKey, tuple.Tuple{idx.Column, idx.Config}.Pack())
+		pe.recordDependency(tr, tblName, "index", idx.Name, dependencyAuto)
+		pe.bumpCatalogVersion(tr)
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not create index: %w", err)
+	}
+	if !created.(bool) {
+		return nil
+	}
+	// Note: inserts see the index from here on and index their rows, the batches index the others
+	pe.catalogChanged()
+
+	tables := []string{tblName}
+	partitions, err := pe.getPartitionSpec(tblName)
+	if err != nil {
+		return err
+	}
+	if partitions != nil {
+		for _, p := range partitions.Partitions {
+			tables = append(tables, p.Name)
+		}
+	}
+
+	for _, name := range tables {
+		err = pe.scanRowBatches(name, func(tr fdb.Transaction, ids []string, rows []row) error {
+			for i, r := range rows {
+				if err := pe.indexText(tr, tblName, idx, name, ids[i], r[idx.Column], true); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not create index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (pe pgEngine) getTextIndexes(tr fdb.ReadTransaction, table string) []textIndex {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	indexSS := catalogDir.Sub("index")
+
+	var indexes []textIndex
+	ri := tr.GetRange(indexSS.Sub(table), fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).Iterator()
+	for ri.Advance() {
+		kv := ri.MustGet()
+		k, _ := indexSS.Unpack(kv.Key)
+		v, err := tuple.Unpack(kv.Value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		indexes = append(indexes, textIndex{Name: k[1].(string), Column: v[0].(string), Config: v[1].(string)})
+	}
+	return indexes
+}
+
+// Add (or with set false, remove) the index entries for a cell of row id stored in target.
+func (pe pgEngine) indexText(tr fdb.Transaction, table string, idx textIndex, target string, id string, cell any, set bool) error {
+	lexemes, err := indexLexemes(idx, cell)
+	if err != nil {
+		return err
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	textIndexSS := dataDir.Sub("text_index")
+
+	for _, l := range lexemes {
+		key := tuple.Tuple{table, idx.Name, l, rowIDElement(id)}
+		if set {
+			setRowKey(tr, textIndexSS, key, []byte(target))
+		} else {
+			writes(tr).Clear(textIndexSS.Pack(key))
+		}
+	}
+	return nil
+}
+
+// The keys of the entries of the index for a cell, under the name of the index and before the row id.
+func indexLexemes(idx textIndex, cell any) ([]tuple.TupleElement, error) {
+	if cell == nil {
+		return nil, nil
+	}
+
+	// Note: jsonb indexes are keyed by the keys of the document and point indexes by the Z-order of the point
+	var lexemes []tuple.TupleElement
+	switch idx.Config {
+	case jsonbIndexConfig:
+		for _, k := range jsonbIndexKeys(cell) {
+			lexemes = append(lexemes, k)
+		}
+	case pointIndexConfig:
+		if p, ok := cell.(point); ok {
+			lexemes = append(lexemes, zOrder(p))
+		}
+	case btreeIndexConfig:
+		lexemes = append(lexemes, cellElement(cell))
+	default:
+		words, err := toTsvector(idx.Config, fmt.Sprint(cell))
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range words {
+			lexemes = append(lexemes, w)
+		}
+	}
+	return lexemes, nil
+}
+
+// Clear every index entry of the table.
+func (pe pgEngine) clearTextIndexes(tr fdb.Transaction, table string) {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writes(tr).ClearRange(dataDir.Sub("text_index").Sub(table))
+}
+
+/*
+
+Find a top level `to_tsvector(...) @@ to_tsquery(...)` in the WHERE clause that an index of
+the table can answer and read the matching rows through the index. Returns false if there
+is none and the table has to be scanned.
+
+*/
+
+func (pe pgEngine) textSearchRows(rtr fdb.ReadTransaction, stmt *pgquery.SelectStmt, tbl *tableDefinition, tables []string) ([]row, bool, error) {
+	indexes := pe.getTextIndexes(rtr, tbl.Name)
+	if len(indexes) == 0 {
+		return nil, false, nil
+	}
+
+	for _, a := range topLevelPredicates(stmt.WhereClause, "@@") {
+		vector, query := a.Lexpr.GetFuncCall(), a.Rexpr.GetFuncCall()
+		if vector == nil || query == nil {
+			continue
+		}
+		if funcName(vector) != "to_tsvector" {
+			vector, query = query, vector
+		}
+		if funcName(vector) != "to_tsvector" || (funcName(query) != "to_tsquery" && funcName(query) != "plainto_tsquery") {
+			continue
+		}
+
+		config, arg, ok := textSearchArgs(vector)
+		if !ok || arg.GetColumnRef() == nil {
+			continue
+		}
+		fields := arg.GetColumnRef().Fields
+		column := fields[len(fields)-1].GetString_().GetStr()
+
+		queryConfig, queryArg, ok := textSearchArgs(query)
+		text, isConst := constString(queryArg)
+		if !ok || !isConst || queryConfig != config {
+			continue
+		}
+
+		for _, idx := range indexes {
+			if idx.Column != column || idx.Config != config {
+				continue
+			}
+
+			var q *tsquery
+			var err error
+			if funcName(query) == "to_tsquery" {
+				q, err = toTsquery(config, text)
+			} else {
+				q, err = plainToTsquery(config, text)
+			}
+			if err != nil {
+				return nil, false, err
+			}
+
+			ids, ok := pe.textIndexLookup(rtr, tbl.Name, idx, q)
+			if !ok {
+				continue
+			}
+
+			return pe.readRowsById(rtr, tbl, ids, tables), true, nil
+		}
+	}
+
+	if rows, ok, err := pe.jsonbIndexRows(rtr, stmt.WhereClause, tbl, tables, indexes); ok || err != nil {
+		return rows, ok, err
+	}
+	if rows, ok, err := pe.pointIndexRows(rtr, stmt.WhereClause, tbl, tables, indexes); ok || err != nil {
+		return rows, ok, err
+	}
+	return pe.btreeIndexRows(rtr, stmt.WhereClause, tbl, tables, indexes)
+}
+
+// The comparisons with one of the operators that the WHERE clause ANDs together at its top level.
+func topLevelPredicates(where *pgquery.Node, ops ...string) []*pgquery.A_Expr {
+	if where == nil {
+		return nil
+	}
+
+	if b := where.GetBoolExpr(); b != nil {
+		if b.Boolop != pgquery.BoolExprType_AND_EXPR {
+			return nil
+		}
+
+		var predicates []*pgquery.A_Expr
+		for _, arg := range b.Args {
+			predicates = append(predicates, topLevelPredicates(arg, ops...)...)
+		}
+		return predicates
+	}
+
+	a := where.GetAExpr()
+	if a == nil || a.Kind != pgquery.A_Expr_Kind_AEXPR_OP || len(a.Name) != 1 || a.Lexpr == nil {
+		return nil
+	}
+	for _, op := range ops {
+		if a.Name[0].GetString_().GetStr() == op {
+			return []*pgquery.A_Expr{a}
+		}
+	}
+	return nil
+}
+
+// The row ids matching the query, mapped to the table holding the row. False if NOT makes the index unusable.
+func (pe pgEngine) textIndexLookup(rtr fdb.ReadTransaction, table string, idx textIndex, q *tsquery) (map[string]string, bool) {
+	if q == nil {
+		return map[string]string{}, true
+	}
+
+	switch q.op {
+	case "&":
+		left, lok := pe.textIndexLookup(rtr, table, idx, q.args[0])
+		right, rok := pe.textIndexLookup(rtr, table, idx, q.args[1])
+		// Note: a NOT on one side of an AND is checked by the WHERE clause after the lookup
+		switch {
+		case !lok && !rok:
+			return nil, false
+		case !lok:
+			return right, true
+		case !rok:
+			return left, true
+		}
+
+		ids := map[string]string{}
+		for id, t := range left {
+			if _, ok := right[id]; ok {
+				ids[id] = t
+			}
+		}
+		return ids, true
+	case "|":
+		left, lok := pe.textIndexLookup(rtr, table, idx, q.args[0])
+		right, rok := pe.textIndexLookup(rtr, table, idx, q.args[1])
+		if !lok || !rok {
+			return nil, false
+		}
+
+		for id, t := range right {
+			left[id] = t
+		}
+		return left, true
+	case "!":
+		return nil, false
+	}
+
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lexemeSS := dataDir.Sub("text_index").Sub(table, idx.Name, q.lexeme)
+
+	ids := map[string]string{}
+	ri := rtr.GetRange(lexemeSS, fdb.RangeOptions{
+		Mode: fdb.StreamingModeWantAll,
+	}).Iterator()
+	for ri.Advance() {
+		kv := ri.MustGet()
+		t, _ := lexemeSS.Unpack(kv.Key)
+		ids[rowIDString(t[0])] = string(kv.Value)
+	}
+	return ids, true
+}
+
+// Read the rows with the given ids from the row layout, skipping tables that aren't in tables.
+func (pe pgEngine) readRowsById(rtr fdb.ReadTransaction, tbl *tableDefinition, ids map[string]string, tables []string) []row {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+
+	// Note: return the rows in key order, like a scan of the tables would
+	var sorted []string
+	for _, t := range tables {
+		var tableIds []string
+		for id, table := range ids {
+			if table == t {
+				tableIds = append(tableIds, id)
+			}
+		}
+		sort.Slice(tableIds, func(i, j int) bool { return rowIDLess(tableIds[i], tableIds[j]) })
+		sorted = append(sorted, tableIds...)
+	}
+
+	// Note: the entries of rows with a tombstone are there until the rows are cleared, see tombstone.go
+	tombstones := make([]fdb.FutureByteSlice, len(sorted))
+	for i, id := range sorted {
+		tombstones[i] = readTombstone(rtr, tableDataSS, ids[id], id)
+	}
+
+	var rows []row
+	for i, r := range readRowsAt(rtr, tableDataSS, tbl, sorted, ids) {
+		// Note: entries of dropped partitions are left behind, their rows are gone
+		if len(r) > 0 && tombstones[i].MustGet() == nil {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// Read the rows with the ids, from the targets ids maps them to. A row that doesn't exist is empty.
+func readRowsAt(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, tbl *tableDefinition, sorted []string, ids map[string]string) []row {
+	// Note: every row is read at once and waited for after, instead of a round trip per row
+	values := make([]fdb.FutureByteSlice, len(sorted))
+	cells := make([][]fdb.FutureByteSlice, len(sorted))
+	for i, id := range sorted {
+		if tbl.storesRows() {
+			values[i] = rtr.Get(tableDataSS.Pack(rowKey(ids[id], id)))
+			continue
+		}
+		// Note: without the row layout the cells of the row are in the cells of every column
+		for _, column := range tbl.ColumnNames {
+			cells[i] = append(cells[i], rtr.Get(tableDataSS.Pack(tuple.Tuple{ids[id], "c", tbl.columnKey(column), rowIDElement(id)})))
+		}
+	}
+
+	var rows []row
+	for i, id := range sorted {
+		if tbl.storesRows() {
+			r := row{}
+			if value := values[i].MustGet(); value != nil {
+				r = readRowValue(rtr, tableDataSS, tbl, ids[id], id, value)
+			}
+			rows = append(rows, r)
+			continue
+		}
+		r := row{}
+		for columnIndex, column := range tbl.ColumnNames {
+			// Note: rows inserted before a column was added have no cell for it
+			if value := cells[i][columnIndex].MustGet(); value != nil {
+				r[column] = readCell(rtr, tableDataSS, ids[id], id, tbl.columnKey(column), value)
+			}
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
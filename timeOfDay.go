@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Times of day, the time type.
+
+A time is the number of microseconds since midnight, from 00:00:00 up to and including
+24:00:00. Adding an interval wraps around midnight, like Postgres:
+
+```sql
+create table shift (name text, starts time, length interval);
+insert into shift values ('early', '06:00', '8 hours');
+select starts + length from shift;  -- 14:00:00
+```
+
+Cells hold the microseconds in a tagged nested tuple, in the order of the times:
+
+```
+data/table_data/shift/r/72746a7f-727f-4e0a-88f1-d983fea5c158/starts: (("time", 21600000000),)
+```
+
+*/
+
+type timeOfDay int64
+
+const microsPerDay = secondsPerDay * 1e6
+
+func isTimeType(colType string) bool {
+	base, _ := splitTypeMods(colType)
+	return base == "pg_catalog.time"
+}
+
+func (t timeOfDay) String() string {
+	if t == microsPerDay {
+		return "24:00:00"
+	}
+	return timeFromMicros(int64(t)).UTC().Format("15:04:05.999999")
+}
+
+// Parse a time of day. A date in front of it is allowed and ignored, a time zone is not allowed.
+func parseTimeOfDay(s string) (timeOfDay, error) {
+	value := strings.TrimSpace(s)
+	if strings.EqualFold(value, "allballs") {
+		return 0, nil
+	}
+	if len(value) > 10 && (value[10] == ' ' || value[10] == 'T') {
+		if _, err := parseDate(value[:10]); err == nil {
+			value = strings.TrimSpace(value[11:])
+		}
+	}
+
+	m := timeOfDayRegexp.FindStringSubmatch(value)
+	if m == nil || m[5] != "" {
+		return 0, fmt.Errorf("invalid input syntax for type time: %q", s)
+	}
+
+	us, err := parseTimestampMicros("1970-01-01 "+value, time.UTC, "time without time zone")
+	if err != nil {
+		return 0, fmt.Errorf("date/time field value out of range: %q", s)
+	}
+	return timeOfDay(us), nil
+}
+
+func castTimeOfDay(v any, colType string) (string, error) {
+	var t timeOfDay
+	switch value := v.(type) {
+	case timeOfDay:
+		t = value
+	case string:
+		parsed, err := parseTimeOfDay(value)
+		if err != nil {
+			return "", err
+		}
+		t = parsed
+	case timestamp, timestamptz:
+		ts, _ := timestampValue(value, false)
+		t = timeOfDay(((ts % microsPerDay) + microsPerDay) % microsPerDay)
+	case interval:
+		t = timeOfDay(((value.micros % microsPerDay) + microsPerDay) % microsPerDay)
+	default:
+		return "", fmt.Errorf("cannot cast type %T to time without time zone", v)
+	}
+
+	us, err := roundTimestamp(int64(t), colType)
+	if err != nil {
+		return "", err
+	}
+	return timeOfDay(us).String(), nil
+}
+
+func isTimeOfDay(v any) bool {
+	_, ok := v.(timeOfDay)
+	return ok
+}
+
+// Compare times with each other or with string constants, which are read as times.
+func cmpTimeOfDay(left, right any) (int, error) {
+	operand := func(v any) (timeOfDay, error) {
+		switch value := v.(type) {
+		case timeOfDay:
+			return value, nil
+		case string:
+			return parseTimeOfDay(value)
+		}
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return cmpInt(int64(l), int64(r)), nil
+}
+
+func encodeTimeOfDay(t timeOfDay) tuple.Tuple {
+	return tuple.Tuple{"time", int64(t)}
+}
+
+func decodeTimeOfDay(t tuple.Tuple) (timeOfDay, bool) {
+	if len(t) != 2 || t[0] != "time" {
+		return 0, false
+	}
+	us, ok := t[1].(int64)
+	return timeOfDay(us), ok
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Timestamps, with and without time zone.
+
+Both are microseconds since 1970-01-01 00:00:00. A timestamp is a date and time of day as
+written, without a time zone. A timestamp with time zone is an instant, given in UTC:
+input without an offset is read in the server time zone (the -timezone flag) and output is
+written in it.
+
+```sql
+create table login (name text, at timestamptz);
+insert into login values ('garry', '2024-03-01 09:30:00+01'), ('ted', now());
+select name from login where at >= '2024-03-01' and at < current_timestamp;
+```
+
+Cells hold the microseconds in a tagged nested tuple, which the tuple encoding keeps in
+time order, so ranges of timestamps are ranges of keys:
+
+```
+data/table_data/login/r/72746a7f-727f-4e0a-88f1-d983fea5c158/at: (("timestamptz", 1709281800000000),)
+```
+
+A declared precision, `timestamp(3)`, rounds the fraction of a second to that many digits.
+
+*/
+
+type timestamp int64
+
+type timestamptz int64
+
+const (
+	timestampInfinity     = math.MaxInt64
+	timestampNegInfinity  = math.MinInt64
+	maxTimestampPrecision = 6
+)
+
+// The time zone timestamps with time zone are read and written in.
+var defaultTimeZone = time.UTC
+
+var timeOfDayRegexp = regexp.MustCompile(`^(\d{1,2}):(\d{2})(?::(\d{2})(\.\d+)?)?\s*(.*)$`)
+
+var zoneOffsetRegexp = regexp.MustCompile(`^([+-])(\d{1,2})(?::?(\d{2}))?(?::?(\d{2}))?$`)
+
+func isTimestampType(colType string) bool {
+	base, _ := splitTypeMods(colType)
+	return base == "pg_catalog.timestamp" || base == "pg_catalog.timestamptz"
+}
+
+func microsFromTime(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+func timeFromMicros(us int64) time.Time {
+	return time.Unix(us/1e6, us%1e6*1e3)
+}
+
+func nowMicros() int64 {
+	return microsFromTime(time.Now())
+}
+
+// The time zone named by an offset like +02 or -03:30, or by a name like UTC or Europe/Paris.
+func parseTimeZone(zone string) (*time.Location, error) {
+	if m := zoneOffsetRegexp.FindStringSubmatch(zone); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		seconds, _ := strconv.Atoi(m[4])
+		offset := hours*3600 + minutes*60 + seconds
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(zone, offset), nil
+	}
+
+	switch strings.ToUpper(zone) {
+	case "Z", "UTC", "GMT":
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("time zone %q not recognized", zone)
+	}
+	return loc, nil
+}
+
+/*
+
+Parse a timestamp, a date optionally followed by a time of day and a time zone:
+
+```
+2024-03-01
+2024-03-01 09:30
+2024-03-01T09:30:00.123456+01:00
+2024-03-01 09:30:00 Europe/Paris
+```
+
+The result is in microseconds since the epoch in UTC. A value without a time zone is read
+in loc, a timestamp without time zone passes UTC so the time of day is kept as written.
+
+*/
+
+func parseTimestampMicros(s string, loc *time.Location, typeName string) (int64, error) {
+	value := strings.TrimSpace(s)
+	switch strings.ToLower(value) {
+	case "now":
+		return nowMicros(), nil
+	case "infinity", "+infinity":
+		return timestampInfinity, nil
+	case "-infinity":
+		return timestampNegInfinity, nil
+	}
+
+	datePart, rest := value, ""
+	if len(value) > 10 && (value[10] == ' ' || value[10] == 'T') {
+		datePart, rest = value[:10], strings.TrimSpace(value[11:])
+	}
+
+	d, err := parseDate(datePart)
+	if err != nil {
+		return 0, fmt.Errorf("invalid input syntax for type %s: %q", typeName, s)
+	}
+	if d == dateInfinity || d == dateNegInfinity {
+		return timestampMicrosFromDate(d), nil
+	}
+
+	var hour, minute, second, nanos int
+	zone := rest
+	if m := timeOfDayRegexp.FindStringSubmatch(rest); m != nil {
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		second, _ = strconv.Atoi(m[3])
+		if m[4] != "" {
+			fraction := (m[4][1:] + "000000000")[:9]
+			nanos, _ = strconv.Atoi(fraction)
+		}
+		zone = m[5]
+	}
+	if hour > 24 || minute > 59 || second > 60 || (hour == 24 && (minute > 0 || second > 0 || nanos > 0)) {
+		return 0, fmt.Errorf("date/time field value out of range: %q", s)
+	}
+
+	if zone != "" {
+		if loc, err = parseTimeZone(zone); err != nil {
+			return 0, err
+		}
+	}
+
+	y, mo, day := d.time().Date()
+	t := time.Date(y, mo, day, hour, minute, second, nanos, loc)
+	// Note: round the nanoseconds to microseconds, halfway away from zero
+	return microsFromTime(t.Add(500 * time.Nanosecond).Truncate(time.Microsecond)), nil
+}
+
+func timestampMicrosFromDate(d date) int64 {
+	switch d {
+	case dateInfinity:
+		return timestampInfinity
+	case dateNegInfinity:
+		return timestampNegInfinity
+	}
+	return int64(d) * secondsPerDay * 1e6
+}
+
+func formatTimestamp(us int64, loc *time.Location) string {
+	switch us {
+	case timestampInfinity:
+		return "infinity"
+	case timestampNegInfinity:
+		return "-infinity"
+	}
+	return timeFromMicros(us).In(loc).Format("2006-01-02 15:04:05.999999")
+}
+
+func (ts timestamp) String() string {
+	return formatTimestamp(int64(ts), time.UTC)
+}
+
+func (ts timestamptz) String() string {
+	s := formatTimestamp(int64(ts), defaultTimeZone)
+	if ts == timestampInfinity || ts == timestampNegInfinity {
+		return s
+	}
+
+	// Note: the offset is written in hours, with minutes only when it has them
+	_, offset := timeFromMicros(int64(ts)).In(defaultTimeZone).Zone()
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	s += fmt.Sprintf("%s%02d", sign, offset/3600)
+	if offset%3600 != 0 {
+		s += fmt.Sprintf(":%02d", offset%3600/60)
+	}
+	return s
+}
+
+// Move a timestamp with time zone to the wall clock time in the server time zone, and back.
+func (ts timestamptz) local() timestamp {
+	if ts == timestampInfinity || ts == timestampNegInfinity {
+		return timestamp(ts)
+	}
+	_, offset := timeFromMicros(int64(ts)).In(defaultTimeZone).Zone()
+	return timestamp(int64(ts) + int64(offset)*1e6)
+}
+
+func (ts timestamp) zoned() timestamptz {
+	if ts == timestampInfinity || ts == timestampNegInfinity {
+		return timestamptz(ts)
+	}
+	t := timeFromMicros(int64(ts)).UTC()
+	local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), defaultTimeZone)
+	return timestamptz(microsFromTime(local))
+}
+
+// Round to the declared number of digits of the fraction of a second.
+func roundTimestamp(us int64, colType string) (int64, error) {
+	_, mods := splitTypeMods(colType)
+	if len(mods) == 0 || us == timestampInfinity || us == timestampNegInfinity {
+		return us, nil
+	}
+	if mods[0] < 0 || mods[0] > maxTimestampPrecision {
+		return 0, fmt.Errorf("TIMESTAMP(%d) precision must be between 0 and %d", mods[0], maxTimestampPrecision)
+	}
+
+	unit := int64(math.Pow10(maxTimestampPrecision - mods[0]))
+	if us >= 0 {
+		return (us + unit/2) / unit * unit, nil
+	}
+	return -((-us + unit/2) / unit * unit), nil
+}
+
+func parseTimestamp(colType string, s string) (any, error) {
+	base, _ := splitTypeMods(colType)
+	if base == "pg_catalog.timestamptz" {
+		us, err := timestampValue(s, true)
+		return timestamptz(us), err
+	}
+	us, err := timestampValue(s, false)
+	return timestamp(us), err
+}
+
+// The microseconds of a value as a timestamp with or without time zone.
+func timestampValue(v any, zoned bool) (int64, error) {
+	switch value := v.(type) {
+	case timestamp:
+		if zoned {
+			return int64(value.zoned()), nil
+		}
+		return int64(value), nil
+	case timestamptz:
+		if !zoned {
+			return int64(value.local()), nil
+		}
+		return int64(value), nil
+	case date:
+		us := timestampMicrosFromDate(value)
+		if zoned {
+			us = int64(timestamp(us).zoned())
+		}
+		return us, nil
+	case string:
+		if zoned {
+			return parseTimestampMicros(value, defaultTimeZone, "timestamp with time zone")
+		}
+		return parseTimestampMicros(value, time.UTC, "timestamp without time zone")
+	}
+
+	if zoned {
+		return 0, fmt.Errorf("cannot cast type %T to timestamp with time zone", v)
+	}
+	return 0, fmt.Errorf("cannot cast type %T to timestamp without time zone", v)
+}
+
+func castTimestamp(v any, colType string) (string, error) {
+	base, _ := splitTypeMods(colType)
+	zoned := base == "pg_catalog.timestamptz"
+
+	us, err := timestampValue(v, zoned)
+	if err != nil {
+		return "", err
+	}
+	us, err = roundTimestamp(us, colType)
+	if err != nil {
+		return "", err
+	}
+
+	if zoned {
+		return timestamptz(us).String(), nil
+	}
+	return timestamp(us).String(), nil
+}
+
+// The date of a timestamp, timestamps with time zone are in the server time zone.
+func dateFromTimestamp(v any) (date, bool) {
+	var ts timestamp
+	switch value := v.(type) {
+	case timestamp:
+		ts = value
+	case timestamptz:
+		ts = value.local()
+	default:
+		return 0, false
+	}
+
+	switch ts {
+	case timestampInfinity:
+		return dateInfinity, true
+	case timestampNegInfinity:
+		return dateNegInfinity, true
+	}
+	return dateFromTime(timeFromMicros(int64(ts)).UTC()), true
+}
+
+func isTimestamp(v any) bool {
+	switch v.(type) {
+	case timestamp, timestamptz:
+		return true
+	}
+	return false
+}
+
+/*
+
+Compare timestamps with each other, with dates and with string constants. Like Postgres,
+when a timestamp with time zone is involved the other side is converted to one, otherwise
+both sides are timestamps without time zone.
+
+*/
+
+func cmpTimestamp(left, right any) (int, error) {
+	_, lz := left.(timestamptz)
+	_, rz := right.(timestamptz)
+	zoned := lz || rz
+
+	l, err := timestampValue(left, zoned)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+	r, err := timestampValue(right, zoned)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+	return cmpInt(l, r), nil
+}
+
+func timestampMicros(v any) int64 {
+	switch value := v.(type) {
+	case timestamp:
+		return int64(value)
+	case timestamptz:
+		return int64(value)
+	}
+	return 0
+}
+
+// now(), current_timestamp and friends. All of them are the current time, there is no transaction start time to report.
+func evalTimestampFunc(name string) (any, error) {
+	switch name {
+	case "now", "transaction_timestamp", "statement_timestamp", "clock_timestamp":
+		return timestamptz(nowMicros()), nil
+	case "localtimestamp":
+		return timestamptz(nowMicros()).local(), nil
+	}
+	return nil, fmt.Errorf("function %s does not exist", name)
+}
+
+// CURRENT_TIMESTAMP(p) and LOCALTIMESTAMP(p) round to the given precision.
+func evalTimestampValueFunction(svf *pgquery.SQLValueFunction) (any, error) {
+	var v any
+	var colType string
+	switch svf.Op {
+	case pgquery.SQLValueFunctionOp_SVFOP_CURRENT_TIMESTAMP, pgquery.SQLValueFunctionOp_SVFOP_CURRENT_TIMESTAMP_N:
+		v, _ = evalTimestampFunc("now")
+		colType = "pg_catalog.timestamptz"
+	case pgquery.SQLValueFunctionOp_SVFOP_LOCALTIMESTAMP, pgquery.SQLValueFunctionOp_SVFOP_LOCALTIMESTAMP_N:
+		v, _ = evalTimestampFunc("localtimestamp")
+		colType = "pg_catalog.timestamp"
+	default:
+		return nil, fmt.Errorf("unsupported expression: %s", svf.Op)
+	}
+
+	if svf.Typmod < 0 {
+		return v, nil
+	}
+	us, err := roundTimestamp(timestampMicros(v), fmt.Sprintf("%s(%d)", colType, svf.Typmod))
+	if err != nil {
+		return nil, err
+	}
+	if colType == "pg_catalog.timestamptz" {
+		return timestamptz(us), nil
+	}
+	return timestamp(us), nil
+}
+
+func encodeTimestamp(v any) (tuple.Tuple, bool) {
+	switch value := v.(type) {
+	case timestamp:
+		return tuple.Tuple{"timestamp", int64(value)}, true
+	case timestamptz:
+		return tuple.Tuple{"timestamptz", int64(value)}, true
+	}
+	return nil, false
+}
+
+func decodeTimestamp(t tuple.Tuple) (any, bool) {
+	if len(t) != 2 {
+		return nil, false
+	}
+	us, ok := t[1].(int64)
+	if !ok {
+		return nil, false
+	}
+
+	switch t[0] {
+	case "timestamp":
+		return timestamp(us), true
+	case "timestamptz":
+		return timestamptz(us), true
+	}
+	return nil, false
+}
+
+// SHOW timezone, the time zone is set for the server with the -timezone flag.
+func (pgs *pgServer) handleTimeZoneStmt(stmt *pgquery.Node) (bool, error) {
+	show := stmt.GetVariableShowStmt()
+	if show == nil || strings.ToLower(show.Name) != "timezone" {
+		return false, nil
+	}
+
+	return true, pgs.writePgResult(&pgResult{
+		fieldNames: []string{"TimeZone"},
+		fieldTypes: []string{"text"},
+		rows:       [][]any{{defaultTimeZone.String()}},
+	}, "SHOW")
+}
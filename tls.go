@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+/*
+
+TLS for client connections.
+
+Clients that want TLS send an SSLRequest before the StartupMessage. Without a certificate the
+server answers 'N' and the client goes on in plain text. With one the server answers 'S', the
+TLS handshake follows and the rest of the session, starting with the StartupMessage, is
+encrypted:
+
+```bash
+$ ./fakegres-fdb -tls-cert=server.crt -tls-key=server.key
+$ psql "host=localhost port=6000 sslmode=require"
+```
+
+With -tls-only clients that don't ask for TLS are turned away with a FATAL ErrorResponse
+instead of being let in. Clients can authenticate with a certificate of their own, see
+clientCert.go.
+
+*/
+
+// The TLS configuration of the server, nil when no certificate is configured.
+func loadTLSConfig(cfg config) *tls.Config {
+	if cfg.tlsCert == "" && cfg.tlsKey == "" {
+		if cfg.tlsOnly {
+			log.Fatal("-tls-only needs -tls-cert and -tls-key")
+		}
+		if cfg.tlsClientCA != "" || cfg.auth == "cert" {
+			log.Fatal("-tls-client-ca and -auth=cert need -tls-cert and -tls-key")
+		}
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.tlsCert, cfg.tlsKey)
+	if err != nil {
+		log.Fatalf("could not load TLS certificate: %s", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	loadClientCA(tlsConfig, cfg)
+	return tlsConfig
+}
+
+// Accept an SSLRequest, run the handshake and continue the session on the TLS connection.
+func (pgs *pgServer) startTLS() (*pgproto3.Backend, error) {
+	if err := pgs.write([]byte("S")); err != nil {
+		return nil, fmt.Errorf("error sending accept SSL request: %w", err)
+	}
+
+	conn := tls.Server(pgs.conn, pgs.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("error in TLS handshake: %w", err)
+	}
+
+	pgs.writeMu.Lock()
+	pgs.conn = conn
+	pgs.writeMu.Unlock()
+	return pgs.newBackend(), nil
+}
+
+// Refuse a session that didn't ask for TLS on a server that requires it.
+func (pgs *pgServer) rejectPlainText() error {
+	if err := pgs.writeFatal(sqlStateInvalidAuthorizationSpecification, "connection requires TLS"); err != nil {
+		return err
+	}
+	return fmt.Errorf("refused connection without TLS from %s", pgs.conn.RemoteAddr())
+}
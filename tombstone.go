@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Tombstone deletes.
+
+A DELETE of a table with at least -tombstone-delete-rows rows doesn't clear its rows, it writes
+a tombstone for every row next to its cells, and marks the table or partition as having them:
+
+```
+data/table_data/person/t/<row id>: ""
+data/tombstoned/person: "person"
+```
+
+The tombstones are written in batches of their own transactions (see tombstoneRows), a key per
+row instead of the cells of the row, the entries of its indexes and its chunks, and take the
+rows off the row count as they are written. Reads skip the rows with a tombstone, so
+they are gone once their batch committed.
+
+A reaper goroutine of the server wakes up every -tombstone-reap-interval, looks for the marked
+tables and partitions of every database and schema, and clears the rows with a tombstone, the
+entries of their indexes and the tombstones, in batches of tombstoneReapBatchRows rows of their
+own transactions, then the mark. Rows inserted while a DELETE writes its tombstones are kept.
+
+*/
+
+// The rows from which a DELETE writes tombstones, set by -tombstone-delete-rows, 0 to always clear rows.
+var tombstoneDeleteRows int64 = 100000
+
+// The rows the reaper clears in a transaction at most.
+const tombstoneReapBatchRows = 100
+
+// The tombstones a scan reads ahead at most.
+const tombstoneScanKeys = 1000
+
+// The key marking the target as having tombstones, its value is the name of its table.
+func (pe pgEngine) tombstonedKey(target string) fdb.Key {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dataDir.Sub("tombstoned").Pack(tuple.Tuple{target})
+}
+
+/*
+
+Write tombstones for the rows of a table or partition in batches, like transactInBatches, and
+return how many there were. A batch reads the row keys from where the one before it stopped,
+until it is over maxBatchTime or maxDeleteBatchKeys, and writes a tombstone for every row it
+read that has none. Tables without the row layout are read in the cells of their first column.
+Every batch takes its rows off the row count at rowsKey and marks the target at tombstonedKey.
+
+The batches stop at the last row key there was before the first of them. Rows get the
+versionstamp of their insert as id (see rowId.go), so rows inserted while the tombstones are
+written sort after it and are kept.
+
+*/
+
+// The row keys a batch of tombstones reads at most, a var so tests can make batches smaller.
+var maxDeleteBatchKeys = 10000
+
+func (pe pgEngine) tombstoneRows(targetSS subspace.Subspace, rowsKey, tombstonedKey fdb.Key, tbl *tableDefinition) (int, error) {
+	// Note: a table without the row layout has a cell of its first column for every row
+	rowSS := targetSS.Sub("r")
+	if !tbl.storesRows() {
+		rowSS = targetSS.Sub("c", tbl.columnKey(tbl.ColumnNames[0]))
+	}
+	lastKey, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		kvs := rtr.GetRange(rowSS, fdb.RangeOptions{Limit: 1, Reverse: true}).GetSliceOrPanic()
+		if len(kvs) == 0 {
+			return fdb.Key(nil), nil
+		}
+		return kvs[0].Key, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not get last row: %w", err)
+	}
+	if lastKey.(fdb.Key) == nil {
+		return 0, nil
+	}
+	begin, _ := rowSS.FDBRangeKeys()
+	end := keyAfter(lastKey.(fdb.Key))
+	deleted, lastID := 0, ""
+	for {
+		var batchDeleted int
+		var batchLastID string
+		var next fdb.Key
+		_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			batchDeleted, batchLastID, next = 0, lastID, nil
+			start := time.Now()
+			tombstones := newTombstoneScan(tr, targetSS)
+
+			// Note: every row has a key in the row based data, and in the cells of the first column without it.
+			// The keys are read from a snapshot, rows written meanwhile don't make the batch retry
+			ri := tr.Snapshot().GetRange(fdb.KeyRange{Begin: begin, End: end}, fdb.RangeOptions{
+				Mode:  fdb.StreamingModeIterator,
+				Limit: maxDeleteBatchKeys,
+			}).Iterator()
+			var last fdb.Key
+			for ri.Advance() {
+				if err := pe.checkCanceled(); err != nil {
+					return nil, err
+				}
+
+				kv := ri.MustGet()
+				t, err := rowSS.Unpack(kv.Key)
+				if err != nil {
+					return nil, err
+				}
+				id := rowIDString(t[0])
+				if id != batchLastID {
+					batchLastID = id
+					// Note: the rows of tombstones written before were taken off the count then
+					if !tombstones.deleted(id) {
+						batchDeleted++
+						writes(tr).Set(targetSS.Pack(tuple.Tuple{"t", rowIDElement(id)}), nil)
+					}
+				}
+				last = kv.Key
+				if time.Since(start) > maxBatchTime {
+					break
+				}
+			}
+			if last == nil {
+				return nil, nil
+			}
+
+			next = keyAfter(last)
+			// Note: the count of rows goes down with every batch, sessions see it while the rest is deleted
+			writes(tr).Add(rowsKey, encodeRowCount(int64(-batchDeleted)))
+			writes(tr).Set(tombstonedKey, []byte(tbl.Name))
+			return nil, nil
+		})
+		if err != nil {
+			return deleted, transactionLimitError(err)
+		}
+		if next == nil {
+			return deleted, nil
+		}
+		deleted += batchDeleted
+		lastID = batchLastID
+		begin = next
+	}
+}
+
+// Whether the DELETE of the targets writes tombstones, from their row counts.
+func (pe pgEngine) deletesWithTombstones(targets []string) (bool, error) {
+	if tombstoneDeleteRows <= 0 {
+		return false, nil
+	}
+	statsSS := pe.statsSubspace()
+	rows, err := pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		var rows int64
+		for _, target := range targets {
+			rows += decodeRowCount(rtr.Get(rowCountKey(statsSS, target)).MustGet())
+		}
+		return rows, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not get row count: %w", err)
+	}
+	return rows.(int64) >= tombstoneDeleteRows, nil
+}
+
+/*
+
+The tombstones of a target, read along with its rows. The rows of a target are scanned in the
+order of their ids, and so are its tombstones, tombstoneScanKeys at a time from the row asked
+about. The first of them are read as soon as the scan is made, by the time the first row is
+asked about they have mostly arrived.
+
+*/
+
+type tombstoneScan struct {
+	rtr     fdb.ReadTransaction
+	ss      subspace.Subspace
+	read    fdb.RangeResult
+	reading bool
+	// The tombstones read and not passed yet, and whether there are no more after them
+	keys []fdb.Key
+	done bool
+}
+
+func newTombstoneScan(rtr fdb.ReadTransaction, targetSS subspace.Subspace) *tombstoneScan {
+	ss := targetSS.Sub("t")
+	return &tombstoneScan{
+		rtr:     rtr,
+		ss:      ss,
+		read:    rtr.GetRange(ss, fdb.RangeOptions{Limit: tombstoneScanKeys, Mode: fdb.StreamingModeWantAll}),
+		reading: true,
+	}
+}
+
+// Wait for the tombstones being read, if any are.
+func (ts *tombstoneScan) wait() {
+	if !ts.reading {
+		return
+	}
+	kvs := ts.read.GetSliceOrPanic()
+	ts.reading = false
+	for _, kv := range kvs {
+		ts.keys = append(ts.keys, kv.Key)
+	}
+	ts.done = len(kvs) < tombstoneScanKeys
+}
+
+// Whether the target has no tombstones.
+func (ts *tombstoneScan) none() bool {
+	ts.wait()
+	return ts.done && len(ts.keys) == 0
+}
+
+// Whether the row has a tombstone. Rows are asked about in the order of their ids.
+func (ts *tombstoneScan) deleted(id string) bool {
+	key := ts.ss.Pack(tuple.Tuple{rowIDElement(id)})
+	for {
+		ts.wait()
+		for len(ts.keys) > 0 && bytes.Compare(ts.keys[0], key) < 0 {
+			ts.keys = ts.keys[1:]
+		}
+		if len(ts.keys) > 0 {
+			return bytes.Equal(ts.keys[0], key)
+		}
+		if ts.done {
+			return false
+		}
+		_, end := ts.ss.FDBRangeKeys()
+		ts.read = ts.rtr.GetRange(fdb.KeyRange{Begin: key, End: end}, fdb.RangeOptions{Limit: tombstoneScanKeys, Mode: fdb.StreamingModeWantAll})
+		ts.reading = true
+	}
+}
+
+// Start reading whether the row has a tombstone.
+func readTombstone(rtr fdb.ReadTransaction, tableDataSS subspace.Subspace, target, id string) fdb.FutureByteSlice {
+	return rtr.Get(tableDataSS.Pack(tuple.Tuple{target, "t", rowIDElement(id)}))
+}
+
+// Clear the row, read as r, with the entries of the indexes and the primary key of its table.
+func (pe pgEngine) clearRow(tr fdb.Transaction, tableDataSS subspace.Subspace, tbl *tableDefinition, indexes []textIndex, target, id string, r row) error {
+	for _, idx := range indexes {
+		if err := pe.indexText(tr, tbl.Name, idx, target, id, r[idx.Column], false); err != nil {
+			return err
+		}
+	}
+	writes(tr).Clear(tableDataSS.Pack(rowKey(target, id)))
+	clearPrimaryKeyEntry(tr, tableDataSS, tbl, target, id, r)
+	writes(tr).ClearRange(tableDataSS.Sub(target, "x", rowIDElement(id)))
+	for _, column := range tbl.ColumnNames {
+		writes(tr).Clear(tableDataSS.Pack(tuple.Tuple{target, "c", tbl.columnKey(column), rowIDElement(id)}))
+	}
+	return nil
+}
+
+// Clear the rows with a tombstone of every database every interval, forever.
+func reapTombstones(db fdb.Database, cfg config, every time.Duration) {
+	for range time.Tick(every) {
+		engines, err := databaseEngines(db, cfg)
+		if err != nil {
+			log.Printf("could not reap deleted rows: %s", err)
+			continue
+		}
+		for _, pe := range engines {
+			if err := pe.withPriority(priorityBatch).reapDatabaseTombstones(); err != nil {
+				log.Printf("could not reap deleted rows of database %s: %s", pe.database, err)
+			}
+		}
+	}
+}
+
+// Clear the rows with a tombstone of the marked targets of every schema of the database.
+func (pe pgEngine) reapDatabaseTombstones() error {
+	schemas, err := pe.schemaNames()
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		spe := pe.withSchema(schema)
+		dataDir, err := directory.CreateOrOpen(spe.db, spe.dirPath("data"), nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tombstonedSS := dataDir.Sub("tombstoned")
+		kvs, err := spe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+			return rtr.GetRange(tombstonedSS, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}).GetSliceOrPanic(), nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not list deleted rows: %w", err)
+		}
+		for _, kv := range kvs.([]fdb.KeyValue) {
+			t, err := tombstonedSS.Unpack(kv.Key)
+			if err != nil {
+				continue
+			}
+			target := t[0].(string)
+			reaped, err := spe.reapTarget(string(kv.Value), target)
+			if err != nil {
+				log.Printf("could not reap deleted rows of %s: %s", target, err)
+			}
+			if reaped > 0 {
+				log.Printf("reaped %d deleted rows of %s", reaped, target)
+			}
+		}
+	}
+	return nil
+}
+
+// Clear the rows of the target with a tombstone, returning how many there were, and its mark once it has none.
+func (pe pgEngine) reapTarget(table, target string) (int, error) {
+	tbl, err := pe.getTableDefinition(table)
+	var pgErr *pgError
+	// Note: the rows of a dropped table are gone with it, its mark is all that is left
+	if errors.As(err, &pgErr) && pgErr.Code == sqlStateUndefinedTable {
+		_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			writes(tr).Clear(pe.tombstonedKey(target))
+			return nil, nil
+		})
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for {
+		n, err := pe.reapTombstoneBatch(tbl, target)
+		reaped += n
+		if err != nil {
+			return reaped, err
+		}
+		if n < tombstoneReapBatchRows {
+			return reaped, nil
+		}
+	}
+}
+
+// Clear at most tombstoneReapBatchRows rows of the target with a tombstone, and its mark if those are the last.
+func (pe pgEngine) reapTombstoneBatch(tbl *tableDefinition, target string) (int, error) {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+	tombstoneSS := tableDataSS.Sub(target, "t")
+	markKey := pe.tombstonedKey(target)
+
+	reaped, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		kvs := tr.GetRange(tombstoneSS, fdb.RangeOptions{
+			Limit: tombstoneReapBatchRows,
+			Mode:  fdb.StreamingModeWantAll,
+		}).GetSliceOrPanic()
+		// Note: the tombstones were read without a snapshot, a DELETE writing more makes this retry and keep the mark
+		if len(kvs) < tombstoneReapBatchRows {
+			writes(tr).Clear(markKey)
+		}
+
+		ids := map[string]string{}
+		var sorted []string
+		for _, kv := range kvs {
+			t, err := tombstoneSS.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			id := rowIDString(t[0])
+			ids[id] = target
+			sorted = append(sorted, id)
+			writes(tr).Clear(kv.Key)
+		}
+
+		indexes := pe.getTextIndexes(tr, tbl.Name)
+		for i, r := range readRowsAt(tr, tableDataSS, tbl, sorted, ids) {
+			if err := pe.clearRow(tr, tableDataSS, tbl, indexes, target, sorted[i], r); err != nil {
+				return nil, err
+			}
+		}
+		return len(kvs), nil
+	})
+	if err != nil {
+		return 0, transactionLimitError(err)
+	}
+	return reaped.(int), nil
+}
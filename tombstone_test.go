@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+// A transactor that calls after once every transaction it ran committed.
+type afterTransact struct {
+	fdb.Database
+	after func()
+}
+
+func (at afterTransact) Transact(f func(fdb.Transaction) (interface{}, error)) (interface{}, error) {
+	v, err := at.Database.Transact(f)
+	if err == nil {
+		at.after()
+	}
+	return v, err
+}
+
+func insertPeople(t *testing.T, pe pgEngine, from, to int) {
+	t.Helper()
+	var values []string
+	for i := from; i < to; i++ {
+		values = append(values, fmt.Sprintf("('person %d')", i))
+	}
+	execSQL(t, pe, "INSERT INTO person VALUES "+strings.Join(values, ", "))
+}
+
+// Rows inserted between the batches of tombstones of a DELETE are kept.
+func TestTombstoneRowsKeepsInsertedRows(t *testing.T) {
+	pe := testEngine(t)
+	execSQL(t, pe, "CREATE TABLE person (name TEXT)")
+	insertPeople(t, pe, 0, 25)
+
+	defer func(keys int) { maxDeleteBatchKeys = keys }(maxDeleteBatchKeys)
+	maxDeleteBatchKeys = 10
+
+	tbl, err := pe.getTableDefinition("person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetSS := dataDir.Sub("table_data").Sub("person")
+	rowsKey := rowCountKey(pe.statsSubspace(), "person")
+	tombstonedKey := pe.tombstonedKey("person")
+
+	batches := 0
+	tpe := pe
+	tpe.db = afterTransact{Database: testDatabase, after: func() {
+		batches++
+		if batches <= 2 {
+			insertPeople(t, pe, 100*batches, 100*batches+5)
+		}
+	}}
+	deleted, err := tpe.tombstoneRows(targetSS, rowsKey, tombstonedKey, tbl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 25 {
+		t.Fatalf("deleted %d rows, expected 25", deleted)
+	}
+
+	// Note: rows are in the order they were inserted, the first 25 are those there were before the DELETE
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		rows := rtr.GetRange(targetSS.Sub("r"), fdb.RangeOptions{}).GetSliceOrPanic()
+		if len(rows) != 35 {
+			t.Fatalf("table has %d rows, expected 35", len(rows))
+		}
+		for i, kv := range rows {
+			tup, err := targetSS.Sub("r").Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			id := rowIDString(tup[0])
+			tombstone := rtr.Get(targetSS.Sub("t").Pack(tuple.Tuple{rowIDElement(id)})).MustGet() != nil
+			if tombstone != (i < 25) {
+				t.Fatalf("row %d has a tombstone: %t", i, tombstone)
+			}
+		}
+		if n := decodeRowCount(rtr.Get(rowsKey).MustGet()); n != 10 {
+			t.Fatalf("row count is %d, expected 10", n)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Transaction blocks.
+
+Outside of a transaction block every statement runs in its own FoundationDB transaction.
+BEGIN opens a FoundationDB transaction that is kept on the connection and every statement
+until COMMIT or ROLLBACK runs inside it. fdb.Transaction is itself an fdb.Transactor, so the
+engine doesn't need to know whether it runs in a block or not.
+
+Note that FoundationDB transactions can't live longer than 5 seconds, so long running
+transaction blocks fail on COMMIT.
+
+Two-phase commit
+
+The transaction of a block records its mutations as it makes them (see writeSet.go).
+PREPARE TRANSACTION discards the FoundationDB transaction and persists its mutations into a
+staging subspace, in chunks that fit in FoundationDB values:
+
+```
+prepared/xact/<gid>:             ("<prepared at>",)
+prepared/xact/<gid>/<chunk>:     <mutations>
+```
+
+COMMIT PREPARED applies the mutations as they were recorded and clears the staging keys in one
+FoundationDB transaction, so they are applied exactly once. The statements don't run again, so
+now(), gen_random_uuid() and the ids of rows keep the values the block saw. ROLLBACK PREPARED
+only clears the staging keys. Prepared transactions survive reconnects and restarts and can be
+committed from any connection.
+
+Note that the reads of a prepared transaction don't conflict with what is written between
+PREPARE TRANSACTION and COMMIT PREPARED, the mutations are applied as they are.
+
+*/
+
+// Drop the state that only lives as long as the transaction block, its SET LOCAL settings and the
+// cursors it declared without WITH HOLD.
+func (pgs *pgServer) endTransactionBlock() {
+	local := pgs.localSettings
+	pgs.localSettings = map[string]string{}
+	for name := range local {
+		pgs.settingChanged(name)
+	}
+
+	for name, c := range pgs.cursors {
+		if !c.holdable {
+			delete(pgs.cursors, name)
+		}
+	}
+	pgs.txChangedCatalog = false
+	pgs.txImplicit = false
+}
+
+// Open a transaction block with the isolation level.
+func (pgs *pgServer) beginTransactionBlock(level string) error {
+	tx, err := pgs.sessionKeyspace().CreateTransaction()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	recordWrites(tx)
+	pgs.tx = &tx
+	pgs.txRows = 0
+	pgs.txFailed = false
+	pgs.txIsolation = level
+	return nil
+}
+
+// Commit the transaction block.
+func (pgs *pgServer) commitTransactionBlock() error {
+	forgetWrites(*pgs.tx)
+	err := pgs.tx.Commit().Get()
+	if pgs.txChangedCatalog {
+		catalogCaches.invalidate(pgs.database)
+	}
+	pgs.tx = nil
+	pgs.endTransactionBlock()
+	if err != nil {
+		pgs.forgetTables()
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Roll the transaction block back.
+func (pgs *pgServer) rollbackTransactionBlock() {
+	forgetWrites(*pgs.tx)
+	pgs.tx.Cancel()
+	pgs.tx = nil
+	pgs.txFailed = false
+	pgs.forgetTables()
+	pgs.endTransactionBlock()
+}
+
+func (pgs *pgServer) handleTransactionStmt(stmt *pgquery.Node) (bool, error) {
+	t := stmt.GetTransactionStmt()
+	if t == nil {
+		return false, nil
+	}
+
+	switch t.Kind {
+	case pgquery.TransactionStmtKind_TRANS_STMT_BEGIN, pgquery.TransactionStmtKind_TRANS_STMT_START:
+		// Note: BEGIN in a query of several statements turns its implicit block into a block of its own
+		if pgs.tx != nil && pgs.txImplicit {
+			pgs.txImplicit = false
+			pgs.done(nil, "BEGIN")
+			return true, nil
+		}
+		if pgs.tx != nil {
+			pgs.sendNotice(notice{Severity: warningSeverity, Code: sqlStateActiveSQLTransaction, Message: "there is already a transaction in progress"})
+			pgs.done(nil, "BEGIN")
+			return true, nil
+		}
+
+		level, err := isolationOption(t.Options)
+		if err != nil {
+			return true, err
+		}
+		if level == "" {
+			level = pgs.defaultIsolation
+		}
+
+		if err := pgs.beginTransactionBlock(level); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "BEGIN")
+	case pgquery.TransactionStmtKind_TRANS_STMT_COMMIT:
+		if pgs.tx == nil {
+			pgs.sendNotice(notice{Severity: warningSeverity, Code: sqlStateNoActiveSQLTransaction, Message: "there is no transaction in progress"})
+			pgs.done(nil, "COMMIT")
+			return true, nil
+		}
+
+		// Note: COMMIT of an aborted transaction block rolls it back
+		if pgs.txFailed {
+			pgs.rollbackTransactionBlock()
+			pgs.done(nil, "ROLLBACK")
+			return true, nil
+		}
+
+		if err := pgs.commitTransactionBlock(); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "COMMIT")
+	case pgquery.TransactionStmtKind_TRANS_STMT_ROLLBACK:
+		if pgs.tx != nil {
+			pgs.rollbackTransactionBlock()
+		} else {
+			pgs.sendNotice(notice{Severity: warningSeverity, Code: sqlStateNoActiveSQLTransaction, Message: "there is no transaction in progress"})
+			pgs.txFailed = false
+		}
+		pgs.done(nil, "ROLLBACK")
+	case pgquery.TransactionStmtKind_TRANS_STMT_PREPARE:
+		if pgs.tx == nil {
+			return true, fmt.Errorf("there is no transaction in progress")
+		}
+
+		mutations := forgetWrites(*pgs.tx)
+		pgs.tx.Cancel()
+		pgs.forgetTables()
+		pgs.tx = nil
+		pgs.endTransactionBlock()
+
+		pe := pgs.engine
+		if err := pe.prepareTransaction(t.Gid, mutations); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "PREPARE TRANSACTION")
+	case pgquery.TransactionStmtKind_TRANS_STMT_COMMIT_PREPARED:
+		if pgs.tx != nil {
+			return true, fmt.Errorf("COMMIT PREPARED cannot run inside a transaction block")
+		}
+
+		pe := pgs.engine
+		if err := pe.commitPrepared(t.Gid); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "COMMIT PREPARED")
+	case pgquery.TransactionStmtKind_TRANS_STMT_ROLLBACK_PREPARED:
+		if pgs.tx != nil {
+			return true, fmt.Errorf("ROLLBACK PREPARED cannot run inside a transaction block")
+		}
+
+		pe := pgs.engine
+		if err := pe.rollbackPrepared(t.Gid); err != nil {
+			return true, err
+		}
+		pgs.done(nil, "ROLLBACK PREPARED")
+	default:
+		return true, fmt.Errorf("unsupported transaction statement: %s", t.Kind)
+	}
+
+	return true, nil
+}
+
+// The size of the chunks the mutations of a prepared transaction are stored in, below the 100KB
+// FoundationDB allows in a value.
+const preparedChunkSize = 64 << 10
+
+func (pe pgEngine) prepareTransaction(gid string, mutations []mutation) error {
+	preparedDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("prepared"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	xactSS := preparedDir.Sub("xact")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		key := xactSS.Pack(tuple.Tuple{gid})
+		if tr.Get(key).MustGet() != nil {
+			return nil, fmt.Errorf("transaction identifier \"%s\" is already in use", gid)
+		}
+
+		tr.Set(key, tuple.Tuple{time.Now().UTC().Format(time.RFC3339)}.Pack())
+		encoded := encodeMutations(mutations)
+		for chunk := 0; len(encoded) > 0; chunk++ {
+			n := min(len(encoded), preparedChunkSize)
+			tr.Set(xactSS.Pack(tuple.Tuple{gid, chunk}), encoded[:n])
+			encoded = encoded[n:]
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not prepare transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (pe pgEngine) commitPrepared(gid string) error {
+	preparedDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("prepared"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	xactSS := preparedDir.Sub("xact")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		key := xactSS.Pack(tuple.Tuple{gid})
+		value := tr.Get(key).MustGet()
+		if value == nil {
+			return nil, fmt.Errorf("prepared transaction with identifier \"%s\" does not exist", gid)
+		}
+
+		var encoded []byte
+		ri := tr.GetRange(xactSS.Sub(gid), fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}).Iterator()
+		for ri.Advance() {
+			encoded = append(encoded, ri.MustGet().Value...)
+		}
+		mutations, err := decodeMutations(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		// Note: the mutations and clearing the staging keys commit together
+		for _, m := range mutations {
+			if err := m.apply(tr); err != nil {
+				return nil, err
+			}
+		}
+
+		tr.Clear(key)
+		tr.ClearRange(xactSS.Sub(gid))
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not commit prepared transaction: %w", err)
+	}
+
+	// Note: the mutations may have changed the catalog
+	catalogCaches.invalidate(pe.database)
+	return nil
+}
+
+func (pe pgEngine) rollbackPrepared(gid string) error {
+	preparedDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("prepared"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	xactSS := preparedDir.Sub("xact")
+
+	_, err = pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		key := xactSS.Pack(tuple.Tuple{gid})
+		if tr.Get(key).MustGet() == nil {
+			return nil, fmt.Errorf("prepared transaction with identifier \"%s\" does not exist", gid)
+		}
+
+		tr.Clear(key)
+		tr.ClearRange(xactSS.Sub(gid))
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not rollback prepared transaction: %w", err)
+	}
+
+	return nil
+}
+
+type preparedTransaction struct {
+	Gid      string
+	Prepared string
+}
+
+func (pe pgEngine) getPreparedTransactions() ([]preparedTransaction, error) {
+	preparedDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("prepared"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	xactSS := preparedDir.Sub("xact")
+
+	var xacts []preparedTransaction
+	_, err = pe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+		ri := rtr.GetRange(xactSS, fdb.RangeOptions{
+			Mode: fdb.StreamingModeWantAll,
+		}).Iterator()
+		for ri.Advance() {
+			kv := ri.MustGet()
+			k, _ := xactSS.Unpack(kv.Key)
+			// Note: the chunks of the mutations are under the key of the transaction
+			if len(k) != 1 {
+				continue
+			}
+			v, err := tuple.Unpack(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			xacts = append(xacts, preparedTransaction{Gid: k[0].(string), Prepared: v[0].(string)})
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get prepared transactions: %w", err)
+	}
+
+	return xacts, nil
+}
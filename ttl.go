@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	pgquery "github.com/pganalyze/pg_query_go/v2"
+)
+
+/*
+
+Row expiration.
+
+A table created with a ttl option expires its rows that long after they were inserted:
+
+```sql
+create table session_event (at timestamp, kind text) with (ttl = '7 days');
+```
+
+The ttl is recorded in the catalog, and INSERT records when every row expires in an index by
+expiration time, next to the rows:
+
+```
+catalog/ttl/session_event: ("interval", 0, 7, 0)
+data/ttl/session_event/<expires at, in microseconds>/<row id>: ""
+```
+
+A reaper goroutine of the server wakes up every -ttl-reap-interval, looks for the tables with a
+ttl in the catalog of every database and schema, and clears the rows that expired, with their
+index entries and their count, in batches of ttlReapBatchRows rows of their own transactions.
+Expired rows are still read until they are reaped. The rows of a partitioned table expire with
+its ttl, in their partitions, which can't have a ttl of their own.
+
+*/
+
+// The rows a transaction of the reaper clears at most.
+const ttlReapBatchRows = 100
+
+// The ttl in the WITH options of CREATE TABLE, nil if there is none.
+func ttlOption(options []*pgquery.Node) (*interval, error) {
+	for _, o := range options {
+		d := o.GetDefElem()
+		if d.Defname != "ttl" {
+			continue
+		}
+		text := d.Arg.GetString_().GetStr()
+		iv, err := parseInterval(text)
+		if err != nil || text == "" {
+			return nil, &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("invalid value for parameter \"ttl\": \"%s\"", text)}
+		}
+		if iv.span() <= 0 {
+			return nil, &pgError{Code: sqlStateInvalidParameterValue, Message: fmt.Sprintf("ttl must be positive, not \"%s\"", text)}
+		}
+		return &iv, nil
+	}
+	return nil, nil
+}
+
+func (pe pgEngine) setTableTTL(tr fdb.Transaction, name string, ttl *interval) {
+	if ttl == nil {
+		return
+	}
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writes(tr).Set(catalogDir.Sub("ttl").Pack(tuple.Tuple{name}), encodeInterval(*ttl).Pack())
+}
+
+// Start reading the ttl of the table, the returned function waits for it, nil if the table has none.
+func (pe pgEngine) readTableTTL(rtr fdb.ReadTransaction, name string) func() *interval {
+	catalogDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("catalog"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := rtr.Get(catalogDir.Sub("ttl").Pack(tuple.Tuple{name}))
+	return func() *interval {
+		value := f.MustGet()
+		if value == nil {
+			return nil
+		}
+		t, err := tuple.Unpack(value)
+		if err != nil {
+			return nil
+		}
+		if iv, ok := decodeInterval(t); ok {
+			return &iv
+		}
+		return nil
+	}
+}
+
+// Record when the row expires, at inserted plus the ttl of the table.
+func (pe pgEngine) expireRow(tr fdb.Transaction, tbl *tableDefinition, target, id string, inserted time.Time) {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	expires := microsFromTime(addIntervalToTime(inserted, *tbl.TTL))
+	setRowKey(tr, dataDir.Sub("ttl"), tuple.Tuple{target, expires, rowIDElement(id)}, nil)
+}
+
+// Reap the expired rows of the tables with a ttl of every database every interval, forever.
+func reapExpiredRows(db fdb.Database, cfg config, every time.Duration) {
+	for range time.Tick(every) {
+		engines, err := databaseEngines(db, cfg)
+		if err != nil {
+			log.Printf("could not reap expired rows: %s", err)
+			continue
+		}
+		for _, pe := range engines {
+			if err := pe.withPriority(priorityBatch).reapDatabaseExpiredRows(); err != nil {
+				log.Printf("could not reap expired rows of database %s: %s", pe.database, err)
+			}
+		}
+	}
+}
+
+// Reap the expired rows of the tables with a ttl of every schema of the database.
+func (pe pgEngine) reapDatabaseExpiredRows() error {
+	schemas, err := pe.schemaNames()
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		spe := pe.withSchema(schema)
+		catalogDir, err := directory.CreateOrOpen(spe.db, spe.dirPath("catalog"), nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ttlSS := catalogDir.Sub("ttl")
+		kvs, err := spe.db.ReadTransact(func(rtr fdb.ReadTransaction) (interface{}, error) {
+			return rtr.GetRange(ttlSS, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}).GetSliceOrPanic(), nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not list tables with a ttl: %w", err)
+		}
+		for _, kv := range kvs.([]fdb.KeyValue) {
+			t, err := ttlSS.Unpack(kv.Key)
+			if err != nil {
+				continue
+			}
+			name := t[0].(string)
+			reaped, err := spe.reapTable(name)
+			if err != nil {
+				log.Printf("could not reap expired rows of %s: %s", name, err)
+			}
+			if reaped > 0 {
+				log.Printf("reaped %d expired rows of %s", reaped, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Clear the rows of the table, and of its partitions, that expired, returning how many there were.
+func (pe pgEngine) reapTable(name string) (int, error) {
+	tbl, err := pe.getTableDefinition(name)
+	if err != nil || tbl.TTL == nil {
+		return 0, err
+	}
+	targets, err := pe.tableTargets(name)
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for _, target := range targets {
+		for {
+			n, err := pe.reapBatch(tbl, target, microsFromTime(time.Now()))
+			reaped += n
+			if err != nil {
+				return reaped, fmt.Errorf("could not reap expired rows of %s: %w", target, err)
+			}
+			if n < ttlReapBatchRows {
+				break
+			}
+		}
+	}
+	return reaped, nil
+}
+
+// Clear the rows of the target that expired before now, at most ttlReapBatchRows of them.
+func (pe pgEngine) reapBatch(tbl *tableDefinition, target string, now int64) (int, error) {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tableDataSS := dataDir.Sub("table_data")
+	ttlSS := dataDir.Sub("ttl")
+	statsSS := pe.statsSubspace()
+
+	reaped, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		begin, _ := ttlSS.Sub(target).FDBRangeKeys()
+		kvs := tr.GetRange(fdb.KeyRange{Begin: begin, End: ttlSS.Pack(tuple.Tuple{target, now})}, fdb.RangeOptions{
+			Limit: ttlReapBatchRows,
+			Mode:  fdb.StreamingModeWantAll,
+		}).GetSliceOrPanic()
+
+		ids := map[string]string{}
+		var sorted []string
+		for _, kv := range kvs {
+			t, err := ttlSS.Unpack(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			id := rowIDString(t[2])
+			ids[id] = target
+			sorted = append(sorted, id)
+			writes(tr).Clear(kv.Key)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return rowIDLess(sorted[i], sorted[j]) })
+
+		indexes := pe.getTextIndexes(tr, tbl.Name)
+		tombstones := make([]fdb.FutureByteSlice, len(sorted))
+		for i, id := range sorted {
+			tombstones[i] = readTombstone(tr, tableDataSS, target, id)
+		}
+		rows := readRowsAt(tr, tableDataSS, tbl, sorted, ids)
+		deleted := 0
+		for i, id := range sorted {
+			// Note: the row may be gone already, deleted with the rest of the table, or have a tombstone and
+			// be off the count already, it is cleared with the tombstone then
+			if len(rows[i]) == 0 || tombstones[i].MustGet() != nil {
+				continue
+			}
+			if err := pe.clearRow(tr, tableDataSS, tbl, indexes, target, id, rows[i]); err != nil {
+				return nil, err
+			}
+			deleted++
+		}
+		countRows(tr, statsSS, target, int64(-deleted))
+		return len(kvs), nil
+	})
+	if err != nil {
+		return 0, transactionLimitError(err)
+	}
+	return reaped.(int), nil
+}
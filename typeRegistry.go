@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+
+The builtin types and what clients need to know about them: the OID they have in Postgres,
+the OID of their array type, their length in bytes (-1 for variable length) and the other
+names they can be spelled with.
+
+Column types are stored in the catalog by the normalized name of the type, whatever alias
+was used to declare the column, with the type modifiers as written by typeNameString:
+
+```sql
+create table person (id int4, age integer, name varchar(10));
+```
+
+```
+catalog/table/person/id: pg_catalog.int4
+catalog/table/person/age: pg_catalog.int4
+catalog/table/person/name: pg_catalog.varchar(10)
+```
+
+The registry also supplies the type, length and type modifier of the fields of a
+RowDescription, and the rows of pg_catalog.pg_type together with the enums in the catalog.
+
+*/
+
+type pgType struct {
+	Name string
+	// The name information_schema reports for the type
+	SQLName  string
+	Oid      uint32
+	ArrayOid uint32
+	Len      int16
+	Aliases  []string
+}
+
+var pgTypes = []pgType{
+	{Name: "pg_catalog.bool", SQLName: "boolean", Oid: 16, ArrayOid: 1000, Len: 1, Aliases: []string{"boolean"}},
+	{Name: "pg_catalog.int2", SQLName: "smallint", Oid: 21, ArrayOid: 1005, Len: 2, Aliases: []string{"smallint"}},
+	{Name: "pg_catalog.int4", SQLName: "integer", Oid: 23, ArrayOid: 1007, Len: 4, Aliases: []string{"int", "integer"}},
+	{Name: "pg_catalog.int8", SQLName: "bigint", Oid: 20, ArrayOid: 1016, Len: 8, Aliases: []string{"bigint"}},
+	{Name: "pg_catalog.float4", SQLName: "real", Oid: 700, ArrayOid: 1021, Len: 4, Aliases: []string{"real"}},
+	{Name: "pg_catalog.float8", SQLName: "double precision", Oid: 701, ArrayOid: 1022, Len: 8, Aliases: []string{"float", "double precision"}},
+	{Name: "pg_catalog.numeric", SQLName: "numeric", Oid: 1700, ArrayOid: 1231, Len: -1, Aliases: []string{"decimal"}},
+	{Name: "text", SQLName: "text", Oid: 25, ArrayOid: 1009, Len: -1},
+	{Name: "pg_catalog.varchar", SQLName: "character varying", Oid: 1043, ArrayOid: 1015, Len: -1, Aliases: []string{"character varying"}},
+	{Name: "pg_catalog.bpchar", SQLName: "character", Oid: 1042, ArrayOid: 1014, Len: -1, Aliases: []string{"character"}},
+	{Name: "pg_catalog.date", SQLName: "date", Oid: 1082, ArrayOid: 1182, Len: 4},
+	{Name: "pg_catalog.time", SQLName: "time without time zone", Oid: 1083, ArrayOid: 1183, Len: 8, Aliases: []string{"time without time zone"}},
+	{Name: "pg_catalog.timestamp", SQLName: "timestamp without time zone", Oid: 1114, ArrayOid: 1115, Len: 8, Aliases: []string{"timestamp without time zone"}},
+	{Name: "pg_catalog.timestamptz", SQLName: "timestamp with time zone", Oid: 1184, ArrayOid: 1185, Len: 8, Aliases: []string{"timestamp with time zone"}},
+	{Name: "pg_catalog.interval", SQLName: "interval", Oid: 1186, ArrayOid: 1187, Len: 16},
+	{Name: "pg_catalog.uuid", SQLName: "uuid", Oid: 2950, ArrayOid: 2951, Len: 16},
+	{Name: "pg_catalog.json", SQLName: "json", Oid: 114, ArrayOid: 199, Len: -1},
+	{Name: "pg_catalog.jsonb", SQLName: "jsonb", Oid: 3802, ArrayOid: 3807, Len: -1},
+	{Name: "pg_catalog.inet", SQLName: "inet", Oid: 869, ArrayOid: 1041, Len: -1},
+	{Name: "pg_catalog.cidr", SQLName: "cidr", Oid: 650, ArrayOid: 651, Len: -1},
+	{Name: "pg_catalog.point", SQLName: "point", Oid: 600, ArrayOid: 1017, Len: 16},
+	{Name: "pg_catalog.box", SQLName: "box", Oid: 603, ArrayOid: 1020, Len: 32},
+	{Name: "record", SQLName: "record", Oid: 2249, ArrayOid: 2287, Len: -1},
+	{Name: "void", SQLName: "void", Oid: 2278, Len: 4},
+}
+
+// Field values are sent in the text format.
+const textFormatCode = 0
+
+// Look up a builtin type by its name or one of its aliases, qualified with pg_catalog or not.
+func lookupType(name string) (pgType, bool) {
+	name = strings.TrimPrefix(strings.ToLower(name), "pg_catalog.")
+	for _, t := range pgTypes {
+		if strings.TrimPrefix(t.Name, "pg_catalog.") == name {
+			return t, true
+		}
+		for _, alias := range t.Aliases {
+			if alias == name {
+				return t, true
+			}
+		}
+	}
+	return pgType{}, false
+}
+
+// Look up a builtin type by its OID.
+func lookupTypeOID(oid uint32) (pgType, bool) {
+	for _, t := range pgTypes {
+		if t.Oid == oid {
+			return t, true
+		}
+	}
+	return pgType{}, false
+}
+
+// The OID of a builtin type or of an array of a builtin type.
+func builtinTypeOID(colType string) (uint32, bool) {
+	if elemType, ok := arrayElemType(colType); ok {
+		t, ok := lookupType(elemType)
+		return t.ArrayOid, ok && t.ArrayOid != 0
+	}
+	t, ok := lookupType(colType)
+	return t.Oid, ok
+}
+
+// The length of values of a type, -1 for types of variable length.
+func typeLen(colType string) int16 {
+	if isArrayType(colType) {
+		return -1
+	}
+	if t, ok := lookupType(colType); ok {
+		return t.Len
+	}
+	return -1
+}
+
+/*
+
+The type modifier of a type like Postgres stores it in atttypmod, -1 for types without one.
+Lengths of character types include the 4 byte header of the value, numerics pack the
+precision and the scale:
+
+```
+varchar(10)    14
+numeric(10,2)  655366 = (10 << 16 | 2) + 4
+timestamp(3)   3
+```
+
+*/
+
+func typeModifier(colType string) int32 {
+	base, mods := splitTypeMods(colType)
+	if len(mods) == 0 {
+		return -1
+	}
+
+	base = strings.TrimSuffix(base, "[]")
+	switch base {
+	case "pg_catalog.varchar", "pg_catalog.bpchar":
+		return int32(mods[0]) + 4
+	case "pg_catalog.numeric":
+		scale := 0
+		if len(mods) > 1 {
+			scale = mods[1]
+		}
+		return int32(mods[0]<<16|scale) + 4
+	case "pg_catalog.time", "pg_catalog.timestamp", "pg_catalog.timestamptz":
+		return int32(mods[0])
+	}
+	return -1
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/google/uuid"
+)
+
+/*
+
+The uuid type, the same kind of identifier rows are keyed by.
+
+Values are uuid.UUID, 16 bytes, in Go and in the cells, where the tuple encoding has a
+type of its own for them:
+
+```sql
+create table session (id uuid, name text);
+insert into session values (gen_random_uuid(), 'garry');
+select name from session where id = 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11';
+```
+
+```
+data/table_data/session/r/72746a7f-727f-4e0a-88f1-d983fea5c158/id: (UUID(a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11),)
+```
+
+Comparisons are on the 16 bytes rather than on the text, so a constant in any of the forms
+Postgres accepts, in upper or lower case, with or without hyphens or braces, finds the row.
+Values are written in lower case with hyphens.
+
+*/
+
+func parseUUID(s string) (uuid.UUID, error) {
+	value := strings.TrimSpace(s)
+	// Note: uuid.Parse also takes the urn:uuid: prefix, which Postgres doesn't
+	if strings.HasPrefix(strings.ToLower(value), "urn:") {
+		return uuid.UUID{}, fmt.Errorf("invalid input syntax for type uuid: %q", s)
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("invalid input syntax for type uuid: %q", s)
+	}
+	return id, nil
+}
+
+func castUUID(v any) (string, error) {
+	switch value := v.(type) {
+	case uuid.UUID:
+		return value.String(), nil
+	case string:
+		id, err := parseUUID(value)
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+	return "", fmt.Errorf("cannot cast type %T to uuid", v)
+}
+
+func isUUID(v any) bool {
+	_, ok := v.(uuid.UUID)
+	return ok
+}
+
+// Compare uuids with each other or with string constants, which are read as uuids.
+func cmpUUID(left, right any) (int, error) {
+	operand := func(v any) (uuid.UUID, error) {
+		switch value := v.(type) {
+		case uuid.UUID:
+			return value, nil
+		case string:
+			return parseUUID(value)
+		}
+		return uuid.UUID{}, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+
+	l, err := operand(left)
+	if err != nil {
+		return 0, err
+	}
+	r, err := operand(right)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(l[:], r[:]), nil
+}
+
+func encodeUUID(id uuid.UUID) tuple.UUID {
+	return tuple.UUID(id)
+}
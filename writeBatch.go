@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/directory"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/google/uuid"
+)
+
+/*
+
+Write batches.
+
+A FoundationDB transaction may write 10MB and may run for 5 seconds, a statement that writes
+more in one transaction fails. Outside of a transaction block INSERT, and DELETE when it writes
+tombstones (see tombstone.go), split their writes across transactions instead: the transaction a batch of rows is written in commits once
+it wrote maxBatchBytes or ran for maxBatchTime, and the next one goes on from the row after the
+last one committed.
+
+```sql
+insert into person values ('garry', 14), ('ted', 20), ... -- 200000 rows
+INSERT 0 200000
+```
+
+A conflict retries the batch it happened in, from its first row, not the whole statement. A
+commit that fails with commit_unknown_result may have committed, and its retry would write the
+rows of the batch again with new ids. Every batch records the step it reached in a marker of the
+write, which the retry reads first, and a retry that finds the batch committed goes on after it:
+
+```
+data/commit/<id of the write>: (<steps committed>,)
+```
+
+The marker is cleared once the write is done, a server that stops in the middle of a write
+leaves it behind. Rows with a primary key can also be sent again by the client, see primaryKey.go.
+INSERT checks every row before it writes the first one, so a row that isn't valid writes none
+of them. A batch that still fails ends the statement with the batches before it committed, and
+the error tells how many rows they wrote, so the rest can be sent again.
+
+In a transaction block every batch is in the transaction of the block, which can't be split. A
+block that writes too much fails with 54000 program_limit_exceeded instead of the error code of
+FoundationDB.
+
+Writes only read with conflicts what they must not miss a change of, so writes to the same
+table don't make each other retry:
+
+```
+INSERT  catalog/table/<table>        DROP TABLE may not clear the table while rows are added
+        catalog/layout/<table>       a change of the layout may not miss the rows
+        catalog/index/<table>/...    CREATE INDEX may not miss the rows
+        the key of each row          in a block, two blocks may not write the same row id
+        primary key entries          two INSERTs may not both write a row of the same key
+DELETE  tombstones                   the reaper may not clear the mark of the table meanwhile,
+                                     the keys of the rows are read from a snapshot
+```
+
+The keys of rows inserted outside of a block are versionstamped, which have no write conflicts,
+so inserts into the same table never conflict. A DELETE that clears the ranges of a table (see
+clearTable.go) does it in one transaction, which reads the row counts that inserts add to, an
+insert committed meanwhile makes it retry.
+
+*/
+
+const (
+	maxBatchBytes = 4 << 20
+	maxBatchTime  = 2 * time.Second
+	// The user versions of the versionstamps of row ids, see newRowIDs
+	maxBatchSteps = 1 << 16
+)
+
+// FoundationDB error codes of transactions that are over a limit.
+const (
+	fdbTransactionTooOld   = 1007
+	fdbTransactionTooLarge = 2101
+)
+
+/*
+
+Run the steps of a write, calling begin at the start of every transaction, and committing the
+transaction whenever it is over maxBatchBytes, maxBatchTime or maxBatchSteps. Returns the number of steps
+committed, which is every step unless it fails.
+
+*/
+
+func (pe pgEngine) transactInBatches(steps int, begin func(tr fdb.Transaction) error, step func(tr fdb.Transaction, i int) error) (int, error) {
+	db, ok := pe.db.(keyspace)
+	if !ok {
+		// Note: the transaction of the block runs the function right away, without retrying it
+		_, err := pe.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			if err := begin(tr); err != nil {
+				return nil, err
+			}
+			for i := 0; i < steps; i++ {
+				if err := step(tr, i); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return 0, transactionLimitError(err)
+		}
+		return steps, nil
+	}
+
+	marker := pe.commitMarkerKey()
+	defer clearCommitMarker(db, marker)
+
+	// Note: a write of no rows still runs begin, in one transaction
+	committed := 0
+	for {
+		next, err := db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			done := tr.Get(marker)
+			err := begin(tr)
+			// Note: the marker has the steps of the batch if an attempt before this one committed it
+			if t, _ := tuple.Unpack(done.MustGet()); len(t) == 1 {
+				if reached, ok := t[0].(int64); ok && int(reached) > committed {
+					return int(reached), nil
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			i := committed
+			for i < steps {
+				if err := step(tr, i); err != nil {
+					return nil, err
+				}
+				i++
+				if time.Since(start) > maxBatchTime || tr.GetApproximateSize().MustGet() > maxBatchBytes || i-committed >= maxBatchSteps {
+					break
+				}
+			}
+			writes(tr).Set(marker, tuple.Tuple{int64(i)}.Pack())
+			return i, nil
+		})
+		if err != nil {
+			if committed > 0 {
+				return committed, partialWriteError(transactionLimitError(err), committed)
+			}
+			return 0, transactionLimitError(err)
+		}
+		committed = next.(int)
+		if committed >= steps {
+			return committed, nil
+		}
+	}
+}
+
+// The key of the marker of a new write, see transactInBatches.
+func (pe pgEngine) commitMarkerKey() fdb.Key {
+	dataDir, err := directory.CreateOrOpen(pe.db, pe.dirPath("data"), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	id := uuid.New()
+	return dataDir.Sub("commit").Pack(tuple.Tuple{id[:]})
+}
+
+// Clear the marker of a write that is done, in the background.
+func clearCommitMarker(db keyspace, marker fdb.Key) {
+	go func() {
+		_, err := db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+			writes(tr).Clear(marker)
+			return nil, nil
+		})
+		if err != nil {
+			log.Printf("could not clear commit marker: %s", err)
+		}
+	}()
+}
+
+// The error of a write that failed after some of its batches were committed, telling how many rows they wrote.
+func partialWriteError(err error, written int) error {
+	pgErr := &pgError{Code: internalErrorCode, Message: err.Error()}
+	var e *pgError
+	if errors.As(err, &e) {
+		copied := *e
+		pgErr = &copied
+	}
+	pgErr.Detail = fmt.Sprintf("%d rows were committed before the error.", written)
+	return pgErr
+}
+
+// The error of FoundationDB for a transaction that is over a limit, as the error Postgres sends.
+func transactionLimitError(err error) error {
+	var fdbErr fdb.Error
+	if !errors.As(err, &fdbErr) {
+		return err
+	}
+	switch fdbErr.Code {
+	case fdbTransactionTooLarge:
+		return &pgError{Code: sqlStateProgramLimitExceeded, Message: "transaction is too large, it writes more than FoundationDB allows in one transaction"}
+	case fdbTransactionTooOld:
+		return &pgError{Code: sqlStateProgramLimitExceeded, Message: "transaction is too old, it ran longer than FoundationDB allows a transaction to run"}
+	}
+	return err
+}
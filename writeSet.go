@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+)
+
+/*
+
+Write sets.
+
+FoundationDB can't hand out the mutations of a transaction, and PREPARE TRANSACTION needs them
+to commit the block later (see transaction.go). The transaction of a transaction block records
+its mutations as they are made instead. The engine writes through writes(tr), which writes to tr
+and also records the mutation when tr is the transaction of a block:
+
+```go
+writes(tr).Set(key, value)
+writes(tr).Add(rowCountKey(statsSS, target), encodeRowCount(n))
+```
+
+A mutation is recorded as it was made, an atomic add as the add and not as the value it
+results in, and a versionstamped key with its incomplete versionstamp, so applying the write set
+in another transaction makes the same changes as committing the block would have, with the
+versionstamp of that transaction.
+
+Writes that never run in a transaction block, like those of the audit log or of -reset, write
+to tr directly.
+
+*/
+
+// The write sets of the transactions of the open transaction blocks, by transaction.
+var writeSets sync.Map
+
+type mutation struct {
+	op    string
+	key   []byte
+	param []byte
+}
+
+type writeSet struct {
+	mu        sync.Mutex
+	mutations []mutation
+}
+
+// Start recording the mutations of the transaction of a transaction block.
+func recordWrites(tr fdb.Transaction) {
+	writeSets.Store(tr, &writeSet{})
+}
+
+// Stop recording the mutations of the transaction and return those it recorded.
+func forgetWrites(tr fdb.Transaction) []mutation {
+	v, ok := writeSets.LoadAndDelete(tr)
+	if !ok {
+		return nil
+	}
+	return v.(*writeSet).mutations
+}
+
+// Writes to a transaction, recording the mutations if it is the transaction of a block.
+type txWriter struct {
+	tr fdb.Transaction
+	ws *writeSet
+}
+
+func writes(tr fdb.Transaction) txWriter {
+	w := txWriter{tr: tr}
+	if v, ok := writeSets.Load(tr); ok {
+		w.ws = v.(*writeSet)
+	}
+	return w
+}
+
+func (w txWriter) record(op string, key fdb.Key, param []byte) {
+	if w.ws == nil {
+		return
+	}
+	// Note: the caller may reuse the buffers after the write
+	m := mutation{op: op, key: append([]byte(nil), key...), param: append([]byte(nil), param...)}
+	w.ws.mu.Lock()
+	w.ws.mutations = append(w.ws.mutations, m)
+	w.ws.mu.Unlock()
+}
+
+func (w txWriter) Set(key fdb.KeyConvertible, value []byte) {
+	w.tr.Set(key, value)
+	w.record("set", key.FDBKey(), value)
+}
+
+func (w txWriter) Clear(key fdb.KeyConvertible) {
+	w.tr.Clear(key)
+	w.record("clear", key.FDBKey(), nil)
+}
+
+func (w txWriter) ClearRange(er fdb.ExactRange) {
+	w.tr.ClearRange(er)
+	begin, end := er.FDBRangeKeys()
+	w.record("clear_range", begin.FDBKey(), end.FDBKey())
+}
+
+func (w txWriter) Add(key fdb.KeyConvertible, param []byte) {
+	w.tr.Add(key, param)
+	w.record("add", key.FDBKey(), param)
+}
+
+func (w txWriter) SetVersionstampedKey(key fdb.KeyConvertible, param []byte) {
+	w.tr.SetVersionstampedKey(key, param)
+	w.record("set_versionstamped_key", key.FDBKey(), param)
+}
+
+func (w txWriter) SetVersionstampedValue(key fdb.KeyConvertible, param []byte) {
+	w.tr.SetVersionstampedValue(key, param)
+	w.record("set_versionstamped_value", key.FDBKey(), param)
+}
+
+// Make the mutation in the transaction.
+func (m mutation) apply(tr fdb.Transaction) error {
+	switch m.op {
+	case "set":
+		tr.Set(fdb.Key(m.key), m.param)
+	case "clear":
+		tr.Clear(fdb.Key(m.key))
+	case "clear_range":
+		tr.ClearRange(fdb.KeyRange{Begin: fdb.Key(m.key), End: fdb.Key(m.param)})
+	case "add":
+		tr.Add(fdb.Key(m.key), m.param)
+	case "set_versionstamped_key":
+		tr.SetVersionstampedKey(fdb.Key(m.key), m.param)
+	case "set_versionstamped_value":
+		tr.SetVersionstampedValue(fdb.Key(m.key), m.param)
+	default:
+		return fmt.Errorf("unknown mutation \"%s\"", m.op)
+	}
+	return nil
+}
+
+// Encode the mutations as one tuple, which is split into chunks to be stored.
+func encodeMutations(mutations []mutation) []byte {
+	t := make(tuple.Tuple, len(mutations))
+	for i, m := range mutations {
+		t[i] = tuple.Tuple{m.op, m.key, m.param}
+	}
+	return t.Pack()
+}
+
+func decodeMutations(b []byte) ([]mutation, error) {
+	t, err := tuple.Unpack(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode mutations: %w", err)
+	}
+	mutations := make([]mutation, len(t))
+	for i, e := range t {
+		m, ok := e.(tuple.Tuple)
+		if !ok || len(m) != 3 {
+			return nil, fmt.Errorf("could not decode mutations: mutation %d is not valid", i)
+		}
+		op, _ := m[0].(string)
+		key, _ := m[1].([]byte)
+		param, _ := m[2].([]byte)
+		mutations[i] = mutation{op: op, key: key, param: param}
+	}
+	return mutations, nil
+}